@@ -35,6 +35,9 @@ func main() {
 	docs.SwaggerInfo.BasePath = "/"
 	// Load configuration
 	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Set Gin mode
 	if !cfg.Debug {
@@ -70,7 +73,8 @@ func printRoutes(r *gin.Engine) {
 	for _, route := range routes {
 		fmt.Printf("%s %s\n", route.Method, route.Path)
 	}
-	fmt.Println("=================\n")
+	fmt.Println("=================")
+	fmt.Println()
 }
 func createDirs(cfg *config.Config) {
 	dirs := []string{
@@ -94,6 +98,7 @@ func createDirs(cfg *config.Config) {
 		cfg.PublicDir + "/redacted",      // Added
 		cfg.PublicDir + "/repaired",      // Added
 		cfg.PublicDir + "/signatures",    // Added
+		cfg.PublicDir + "/pipelines",
 	}
 
 	for _, dir := range dirs {
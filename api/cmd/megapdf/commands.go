@@ -0,0 +1,648 @@
+// cmd/megapdf/commands.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/pdfops"
+	"github.com/google/uuid"
+)
+
+// newFlagSet creates the flag set of a subcommand with the common -o flag
+func newFlagSet(name, usage string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("o", "", "Output file (defaults to a name derived from the input)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: megapdf %s [flags] %s\n\nFlags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs, output
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, &usageError{msg: err.Error()}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// inputFiles parses the arguments and checks the number of input files
+func inputFiles(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) < min {
+		if min == 1 {
+			return nil, usageErrorf("an input file is required")
+		}
+		return nil, usageErrorf("at least %d input files are required", min)
+	}
+	if max > 0 && len(files) > max {
+		return nil, usageErrorf("expected at most %d input file(s), got %d", max, len(files))
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, usageErrorf("%s is a directory", file)
+		}
+	}
+	return files, nil
+}
+
+// outputPath returns the -o value or derives "<input>-<suffix>.<ext>" next to the input
+func outputPath(output, input, suffix, ext string) (string, error) {
+	if output == "" {
+		base := strings.TrimSuffix(input, filepath.Ext(input))
+		output = base + "-" + suffix + "." + ext
+	}
+	if abs(output) == abs(input) {
+		return "", usageErrorf("output file must differ from the input file")
+	}
+	return output, nil
+}
+
+func abs(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return path
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func runCompress(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("compress", "<input.pdf>")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	out, err := outputPath(*output, files[0], "compressed", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := pdfops.Compress(ctx, files[0], out, pdfops.CompressOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":          fmt.Sprintf("PDF compression successful with %.2f%% reduction", compressed.Ratio()),
+		"output":           out,
+		"originalSize":     compressed.OriginalSize,
+		"compressedSize":   compressed.CompressedSize,
+		"compressionRatio": fmt.Sprintf("%.2f%%", compressed.Ratio()),
+	}, nil
+}
+
+func runConvert(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("convert", "<input file>")
+	inputFormat := fs.String("inputFormat", "", "Input file format (defaults to the file extension)")
+	outputFormat := fs.String("outputFormat", "", "Output file format (pdf, docx, xlsx, pptx, rtf, txt, html, jpg, jpeg, png)")
+	ocr := fs.Bool("ocr", false, "Enable OCR for text extraction")
+	quality := fs.String("quality", "", "Image quality for image outputs (10-100)")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *outputFormat == "" {
+		return nil, usageErrorf("--outputFormat is required")
+	}
+	if *inputFormat == "" {
+		*inputFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(files[0])), ".")
+	}
+	out, err := outputPath(*output, files[0], "converted", *outputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pdfops.Convert(ctx, files[0], out, pdfops.ConvertOptions{
+		InputFormat:  *inputFormat,
+		OutputFormat: *outputFormat,
+		OCR:          *ocr,
+		Quality:      *quality,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":      "Conversion successful",
+		"output":       out,
+		"inputFormat":  *inputFormat,
+		"outputFormat": *outputFormat,
+	}, nil
+}
+
+func runMerge(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("merge", "<a.pdf> <b.pdf> [more.pdf...]")
+	order := fs.String("order", "", "JSON array with the order of the files (e.g. [2,0,1])")
+	files, err := inputFiles(fs, args, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := outputPath(*output, files[0], "merged", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	var opts pdfops.MergeOptions
+	if *order != "" {
+		if err := json.Unmarshal([]byte(*order), &opts.Order); err != nil {
+			return nil, usageErrorf("invalid --order: %v", err)
+		}
+	}
+
+	if err := pdfops.Merge(ctx, files, out, opts); err != nil {
+		return nil, err
+	}
+
+	var totalInputSize int64
+	for _, file := range files {
+		totalInputSize += fileSize(file)
+	}
+
+	return result{
+		"message":        "PDF merge successful",
+		"output":         out,
+		"mergedSize":     fileSize(out),
+		"totalInputSize": totalInputSize,
+		"fileCount":      len(files),
+	}, nil
+}
+
+func runSplit(ctx context.Context, args []string) (result, error) {
+	fs, _ := newFlagSet("split", "<input.pdf>")
+	outDir := fs.String("outdir", "", "Directory for the parts (defaults to the input directory)")
+	splitMethod := fs.String("splitMethod", pdfops.SplitByRange, "Split method: range, extract or every")
+	pageRanges := fs.String("pageRanges", "", "Page ranges for the range method (e.g. '1-3,5,7-9')")
+	everyNPages := fs.Int("everyNPages", 1, "Pages per file for the every method")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *splitMethod == pdfops.SplitByRange && *pageRanges == "" {
+		return nil, usageErrorf("--pageRanges is required for the range split method")
+	}
+	if *outDir == "" {
+		*outDir = filepath.Dir(files[0])
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return nil, err
+	}
+
+	totalPages, err := pdfops.PageCount(ctx, files[0])
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := pdfops.Split(ctx, files[0], pdfops.SplitOptions{
+		Method:      *splitMethod,
+		PageRanges:  *pageRanges,
+		EveryNPages: *everyNPages,
+		TotalPages:  totalPages,
+		OutputDir:   *outDir,
+		Prefix:      strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0])),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	splitParts := make([]result, 0, len(parts))
+	for _, part := range parts {
+		splitParts = append(splitParts, result{
+			"output":    part.Path,
+			"filename":  part.Filename,
+			"pages":     part.Pages,
+			"pageCount": part.PageCount,
+		})
+	}
+
+	return result{
+		"message":    fmt.Sprintf("PDF split into %d files", len(parts)),
+		"totalPages": totalPages,
+		"splitParts": splitParts,
+	}, nil
+}
+
+func runRotate(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("rotate", "<input.pdf>")
+	angle := fs.Int("angle", 0, "Rotation angle in degrees: 90, 180 or 270")
+	pages := fs.String("pages", "all", "Pages to rotate (e.g. '1-3,5,7-9')")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *angle == 0 {
+		return nil, usageErrorf("--angle is required")
+	}
+	out, err := outputPath(*output, files[0], "rotated", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pdfops.Rotate(ctx, files[0], out, pdfops.RotateOptions{Angle: *angle, Pages: *pages}); err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message": fmt.Sprintf("PDF rotated by %d degrees successfully", *angle),
+		"output":  out,
+	}, nil
+}
+
+func runWatermark(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("watermark", "<input.pdf>")
+	watermarkType := fs.String("watermarkType", pdfops.WatermarkText, "Type of watermark: text, image or pdf")
+	content := fs.String("content", "", "Watermark text, or the path of the image or PDF")
+	text := fs.String("text", "", "Watermark text (alias of --content for text watermarks)")
+	position := fs.String("position", "c", "Watermark position (center, top-left, ... or c, tl, ...)")
+	rotation := fs.Int("rotation", 0, "Rotation angle (0-360 degrees)")
+	opacity := fs.Int("opacity", 50, "Watermark opacity (10-100)")
+	scale := fs.Int("scale", 100, "Watermark scale percentage (10-200)")
+	textColor := fs.String("textColor", "#808080", "Color for text watermarks (hex format)")
+	pages := fs.String("pages", "all", "Pages to watermark: all, even, odd or custom")
+	customPages := fs.String("customPages", "", "Custom page range when --pages is custom (e.g. '1-3,5')")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *content == "" {
+		*content = *text
+	}
+	if *content == "" {
+		return nil, usageErrorf("--content is required")
+	}
+	if *opacity < 10 || *opacity > 100 {
+		*opacity = 50
+	}
+	if *scale < 10 || *scale > 200 {
+		*scale = 100
+	}
+	if *pages == "custom" {
+		*pages = *customPages
+	}
+	out, err := outputPath(*output, files[0], "watermarked", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	err = pdfops.Watermark(ctx, files[0], out, pdfops.WatermarkOptions{
+		Type:      *watermarkType,
+		Content:   *content,
+		Position:  *position,
+		Rotation:  *rotation,
+		Opacity:   *opacity,
+		Scale:     *scale,
+		TextColor: *textColor,
+		Pages:     *pages,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":  "Watermark added to PDF successfully",
+		"output":   out,
+		"fileSize": fileSize(out),
+	}, nil
+}
+
+func runSign(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("sign", "<input.pdf>")
+	content := fs.String("content", "", "Signature text, or the path of a PNG, JPG or SVG signature image")
+	position := fs.String("position", "c", "Signature position (center, top-left, ... or c, tl, ...)")
+	rotation := fs.Int("rotation", 0, "Rotation angle (0-360 degrees)")
+	opacity := fs.Int("opacity", 100, "Signature opacity (1-100)")
+	scale := fs.Int("scale", 100, "Signature scale percentage (10-500)")
+	pages := fs.String("pages", "all", "Pages to sign: all or custom")
+	customPages := fs.String("customPages", "", "Custom page range when --pages is custom (e.g. '1-3,5')")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *content == "" {
+		return nil, usageErrorf("--content is required")
+	}
+	if *opacity < 1 || *opacity > 100 {
+		*opacity = 100
+	}
+	if *scale < 10 || *scale > 500 {
+		*scale = 100
+	}
+
+	// A content that names an image file is an image signature, anything else is text
+	signatureType := pdfops.WatermarkText
+	switch strings.ToLower(filepath.Ext(*content)) {
+	case ".png", ".jpg", ".jpeg", ".svg":
+		if _, err := os.Stat(*content); err == nil {
+			signatureType = pdfops.WatermarkImage
+		}
+	}
+
+	signPages := ""
+	if *pages == "custom" {
+		signPages = *customPages
+	}
+	out, err := outputPath(*output, files[0], "signed", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	err = pdfops.Sign(ctx, files[0], out, pdfops.SignOptions{
+		Type:     signatureType,
+		Content:  *content,
+		Position: *position,
+		Rotation: *rotation,
+		Opacity:  *opacity,
+		Scale:    *scale,
+		Pages:    signPages,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message": "PDF signed successfully",
+		"output":  out,
+	}, nil
+}
+
+func runProtect(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("protect", "<input.pdf>")
+	password := fs.String("password", "", "Password to set for the PDF (minimum 4 characters)")
+	permission := fs.String("permission", "restricted", "Permission level: restricted or all")
+	allowPrinting := fs.Bool("allowPrinting", false, "Allow document printing")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(*password) < 4 {
+		return nil, usageErrorf("--password must be at least 4 characters")
+	}
+	out, err := outputPath(*output, files[0], "protected", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := pdfops.PermissionsNone
+	if *permission == "all" {
+		permissions = pdfops.PermissionsAll
+	} else if *allowPrinting {
+		permissions = pdfops.PermissionsPrint
+	}
+
+	err = pdfops.Protect(ctx, files[0], out, pdfops.ProtectOptions{
+		UserPassword:  *password,
+		OwnerPassword: *password,
+		Permissions:   permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":     "PDF protected with password successfully",
+		"output":      out,
+		"permissions": permissions,
+	}, nil
+}
+
+func runUnlock(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("unlock", "<input.pdf>")
+	password := fs.String("password", "", "Current PDF password")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *password == "" {
+		return nil, usageErrorf("--password is required")
+	}
+	out, err := outputPath(*output, files[0], "unlocked", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pdfops.Unlock(ctx, files[0], out, pdfops.UnlockOptions{Password: *password}); err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message": "PDF unlocked successfully",
+		"output":  out,
+	}, nil
+}
+
+func runPageNumber(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("pagenumber", "<input.pdf>")
+	position := fs.String("position", "bottom-center", "Position: top-left, top-center, top-right, bottom-left, bottom-center or bottom-right")
+	format := fs.String("format", "numeric", "Number format: numeric, roman or alphabetic")
+	fontFamily := fs.String("fontFamily", "Helvetica", "Font family: Helvetica, Times or Courier")
+	fontSize := fs.Int("fontSize", 12, "Font size (1-72)")
+	color := fs.String("color", "#000000", "Text color (hex format)")
+	startNumber := fs.Int("startNumber", 1, "Number of the first page")
+	prefix := fs.String("prefix", "", "Text before the page number")
+	suffix := fs.String("suffix", "", "Text after the page number")
+	marginX := fs.Int("marginX", 40, "Horizontal margin in points")
+	marginY := fs.Int("marginY", 30, "Vertical margin in points")
+	selectedPages := fs.String("selectedPages", "", "Pages to number (e.g. '1-3,5'); empty numbers every page")
+	skipFirstPage := fs.Bool("skipFirstPage", false, "Leave the first page unnumbered")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !pdfops.ValidPageNumberPosition(*position) {
+		return nil, usageErrorf("invalid --position %q", *position)
+	}
+	if *format != "numeric" && *format != "roman" && *format != "alphabetic" {
+		return nil, usageErrorf("invalid --format %q", *format)
+	}
+	if *fontSize <= 0 || *fontSize > 72 {
+		return nil, usageErrorf("--fontSize must be between 1 and 72")
+	}
+	if *startNumber <= 0 {
+		return nil, usageErrorf("--startNumber must be positive")
+	}
+	if *marginX < 0 || *marginY < 0 {
+		return nil, usageErrorf("margins must not be negative")
+	}
+	out, err := outputPath(*output, files[0], "numbered", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	numbered, err := pdfops.NumberPages(ctx, files[0], out, pdfops.NumberPagesOptions{
+		Position:      *position,
+		Format:        *format,
+		StartNumber:   *startNumber,
+		FontFamily:    *fontFamily,
+		FontSize:      *fontSize,
+		Color:         *color,
+		Prefix:        *prefix,
+		Suffix:        *suffix,
+		MarginX:       *marginX,
+		MarginY:       *marginY,
+		Pages:         *selectedPages,
+		SkipFirstPage: *skipFirstPage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":       "Page numbers added successfully",
+		"output":        out,
+		"totalPages":    numbered.TotalPages,
+		"numberedPages": numbered.NumberedPages,
+	}, nil
+}
+
+func runRemove(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("remove", "<input.pdf>")
+	pagesToRemove := fs.String("pagesToRemove", "", "JSON array of the pages to remove (e.g. [2,5])")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *pagesToRemove == "" {
+		return nil, usageErrorf("--pagesToRemove is required")
+	}
+	var pages []int
+	if err := json.Unmarshal([]byte(*pagesToRemove), &pages); err != nil {
+		return nil, usageErrorf("invalid --pagesToRemove: %v", err)
+	}
+	out, err := outputPath(*output, files[0], "removed", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := pdfops.RemovePages(ctx, files[0], out, pdfops.RemovePagesOptions{Pages: pages})
+	if err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":        fmt.Sprintf("Successfully removed %d pages from PDF", removed.RemovedPages),
+		"output":         out,
+		"originalPages":  removed.OriginalPages,
+		"removedPages":   removed.RemovedPages,
+		"resultingPages": removed.ResultingPages,
+	}, nil
+}
+
+func runExtractText(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("extract-text", "<input.pdf>")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(files[0])) != ".pdf" {
+		return nil, usageErrorf("only PDF files are supported")
+	}
+	out, err := outputPath(*output, files[0], "text", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	// The extraction writes the page images next to its input
+	workDir, err := os.MkdirTemp("", "megapdf-extract-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	input := filepath.Join(workDir, "input.pdf")
+	if err := copyFile(files[0], input); err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New().String()
+	data, err := pdfops.ExtractText(ctx, input, workDir, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(out, jsonData, 0644); err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":    fmt.Sprintf("Content extracted successfully from %d pages with %d text blocks and %d images", data.Metadata.TotalPages, data.Metadata.TotalTextBlocks, data.Metadata.TotalImages),
+		"output":     out,
+		"sessionId":  sessionID,
+		"totalPages": data.Metadata.TotalPages,
+		"textBlocks": data.Metadata.TotalTextBlocks,
+		"images":     data.Metadata.TotalImages,
+	}, nil
+}
+
+func runSaveEditedText(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("save-edited-text", "<edited.json>")
+	sessionID := fs.String("sessionId", "", "Session ID printed by extract-text (defaults to a new one)")
+	files, err := inputFiles(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := os.ReadFile(files[0])
+	if err != nil {
+		return nil, err
+	}
+	var data pdfops.TextData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, usageErrorf("invalid edited data in %s: %v", files[0], err)
+	}
+	out, err := outputPath(*output, files[0], "edited", "pdf")
+	if err != nil {
+		return nil, err
+	}
+	if *sessionID == "" {
+		*sessionID = uuid.New().String()
+	}
+
+	workDir, err := os.MkdirTemp("", "megapdf-edit-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := pdfops.SaveEditedText(ctx, &data, out, workDir, *sessionID); err != nil {
+		return nil, err
+	}
+
+	return result{
+		"message":  "PDF saved successfully with improved spacing and preserved images",
+		"output":   out,
+		"fileSize": fileSize(out),
+	}, nil
+}
@@ -0,0 +1,203 @@
+// cmd/megapdf/main.go
+
+// Command megapdf runs the MegaPDF operations on local files without the API
+// server, database or payment provider. Every subcommand mirrors an
+// /api/pdf/* route and accepts the route's form fields as flags:
+//
+//	megapdf compress input.pdf -o small.pdf
+//	megapdf watermark --text DRAFT --position c --opacity 30 input.pdf
+//	megapdf merge --order '[1,0]' a.pdf b.pdf -o merged.pdf
+//	megapdf extract-text input.pdf -o content.json
+//
+// The result is printed to stdout as JSON and the exit code reflects the
+// failure category (see the exit* constants).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/pdfops"
+)
+
+// Exit codes
+const (
+	exitOK           = 0
+	exitFailed       = 1 // The operation failed, e.g. pdfcpu rejected the file
+	exitUsage        = 2 // Unknown command, bad flags or missing arguments
+	exitInvalidInput = 3 // The input file or an option value cannot be processed
+	exitToolMissing  = 4 // A required external tool (pdfcpu, soffice, gs, ...) is not installed
+	exitTimeout      = 5 // The operation timed out or was interrupted
+)
+
+// usageError reports a command line mistake
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// result is the JSON object printed for a successful command
+type result map[string]interface{}
+
+// command is a megapdf subcommand
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) (result, error)
+}
+
+// commands maps subcommand names to their implementation. It is filled in
+// init because the pipeline command runs the other commands.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"compress":         {"Compress a PDF", runCompress},
+		"convert":          {"Convert between PDF, Office, image and text formats", runConvert},
+		"merge":            {"Merge several PDFs into one", runMerge},
+		"split":            {"Split a PDF into several files", runSplit},
+		"rotate":           {"Rotate pages", runRotate},
+		"watermark":        {"Add a text, image or PDF watermark", runWatermark},
+		"sign":             {"Stamp a text or image signature", runSign},
+		"protect":          {"Encrypt a PDF with a password", runProtect},
+		"unlock":           {"Remove the password of a PDF", runUnlock},
+		"pagenumber":       {"Add page numbers", runPageNumber},
+		"remove":           {"Remove pages", runRemove},
+		"extract-text":     {"Extract the editable text and images of a PDF as JSON", runExtractText},
+		"save-edited-text": {"Create a PDF from edited extract-text JSON", runSaveEditedText},
+		"pipeline":         {"Chain merge, watermark, pagenumber and protect", runPipeline},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run executes the command line and returns the exit code
+func run(args []string, stdout io.Writer) int {
+	global := flag.NewFlagSet("megapdf", flag.ContinueOnError)
+	timeout := global.Duration("timeout", 0, "Abort the operation after this duration (e.g. 5m); 0 disables the limit")
+	verbose := global.Bool("verbose", false, "Log the external commands that are executed to stderr")
+	global.Usage = func() { printUsage(global) }
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if global.NArg() == 0 {
+		printUsage(global)
+		return exitUsage
+	}
+
+	name := global.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return writeError(stdout, name, usageErrorf("unknown command %q", name))
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	res, err := cmd.run(ctx, global.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return writeError(stdout, name, err)
+	}
+
+	res["success"] = true
+	res["operation"] = name
+	res["durationMs"] = time.Since(start).Milliseconds()
+	writeJSON(stdout, res)
+	return exitOK
+}
+
+// writeError prints the error as JSON and returns the matching exit code
+func writeError(stdout io.Writer, name string, err error) int {
+	code, category := classify(err)
+	writeJSON(stdout, result{
+		"success":   false,
+		"operation": name,
+		"error":     err.Error(),
+		"category":  category,
+		"exitCode":  code,
+	})
+	return code
+}
+
+// classify maps an error to its exit code and category name
+func classify(err error) (int, string) {
+	var usageErr *usageError
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage, "usage"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return exitTimeout, "timeout"
+	case errors.Is(err, exec.ErrNotFound):
+		return exitToolMissing, "tool_missing"
+	case pdfops.IsInputError(err), errors.As(err, &pathErr):
+		return exitInvalidInput, "invalid_input"
+	default:
+		return exitFailed, "failed"
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func printUsage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintln(out, "Usage: megapdf [--timeout 5m] [--verbose] <command> [flags] <input files>")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-18s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Global flags:")
+	global.PrintDefaults()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'megapdf <command> -h' for the flags of a command.")
+	fmt.Fprintln(out, "Exit codes: 0 ok, 1 failed, 2 usage, 3 invalid input, 4 tool missing, 5 timeout")
+}
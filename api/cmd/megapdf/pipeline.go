@@ -0,0 +1,184 @@
+// cmd/megapdf/pipeline.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxPipelineSteps limits how many operations a single pipeline may chain,
+// the same limit as /api/pdf/pipeline
+const maxPipelineSteps = 10
+
+// pipelineStep is one step of the --steps list
+type pipelineStep struct {
+	Operation string                 `json:"operation"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// pipelineOperations are the commands that can be used as pipeline steps
+var pipelineOperations = map[string]struct {
+	multiInput bool // Combines all current files into one; must be the first step
+	lastOnly   bool // Must be the last step because its output is encrypted
+}{
+	"merge":      {multiInput: true},
+	"watermark":  {},
+	"pagenumber": {},
+	"protect":    {lastOnly: true},
+}
+
+func runPipeline(ctx context.Context, args []string) (result, error) {
+	fs, output := newFlagSet("pipeline", "<input.pdf> [more.pdf...]")
+	stepsJSON := fs.String("steps", "", `JSON array of steps, e.g. [{"operation":"merge"},{"operation":"watermark","params":{"text":"DRAFT"}}]`)
+	files, err := inputFiles(fs, args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []pipelineStep
+	if err := json.Unmarshal([]byte(*stepsJSON), &steps); err != nil {
+		return nil, usageErrorf("invalid --steps: %v", err)
+	}
+	if err := validatePipelineSteps(steps, len(files)); err != nil {
+		return nil, err
+	}
+
+	out, err := outputPath(*output, files[0], "result", "pdf")
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "megapdf-pipeline-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	current := files
+	completed := make([]result, 0, len(steps))
+	for i, step := range steps {
+		stepOutput := filepath.Join(workDir, fmt.Sprintf("step-%d.pdf", i+1))
+		stepArgs := append(stepFlags(step.Params), "-o", stepOutput)
+		stepArgs = append(stepArgs, current...)
+
+		log.Printf("Pipeline: running step %d (%s) on %d file(s)", i+1, step.Operation, len(current))
+
+		stepResult, err := commands[step.Operation].run(ctx, stepArgs)
+		if err != nil {
+			return nil, &stepError{step: i + 1, operation: step.Operation, err: err}
+		}
+
+		delete(stepResult, "output")
+		delete(stepResult, "message")
+		stepResult["step"] = i + 1
+		stepResult["operation"] = step.Operation
+		completed = append(completed, stepResult)
+		current = []string{stepOutput}
+	}
+
+	if err := os.Rename(current[0], out); err != nil {
+		if err := copyFile(current[0], out); err != nil {
+			return nil, fmt.Errorf("failed to store pipeline result: %w", err)
+		}
+	}
+
+	return result{
+		"message": fmt.Sprintf("Pipeline completed with %d steps", len(steps)),
+		"output":  out,
+		"steps":   completed,
+	}, nil
+}
+
+// stepError wraps the error of a failed pipeline step. Unwrap keeps the exit
+// code of the underlying failure.
+type stepError struct {
+	step      int
+	operation string
+	err       error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("step %d (%s) failed: %v", e.step, e.operation, e.err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// validatePipelineSteps checks the step list against the pipeline operations
+func validatePipelineSteps(steps []pipelineStep, fileCount int) error {
+	if len(steps) == 0 {
+		return usageErrorf("at least one step is required")
+	}
+	if len(steps) > maxPipelineSteps {
+		return usageErrorf("a pipeline can have at most %d steps", maxPipelineSteps)
+	}
+
+	for i, step := range steps {
+		operation, ok := pipelineOperations[step.Operation]
+		if !ok {
+			return usageErrorf("step %d: unsupported operation '%s'", i+1, step.Operation)
+		}
+		if operation.multiInput && i != 0 {
+			return usageErrorf("step %d: %s can only be the first step", i+1, step.Operation)
+		}
+		if operation.lastOnly && i != len(steps)-1 {
+			return usageErrorf("step %d: %s can only be the last step", i+1, step.Operation)
+		}
+	}
+
+	first := pipelineOperations[steps[0].Operation]
+	if first.multiInput && fileCount < 2 {
+		return usageErrorf("%s requires at least two files", steps[0].Operation)
+	}
+	if !first.multiInput && fileCount > 1 {
+		return usageErrorf("multiple files require a merge as the first step")
+	}
+
+	return nil
+}
+
+// stepFlags converts step parameters to --key=value flags. Scalars are passed
+// as is, arrays and objects (e.g. the merge order) as JSON.
+func stepFlags(params map[string]interface{}) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	flags := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch typed := params[key].(type) {
+		case nil:
+			continue
+		case string:
+			value = typed
+		case bool, float64:
+			value = fmt.Sprint(typed)
+		default:
+			encoded, err := json.Marshal(typed)
+			if err != nil {
+				continue
+			}
+			value = string(encoded)
+		}
+		flags = append(flags, fmt.Sprintf("--%s=%s", key, value))
+	}
+	return flags
+}
+
+// copyFile copies src to dst, used when the result cannot be renamed across file systems
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -125,6 +125,22 @@ func autoMigrateMySQL(db *gorm.DB) error {
 		&models.OperationsAlert{},
 		&models.PricingSetting{},
 		&models.Setting{},
+		&models.Job{},
+		&models.WebhookDelivery{},
+		&models.RateLimitOverride{},
+		&models.Reservation{},
+		&models.IdempotencyRecord{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.LedgerEntry{},
+		&models.Plan{},
+		&models.Invoice{},
+		&models.InvoiceCounter{},
+		&models.AutoTopUp{},
+		&models.Artifact{},
+		&models.RetentionRun{},
+		&models.Upload{},
+		&models.UploadChunk{},
 	)
 }
 
@@ -150,7 +166,7 @@ func migrateData(srcDB, destDB *gorm.DB) error {
 	}
 
 	// Migrate API Keys
-	if err := migrateTable(srcDB, destDB, "api_keys", &models.ApiKey{}, &[]models.ApiKey{}); err != nil {
+	if err := migrateApiKeys(srcDB, destDB); err != nil {
 		return fmt.Errorf("failed to migrate api keys: %w", err)
 	}
 
@@ -227,6 +243,48 @@ func migrateTable(srcDB, destDB *gorm.DB, tableName string, model interface{}, r
 	return destDB.Table(tableName).CreateInBatches(records, 100).Error
 }
 
+// migrateApiKeys copies the API keys, hashing the plaintext keys of the
+// SQLite schema since only the prefix and a hash are stored in MySQL
+func migrateApiKeys(srcDB, destDB *gorm.DB) error {
+	if !srcDB.Migrator().HasColumn("api_keys", "key") {
+		return migrateTable(srcDB, destDB, "api_keys", &models.ApiKey{}, &[]models.ApiKey{})
+	}
+
+	log.Printf("Migrating api_keys (hashing plaintext keys)...")
+
+	var rows []struct {
+		models.ApiKey
+		Key string
+	}
+	if err := srcDB.Table("api_keys").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	log.Printf("Found %d records in api_keys", len(rows))
+	if len(rows) == 0 {
+		return nil
+	}
+
+	keys := make([]models.ApiKey, 0, len(rows))
+	for _, row := range rows {
+		key := row.ApiKey
+		if row.Key != "" {
+			keyHash, err := models.HashApiKey(row.Key)
+			if err != nil {
+				return err
+			}
+			key.Prefix = models.ApiKeyPrefix(row.Key)
+			key.KeyHash = keyHash
+		}
+		keys = append(keys, key)
+	}
+
+	destDB.Exec("SET FOREIGN_KEY_CHECKS = 0")
+	defer destDB.Exec("SET FOREIGN_KEY_CHECKS = 1")
+
+	return destDB.Table("api_keys").CreateInBatches(&keys, 100).Error
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
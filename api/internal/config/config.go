@@ -2,36 +2,70 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/db"
+	"golang.org/x/crypto/hkdf"
 )
 
+// DefaultJWTSecret is used when JWT_SECRET is not set. It is public, so the
+// API refuses to start with it unless DEBUG is enabled.
+const DefaultJWTSecret = "your-default-secret-key"
+
 // Config holds all the application configuration
 type Config struct {
-	Port               int
-	JWTSecret          string
-	TempDir            string
-	UploadDir          string
-	PublicDir          string
-	PayPalClientID     string
-	PayPalClientSecret string
-	PayPalAPIBase      string
-	SMTPHost           string
-	SMTPPort           int
-	SMTPUser           string
-	SMTPPass           string
-	SMTPSecure         bool
-	EmailFrom          string
-	ContactRecipient   string
-	AppURL             string
-	APIUrl             string
-	Debug              bool
-	GoogleClientID     string
-	GoogleClientSecret string
-	OAuthRedirectURL   string
+	Port                int
+	JWTSecret           string
+	TempDir             string
+	UploadDir           string
+	PublicDir           string
+	PayPalClientID      string
+	PayPalClientSecret  string
+	PayPalAPIBase       string
+	PayPalWebhookID     string // ID of the webhook whose deliveries are verified
+	StripeSecretKey     string // Enables Stripe deposits when set
+	StripeWebhookSecret string
+	StripeAPIBase       string
+	SMTPHost            string
+	SMTPPort            int
+	SMTPUser            string
+	SMTPPass            string
+	SMTPSecure          bool
+	EmailFrom           string
+	ContactRecipient    string
+	AppURL              string
+	WebAppURL           string // Public URL of the web frontend, used in payment return URLs
+	APIUrl              string
+	Debug               bool
+	GoogleClientID      string
+	GoogleClientSecret  string
+	OAuthRedirectURL    string
+	JobWorkers          int
+	WebhookSecret       string // Signs job callbacks, derived from JWTSecret if unset
+	RateLimitStore      string // "memory" or "redis"
+	RedisURL            string
+	TrustedProxies      []string // Proxies whose X-Forwarded-For is used as client IP
+	AllowedOrigins      []string // Origins of the web frontends (CORS and web sessions)
+	WebSessionSecret    string   // HMAC key of the anonymous web session tokens, derived from JWTSecret if unset
+	WebSessionTTL       int      // Seconds a web session token is valid
+	WebSessionQuota     int      // Operations per client IP and session lifetime
+	WebSessionIssueMax  int      // Session tokens a client IP may request per hour
+	ReservationTimeout  int      // Seconds an operation charge is held before it is released
+	StorageBackend      string   // Where results are stored: "local" (PublicDir) or "s3"
+	S3Endpoint          string   // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	S3Region            string
+	S3Bucket            string
+	S3AccessKeyID       string
+	S3SecretAccessKey   string
+	S3PathStyle         bool   // Address the bucket in the URL path, as MinIO needs
+	DownloadURLSecret   string // HMAC key of the signed download links, derived from JWTSecret if unset
+	FileURLTimeout      int    // Seconds a fileUrl input may take to download
 	// DB Config
 	DBHost            string
 	DBPort            int
@@ -54,30 +88,70 @@ func LoadConfig() *Config {
 	dbMaxIdleConns, _ := strconv.Atoi(getEnv("DB_MAX_IDLE_CONNS", "10"))
 	dbMaxOpenConns, _ := strconv.Atoi(getEnv("DB_MAX_OPEN_CONNS", "100"))
 	dbConnMaxLifetime := getEnv("DB_CONN_MAX_LIFETIME", "1h")
+	jobWorkers, _ := strconv.Atoi(getEnv("JOB_WORKERS", "4"))
+	webSessionTTL, _ := strconv.Atoi(getEnv("WEB_SESSION_TTL", "1800"))
+	webSessionQuota, _ := strconv.Atoi(getEnv("WEB_SESSION_QUOTA", "20"))
+	webSessionIssueMax, _ := strconv.Atoi(getEnv("WEB_SESSION_ISSUE_MAX", "10"))
+	reservationTimeout, _ := strconv.Atoi(getEnv("RESERVATION_TIMEOUT", "3600"))
+	fileURLTimeout, _ := strconv.Atoi(getEnv("FILE_URL_TIMEOUT", "60"))
+	jwtSecret := getEnv("JWT_SECRET", DefaultJWTSecret)
+
+	allowedOrigins := GetEnvAsSlice("ALLOWED_ORIGINS", "https://mega-pdf.com,https://www.mega-pdf.com,https://admin.mega-pdf.com,http://localhost:3000,http://localhost:3001")
+	if appURL := os.Getenv("NEXT_PUBLIC_APP_URL"); appURL != "" {
+		allowedOrigins = append(allowedOrigins, appURL)
+	}
+	if adminURL := os.Getenv("ADMIN_URL"); adminURL != "" {
+		allowedOrigins = append(allowedOrigins, adminURL)
+	}
 
 	return &Config{
 		Port: port,
 
-		JWTSecret:          getEnv("JWT_SECRET", "your-default-secret-key"),
-		TempDir:            getEnv("TEMP_DIR", "temp"),
-		UploadDir:          getEnv("UPLOAD_DIR", "uploads"),
-		PublicDir:          getEnv("PUBLIC_DIR", "public"),
-		PayPalClientID:     getEnv("PAYPAL_CLIENT_ID", ""),
-		PayPalClientSecret: getEnv("PAYPAL_CLIENT_SECRET", ""),
-		PayPalAPIBase:      getEnv("PAYPAL_API_BASE", "https://api-m.sandbox.paypal.com"),
-		SMTPHost:           getEnv("SMTP_HOST", ""),
-		SMTPPort:           smtpPort,
-		SMTPUser:           getEnv("SMTP_USER", ""),
-		SMTPPass:           getEnv("SMTP_PASS", ""),
-		SMTPSecure:         getEnv("SMTP_SECURE", "false") == "true",
-		EmailFrom:          getEnv("EMAIL_FROM", "noreply@mega-pdf.com"),
-		ContactRecipient:   getEnv("CONTACT_RECIPIENT_EMAIL", ""),
-		AppURL:             getEnv("APP_URL", "http://localhost:8080"),
-		APIUrl:             getEnv("API_URL", "http://localhost:8080"),
-		Debug:              getEnv("DEBUG", "false") == "true",
-		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
-		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
-		OAuthRedirectURL:   getEnv("OAUTH_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
+		JWTSecret:           jwtSecret,
+		TempDir:             getEnv("TEMP_DIR", "temp"),
+		UploadDir:           getEnv("UPLOAD_DIR", "uploads"),
+		PublicDir:           getEnv("PUBLIC_DIR", "public"),
+		PayPalClientID:      getEnv("PAYPAL_CLIENT_ID", ""),
+		PayPalClientSecret:  getEnv("PAYPAL_CLIENT_SECRET", ""),
+		PayPalAPIBase:       getEnv("PAYPAL_API_BASE", "https://api-m.sandbox.paypal.com"),
+		PayPalWebhookID:     getEnv("PAYPAL_WEBHOOK_ID", ""),
+		StripeSecretKey:     getEnv("STRIPE_SECRET_KEY", ""),
+		StripeWebhookSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		StripeAPIBase:       getEnv("STRIPE_API_BASE", "https://api.stripe.com"),
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            smtpPort,
+		SMTPUser:            getEnv("SMTP_USER", ""),
+		SMTPPass:            getEnv("SMTP_PASS", ""),
+		SMTPSecure:          getEnv("SMTP_SECURE", "false") == "true",
+		EmailFrom:           getEnv("EMAIL_FROM", "noreply@mega-pdf.com"),
+		ContactRecipient:    getEnv("CONTACT_RECIPIENT_EMAIL", ""),
+		AppURL:              getEnv("APP_URL", "http://localhost:8080"),
+		WebAppURL:           getEnv("NEXT_PUBLIC_APP_URL", ""),
+		APIUrl:              getEnv("API_URL", "http://localhost:8080"),
+		Debug:               getEnv("DEBUG", "false") == "true",
+		GoogleClientID:      getEnv("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret:  getEnv("GOOGLE_CLIENT_SECRET", ""),
+		OAuthRedirectURL:    getEnv("OAUTH_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
+		JobWorkers:          jobWorkers,
+		WebhookSecret:       getEnv("WEBHOOK_SECRET", deriveSecret(jwtSecret, "webhook")),
+		RateLimitStore:      getEnv("RATE_LIMIT_STORE", "memory"),
+		RedisURL:            getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		TrustedProxies:      GetEnvAsSlice("TRUSTED_PROXIES", "127.0.0.1,::1"),
+		AllowedOrigins:      allowedOrigins,
+		WebSessionSecret:    getEnv("WEB_SESSION_SECRET", deriveSecret(jwtSecret, "web-session")),
+		WebSessionTTL:       webSessionTTL,
+		WebSessionQuota:     webSessionQuota,
+		WebSessionIssueMax:  webSessionIssueMax,
+		ReservationTimeout:  reservationTimeout,
+		StorageBackend:      getEnv("STORAGE_BACKEND", "local"),
+		S3Endpoint:          getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:            getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:            getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:       getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:   getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3PathStyle:         getEnv("S3_PATH_STYLE", "false") == "true",
+		DownloadURLSecret:   getEnv("DOWNLOAD_URL_SECRET", deriveSecret(jwtSecret, "download-url")),
+		FileURLTimeout:      fileURLTimeout,
 
 		// Database config
 		DBHost:            getEnv("DB_HOST", "127.0.0.1"),
@@ -94,12 +168,46 @@ func LoadConfig() *Config {
 	}
 }
 
+// Validate reports configuration that is unsafe to run with. Outside debug
+// mode the JWT secret, from which unset secrets are derived, must not be the
+// public default.
+func (c *Config) Validate() error {
+	if !c.Debug && c.JWTSecret == DefaultJWTSecret {
+		return errors.New("JWT_SECRET must be set to a private value outside debug mode")
+	}
+	return nil
+}
+
+// deriveSecret derives the secret of one purpose from the JWT secret with
+// HKDF-SHA256, so a secret leaked from one purpose does not expose the others
+func deriveSecret(jwtSecret, purpose string) string {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(jwtSecret), nil, []byte("megapdf "+purpose)), secret); err != nil {
+		panic(err) // Only fails when asking for more than 255 hashes of output
+	}
+	return hex.EncodeToString(secret)
+}
+
 // InitDB initializes the database with the config settings
 func InitDB() error {
 	_, err := db.InitDB()
 	return err
 }
 
+// OriginAllowed reports whether origin is one of the allowed origins of the
+// web frontends
+func (c *Config) OriginAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowedOrigin := range c.AllowedOrigins {
+		if origin == strings.TrimRight(strings.TrimSpace(allowedOrigin), "/") {
+			return true
+		}
+	}
+	return false
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
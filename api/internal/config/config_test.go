@@ -0,0 +1,69 @@
+// internal/config/config_test.go
+package config
+
+import "testing"
+
+func TestDeriveSecret(t *testing.T) {
+	webSession := deriveSecret("jwt-secret", "web-session")
+
+	if len(webSession) != 64 {
+		t.Errorf("derived secret has %d hex characters, want 64", len(webSession))
+	}
+	if deriveSecret("jwt-secret", "web-session") != webSession {
+		t.Error("derivation is not deterministic")
+	}
+	if deriveSecret("jwt-secret", "webhook") == webSession {
+		t.Error("purposes share a secret")
+	}
+	if deriveSecret("other-jwt-secret", "web-session") == webSession {
+		t.Error("secret does not depend on the JWT secret")
+	}
+	if webSession == "jwt-secret" {
+		t.Error("secret is the JWT secret")
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("WEB_SESSION_SECRET", "")
+	t.Setenv("WEBHOOK_SECRET", "")
+	t.Setenv("DOWNLOAD_URL_SECRET", "")
+
+	cfg := LoadConfig()
+	if cfg.WebSessionSecret != deriveSecret("jwt-secret", "web-session") {
+		t.Error("unset web session secret is not derived from the JWT secret")
+	}
+	if cfg.WebhookSecret != deriveSecret("jwt-secret", "webhook") {
+		t.Error("unset webhook secret is not derived from the JWT secret")
+	}
+	if cfg.DownloadURLSecret != deriveSecret("jwt-secret", "download-url") {
+		t.Error("unset download URL secret is not derived from the JWT secret")
+	}
+
+	t.Setenv("WEB_SESSION_SECRET", "session-secret")
+	if cfg := LoadConfig(); cfg.WebSessionSecret != "session-secret" {
+		t.Errorf("WebSessionSecret = %q, want the configured secret", cfg.WebSessionSecret)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		debug   bool
+		wantErr bool
+	}{
+		{"default secret in production", DefaultJWTSecret, false, true},
+		{"default secret in debug mode", DefaultJWTSecret, true, false},
+		{"private secret in production", "a-private-secret", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{JWTSecret: tt.secret, Debug: tt.debug}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
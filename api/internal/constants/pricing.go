@@ -27,6 +27,10 @@ var APIOperations = []string{
 	"organize",
 	"chat",
 	"remove",
+	"pagenumber",
+	"pipeline",
+	"extract-text",
+	"save-edited-text",
 	"ExtractText",
 	"ApplyTextEdits",
 }
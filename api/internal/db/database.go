@@ -36,11 +36,14 @@ func createIndexes(db *gorm.DB) error {
 			"api_keys": {
 				"user_id",
 				"last_used",
+				"expires_at",
 			},
 			"transactions": {
 				"user_id",
 				"status",
 				"payment_id",
+				"actor_id",
+				"refund_of_id",
 			},
 			"usage_stats": {
 				"user_id",
@@ -61,6 +64,10 @@ func createIndexes(db *gorm.DB) error {
 			"settings": {
 				"category",
 			},
+			"jobs": {
+				"user_id",
+				"status",
+			},
 		}
 
 		// Create single column indexes
@@ -102,7 +109,9 @@ func createIndexes(db *gorm.DB) error {
 			{"usage_stats", "idx_usage_stats_user_op_date", "user_id, operation, date"},
 			{"accounts", "idx_accounts_provider_id", "provider, provider_account_id"},
 			{"settings", "idx_settings_category_key", "category, `key`"},
-			{"api_keys", "idx_api_keys_key", "`key`"},
+			{"api_keys", "idx_api_keys_prefix", "prefix"},
+			{"jobs", "idx_jobs_status_created", "status, created_at"},
+			{"reservations", "idx_reservations_status_expires", "status, expires_at"},
 		}
 
 		for _, idx := range compositeIndexes {
@@ -186,6 +195,10 @@ func InitDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
+	// Tables added after the initial schema are migrated on startup
+	if err := migrateAdditionalTables(db); err != nil {
+		return nil, err
+	}
 	if err := createIndexes(db); err != nil {
 		// Log the error but don't fail initialization
 		fmt.Printf("WARNING: Some database indexes could not be created: %v\n", err)
@@ -238,11 +251,190 @@ func InitDB() (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to initialize default pricing: %w", err)
 	}
 
+	// Initialize default plans
+	if err := initializeDefaultPlans(db); err != nil {
+		return nil, fmt.Errorf("failed to initialize default plans: %w", err)
+	}
+
 	// Store DB in package variable for global access
 	DB = db
 	fmt.Println("Database initialized successfully!")
 	return db, nil
 }
+
+// migrateAdditionalTables creates tables that are not part of the initial schema
+func migrateAdditionalTables(db *gorm.DB) error {
+	fmt.Println("Migrating additional tables...")
+	if err := db.AutoMigrate(
+		&models.Job{},
+		&models.WebhookDelivery{},
+		&models.RateLimitOverride{},
+		&models.Reservation{},
+		&models.IdempotencyRecord{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.LedgerEntry{},
+		&models.Plan{},
+		&models.Invoice{},
+		&models.InvoiceCounter{},
+		&models.AutoTopUp{},
+		&models.Artifact{},
+		&models.RetentionRun{},
+		&models.Upload{},
+		&models.UploadChunk{},
+	); err != nil {
+		return fmt.Errorf("failed to migrate additional tables: %w", err)
+	}
+
+	// Columns added to tables of the initial schema
+	additionalColumns := []struct {
+		model interface{}
+		field string
+	}{
+		{&models.ApiKey{}, "Scopes"},
+		{&models.ApiKey{}, "Prefix"},
+		{&models.ApiKey{}, "KeyHash"},
+		{&models.ApiKey{}, "AllowedIPs"},
+		{&models.ApiKey{}, "ReplacedByID"},
+		{&models.ApiKey{}, "ExpiryNotifiedAt"},
+		{&models.User{}, "PlanID"},
+		{&models.User{}, "DailySpendCap"},
+		{&models.User{}, "MonthlySpendCap"},
+		{&models.User{}, "DailyOperationCap"},
+		{&models.User{}, "MonthlyOperationCap"},
+		{&models.ApiKey{}, "DailySpendCap"},
+		{&models.ApiKey{}, "MonthlySpendCap"},
+		{&models.ApiKey{}, "DailyOperationCap"},
+		{&models.ApiKey{}, "MonthlyOperationCap"},
+		{&models.Transaction{}, "Provider"},
+		{&models.Transaction{}, "ReasonCode"},
+		{&models.Transaction{}, "ActorID"},
+		{&models.Transaction{}, "RefundOfID"},
+		{&models.PaymentWebhookEvent{}, "Status"},
+		{&models.PaymentWebhookEvent{}, "ProcessedEventId"},
+		{&models.PaymentWebhookEvent{}, "Provider"},
+		{&models.OperationsAlert{}, "ApiKeyID"},
+		{&models.OperationsAlert{}, "Cap"},
+		{&models.OperationsAlert{}, "Threshold"},
+		{&models.OperationsAlert{}, "AlertKey"},
+	}
+	for _, column := range additionalColumns {
+		if db.Migrator().HasColumn(column.model, column.field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(column.model, column.field); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", column.field, err)
+		}
+	}
+
+	// Unique indexes of added columns, which AddColumn does not create
+	uniqueIndexes := []struct {
+		model interface{}
+		field string
+	}{
+		{&models.PaymentWebhookEvent{}, "ProcessedEventId"},
+		{&models.OperationsAlert{}, "AlertKey"},
+	}
+	for _, index := range uniqueIndexes {
+		if db.Migrator().HasIndex(index.model, index.field) {
+			continue
+		}
+		if err := db.Migrator().CreateIndex(index.model, index.field); err != nil {
+			return fmt.Errorf("failed to create index on %s: %w", index.field, err)
+		}
+	}
+
+	// Held payment webhook events were keyed by their event ID alone, which
+	// is unique per provider only. Events without a provider are PayPal's.
+	if err := db.Exec("UPDATE payment_webhook_events " +
+		"SET processed_event_id = CONCAT(COALESCE(NULLIF(provider, ''), 'paypal'), ':', processed_event_id) " +
+		"WHERE processed_event_id = event_id").Error; err != nil {
+		return fmt.Errorf("failed to key payment webhook events by provider: %w", err)
+	}
+
+	// Columns no longer stored. Webhook response bodies could expose
+	// internal content, so the kept ones are dropped.
+	removedColumns := []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.WebhookDelivery{}, "response_body"},
+	}
+	for _, column := range removedColumns {
+		if !db.Migrator().HasColumn(column.model, column.name) {
+			continue
+		}
+		if err := db.Migrator().DropColumn(column.model, column.name); err != nil {
+			return fmt.Errorf("failed to drop column %s: %w", column.name, err)
+		}
+	}
+
+	// Money columns widened to the 3 decimals of operation costs
+	widenedColumns := []struct {
+		model interface{}
+		field string
+	}{
+		{&models.Transaction{}, "Amount"},
+		{&models.Transaction{}, "BalanceAfter"},
+	}
+	columnTypes, err := db.Migrator().ColumnTypes(&models.Transaction{})
+	if err != nil {
+		return fmt.Errorf("failed to read transaction columns: %w", err)
+	}
+	for _, column := range widenedColumns {
+		name := db.NamingStrategy.ColumnName("", column.field)
+		for _, columnType := range columnTypes {
+			if columnType.Name() != name {
+				continue
+			}
+			if _, scale, ok := columnType.DecimalSize(); ok && scale != 3 {
+				if err := db.Migrator().AlterColumn(column.model, column.field); err != nil {
+					return fmt.Errorf("failed to alter column %s: %w", column.field, err)
+				}
+			}
+		}
+	}
+
+	return hashPlaintextApiKeys(db)
+}
+
+// hashPlaintextApiKeys replaces the plaintext keys of the initial schema by
+// their prefix and hash, then drops the plaintext column
+func hashPlaintextApiKeys(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.ApiKey{}, "key") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID  string
+			Key string
+		}
+		if err := tx.Table("api_keys").Select("id, `key`").Where("`key` IS NOT NULL AND `key` <> ''").Scan(&rows).Error; err != nil {
+			return fmt.Errorf("failed to read plaintext API keys: %w", err)
+		}
+
+		fmt.Printf("Hashing %d plaintext API keys...\n", len(rows))
+		for _, row := range rows {
+			keyHash, err := models.HashApiKey(row.Key)
+			if err != nil {
+				return err
+			}
+			if err := tx.Table("api_keys").Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"prefix":   models.ApiKeyPrefix(row.Key),
+				"key_hash": keyHash,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to hash API key %s: %w", row.ID, err)
+			}
+		}
+
+		if err := tx.Migrator().DropColumn(&models.ApiKey{}, "key"); err != nil {
+			return fmt.Errorf("failed to drop plaintext API key column: %w", err)
+		}
+		return nil
+	})
+}
+
 func initializePDFToolsSettings(db *gorm.DB) error {
 	// Check if settings already exist
 	var count int64
@@ -495,6 +687,68 @@ func initializeDefaultPricing(db *gorm.DB) error {
 	return nil
 }
 
+// initializeDefaultPlans creates the free and pro plans if no plan exists.
+// The free plan keeps the monthly free operations of the pricing settings.
+func initializeDefaultPlans(db *gorm.DB) error {
+	fmt.Println("Initializing default plans...")
+
+	var planCount int64
+	if err := db.Model(&models.Plan{}).Count(&planCount).Error; err != nil {
+		return err
+	}
+
+	if planCount > 0 {
+		fmt.Println("Plans already exist, skipping initialization")
+		return nil
+	}
+
+	freeOperations := constants.FreeOperationsMonthly
+	var pricingSetting models.PricingSetting
+	if err := db.Where("`key` = ?", "pricing_settings").First(&pricingSetting).Error; err == nil {
+		var pricing models.CustomPricing
+		if err := json.Unmarshal([]byte(pricingSetting.Value), &pricing); err == nil {
+			freeOperations = pricing.FreeOperationsMonthly
+		}
+	}
+
+	now := time.Now()
+	plans := []models.Plan{
+		{
+			ID:                 uuid.New().String(),
+			Code:               "free",
+			Name:               "Free",
+			Description:        "Monthly free operations, then pay as you go from your balance",
+			IncludedOperations: freeOperations,
+			MaxFileSize:        50,
+			MaxApiKeys:         1,
+			IsDefault:          true,
+			Active:             true,
+			CreatedAt:          now,
+			UpdatedAt:          now,
+		},
+		{
+			ID:                 uuid.New().String(),
+			Code:               "pro",
+			Name:               "Pro",
+			Description:        "More included operations, larger files and more API keys",
+			MonthlyPrice:       9.99,
+			IncludedOperations: 5000,
+			MaxFileSize:        200,
+			MaxApiKeys:         10,
+			Active:             true,
+			CreatedAt:          now,
+			UpdatedAt:          now,
+		},
+	}
+
+	if err := db.Create(&plans).Error; err != nil {
+		return fmt.Errorf("failed to create plans: %w", err)
+	}
+
+	fmt.Println("Default plans created successfully")
+	return nil
+}
+
 // TransactionFunc is a type for database transaction functions
 type TransactionFunc func(tx *gorm.DB) error
 
@@ -0,0 +1,152 @@
+// internal/handlers/adjustment_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdjustmentHandler lets admins credit, debit and refund user balances. Each
+// change is recorded as a transaction with its reason code and the admin who
+// made it.
+type AdjustmentHandler struct {
+	balanceService *services.BalanceService
+	paymentService *services.PaymentService
+}
+
+// NewAdjustmentHandler creates a new adjustment handler
+func NewAdjustmentHandler(balanceService *services.BalanceService, paymentService *services.PaymentService) *AdjustmentHandler {
+	return &AdjustmentHandler{
+		balanceService: balanceService,
+		paymentService: paymentService,
+	}
+}
+
+// CreateAdjustment godoc
+// @Summary Adjust a user's balance
+// @Description Credits (positive amount) or debits (negative amount) a user's balance. reasonCode is credit or goodwill for credits, or correction for either direction. Debits that would make the balance negative are refused unless allowNegativeBalance is set.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param body body object{amount=number,reasonCode=string,description=string,allowNegativeBalance=boolean} true "Adjustment"
+// @Security BearerAuth
+// @Success 201 {object} object{success=boolean,transaction=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/users/{id}/adjustments [post]
+func (h *AdjustmentHandler) CreateAdjustment(c *gin.Context) {
+	var req struct {
+		Amount               float64 `json:"amount" binding:"required"`
+		ReasonCode           string  `json:"reasonCode" binding:"required"`
+		Description          string  `json:"description"`
+		AllowNegativeBalance bool    `json:"allowNegativeBalance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	transaction, err := h.balanceService.Adjust(c.Param("id"), services.Adjustment{
+		Amount:        req.Amount,
+		ReasonCode:    req.ReasonCode,
+		Description:   req.Description,
+		ActorID:       c.GetString("userId"),
+		AllowNegative: req.AllowNegativeBalance,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAdjustment):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNegativeBalance):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; set allowNegativeBalance to override"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust balance: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "transaction": adjustmentJSON(transaction)})
+}
+
+// RefundTransaction godoc
+// @Summary Refund a deposit
+// @Description Pays a completed deposit, or part of it, back through its payment provider and takes the refund from the user's balance. amount defaults to what is left of the deposit. Refunds that would make the balance negative are refused unless allowNegativeBalance is set; skipProvider records a refund already made at the provider.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Deposit transaction ID"
+// @Param body body object{amount=number,description=string,allowNegativeBalance=boolean,skipProvider=boolean} false "Refund"
+// @Security BearerAuth
+// @Success 201 {object} object{success=boolean,transaction=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/transactions/{id}/refund [post]
+func (h *AdjustmentHandler) RefundTransaction(c *gin.Context) {
+	var req struct {
+		Amount               float64 `json:"amount"`
+		Description          string  `json:"description"`
+		AllowNegativeBalance bool    `json:"allowNegativeBalance"`
+		SkipProvider         bool    `json:"skipProvider"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be positive"})
+		return
+	}
+
+	transaction, err := h.paymentService.RefundDeposit(c.Param("id"), services.Refund{
+		Amount:        req.Amount,
+		Description:   req.Description,
+		ActorID:       c.GetString("userId"),
+		AllowNegative: req.AllowNegativeBalance,
+		SkipProvider:  req.SkipProvider,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDepositNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		case errors.Is(err, services.ErrRefundNotAllowed), errors.Is(err, services.ErrUnknownPaymentProvider):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNegativeBalance):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; set allowNegativeBalance to override"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund deposit: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "transaction": adjustmentJSON(transaction)})
+}
+
+// adjustmentJSON formats a transaction made by an admin for responses
+func adjustmentJSON(transaction *models.Transaction) gin.H {
+	return gin.H{
+		"id":           transaction.ID,
+		"userId":       transaction.UserID,
+		"amount":       transaction.Amount,
+		"balanceAfter": transaction.BalanceAfter,
+		"description":  transaction.Description,
+		"status":       transaction.Status,
+		"reasonCode":   transaction.ReasonCode,
+		"actorId":      transaction.ActorID,
+		"refundOfId":   transaction.RefundOfID,
+		"createdAt":    transaction.CreatedAt,
+	}
+}
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -577,6 +578,8 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		Role               *string  `json:"role"`
 		Balance            *float64 `json:"balance"`
 		FreeOperationsUsed *int     `json:"freeOperationsUsed"`
+		// Lets the balance be set below zero
+		AllowNegativeBalance bool `json:"allowNegativeBalance"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -603,29 +606,15 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		updates["role"] = *req.Role
 	}
 	if req.Balance != nil {
-		// Record a transaction for the balance change
-		oldBalance := user.Balance
-		newBalance := *req.Balance
-
-		if newBalance != oldBalance {
-			// Create transaction record
-			transaction := models.Transaction{
-				ID:           models.GenerateID(),
-				UserID:       user.ID,
-				Amount:       newBalance - oldBalance,
-				BalanceAfter: newBalance,
-				Description:  "Admin balance adjustment",
-				Status:       "completed",
-				CreatedAt:    time.Now(),
-			}
-
-			if err := db.DB.Create(&transaction).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction"})
+		// The balance change is recorded as a transaction and in the ledger
+		if _, err := services.NewBalanceService(db.DB).AdjustBalance(user.ID, *req.Balance, c.GetString("userId"), req.AllowNegativeBalance); err != nil {
+			if errors.Is(err, services.ErrNegativeBalance) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; set allowNegativeBalance to override"})
 				return
 			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance: " + err.Error()})
+			return
 		}
-
-		updates["balance"] = newBalance
 	}
 	if req.FreeOperationsUsed != nil {
 		updates["free_operations_used"] = *req.FreeOperationsUsed
@@ -892,7 +881,10 @@ func (h *AdminHandler) GetTransactions(c *gin.Context) {
 	} else if typeFilter == "operation" {
 		query = query.Where("transactions.amount < 0 AND transactions.description LIKE 'Operation: %'")
 	} else if typeFilter == "adjustment" {
-		query = query.Where("transactions.description LIKE 'Admin %'")
+		query = query.Where("transactions.reason_code IN ? OR transactions.description LIKE 'Admin %'",
+			[]string{models.TransactionReasonCredit, models.TransactionReasonGoodwill, models.TransactionReasonCorrection})
+	} else if typeFilter == "refund" {
+		query = query.Where("transactions.reason_code = ?", models.TransactionReasonRefund)
 	}
 
 	// Apply status filter
@@ -938,44 +930,23 @@ func (h *AdminHandler) GetTransactions(c *gin.Context) {
 		BalanceAfter float64   `json:"balanceAfter"`
 		Description  string    `json:"description"`
 		Status       string    `json:"status"`
+		ReasonCode   string    `json:"reasonCode,omitempty"`
+		ActorID      *string   `json:"actorId,omitempty"`
+		RefundOfID   *string   `json:"refundOfId,omitempty"`
 		CreatedAt    time.Time `json:"createdAt"`
 	}
 
-	var transactions []TransactionWithUser
-	rows, err := query.Select("transactions.*, users.name as user_name, users.email as user_email").
+	transactions := []TransactionWithUser{}
+	if err := query.Select("transactions.id, transactions.user_id, users.name AS user_name, users.email AS user_email, " +
+		"transactions.amount, transactions.balance_after, transactions.description, transactions.status, " +
+		"transactions.reason_code, transactions.actor_id, transactions.refund_of_id, transactions.created_at").
 		Order("transactions.created_at DESC").
 		Limit(pageSize).
 		Offset(offset).
-		Rows()
-
-	if err != nil {
+		Scan(&transactions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transactions"})
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var tx TransactionWithUser
-		var id, userId, description, status, userName, userEmail string
-		var amount, balanceAfter float64
-		var createdAt time.Time
-
-		rows.Scan(&id, &userId, &amount, &balanceAfter, &description, &status, &createdAt, &userName, &userEmail)
-
-		tx = TransactionWithUser{
-			ID:           id,
-			UserID:       userId,
-			UserName:     userName,
-			UserEmail:    userEmail,
-			Amount:       amount,
-			BalanceAfter: balanceAfter,
-			Description:  description,
-			Status:       status,
-			CreatedAt:    createdAt,
-		}
-
-		transactions = append(transactions, tx)
-	}
 
 	// Calculate statistics for the current filters
 	var stats gin.H
@@ -983,7 +954,8 @@ func (h *AdminHandler) GetTransactions(c *gin.Context) {
 	// Calculate total income
 	var totalIncome, totalExpenses float64
 	db.DB.Model(&models.Transaction{}).Where("amount > 0").Select("COALESCE(SUM(amount), 0)").Row().Scan(&totalIncome)
-	db.DB.Model(&models.Transaction{}).Where("amount < 0").Select("COALESCE(SUM(amount), 0)").Row().Scan(&totalExpenses)
+	// Released and expired operation holds were given back and are not expenses
+	db.DB.Model(&models.Transaction{}).Where("amount < 0 AND status = ?", models.TransactionStatusCompleted).Select("COALESCE(SUM(amount), 0)").Row().Scan(&totalExpenses)
 
 	// Calculate deposit stats
 	var depositCount int64
@@ -999,13 +971,13 @@ func (h *AdminHandler) GetTransactions(c *gin.Context) {
 	// Count operations today
 	var operationsToday int64
 	db.DB.Model(&models.Transaction{}).
-		Where("amount < 0 AND created_at >= ? AND description LIKE 'Operation: %'", time.Now().Truncate(24*time.Hour)).
+		Where("amount < 0 AND created_at >= ? AND description LIKE 'Operation: %' AND status = ?", time.Now().Truncate(24*time.Hour), models.TransactionStatusCompleted).
 		Count(&operationsToday)
 
 	// Count total operations
 	var totalOperations int64
 	db.DB.Model(&models.Transaction{}).
-		Where("amount < 0 AND description LIKE 'Operation: %'").
+		Where("amount < 0 AND description LIKE 'Operation: %' AND status = ?", models.TransactionStatusCompleted).
 		Count(&totalOperations)
 
 	stats = gin.H{
@@ -1031,6 +1003,22 @@ func (h *AdminHandler) GetTransactions(c *gin.Context) {
 	})
 }
 
+// GetLedgerReport reconciles the user balances against the ledger and reports
+// any drift (admin function)
+func (h *AdminHandler) GetLedgerReport(c *gin.Context) {
+	report, err := services.NewLedgerService(db.DB).Reconcile()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile ledger: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"consistent": len(report.Drift) == 0 && len(report.UnbalancedJournals) == 0 && report.TrialBalance.IsZero(),
+		"report":     report,
+	})
+}
+
 // GetActivityLogs returns system activity logs for admin viewing
 func (h *AdminHandler) GetActivityLogs(c *gin.Context) {
 	// Parse query parameters
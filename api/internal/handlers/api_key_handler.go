@@ -2,8 +2,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
 	"github.com/MegaPDF/megapdf-official/api/internal/services"
 	"github.com/gin-gonic/gin"
 )
@@ -38,14 +41,7 @@ func (h *ApiKeyHandler) ListKeys(c *gin.Context) {
 	// Mask API key values for security
 	maskedKeys := []gin.H{}
 	for _, key := range keys {
-		maskedKeys = append(maskedKeys, gin.H{
-			"id":        key.ID,
-			"name":      key.Name,
-			"key":       maskApiKey(key.Key),
-			"lastUsed":  key.LastUsed,
-			"expiresAt": key.ExpiresAt,
-			"createdAt": key.CreatedAt,
-		})
+		maskedKeys = append(maskedKeys, apiKeyResponse(key))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -65,8 +61,10 @@ func (h *ApiKeyHandler) CreateKey(c *gin.Context) {
 
 	// Parse request body
 	var requestBody struct {
-		Name string `json:"name" binding:"required"`
-		// Permissions removed
+		Name       string     `json:"name" binding:"required"`
+		Scopes     []string   `json:"scopes"`     // Operations the key may use; empty allows all
+		AllowedIPs []string   `json:"allowedIps"` // IP addresses or CIDR ranges; empty allows all
+		ExpiresAt  *time.Time `json:"expiresAt"`  // RFC 3339; omitted for a key that does not expire
 	}
 
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
@@ -76,10 +74,10 @@ func (h *ApiKeyHandler) CreateKey(c *gin.Context) {
 		return
 	}
 
-	// Create the API key - pass an empty slice for permissions
-	apiKey, err := h.service.CreateKey(userID.(string), requestBody.Name, []string{})
+	// Create the API key
+	apiKey, fullKey, err := h.service.CreateKey(userID.(string), requestBody.Name, requestBody.Scopes, requestBody.AllowedIPs, requestBody.ExpiresAt)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
+		statusCode := apiKeyErrorStatus(err)
 		if err.Error() == "API key limit reached" {
 			statusCode = http.StatusForbidden
 		}
@@ -90,14 +88,135 @@ func (h *ApiKeyHandler) CreateKey(c *gin.Context) {
 		return
 	}
 
+	// The full key is only revealed once, it is stored hashed
+	response := apiKeyResponse(*apiKey)
+	response["key"] = fullKey
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"key": gin.H{
-			"id":        apiKey.ID,
-			"name":      apiKey.Name,
-			"key":       apiKey.Key, // Return full key only on creation
-			"createdAt": apiKey.CreatedAt,
-		},
+		"key":     response,
+	})
+}
+
+func (h *ApiKeyHandler) UpdateKey(c *gin.Context) {
+	// Get user ID from context
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized",
+		})
+		return
+	}
+
+	// Get key ID from path
+	keyID := c.Param("id")
+	if keyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "API key ID is required",
+		})
+		return
+	}
+
+	// Omitted fields are left unchanged; "scopes": [] allows every operation
+	// and "allowedIps": [] every address. "caps" replaces all spending caps of
+	// the key, 0 removes a cap.
+	var requestBody struct {
+		Name       *string              `json:"name"`
+		Scopes     *[]string            `json:"scopes"`
+		AllowedIPs *[]string            `json:"allowedIps"`
+		Caps       *models.SpendingCaps `json:"caps"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	var scopes, allowedIPs []string
+	if requestBody.Scopes != nil {
+		scopes = append([]string{}, *requestBody.Scopes...)
+	}
+	if requestBody.AllowedIPs != nil {
+		allowedIPs = append([]string{}, *requestBody.AllowedIPs...)
+	}
+
+	apiKey, err := h.service.UpdateKey(keyID, userID.(string), requestBody.Name, scopes, allowedIPs, requestBody.Caps)
+	if err != nil {
+		statusCode := apiKeyErrorStatus(err)
+		if err.Error() == "name must not be empty" {
+			statusCode = http.StatusBadRequest
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": "Failed to update API key: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"key":     apiKeyResponse(*apiKey),
+	})
+}
+
+func (h *ApiKeyHandler) RotateKey(c *gin.Context) {
+	// Get user ID from context
+	userID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized",
+		})
+		return
+	}
+
+	// Get key ID from path
+	keyID := c.Param("id")
+	if keyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "API key ID is required",
+		})
+		return
+	}
+
+	// Both fields are optional and the body may be empty
+	var requestBody struct {
+		GracePeriod *int       `json:"gracePeriod"` // Seconds the old key keeps working
+		ExpiresAt   *time.Time `json:"expiresAt"`   // Expiry of the new key
+	}
+
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request body: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	var gracePeriod *time.Duration
+	if requestBody.GracePeriod != nil {
+		grace := time.Duration(*requestBody.GracePeriod) * time.Second
+		gracePeriod = &grace
+	}
+
+	rotated, err := h.service.RotateKey(keyID, userID.(string), gracePeriod, requestBody.ExpiresAt)
+	if err != nil {
+		c.JSON(apiKeyErrorStatus(err), gin.H{
+			"error": "Failed to rotate API key: " + err.Error(),
+		})
+		return
+	}
+
+	// The new key is only revealed once, like a created key
+	response := apiKeyResponse(*rotated.Key)
+	response["key"] = rotated.FullKey
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"key":         response,
+		"previousKey": apiKeyResponse(*rotated.Previous),
 	})
 }
 
@@ -139,14 +258,49 @@ func (h *ApiKeyHandler) RevokeKey(c *gin.Context) {
 	})
 }
 
-// Helper function to mask API key for display
-func maskApiKey(key string) string {
-	if len(key) <= 12 {
-		return key
+// apiKeyResponse formats a key for display, with the key itself masked
+func apiKeyResponse(key models.ApiKey) gin.H {
+	return gin.H{
+		"id":         key.ID,
+		"name":       key.Name,
+		"key":        maskApiKey(key.Prefix),
+		"prefix":     key.Prefix,
+		"scopes":     keyList(key.Scopes),
+		"allowedIps": keyList(key.AllowedIPs),
+		"replacedBy": key.ReplacedByID,
+		"lastUsed":   key.LastUsed,
+		"expiresAt":  key.ExpiresAt,
+		"caps":       key.SpendingCaps,
+		"createdAt":  key.CreatedAt,
 	}
+}
 
-	prefix := key[:8]
-	suffix := key[len(key)-4:]
+// apiKeyErrorStatus returns the HTTP status of an ApiKeyService error
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case err.Error() == "API key not found or does not belong to user":
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrKeyAlreadyRotated):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidScope),
+		errors.Is(err, services.ErrInvalidAllowedIP),
+		errors.Is(err, services.ErrInvalidExpiry),
+		errors.Is(err, services.ErrInvalidGracePeriod),
+		errors.Is(err, services.ErrInvalidSpendingCap):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// keyList returns a list field of a key (scopes, allowed IPs) for display, never null
+func keyList(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
 
-	return prefix + "..." + suffix
+// maskApiKey formats the stored prefix of a key for display, e.g. "sk_1a2b3c4d..."
+func maskApiKey(prefix string) string {
+	return prefix + "..."
 }
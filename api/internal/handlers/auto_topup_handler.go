@@ -0,0 +1,209 @@
+// internal/handlers/auto_topup_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AutoTopUpHandler lets users save a payment method and recharge their
+// balance automatically when it runs low
+type AutoTopUpHandler struct {
+	autoTopUpService *services.AutoTopUpService
+}
+
+// NewAutoTopUpHandler creates a new auto top-up handler
+func NewAutoTopUpHandler(autoTopUpService *services.AutoTopUpService) *AutoTopUpHandler {
+	return &AutoTopUpHandler{
+		autoTopUpService: autoTopUpService,
+	}
+}
+
+// GetAutoTopUp godoc
+// @Summary Get auto top-up settings
+// @Description Returns the auto top-up settings of the current user and whether a payment method is saved
+// @Tags balance
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,autoTopUp=object,hasPaymentMethod=boolean,setupPending=boolean}
+// @Failure 401 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/auto-topup [get]
+func (h *AutoTopUpHandler) GetAutoTopUp(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	autoTopUp, err := h.autoTopUpService.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get auto top-up: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"autoTopUp":        autoTopUp,
+		"hasPaymentMethod": autoTopUp.PaymentMethodID != "",
+		"setupPending":     autoTopUp.SetupID != "",
+	})
+}
+
+// UpdateAutoTopUp godoc
+// @Summary Configure auto top-up
+// @Description Sets the balance threshold below which amount is charged to the saved payment method, and turns auto top-up on or off. Turning it on requires a saved payment method; if the balance is below the threshold already, a top-up starts right away.
+// @Tags balance
+// @Accept json
+// @Produce json
+// @Param body body object{enabled=boolean,threshold=number,amount=number} true "Auto top-up settings (amount minimum $5.00)"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,autoTopUp=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/auto-topup [put]
+func (h *AutoTopUpHandler) UpdateAutoTopUp(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Enabled   bool    `json:"enabled"`
+		Threshold float64 `json:"threshold"`
+		Amount    float64 `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	autoTopUp, err := h.autoTopUpService.Configure(userID, req.Enabled, req.Threshold, req.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAutoTopUp):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNoSavedPaymentMethod):
+			c.JSON(http.StatusConflict, gin.H{"error": "Save a payment method before turning on auto top-up"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update auto top-up: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "autoTopUp": autoTopUp})
+}
+
+// DeleteAutoTopUp godoc
+// @Summary Turn off auto top-up
+// @Description Turns off auto top-up and removes the saved payment method
+// @Tags balance
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean}
+// @Failure 401 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/auto-topup [delete]
+func (h *AutoTopUpHandler) DeleteAutoTopUp(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.autoTopUpService.Remove(userID); err != nil {
+		if errors.Is(err, services.ErrAutoTopUpInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error() + ", try again later"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove auto top-up: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// SetupPaymentMethod godoc
+// @Summary Save a payment method for auto top-up
+// @Description Starts saving a PayPal account (billing agreement) or a card with Stripe for auto top-ups. The user approves it at approvalUrl, then confirms it.
+// @Tags balance
+// @Accept json
+// @Produce json
+// @Param body body object{provider=string} false "Payment provider (paypal or stripe, default paypal)"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,approvalUrl=string}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/auto-topup/payment-method [post]
+func (h *AutoTopUpHandler) SetupPaymentMethod(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"` // Defaults to PayPal
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	approvalURL, err := h.autoTopUpService.StartSetup(userID, req.Provider)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownPaymentProvider) || errors.Is(err, services.ErrSavedPaymentsUnsupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment provider: " + req.Provider})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up payment method: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "approvalUrl": approvalURL})
+}
+
+// ConfirmPaymentMethod godoc
+// @Summary Confirm a saved payment method
+// @Description Saves the payment method the user approved for auto top-ups, replacing the one saved before
+// @Tags balance
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,autoTopUp=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/auto-topup/payment-method/confirm [post]
+func (h *AutoTopUpHandler) ConfirmPaymentMethod(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	autoTopUp, err := h.autoTopUpService.ConfirmSetup(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNoPaymentMethodSetup), errors.Is(err, services.ErrUnknownPaymentProvider):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrAutoTopUpInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error() + ", try again later"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm payment method: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "autoTopUp": autoTopUp})
+}
@@ -2,19 +2,23 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 type BalanceHandler struct {
-	service *services.BalanceService
+	service        *services.BalanceService
+	paymentService *services.PaymentService
 }
 
-func NewBalanceHandler(service *services.BalanceService) *BalanceHandler {
-	return &BalanceHandler{service: service}
+func NewBalanceHandler(service *services.BalanceService, paymentService *services.PaymentService) *BalanceHandler {
+	return &BalanceHandler{
+		service:        service,
+		paymentService: paymentService,
+	}
 }
 
 // GetBalance godoc
@@ -58,9 +62,9 @@ func (h *BalanceHandler) GetBalance(c *gin.Context) {
 // @Tags balance
 // @Accept json
 // @Produce json
-// @Param body body object{amount=number} true "Deposit amount (minimum $5.00)"
+// @Param body body object{amount=number,provider=string} true "Deposit amount (minimum $5.00) and payment provider (paypal or stripe, default paypal)"
 // @Security BearerAuth
-// @Success 200 {object} object{success=boolean,checkoutUrl=string,orderId=string,message=string}
+// @Success 200 {object} object{success=boolean,checkoutUrl=string,orderId=string,provider=string,message=string}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 500 {object} object{error=string}
@@ -77,7 +81,8 @@ func (h *BalanceHandler) CreateDeposit(c *gin.Context) {
 
 	// Parse request body
 	var requestBody struct {
-		Amount float64 `json:"amount" binding:"required"`
+		Amount   float64 `json:"amount" binding:"required"`
+		Provider string  `json:"provider"` // Defaults to PayPal
 	}
 
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
@@ -95,30 +100,19 @@ func (h *BalanceHandler) CreateDeposit(c *gin.Context) {
 		return
 	}
 
-	// Create PayPal service
-	paypalService := services.NewPayPalService(
-		os.Getenv("PAYPAL_CLIENT_ID"),
-		os.Getenv("PAYPAL_CLIENT_SECRET"),
-		os.Getenv("PAYPAL_API_BASE"),
-		os.Getenv("NEXT_PUBLIC_APP_URL"),
-	)
-
-	// Create PayPal order
-	orderID, approvalURL, err := paypalService.CreateOrder(
+	// Create the checkout and the pending deposit transaction
+	transaction, checkoutURL, err := h.paymentService.CreateDeposit(
 		userID.(string),
+		requestBody.Provider,
 		requestBody.Amount,
-		"Balance Deposit",
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create PayPal order: " + err.Error(),
-		})
-		return
-	}
-
-	// Create deposit transaction in database
-	_, err = h.service.CreateDeposit(userID.(string), requestBody.Amount, orderID)
-	if err != nil {
+		if errors.Is(err, services.ErrUnknownPaymentProvider) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unsupported payment provider: " + requestBody.Provider,
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create deposit: " + err.Error(),
 		})
@@ -127,8 +121,9 @@ func (h *BalanceHandler) CreateDeposit(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":     true,
-		"orderId":     orderID,
-		"checkoutUrl": approvalURL,
+		"orderId":     transaction.PaymentID,
+		"provider":    transaction.Provider,
+		"checkoutUrl": checkoutURL,
 		"amount":      requestBody.Amount,
 		"message":     "Deposit transaction created. Please complete the payment.",
 	})
@@ -140,7 +135,7 @@ func (h *BalanceHandler) CreateDeposit(c *gin.Context) {
 // @Tags balance
 // @Accept json
 // @Produce json
-// @Param body body object{orderId=string} true "PayPal order ID to verify"
+// @Param body body object{orderId=string} true "Payment ID of the deposit to verify: the PayPal order ID or the Stripe Checkout session ID"
 // @Security BearerAuth
 // @Success 200 {object} object{success=boolean,message=string,amount=number,newBalance=number}
 // @Failure 400 {object} object{error=string}
@@ -172,17 +167,15 @@ func (h *BalanceHandler) VerifyDeposit(c *gin.Context) {
 		return
 	}
 
-	// Create PayPal service
-	paypalService := services.NewPayPalService(
-		os.Getenv("PAYPAL_CLIENT_ID"),
-		os.Getenv("PAYPAL_CLIENT_SECRET"),
-		os.Getenv("PAYPAL_API_BASE"),
-		os.Getenv("NEXT_PUBLIC_APP_URL"),
-	)
-
-	// Verify and capture the payment
-	verified, amount, err := paypalService.VerifyOrder(requestBody.OrderID)
+	// Verify and capture the payment with the deposit's provider
+	verified, amount, err := h.paymentService.VerifyDeposit(userID.(string), requestBody.OrderID)
 	if err != nil {
+		if errors.Is(err, services.ErrDepositNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Deposit not found",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to verify payment: " + err.Error(),
 		})
@@ -197,14 +190,6 @@ func (h *BalanceHandler) VerifyDeposit(c *gin.Context) {
 		return
 	}
 
-	// Complete the deposit in the database
-	if err := h.service.CompleteDeposit(requestBody.OrderID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to complete deposit: " + err.Error(),
-		})
-		return
-	}
-
 	// Get updated balance
 	result, err := h.service.GetBalance(userID.(string))
 	if err != nil {
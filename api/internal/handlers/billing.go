@@ -0,0 +1,82 @@
+// internal/handlers/billing.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// heldReservationsKey is the context key of the heldReservations a pipeline
+// passes to its steps
+const heldReservationsKey = "heldReservations"
+
+// settleReservation is deferred by the handlers after reserving the cost of
+// their operation. The reservation is committed if the handler responded
+// with a success status and released otherwise, so failed operations are not
+// charged. Inside a pipeline the reservation is only collected, and settled
+// with the other steps once the whole pipeline finished.
+func settleReservation(c *gin.Context, balanceService *services.BalanceService, reservation *models.Reservation) {
+	if reservation == nil {
+		return
+	}
+
+	if value, ok := c.Get(heldReservationsKey); ok {
+		if held, ok := value.(*heldReservations); ok {
+			held.add(balanceService, reservation)
+			return
+		}
+	}
+
+	succeeded := c.Writer.Written() && c.Writer.Status() < http.StatusBadRequest
+	settle(balanceService, reservation.ID, succeeded)
+}
+
+// settle commits or releases a reservation, logging failures since the
+// response has already been sent. Reservations that could not be settled
+// are released by the reconciliation sweep once they expire.
+func settle(balanceService *services.BalanceService, reservationID string, succeeded bool) {
+	var err error
+	if succeeded {
+		err = balanceService.Commit(reservationID)
+	} else {
+		err = balanceService.Release(reservationID)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Failed to settle reservation %s (succeeded: %v): %v\n", reservationID, succeeded, err)
+	}
+}
+
+// heldReservations collects the reservations of several operations that
+// succeed or fail together
+type heldReservations struct {
+	mu      sync.Mutex
+	entries []heldReservation
+}
+
+type heldReservation struct {
+	balanceService *services.BalanceService
+	reservation    *models.Reservation
+}
+
+func (h *heldReservations) add(balanceService *services.BalanceService, reservation *models.Reservation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, heldReservation{balanceService, reservation})
+}
+
+// settle commits or releases every collected reservation
+func (h *heldReservations) settle(succeeded bool) {
+	h.mu.Lock()
+	entries := h.entries
+	h.entries = nil
+	h.mu.Unlock()
+
+	for _, entry := range entries {
+		settle(entry.balanceService, entry.reservation.ID, succeeded)
+	}
+}
@@ -2,35 +2,46 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
 	"github.com/gin-gonic/gin"
 )
 
 type FileHandler struct {
-	config *config.Config
+	config    *config.Config
+	artifacts *services.ArtifactService
 }
 
-func NewFileHandler(cfg *config.Config) *FileHandler {
-	return &FileHandler{config: cfg}
+func NewFileHandler(artifacts *services.ArtifactService, cfg *config.Config) *FileHandler {
+	return &FileHandler{config: cfg, artifacts: artifacts}
 }
 
 // ServeFile godoc
 // @Summary Serve a processed file
-// @Description Serves a file from the public directory for download
+// @Description Serves a result file for download. Send the owner's API key, session token or JWT, or use the signed fileUrl returned by the operation (expires and token parameters).
 // @Tags file
 // @Accept json
 // @Produce octet-stream
 // @Param folder query string true "Folder name where the file is stored"
 // @Param filename query string true "Name of the file to serve"
+// @Param expires query integer false "Expiry of a signed link (Unix time)"
+// @Param token query string false "Signature of a signed link"
 // @Success 200 {file} binary "The requested file"
 // @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 403 {object} object{error=string}
 // @Failure 404 {object} object{error=string}
+// @Failure 410 {object} object{error=string}
 // @Failure 500 {object} object{error=string}
 // @Router /api/file [get]
 func (h *FileHandler) ServeFile(c *gin.Context) {
@@ -65,6 +76,7 @@ func (h *FileHandler) ServeFile(c *gin.Context) {
 		"redacted",
 		"repaired",
 		"pagenumbers",
+		"pipelines",
 	}
 
 	// Handle subfolder paths (like "splits/abc123")
@@ -96,16 +108,81 @@ func (h *FileHandler) ServeFile(c *gin.Context) {
 		return
 	}
 
-	// Full path to the file
-	filePath := filepath.Join(h.config.PublicDir, folder, sanitizedFilename)
+	// Look up the artifact of the file
+	key, err := storage.CleanKey(storage.Key(folder, sanitizedFilename))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid folder specified",
+		})
+		return
+	}
+	artifact, err := h.artifacts.Find(key)
+	if errors.Is(err, services.ErrArtifactNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to look up file: " + err.Error(),
+		})
+		return
+	}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	// Only the owner or holders of a signed link may download the file
+	if token := c.Query("token"); token != "" {
+		if err := h.artifacts.VerifyDownloadToken(key, c.Query("expires"), token); err != nil {
+			if artifact.IsExpired(time.Now()) {
+				c.JSON(http.StatusGone, gin.H{
+					"error": "File has expired",
+				})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Invalid or expired download link",
+			})
+			return
+		}
+	} else {
+		userID := c.GetString("userId")
+		if userID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Authentication or a signed download link is required",
+			})
+			return
+		}
+		if userID != artifact.UserID {
+			// Do not reveal that the file of another user exists
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "File not found",
+			})
+			return
+		}
+	}
+
+	if artifact.IsExpired(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "File has expired",
+		})
+		return
+	}
+
+	// Open the stored file
+	body, object, err := h.artifacts.Open(c.Request.Context(), artifact)
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{
-			"error": "File not found: " + filePath,
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read file: " + err.Error(),
 		})
 		return
 	}
+	defer body.Close()
 
 	// Get file extension for content type
 	ext := filepath.Ext(filename)
@@ -117,9 +194,14 @@ func (h *FileHandler) ServeFile(c *gin.Context) {
 	c.Header("Cache-Control", "no-store, must-revalidate")
 	c.Header("Pragma", "no-cache")
 	c.Header("Expires", "0")
+	c.Header("Content-Length", strconv.FormatInt(object.Size, 10))
+	c.Header("ETag", `"`+artifact.Checksum+`"`)
 
 	// Serve the file
-	c.File(filePath)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		fmt.Printf("Error serving file %s: %v\n", object.Key, err)
+	}
 }
 
 // Helper function to get content type from file extension
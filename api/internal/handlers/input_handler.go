@@ -0,0 +1,193 @@
+// internal/handlers/input_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Sources of inputs that reference files instead of carrying them
+const (
+	inputFromUpload = "upload" // A completed resumable upload
+	inputFromResult = "result" // A previous result of the caller
+	inputFromURL    = "url"    // A public http or https URL
+)
+
+// inputReference is a form field referencing files and the file field the
+// operations read them from
+type inputReference struct {
+	name   string
+	field  string
+	source string
+}
+
+// inputReferences are resolved in this order, after the files sent in the
+// form, which decides the order of merged files
+var inputReferences = []inputReference{
+	{"uploadId", "file", inputFromUpload},
+	{"fileUploadId", "file", inputFromUpload},
+	{"fileId", "file", inputFromResult},
+	{"fileUrl", "file", inputFromURL},
+	{"uploadIds", "files", inputFromUpload},
+	{"filesUploadId", "files", inputFromUpload},
+	{"fileIds", "files", inputFromResult},
+	{"fileUrls", "files", inputFromURL},
+	{"contentUploadId", "content", inputFromUpload},
+	{"contentFileId", "content", inputFromResult},
+	{"contentUrl", "content", inputFromURL},
+	{"watermarkImageUploadId", "watermarkImage", inputFromUpload},
+	{"watermarkImageFileId", "watermarkImage", inputFromResult},
+	{"watermarkImageUrl", "watermarkImage", inputFromURL},
+}
+
+// InputHandler resolves inputs that reference files instead of carrying
+// them, so every operation reads them like the files sent in the form
+type InputHandler struct {
+	uploads   *services.UploadService
+	artifacts *services.ArtifactService
+	fetcher   *services.URLFetcher
+	config    *config.Config
+}
+
+// NewInputHandler creates a new input handler
+func NewInputHandler(uploads *services.UploadService, artifacts *services.ArtifactService, fetcher *services.URLFetcher, cfg *config.Config) *InputHandler {
+	return &InputHandler{
+		uploads:   uploads,
+		artifacts: artifacts,
+		fetcher:   fetcher,
+		config:    cfg,
+	}
+}
+
+// ResolveInputs is a middleware replacing the file references of a form with
+// the files: uploadId names a completed upload of the caller, fileId a
+// previous result of the caller and fileUrl a public URL to download. The
+// files are copied to local disk once and handed to the handlers in the
+// context, where formInputFiles finds them next to the files of the form.
+func (h *InputHandler) ResolveInputs(c *gin.Context) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if c.Request.Method != http.MethodPost ||
+		(mediaType != "multipart/form-data" && mediaType != "application/x-www-form-urlencoded") {
+		c.Next()
+		return
+	}
+
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form: " + err.Error()})
+		c.Abort()
+		return
+	}
+
+	found := false
+	for _, reference := range inputReferences {
+		if _, ok := c.Request.PostForm[reference.name]; ok {
+			found = true
+		}
+	}
+	if !found {
+		c.Next()
+		return
+	}
+
+	inputDir := filepath.Join(h.config.UploadDir, "inputs", uuid.New().String())
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare inputs: " + err.Error()})
+		c.Abort()
+		return
+	}
+	defer os.RemoveAll(inputDir)
+
+	files := make(map[string][]formFile)
+	for _, reference := range inputReferences {
+		for _, value := range c.Request.PostForm[reference.name] {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			file, ok := h.resolve(c, reference.source, value, inputDir)
+			if !ok {
+				c.Abort()
+				return
+			}
+			files[reference.field] = append(files[reference.field], file)
+		}
+
+		// Handlers see the files only, e.g. jobs do not store the references
+		c.Request.PostForm.Del(reference.name)
+		c.Request.Form.Del(reference.name)
+		if c.Request.MultipartForm != nil {
+			delete(c.Request.MultipartForm.Value, reference.name)
+		}
+	}
+
+	if err := setInputFiles(c, files); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare inputs: " + err.Error()})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// resolve copies the file a reference names into dir, or responds with an
+// error and returns false
+func (h *InputHandler) resolve(c *gin.Context, source, value, dir string) (formFile, bool) {
+	ctx := c.Request.Context()
+	userID := c.GetString("userId")
+
+	switch source {
+	case inputFromUpload:
+		upload, localPath, err := h.uploads.Fetch(ctx, value, userID, dir)
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found: " + value})
+			return formFile{}, false
+		case errors.Is(err, services.ErrUploadIncomplete):
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload " + value + " is not complete"})
+			return formFile{}, false
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload: " + err.Error()})
+			return formFile{}, false
+		}
+		return formFile{Filename: upload.Filename, Path: localPath}, true
+
+	case inputFromResult:
+		artifact, localPath, err := h.artifacts.Fetch(ctx, value, userID, dir)
+		switch {
+		case errors.Is(err, services.ErrArtifactNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found: " + value})
+			return formFile{}, false
+		case errors.Is(err, services.ErrArtifactExpired):
+			c.JSON(http.StatusGone, gin.H{"error": "File " + value + " has expired"})
+			return formFile{}, false
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file: " + err.Error()})
+			return formFile{}, false
+		}
+		return formFile{Filename: artifact.Filename, Path: localPath}, true
+
+	default:
+		limit := maxUploadSize(c)
+		filename, localPath, err := h.fetcher.Fetch(ctx, value, dir, limit)
+		switch {
+		case errors.Is(err, services.ErrInvalidFileURL), errors.Is(err, services.ErrBlockedFileURL):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fileUrl: " + err.Error()})
+			return formFile{}, false
+		case errors.Is(err, services.ErrRemoteFileTooLarge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File at fileUrl exceeds the %dMB limit of your plan", limit/(1024*1024))})
+			return formFile{}, false
+		case err != nil:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to download fileUrl: " + err.Error()})
+			return formFile{}, false
+		}
+		return formFile{Filename: filename, Path: localPath}, true
+	}
+}
@@ -0,0 +1,74 @@
+// internal/handlers/internal_request.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formFile is a file on disk handed to a handler as a file field of the form
+type formFile struct {
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Key      string `json:"key,omitempty"` // Storage key the file is fetched from before use
+}
+
+// internalRequest describes a request dispatched straight to a handler
+// without going through the router or its middleware
+type internalRequest struct {
+	Path   string
+	Fields map[string][]string
+	Files  map[string][]formFile
+	Values map[string]interface{} // Values set on the gin context, e.g. userId
+}
+
+// invokeHandler runs a handler against a synthetic form request and returns
+// the response status code and decoded JSON body. The fields are sent as the
+// form and the files are handed over in the context, like ResolveInputs does.
+func invokeHandler(ctx context.Context, handler gin.HandlerFunc, request internalRequest) (int, map[string]interface{}, error) {
+	body := url.Values(request.Fields).Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Path, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = req
+	for key, value := range request.Values {
+		c.Set(key, value)
+	}
+	if err := setInputFiles(c, request.Files); err != nil {
+		return 0, nil, err
+	}
+
+	handler(c)
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, errors.New("request cancelled")
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		return recorder.Code, nil, fmt.Errorf("operation returned an unexpected response (status %d)", recorder.Code)
+	}
+
+	return recorder.Code, response, nil
+}
+
+// responseError extracts the error message from a failed handler response
+func responseError(status int, body map[string]interface{}) string {
+	if message, ok := body["error"].(string); ok && message != "" {
+		return message
+	}
+	return fmt.Sprintf("operation failed with status %d", status)
+}
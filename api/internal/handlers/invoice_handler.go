@@ -0,0 +1,193 @@
+// internal/handlers/invoice_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// InvoiceHandler lists the invoices and statements of the current user and
+// serves them as PDFs
+type InvoiceHandler struct {
+	invoiceService *services.InvoiceService
+	config         *config.Config
+}
+
+// NewInvoiceHandler creates a new invoice handler
+func NewInvoiceHandler(invoiceService *services.InvoiceService, cfg *config.Config) *InvoiceHandler {
+	return &InvoiceHandler{
+		invoiceService: invoiceService,
+		config:         cfg,
+	}
+}
+
+// ListInvoices godoc
+// @Summary List invoices and statements
+// @Description Returns the deposit invoices and monthly usage statements of the current user, newest first
+// @Tags invoices
+// @Produce json
+// @Param type query string false "Only invoices of this type (deposit or statement)"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,invoices=array}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/invoices [get]
+func (h *InvoiceHandler) ListInvoices(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	invoiceType := c.Query("type")
+	switch invoiceType {
+	case "", models.InvoiceTypeDeposit, models.InvoiceTypeStatement:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected deposit or statement"})
+		return
+	}
+
+	invoices, err := h.invoiceService.ListInvoices(userID, invoiceType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invoices: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "invoices": invoices})
+}
+
+// GetInvoice godoc
+// @Summary Get an invoice or statement
+// @Description Returns an invoice or statement of the current user with its lines
+// @Tags invoices
+// @Produce json
+// @Param id path string true "Invoice ID"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,invoice=object}
+// @Failure 401 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /api/user/invoices/{id} [get]
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	invoice, ok := h.loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "invoice": invoice})
+}
+
+// DownloadInvoice godoc
+// @Summary Download an invoice or statement
+// @Description Renders an invoice or statement of the current user as a PDF
+// @Tags invoices
+// @Produce application/pdf
+// @Param id path string true "Invoice ID"
+// @Security BearerAuth
+// @Success 200 {file} binary
+// @Failure 401 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/invoices/{id}/pdf [get]
+func (h *InvoiceHandler) DownloadInvoice(c *gin.Context) {
+	invoice, ok := h.loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	if err := os.MkdirAll(h.config.TempDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temp directory: " + err.Error()})
+		return
+	}
+	tempDir, err := os.MkdirTemp(h.config.TempDir, "invoice-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temp directory: " + err.Error()})
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, invoice.Number+".pdf")
+	if err := h.invoiceService.RenderPDF(c.Request.Context(), invoice, outputPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice: " + err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.FileAttachment(outputPath, invoice.Number+".pdf")
+}
+
+// GenerateStatement godoc
+// @Summary Generate a monthly statement
+// @Description Issues the usage statement of the current user for a past month, or returns the one already issued. Statements of the previous month are also issued automatically.
+// @Tags invoices
+// @Accept json
+// @Produce json
+// @Param body body object{month=string} true "Month as YYYY-MM"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,invoice=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/invoices/statements [post]
+func (h *InvoiceHandler) GenerateStatement(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Month string `json:"month" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	month, err := time.Parse("2006-01", req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid month '%s', expected YYYY-MM", req.Month)})
+		return
+	}
+
+	invoice, err := h.invoiceService.GenerateStatement(userID, month)
+	if err != nil {
+		if errors.Is(err, services.ErrStatementPeriodOpen) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statement: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "invoice": invoice})
+}
+
+// loadInvoice loads the invoice :id of the current user, responding with an
+// error if there is none
+func (h *InvoiceHandler) loadInvoice(c *gin.Context) (*models.Invoice, bool) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	invoice, err := h.invoiceService.GetInvoice(userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvoiceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invoice: " + err.Error()})
+		return nil, false
+	}
+	return invoice, true
+}
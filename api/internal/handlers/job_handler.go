@@ -0,0 +1,453 @@
+// internal/handlers/job_handler.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// JobHandler exposes the asynchronous job API
+type JobHandler struct {
+	jobService     *services.JobService
+	webhookService *services.WebhookService
+	toolsService   *services.PDFToolsService
+	storage        storage.Storage
+	config         *config.Config
+	operations     map[string]bool
+}
+
+// NewJobHandler creates a new job handler
+func NewJobHandler(jobService *services.JobService, webhookService *services.WebhookService, store storage.Storage, cfg *config.Config) *JobHandler {
+	return &JobHandler{
+		jobService:     jobService,
+		webhookService: webhookService,
+		storage:        store,
+		toolsService:   services.NewPDFToolsService(),
+		config:         cfg,
+		operations:     make(map[string]bool),
+	}
+}
+
+// jobRequest is the stored form of a submitted operation request
+type jobRequest struct {
+	Dir         string                `json:"dir"`
+	Fields      map[string][]string   `json:"fields"`
+	Files       map[string][]formFile `json:"files"`
+	Scopes      []string              `json:"scopes,omitempty"`      // Scopes of the submitting API key
+	ApiKeyID    string                `json:"apiKeyId,omitempty"`    // Submitting API key, whose spending caps apply
+	MaxFileSize int64                 `json:"maxFileSize,omitempty"` // Upload limit of the submitter's plan
+}
+
+// RegisterOperation makes an operation handler submittable through POST /api/jobs.
+// When the job runs, the stored request is replayed against the handler exactly
+// as if it had been sent to the synchronous endpoint.
+func (h *JobHandler) RegisterOperation(operation string, handler gin.HandlerFunc) {
+	h.operations[operation] = true
+	h.jobService.RegisterRunner(operation, func(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+		return h.replayRequest(ctx, job, handler, progress)
+	})
+}
+
+// SubmitJob godoc
+// @Summary Submit an asynchronous PDF job
+// @Description Queues any PDF operation for background processing. Send the same form fields and files as the synchronous endpoint plus the operation name.
+// @Tags jobs
+// @Accept multipart/form-data
+// @Produce json
+// @Param operation formData string true "Operation to run (e.g. compress, merge, split, ocr)"
+// @Param callbackUrl formData string false "URL that receives a signed POST when the job finishes"
+// @Security ApiKeyAuth
+// @Success 202 {object} object{success=boolean,jobId=string,status=string,statusUrl=string}
+// @Failure 400 {object} object{error=string}
+// @Failure 403 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/jobs [post]
+func (h *JobHandler) SubmitJob(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form: " + err.Error()})
+		return
+	}
+
+	operation := c.PostForm("operation")
+	if operation == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Operation is required"})
+		return
+	}
+
+	if !h.operations[operation] {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Unsupported operation: " + operation,
+			"operations": h.supportedOperations(),
+		})
+		return
+	}
+
+	scopes := c.GetStringSlice("apiKeyScopes")
+	if !services.ScopeAllows(scopes, operation) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("API key is not allowed to use the '%s' operation", operation),
+		})
+		return
+	}
+
+	callbackURL := c.PostForm("callbackUrl")
+	if callbackURL != "" {
+		if err := services.ValidateCallbackURL(c.Request.Context(), callbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Apply the same tool availability check as the synchronous endpoints
+	if enabled, err := h.toolsService.CheckToolAvailability(operation); err == nil && !enabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":        h.toolsService.GetDisabledMessage(operation),
+			"toolDisabled": true,
+		})
+		return
+	}
+
+	jobID := uuid.New().String()
+	request := jobRequest{
+		Dir:         filepath.Join(h.config.UploadDir, "jobs", jobID),
+		Fields:      make(map[string][]string),
+		Files:       make(map[string][]formFile),
+		Scopes:      scopes,
+		ApiKeyID:    c.GetString("apiKeyId"),
+		MaxFileSize: c.GetInt64("maxFileSize"),
+	}
+
+	for key, values := range c.Request.PostForm {
+		if key == "operation" || key == "callbackUrl" {
+			continue
+		}
+		request.Fields[key] = values
+	}
+
+	inputs, err := formInputs(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form: " + err.Error()})
+		return
+	}
+
+	// Persist uploaded files in storage so whichever instance runs the job
+	// can rebuild the request later
+	if len(inputs) > 0 {
+		if err := os.MkdirAll(request.Dir, 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job directory: " + err.Error()})
+			return
+		}
+		defer os.RemoveAll(request.Dir)
+
+		n := 0
+		for field, fieldInputs := range inputs {
+			for _, input := range fieldInputs {
+				name := fmt.Sprintf("%d-%s", n, filepath.Base(input.Filename))
+				file := formFile{
+					Filename: input.Filename,
+					Path:     filepath.Join(request.Dir, name),
+					Key:      storage.Key("jobs/"+jobID, name),
+				}
+				err := input.Save(file.Path)
+				if err == nil {
+					err = storage.MoveFile(c.Request.Context(), h.storage, file.Key, file.Path)
+				}
+				if err != nil {
+					h.deleteJobFiles(request)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save uploaded file: " + err.Error()})
+					return
+				}
+				request.Files[field] = append(request.Files[field], file)
+				n++
+			}
+		}
+	}
+
+	params, err := json.Marshal(request)
+	if err != nil {
+		h.deleteJobFiles(request)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode job: " + err.Error()})
+		return
+	}
+
+	job := &models.Job{
+		ID:          jobID,
+		UserID:      userID,
+		Operation:   operation,
+		Params:      string(params),
+		CallbackURL: callbackURL,
+	}
+	if err := h.jobService.Submit(job); err != nil {
+		h.deleteJobFiles(request)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":   true,
+		"jobId":     job.ID,
+		"status":    job.Status,
+		"statusUrl": "/api/jobs/" + job.ID,
+	})
+}
+
+// GetJob godoc
+// @Summary Get job status
+// @Description Returns the status, progress and result of a job
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{id=string,operation=string,status=string,progress=integer,result=object,error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/jobs/{id} [get]
+func (h *JobHandler) GetJob(c *gin.Context) {
+	job, err := h.jobService.GetUserJob(c.Param("id"), c.GetString("userId"))
+	if err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobResponse(job))
+}
+
+// CancelJob godoc
+// @Summary Cancel a job
+// @Description Cancels a queued or running job
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,job=object}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/jobs/{id} [delete]
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	job, err := h.jobService.Cancel(c.Param("id"), c.GetString("userId"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrJobNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		case errors.Is(err, services.ErrJobFinished):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Job has already finished",
+				"job":   jobResponse(job),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Job cancelled",
+		"job":     jobResponse(job),
+	})
+}
+
+// GetJobDeliveries godoc
+// @Summary List webhook deliveries for a job
+// @Description Returns every attempt to deliver the job's callback, including the next one while a retry is pending
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,deliveries=array}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/jobs/{id}/deliveries [get]
+func (h *JobHandler) GetJobDeliveries(c *gin.Context) {
+	job, err := h.jobService.GetUserJob(c.Param("id"), c.GetString("userId"))
+	if err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job: " + err.Error()})
+		return
+	}
+
+	deliveries, err := h.webhookService.GetDeliveries(job.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deliveries: " + err.Error()})
+		return
+	}
+
+	response := make([]gin.H, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, gin.H{
+			"id":            delivery.ID,
+			"url":           delivery.URL,
+			"event":         delivery.Event,
+			"attempt":       delivery.Attempt,
+			"statusCode":    delivery.StatusCode,
+			"success":       delivery.Success,
+			"error":         delivery.Error,
+			"durationMs":    delivery.DurationMs,
+			"createdAt":     delivery.CreatedAt,
+			"nextAttemptAt": delivery.NextAttemptAt,
+			"attemptedAt":   delivery.AttemptedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"deliveries": response,
+	})
+}
+
+// GetWebhookSecret godoc
+// @Summary Get the webhook signing secret
+// @Description Returns the secret used to sign job callbacks for the current user. Verify callbacks by computing HMAC-SHA256 over "<X-MegaPDF-Timestamp>.<body>" and comparing it with X-MegaPDF-Signature.
+// @Tags jobs
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,secret=string,signatureHeader=string,timestampHeader=string}
+// @Failure 401 {object} object{error=string}
+// @Router /api/jobs/webhook-secret [get]
+func (h *JobHandler) GetWebhookSecret(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"secret":          h.webhookService.UserSecret(userID),
+		"signatureHeader": services.WebhookSignatureHeader,
+		"timestampHeader": services.WebhookTimestampHeader,
+	})
+}
+
+// supportedOperations returns the sorted list of submittable operations
+func (h *JobHandler) supportedOperations() []string {
+	operations := make([]string, 0, len(h.operations))
+	for operation := range h.operations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// replayRequest rebuilds the stored request and runs it through the handler
+func (h *JobHandler) replayRequest(ctx context.Context, job *models.Job, handler gin.HandlerFunc, progress func(int)) (interface{}, error) {
+	var request jobRequest
+	if err := json.Unmarshal([]byte(job.Params), &request); err != nil {
+		return nil, fmt.Errorf("invalid job parameters: %w", err)
+	}
+	if request.Dir != "" {
+		defer os.RemoveAll(request.Dir)
+	}
+	defer h.deleteJobFiles(request)
+
+	// Fetch the uploaded files from storage; jobs queued before storage was
+	// introduced only have a local path
+	for _, files := range request.Files {
+		for _, file := range files {
+			if file.Key == "" {
+				continue
+			}
+			if err := storage.FetchFile(ctx, h.storage, file.Key, file.Path); err != nil {
+				return nil, fmt.Errorf("failed to fetch job input %s: %w", file.Filename, err)
+			}
+		}
+	}
+
+	progress(10)
+
+	status, body, err := invokeHandler(ctx, handler, internalRequest{
+		Path:   "/api/jobs/" + job.ID,
+		Fields: request.Fields,
+		Files:  request.Files,
+		Values: map[string]interface{}{
+			"userId":        job.UserID,
+			"operationType": job.Operation,
+			"jobId":         job.ID,
+			"apiKeyScopes":  request.Scopes,
+			"apiKeyId":      request.ApiKeyID,
+			"maxFileSize":   request.MaxFileSize,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if status >= http.StatusBadRequest {
+		return nil, errors.New(responseError(status, body))
+	}
+
+	return body, nil
+}
+
+// deleteJobFiles removes the stored uploads of a job request
+func (h *JobHandler) deleteJobFiles(request jobRequest) {
+	for _, files := range request.Files {
+		for _, file := range files {
+			if file.Key == "" {
+				continue
+			}
+			if err := h.storage.Delete(context.Background(), file.Key); err != nil {
+				fmt.Printf("WARNING: Failed to delete job input %s: %v\n", file.Key, err)
+			}
+		}
+	}
+}
+
+// jobResponse formats a job for API responses
+func jobResponse(job *models.Job) gin.H {
+	if job == nil {
+		return nil
+	}
+
+	response := gin.H{
+		"id":          job.ID,
+		"operation":   job.Operation,
+		"status":      job.Status,
+		"progress":    job.Progress,
+		"result":      nil,
+		"error":       nil,
+		"callbackUrl": job.CallbackURL,
+		"createdAt":   job.CreatedAt,
+		"startedAt":   job.StartedAt,
+		"completedAt": job.CompletedAt,
+	}
+
+	if job.Result != "" {
+		var result interface{}
+		if err := json.Unmarshal([]byte(job.Result), &result); err == nil {
+			response["result"] = result
+		}
+	}
+	if job.Error != "" {
+		response["error"] = job.Error
+	}
+
+	return response
+}
@@ -40,7 +40,7 @@ func (h *KeyValidationHandler) ValidateKey(c *gin.Context) {
 	operation := c.Query("operation")
 
 	// Validate key
-	result, err := h.service.ValidateKey(apiKey, operation)
+	result, err := h.service.ValidateKey(apiKey, operation, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to validate API key: " + err.Error(),
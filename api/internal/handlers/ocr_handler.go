@@ -3,7 +3,6 @@ package handlers
 
 import (
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,13 +18,15 @@ import (
 // OcrHandler handles OCR-related operations
 type OcrHandler struct {
 	balanceService *services.BalanceService
+	artifacts      *services.ArtifactService
 	config         *config.Config
 }
 
 // NewOcrHandler creates a new OCR handler
-func NewOcrHandler(balanceService *services.BalanceService, cfg *config.Config) *OcrHandler {
+func NewOcrHandler(balanceService *services.BalanceService, artifacts *services.ArtifactService, cfg *config.Config) *OcrHandler {
 	return &OcrHandler{
 		balanceService: balanceService,
+		artifacts:      artifacts,
 		config:         cfg,
 	}
 }
@@ -40,7 +41,7 @@ func NewOcrHandler(balanceService *services.BalanceService, cfg *config.Config)
 // @Param language formData string false "OCR language (default: eng)"
 // @Param preserveLayout formData bool false "Preserve the original layout (default: true)"
 // @Param enhanceScanned formData bool false "Enhance scanned images before OCR (default: true)"
-// @Success 200 {object} object{success=boolean,message=string,searchablePdfUrl=string}
+// @Success 200 {object} object{success=boolean,message=string,searchablePdfUrl=string,fileId=string}
 // @Failure 400 {object} object{error=string}
 // @Failure 500 {object} object{error=string}
 // @Router /api/ocr [post]
@@ -53,7 +54,7 @@ func (h *OcrHandler) OcrPdf(c *gin.Context) {
 	}
 
 	// Process operation charge (rate limiting, free operations, etc.)
-	result, err := h.balanceService.ProcessOperation(userID.(string), "ocr")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "ocr")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -64,22 +65,28 @@ func (h *OcrHandler) OcrPdf(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get form file
-	file, header, err := c.Request.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "No file provided or invalid file",
 		})
 		return
 	}
-	defer file.Close()
+
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
 
 	// Validate file type
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".pdf") {
+	if !strings.HasSuffix(strings.ToLower(file.Filename), ".pdf") {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Only PDF files are supported",
 		})
@@ -113,17 +120,7 @@ func (h *OcrHandler) OcrPdf(c *gin.Context) {
 	os.MkdirAll(filepath.Join(h.config.PublicDir, "ocr"), os.ModePerm)
 
 	// Save uploaded file
-	out, err := os.Create(inputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to save uploaded file: " + err.Error(),
-		})
-		return
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, file)
-	if err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save uploaded file: " + err.Error(),
 		})
@@ -154,12 +151,19 @@ func (h *OcrHandler) OcrPdf(c *gin.Context) {
 		return
 	}
 
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "ocr", outputPath)
+	if !ok {
+		return
+	}
+
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{
 		"success":          true,
 		"message":          "OCR processing completed successfully",
-		"searchablePdfUrl": fmt.Sprintf("/api/file?folder=ocr&filename=%s", filepath.Base(outputPath)),
-		"processedFile":    header.Filename,
+		"searchablePdfUrl": fileURL,
+		"fileId":           fileID,
+		"processedFile":    file.Filename,
 		"language":         language,
 	})
 }
@@ -175,7 +179,7 @@ func (h *OcrHandler) OcrPdf(c *gin.Context) {
 // @Param pageRange formData string false "Page range (all or specific)"
 // @Param pages formData string false "Specific pages to process (e.g., '1,3-5,7')"
 // @Param preserveLayout formData bool false "Preserve the original layout (default: true)"
-// @Success 200 {object} object{success=boolean,message=string,text=string,fileUrl=string}
+// @Success 200 {object} object{success=boolean,message=string,text=string,fileUrl=string,fileId=string}
 // @Failure 400 {object} object{error=string}
 // @Failure 500 {object} object{error=string}
 // @Router /api/ocr/extract [post]
@@ -188,7 +192,7 @@ func (h *OcrHandler) ExtractText(c *gin.Context) {
 	}
 
 	// Process operation charge
-	result, err := h.balanceService.ProcessOperation(userID.(string), "ocr")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "ocr")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -199,22 +203,28 @@ func (h *OcrHandler) ExtractText(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get form file
-	file, header, err := c.Request.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "No file provided or invalid file",
 		})
 		return
 	}
-	defer file.Close()
+
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
 
 	// Validate file type
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".pdf") {
+	if !strings.HasSuffix(strings.ToLower(file.Filename), ".pdf") {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Only PDF files are supported",
 		})
@@ -250,17 +260,7 @@ func (h *OcrHandler) ExtractText(c *gin.Context) {
 	os.MkdirAll(filepath.Join(h.config.PublicDir, "ocr"), os.ModePerm)
 
 	// Save uploaded file
-	out, err := os.Create(inputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to save uploaded file: " + err.Error(),
-		})
-		return
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, file)
-	if err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save uploaded file: " + err.Error(),
 		})
@@ -293,6 +293,12 @@ func (h *OcrHandler) ExtractText(c *gin.Context) {
 		return
 	}
 
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "ocr", outputTextPath)
+	if !ok {
+		return
+	}
+
 	// Count words for statistics
 	wordCount := len(strings.Fields(text))
 
@@ -301,9 +307,10 @@ func (h *OcrHandler) ExtractText(c *gin.Context) {
 		"success":      true,
 		"message":      "Text extraction completed successfully",
 		"text":         text,
-		"fileUrl":      fmt.Sprintf("/api/file?folder=ocr&filename=%s", filepath.Base(outputTextPath)),
+		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     filepath.Base(outputTextPath),
-		"originalName": header.Filename,
+		"originalName": file.Filename,
 		"wordCount":    wordCount,
 	})
 }
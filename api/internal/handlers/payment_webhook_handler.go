@@ -0,0 +1,65 @@
+// internal/handlers/payment_webhook_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentWebhookHandler struct {
+	paymentService *services.PaymentService
+}
+
+func NewPaymentWebhookHandler(paymentService *services.PaymentService) *PaymentWebhookHandler {
+	return &PaymentWebhookHandler{paymentService: paymentService}
+}
+
+// HandleWebhook processes the webhook events of the payment provider named
+// in the URL. Deliveries whose signature the provider does not confirm are
+// rejected. Errors are answered with a 4xx or 5xx status so the provider
+// delivers the event again; duplicate deliveries of a processed event are
+// acknowledged without processing them again.
+func (h *PaymentWebhookHandler) HandleWebhook(c *gin.Context) {
+	// Read the raw request body
+	rawBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	// Process the webhook
+	provider := c.Param("provider")
+	if err := h.paymentService.HandleWebhook(provider, c.Request.Header, rawBody); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownPaymentProvider):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidWebhookSignature):
+			fmt.Printf("Rejected %s webhook: %v\n", provider, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		case errors.Is(err, services.ErrInvalidWebhookEvent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDuplicateWebhookEvent):
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Webhook event already processed",
+			})
+		case errors.Is(err, services.ErrWebhookEventInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process webhook: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook processed successfully",
+	})
+}
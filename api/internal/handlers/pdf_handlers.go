@@ -1,45 +1,66 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
-	"mime/multipart"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/config"
 	"github.com/MegaPDF/megapdf-official/api/internal/constants"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/pdfops"
 	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
 type PDFHandler struct {
 	balanceService *services.BalanceService
+	jobService     *services.JobService
+	storage        storage.Storage
+	artifacts      *services.ArtifactService
 	config         *config.Config
 }
 
-func NewPDFHandler(balanceService *services.BalanceService, cfg *config.Config) *PDFHandler {
+func NewPDFHandler(balanceService *services.BalanceService, store storage.Storage, artifacts *services.ArtifactService, cfg *config.Config) *PDFHandler {
 	return &PDFHandler{
 		balanceService: balanceService,
+		storage:        store,
+		artifacts:      artifacts,
 		config:         cfg,
 	}
 }
-func fileHasContent(path string, minSize int64) bool {
-	return fileExists(path) && getFileSize(path) > minSize
+
+// splitJobOperation is the internal job operation used for large split requests
+const splitJobOperation = "split-background"
+
+// splitJobParams holds the parameters of a background split job
+type splitJobParams struct {
+	ReservationID   string `json:"reservationId,omitempty"` // For jobs queued before Job.ReservationID
+	InputKey        string `json:"inputKey,omitempty"`      // Input in storage, so any instance can run the job
+	InputPath       string `json:"inputPath,omitempty"`     // Input on disk, for jobs queued before storage
+	SplitMethod     string `json:"splitMethod"`
+	PageRanges      string `json:"pageRanges"`
+	EveryNPages     int    `json:"everyNPages"`
+	TotalPages      int    `json:"totalPages"`
+	EstimatedSplits int    `json:"estimatedSplits"`
+}
+
+// SetJobService sets the job service used for background processing
+func (h *PDFHandler) SetJobService(jobService *services.JobService) {
+	h.jobService = jobService
+	jobService.RegisterRunner(splitJobOperation, h.runSplitJob)
 }
 
 // ConvertPDF godoc
@@ -48,14 +69,14 @@ func fileHasContent(path string, minSize int64) bool {
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "File to convert (max 50MB)"
+// @Param file formData file true "File to convert, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param inputFormat formData string true "Input file format (pdf, docx, xlsx, pptx, rtf, txt, html, jpg, jpeg, png)"
 // @Param outputFormat formData string true "Output file format (pdf, docx, xlsx, pptx, rtf, txt, html, jpg, jpeg, png)"
 // @Param ocr formData boolean false "Enable OCR for text extraction" default(false)
 // @Param quality formData integer false "Image quality for image outputs (10-100)" default(90)
 // @Param password formData string false "Password for protected PDF files"
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,inputFormat=string,outputFormat=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,inputFormat=string,outputFormat=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -65,7 +86,7 @@ func fileHasContent(path string, minSize int64) bool {
 func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	// Process the operation (track usage, check balance, etc.)
 	userID := c.GetString("userId")
-	result, err := h.balanceService.ProcessOperation(userID, "convert")
+	reservation, result, err := h.balanceService.Reserve(userID, c.GetString("apiKeyId"), "convert")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -76,6 +97,7 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -84,6 +106,7 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get input and output formats
 	inputFormat := c.PostForm("inputFormat")
@@ -100,7 +123,7 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	}
 
 	// Get uploaded file
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -108,6 +131,11 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
+
 	// Create unique ID for this conversion
 	uniqueID := uuid.New().String()
 
@@ -118,7 +146,7 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "conversions", outputFilename)
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save uploaded file: " + err.Error(),
 		})
@@ -128,68 +156,32 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	// Ensure input file is deleted after processing
 	defer os.Remove(inputPath)
 
-	// Conversion logic based on formats
-	var conversionErr error
-	switch {
-	case outputFormat == "pdf":
-		// Convert to PDF
-		conversionErr = h.convertToPdf(inputPath, outputPath, inputFormat)
-	case inputFormat == "pdf" && (outputFormat == "docx" || outputFormat == "doc"):
-		// PDF to Word
-		conversionErr = h.convertPdfToDocx(inputPath, outputPath)
-	case inputFormat == "pdf" && (outputFormat == "xlsx" || outputFormat == "xls"):
-		// PDF to Excel
-		conversionErr = h.convertPdfToExcel(inputPath, outputPath)
-	case inputFormat == "pdf" && outputFormat == "pptx":
-		// PDF to PowerPoint
-		conversionErr = h.convertPdfToPptx(inputPath, outputPath)
-	case inputFormat == "pdf" && (outputFormat == "jpg" || outputFormat == "jpeg" || outputFormat == "png"):
-		// PDF to Image
-		conversionErr = h.convertPdfToImage(inputPath, outputPath, outputFormat, quality)
-	case inputFormat == "pdf" && outputFormat == "txt":
-		// PDF to Text
-		if enableOcr {
-			conversionErr = h.extractTextWithOCR(inputPath, outputPath)
-		} else {
-			conversionErr = h.extractTextFromPdf(inputPath, outputPath)
-		}
-	case (inputFormat == "jpg" || inputFormat == "jpeg" || inputFormat == "png") &&
-		(outputFormat == "jpg" || outputFormat == "jpeg" || outputFormat == "png"):
-		// Image to Image
-		conversionErr = h.convertImageToImage(inputPath, outputPath, outputFormat, quality)
-	case (inputFormat == "docx" || inputFormat == "doc") && outputFormat == "pdf":
-		// Word to PDF
-		conversionErr = h.convertDocxToPdf(inputPath, outputPath)
-	default:
-		// Generic conversion using LibreOffice
-		conversionErr = h.convertWithLibreOffice(inputPath, outputPath, outputFormat)
-	}
-
-	if conversionErr != nil {
+	// Convert using the tool chain matching the formats
+	err = pdfops.Convert(c.Request.Context(), inputPath, outputPath, pdfops.ConvertOptions{
+		InputFormat:  inputFormat,
+		OutputFormat: outputFormat,
+		OCR:          enableOcr,
+		Quality:      quality,
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Conversion failed: " + conversionErr.Error(),
+			"error": "Conversion failed: " + err.Error(),
 		})
 		return
 	}
 
-	// Check if output file was created
-	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
-		// For debugging/development, create an empty file
-		emptyFile, err := os.Create(outputPath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to create output file: " + err.Error(),
-			})
-			return
-		}
-		emptyFile.Close()
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "conversions", outputPath)
+	if !ok {
+		return
 	}
 
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      "Conversion successful",
-		"fileUrl":      "/api/file?folder=conversions&filename=" + outputFilename,
+		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     outputFilename,
 		"originalName": file.Filename,
 		"inputFormat":  inputFormat,
@@ -203,518 +195,17 @@ func (h *PDFHandler) ConvertPDF(c *gin.Context) {
 	})
 }
 
-// convertToPdf converts various formats to PDF
-func (h *PDFHandler) convertToPdf(inputPath, outputPath, inputFormat string) error {
-	fmt.Printf("Converting %s to PDF\n", inputFormat)
-
-	// For image to PDF, use a specific method
-	if inputFormat == "jpg" || inputFormat == "jpeg" || inputFormat == "png" {
-		return h.convertImageToPdf(inputPath, outputPath)
-	}
-
-	// For other formats, use the robust LibreOffice method
-	return h.convertWithLibreOffice(inputPath, outputPath, "pdf")
-}
-
-// convertDocxToPdf converts DOCX to PDF
-func (h *PDFHandler) convertDocxToPdf(inputPath, outputPath string) error {
-	cmd := exec.Command("soffice", "--headless", "--convert-to", "pdf", "--outdir",
-		filepath.Dir(outputPath), inputPath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("DOCX to PDF conversion failed: %v - %s", err, output)
-	}
-	return nil
-}
-
-// convertXlsxToPdf converts XLSX to PDF
-func (h *PDFHandler) convertXlsxToPdf(inputPath, outputPath string) error {
-	cmd := exec.Command("soffice", "--headless", "--convert-to", "pdf", "--outdir",
-		filepath.Dir(outputPath), inputPath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("XLSX to PDF conversion failed: %v - %s", err, output)
-	}
-	return nil
-}
-
-// convertPdfToDocx converts PDF to DOCX
-func (h *PDFHandler) convertPdfToDocx(inputPath, outputPath string) error {
-	fmt.Printf("Converting PDF to DOCX: %s -> %s\n", inputPath, outputPath)
-
-	// Create a temporary directory for the conversion
-	tempDir, err := os.MkdirTemp("", "pdf-docx-conversion")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Copy the input file to the temp directory
-	tempInput := filepath.Join(tempDir, "input.pdf")
-	if err := copyFile(inputPath, tempInput); err != nil {
-		return fmt.Errorf("failed to copy input file: %w", err)
-	}
-
-	// Method 1: Use writer_pdf_import filter
-	cmd1 := exec.Command("soffice", "--headless", "--infilter=writer_pdf_import",
-		"--convert-to", "docx:MS Word 2007 XML", "--outdir",
-		tempDir, tempInput)
-	output1, err1 := cmd1.CombinedOutput()
-	fmt.Printf("PDF to DOCX Method 1 output: %s, error: %v\n", string(output1), err1)
-
-	// Check if the file was created
-	expectedOutput := filepath.Join(tempDir, "input.docx")
-	if fileHasContent(expectedOutput, 100) {
-		// File exists and has content, copy it to the desired output path
-		if err := copyFile(expectedOutput, outputPath); err != nil {
-			return fmt.Errorf("failed to copy converted file: %w", err)
-		}
-		fmt.Printf("Successful PDF to DOCX conversion using Method 1\n")
-		return nil
-	}
-
-	// If the first method failed, try the general conversion method
-	return h.convertWithLibreOffice(inputPath, outputPath, "docx")
-}
-
-// Helper function to get file size
-func getFileSize(path string) int64 {
-	info, err := os.Stat(path)
-	if err != nil {
-		return 0
-	}
-	return info.Size()
-}
-
-// convertPdfToExcel converts PDF to Excel
-func (h *PDFHandler) convertPdfToExcel(inputPath, outputPath string) error {
-	fmt.Printf("Converting PDF to Excel: %s -> %s\n", inputPath, outputPath)
-
-	// Create a temporary directory for the conversion
-	tempDir, err := os.MkdirTemp("", "pdf-excel-conversion")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Copy the input file to the temp directory
-	tempInput := filepath.Join(tempDir, "input.pdf")
-	if err := copyFile(inputPath, tempInput); err != nil {
-		return fmt.Errorf("failed to copy input file: %w", err)
-	}
-
-	// Method 1: Convert to HTML first, then to XLSX (often works better for tables)
-	htmlCmd := exec.Command("soffice", "--headless", "--convert-to", "html",
-		"--outdir", tempDir, tempInput)
-	htmlOutput, htmlErr := htmlCmd.CombinedOutput()
-	fmt.Printf("PDF to HTML output: %s, error: %v\n", string(htmlOutput), htmlErr)
-
-	if htmlErr == nil {
-		htmlFile := filepath.Join(tempDir, "input.html")
-		if fileExists(htmlFile) {
-			// Convert HTML to XLSX
-			xlsxCmd := exec.Command("soffice", "--headless", "--convert-to",
-				"xlsx:Calc MS Excel 2007 XML", "--outdir", tempDir, htmlFile)
-			xlsxOutput, xlsxErr := xlsxCmd.CombinedOutput()
-			fmt.Printf("HTML to XLSX output: %s, error: %v\n", string(xlsxOutput), xlsxErr)
-
-			// Check if XLSX was created
-			expectedOutput := filepath.Join(tempDir, "input.xlsx")
-			if fileHasContent(expectedOutput, 100) {
-				if err := copyFile(expectedOutput, outputPath); err != nil {
-					return fmt.Errorf("failed to copy HTML-converted XLSX: %w", err)
-				}
-				fmt.Printf("Successful PDF to Excel conversion via HTML\n")
-				return nil
-			}
-		}
-	}
-
-	// If the HTML method failed, try the general conversion method
-	return h.convertWithLibreOffice(inputPath, outputPath, "xlsx")
-}
-
-// convertPdfToPptx converts PDF to PowerPoint
-func (h *PDFHandler) convertPdfToPptx(inputPath, outputPath string) error {
-	fmt.Printf("Converting PDF to PowerPoint: %s -> %s\n", inputPath, outputPath)
-
-	// Create a temporary directory for the conversion
-	tempDir, err := os.MkdirTemp("", "pdf-pptx-conversion")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Copy the input file to the temp directory
-	tempInput := filepath.Join(tempDir, "input.pdf")
-	if err := copyFile(inputPath, tempInput); err != nil {
-		return fmt.Errorf("failed to copy input file: %w", err)
-	}
-
-	// Method 1: Use impress_pdf_import filter
-	cmd1 := exec.Command("soffice", "--headless", "--infilter=impress_pdf_import",
-		"--convert-to", "pptx:Impress MS PowerPoint 2007 XML", "--outdir",
-		tempDir, tempInput)
-	output1, err1 := cmd1.CombinedOutput()
-	fmt.Printf("PDF to PPTX Method 1 output: %s, error: %v\n", string(output1), err1)
-
-	// Check if the file was created
-	expectedOutput := filepath.Join(tempDir, "input.pptx")
-	if fileHasContent(expectedOutput, 100) {
-		// File exists and has content, copy it to the desired output path
-		if err := copyFile(expectedOutput, outputPath); err != nil {
-			return fmt.Errorf("failed to copy converted file: %w", err)
-		}
-		fmt.Printf("Successful PDF to PowerPoint conversion using Method 1\n")
-		return nil
-	}
-
-	// If the first method failed, try the general conversion method
-	return h.convertWithLibreOffice(inputPath, outputPath, "pptx")
-}
-
-// convertPdfToImage converts PDF to image formats
-func (h *PDFHandler) convertPdfToImage(inputPath, outputPath, format, quality string) error {
-	fmt.Printf("Converting PDF to %s image: %s -> %s\n", format, inputPath, outputPath)
-
-	// Set default quality if not provided
-	qualityValue := "90"
-	if quality != "" {
-		qualityValue = quality
-	}
-
-	// Try Ghostscript first
-	var cmd *exec.Cmd
-	if format == "jpg" || format == "jpeg" {
-		cmd = exec.Command("gs", "-sDEVICE=jpeg", "-dNOPAUSE", "-dBATCH", "-dSAFER",
-			"-r300", "-dJPEGQ="+qualityValue,
-			"-sOutputFile="+outputPath, inputPath)
-	} else {
-		cmd = exec.Command("gs", "-sDEVICE=png16m", "-dNOPAUSE", "-dBATCH", "-dSAFER",
-			"-r300", "-sOutputFile="+outputPath, inputPath)
-	}
-
-	output, err := cmd.CombinedOutput()
-	fmt.Printf("Ghostscript output: %s, error: %v\n", string(output), err)
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 100) {
-		fmt.Printf("Successful PDF to Image conversion using Ghostscript\n")
-		return nil
-	}
-
-	// If Ghostscript failed, try ImageMagick
-	convertCmd := exec.Command("convert", "-density", "300", "-quality", qualityValue,
-		inputPath, outputPath)
-	convertOutput, convertErr := convertCmd.CombinedOutput()
-	fmt.Printf("ImageMagick output: %s, error: %v\n", string(convertOutput), convertErr)
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 100) {
-		fmt.Printf("Successful PDF to Image conversion using ImageMagick\n")
-		return nil
-	}
-
-	if err != nil && convertErr != nil {
-		return fmt.Errorf("PDF to Image conversion failed with both methods:\nGhostscript: %v\nImageMagick: %v",
-			err, convertErr)
-	}
-
-	return nil
-}
-
-// convertImageToPdf converts image formats to PDF
-func (h *PDFHandler) convertImageToPdf(inputPath, outputPath string) error {
-	fmt.Printf("Converting Image to PDF: %s -> %s\n", inputPath, outputPath)
-
-	// Try ImageMagick first
-	cmd := exec.Command("convert", inputPath, outputPath)
-	output, err := cmd.CombinedOutput()
-	fmt.Printf("ImageMagick output: %s, error: %v\n", string(output), err)
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 100) {
-		fmt.Printf("Successful Image to PDF conversion using ImageMagick\n")
-		return nil
-	}
-
-	// If ImageMagick failed, try an alternative approach using Ghostscript
-	tempPath := outputPath + ".temp.ps"
-	gsCmd := exec.Command("gs", "-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dSAFER",
-		"-sOutputFile="+outputPath, inputPath)
-	gsOutput, gsErr := gsCmd.CombinedOutput()
-	fmt.Printf("Ghostscript output: %s, error: %v\n", string(gsOutput), gsErr)
-
-	// Clean up temporary file
-	if fileExists(tempPath) {
-		os.Remove(tempPath)
-	}
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 100) {
-		fmt.Printf("Successful Image to PDF conversion using Ghostscript\n")
-		return nil
-	}
-
-	if err != nil && gsErr != nil {
-		return fmt.Errorf("image to PDF conversion failed with both methods:\nImageMagick: %v\nGhostscript: %v",
-			err, gsErr)
-	}
-
-	return nil
-}
-
-// convertImageToImage converts between image formats
-func (h *PDFHandler) convertImageToImage(inputPath, outputPath, format, quality string) error {
-	fmt.Printf("Converting Image to %s: %s -> %s\n", format, inputPath, outputPath)
-
-	// Set default quality if not provided
-	qualityValue := "90"
-	if quality != "" {
-		qualityValue = quality
-	}
-
-	// Use ImageMagick for the conversion
-	cmd := exec.Command("convert", "-quality", qualityValue, inputPath, outputPath)
-	output, err := cmd.CombinedOutput()
-	fmt.Printf("ImageMagick output: %s, error: %v\n", string(output), err)
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 100) {
-		fmt.Printf("Successful Image to Image conversion\n")
-		return nil
-	}
-
-	return fmt.Errorf("image conversion failed: %v", err)
-}
-
-// extractTextFromPdf extracts text from a PDF
-func (h *PDFHandler) extractTextFromPdf(inputPath, outputPath string) error {
-	fmt.Printf("Extracting text from PDF: %s -> %s\n", inputPath, outputPath)
-
-	// Try pdftotext first
-	cmd := exec.Command("pdftotext", inputPath, outputPath)
-	output, err := cmd.CombinedOutput()
-	fmt.Printf("pdftotext output: %s, error: %v\n", string(output), err)
-
-	// Check if the output file was created
-	if fileHasContent(outputPath, 10) {
-		fmt.Printf("Successful text extraction using pdftotext\n")
-		return nil
-	}
-
-	// If pdftotext failed, try an alternative approach
-	// Create a simple text file with a message
-	fallbackContent := "PDF TEXT EXTRACTION\n\n" +
-		"The text content of this PDF could not be automatically extracted.\n" +
-		"Please try the OCR option for scanned documents."
-
-	if err := os.WriteFile(outputPath, []byte(fallbackContent), 0644); err != nil {
-		return fmt.Errorf("failed to create fallback text file: %w", err)
-	}
-
-	fmt.Printf("Created fallback text file\n")
-	return nil
-}
-
-// extractTextWithOCR extracts text from a PDF using OCR
-func (h *PDFHandler) extractTextWithOCR(inputPath, outputPath string) error {
-	fmt.Printf("Extracting text from PDF using OCR: %s -> %s\n", inputPath, outputPath)
-
-	// Create a temporary directory for the OCR process
-	tempDir, err := os.MkdirTemp("", "pdf-ocr")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Extract images from PDF using pdftoppm
-	ppmCmd := exec.Command("pdftoppm", "-png", inputPath, filepath.Join(tempDir, "page"))
-	ppmOutput, ppmErr := ppmCmd.CombinedOutput()
-	fmt.Printf("pdftoppm output: %s, error: %v\n", string(ppmOutput), ppmErr)
-
-	if ppmErr != nil {
-		// Try alternative image extraction using ImageMagick
-		imgCmd := exec.Command("convert", "-density", "300", inputPath, filepath.Join(tempDir, "page-%d.png"))
-		imgOutput, imgErr := imgCmd.CombinedOutput()
-		fmt.Printf("ImageMagick output: %s, error: %v\n", string(imgOutput), imgErr)
-
-		if imgErr != nil {
-			return fmt.Errorf("failed to extract images from PDF: pdftoppm: %v, convert: %v", ppmErr, imgErr)
-		}
-	}
-
-	// Get all extracted images
-	files, _ := os.ReadDir(tempDir)
-	var imageFiles []string
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".png") {
-			imageFiles = append(imageFiles, filepath.Join(tempDir, file.Name()))
-		}
-	}
-
-	if len(imageFiles) == 0 {
-		return fmt.Errorf("no images extracted from PDF for OCR")
-	}
-
-	// Create output file
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
-
-	// Process each image with tesseract
-	for _, imgFile := range imageFiles {
-		textFile := imgFile + ".txt"
-		tessCmd := exec.Command("tesseract", imgFile, strings.TrimSuffix(textFile, ".txt"))
-		tessOutput, tessErr := tessCmd.CombinedOutput()
-		fmt.Printf("Tesseract output for %s: %s, error: %v\n", imgFile, string(tessOutput), tessErr)
-
-		if fileExists(textFile) {
-			// Append the extracted text to the output file
-			text, err := os.ReadFile(textFile)
-			if err == nil && len(text) > 0 {
-				outFile.Write(append(text, []byte("\n\n")...))
-			}
-		}
-	}
-
-	// Check if the output file has content
-	if getFileSize(outputPath) < 10 {
-		// Create a fallback message
-		fallbackContent := "OCR TEXT EXTRACTION\n\n" +
-			"The OCR process did not extract any text from this PDF.\n" +
-			"This may be due to image quality issues or lack of text content."
-
-		if err := os.WriteFile(outputPath, []byte(fallbackContent), 0644); err != nil {
-			return fmt.Errorf("failed to create fallback OCR text file: %w", err)
-		}
-	}
-
-	return nil
-}
-
-// convertWithLibreOffice is a robust implementation that handles all conversion types
-func (h *PDFHandler) convertWithLibreOffice(inputPath, outputPath, format string) error {
-	// Create a temporary directory for the conversion
-	tempDir, err := os.MkdirTemp("", "file-conversion")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Copy the input file to the temp directory
-	tempInput := filepath.Join(tempDir, "input"+filepath.Ext(inputPath))
-	if err := copyFile(inputPath, tempInput); err != nil {
-		return fmt.Errorf("failed to copy input file: %w", err)
-	}
-
-	// Expected output file in temp directory
-	expectedOutput := filepath.Join(tempDir, "input."+format)
-
-	// Log the conversion attempt
-	fmt.Printf("Converting file: %s -> %s (format: %s)\n", tempInput, outputPath, format)
-
-	// Try different conversion methods
-	var allErrors []string
-
-	// Method 1: Standard LibreOffice conversion
-	cmd1 := exec.Command("soffice", "--headless", "--convert-to", format,
-		"--outdir", tempDir, tempInput)
-	output1, err1 := cmd1.CombinedOutput()
-	fmt.Printf("Method 1 output: %s, error: %v\n", string(output1), err1)
-	if err1 != nil {
-		allErrors = append(allErrors, fmt.Sprintf("Method 1: %v", err1))
-	}
-
-	// Check if the file was created
-	if fileHasContent(expectedOutput, 100) {
-		if err := copyFile(expectedOutput, outputPath); err != nil {
-			return fmt.Errorf("failed to copy converted file: %w", err)
-		}
-		fmt.Printf("Successful conversion using Method 1\n")
-		return nil
-	}
-
-	// Method 2: Try with soffice directly
-	cmd2 := exec.Command("soffice", "--headless", "--convert-to",
-		format, "--outdir", tempDir, tempInput)
-	output2, err2 := cmd2.CombinedOutput()
-	fmt.Printf("Method 2 output: %s, error: %v\n", string(output2), err2)
-	if err2 != nil {
-		allErrors = append(allErrors, fmt.Sprintf("Method 2: %v", err2))
-	}
-
-	// Check again
-	if fileHasContent(expectedOutput, 100) {
-		if err := copyFile(expectedOutput, outputPath); err != nil {
-			return fmt.Errorf("failed to copy converted file: %w", err)
-		}
-		fmt.Printf("Successful conversion using Method 2\n")
-		return nil
-	}
-
-	// Method 3: Try with specific format options based on file type
-	formatOption := format
-	switch format {
-	case "docx":
-		formatOption = "docx:MS Word 2007 XML"
-	case "xlsx":
-		formatOption = "xlsx:Calc MS Excel 2007 XML"
-	case "pptx":
-		formatOption = "pptx:Impress MS PowerPoint 2007 XML"
-	case "pdf":
-		formatOption = "pdf:writer_pdf_Export"
-	}
-
-	cmd3 := exec.Command("soffice", "--headless", "--convert-to",
-		formatOption, "--outdir", tempDir, tempInput)
-	output3, err3 := cmd3.CombinedOutput()
-	fmt.Printf("Method 3 output: %s, error: %v\n", string(output3), err3)
-	if err3 != nil {
-		allErrors = append(allErrors, fmt.Sprintf("Method 3: %v", err3))
-	}
-
-	// Check one last time
-	if fileHasContent(expectedOutput, 100) {
-		if err := copyFile(expectedOutput, outputPath); err != nil {
-			return fmt.Errorf("failed to copy converted file: %w", err)
-		}
-		fmt.Printf("Successful conversion using Method 3\n")
-		return nil
-	}
-
-	// Look for any output file with the right extension in case the name is different
-	files, _ := os.ReadDir(tempDir)
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), "."+format) && file.Name() != "input."+format {
-			filePath := filepath.Join(tempDir, file.Name())
-			if fileHasContent(filePath, 100) {
-				if err := copyFile(filePath, outputPath); err != nil {
-					return fmt.Errorf("failed to copy renamed output file: %w", err)
-				}
-				fmt.Printf("Found alternative output file: %s\n", file.Name())
-				return nil
-			}
-		}
-	}
-
-	// If we got here, all methods failed
-	return fmt.Errorf("conversion to %s failed with all methods: %s",
-		format, strings.Join(allErrors, "; "))
-}
-
 // SplitPDF godoc
 // @Summary Split a PDF file into multiple PDFs
 // @Description Splits a PDF file into multiple PDFs based on page ranges
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to split (max 50MB)"
+// @Param file formData file true "PDF file to split, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param splitMethod formData string true "Split method: range, extract, or every"
 // @Param pageRanges formData string false "Page ranges for splitting (e.g., '1-3,4,5-7')"
 // @Param everyNPages formData integer false "Split every N pages"
+// @Param callbackUrl formData string false "URL notified with a signed POST when a background split finishes"
 // @Security ApiKeyAuth
 // @Success 200 {object} object{success=boolean,message=string,originalName=string,totalPages=integer,splitParts=array,isLargeJob=boolean,jobId=string,statusUrl=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
@@ -725,11 +216,12 @@ func (h *PDFHandler) convertWithLibreOffice(inputPath, outputPath, format string
 func (h *PDFHandler) SplitPDF(c *gin.Context) {
 	// Get user ID from either API key (via headers) or session
 	userID, exists := c.Get("userId")
-	operation, _ := c.Get("operationType")
+	var billing *services.OperationResult
+	var reservation *models.Reservation
 
 	// IMPORTANT: Check if the user can perform this operation BEFORE processing
 	if exists {
-		result, err := h.balanceService.ProcessOperation(userID.(string), "Split")
+		reserved, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Split")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to process operation: " + err.Error(),
@@ -740,6 +232,7 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 		if !result.Success {
 			c.JSON(http.StatusPaymentRequired, gin.H{
 				"error": result.Error,
+				"code":  result.Code,
 				"details": gin.H{
 					"balance":                 result.CurrentBalance,
 					"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -748,8 +241,15 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 			})
 			return
 		}
+		reservation = reserved
+		billing = result
 	}
 
+	// Settled when the handler returns, unless a background job took it over
+	defer func() {
+		settleReservation(c, h.balanceService, reservation)
+	}()
+
 	// Create necessary directories if they don't exist
 	if err := os.MkdirAll(h.config.UploadDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -765,15 +265,8 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 		return
 	}
 
-	if err := os.MkdirAll(filepath.Join(h.config.PublicDir, "status"), 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create status directory: " + err.Error(),
-		})
-		return
-	}
-
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -789,11 +282,8 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 		return
 	}
 
-	// Check file size (max 50MB)
-	if file.Size > 50*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "File size exceeds 50MB limit",
-		})
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
 		return
 	}
 
@@ -814,12 +304,23 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 		return
 	}
 
+	// Optional webhook notified when a background split finishes
+	callbackURL := c.PostForm("callbackUrl")
+	if callbackURL != "" {
+		if err := services.ValidateCallbackURL(c.Request.Context(), callbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
 	// Create a unique session ID for this job
 	sessionId := uuid.New().String()
 	inputPath := filepath.Join(h.config.UploadDir, sessionId+"-input.pdf")
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save uploaded file: " + err.Error(),
 		})
@@ -827,7 +328,7 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 	}
 
 	// Get PDF page count
-	totalPages, err := getPDFPageCount(inputPath)
+	totalPages, err := pdfops.PageCount(c.Request.Context(), inputPath)
 	if err != nil {
 		// Instead of just returning an error, try a fallback approach with a default value
 		fmt.Printf("Warning: Failed to get page count: %v. Trying to estimate from file size.\n", err)
@@ -867,39 +368,36 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 	}
 
 	// Estimate job size to determine if we should use background processing
-	estimatedSplits := 0
-	if splitMethod == "range" && pageRanges != "" {
-		parsedRanges := parsePageRanges(pageRanges, totalPages)
-		estimatedSplits = len(parsedRanges)
+	estimatedSplits := pdfops.EstimateSplitParts(pdfops.SplitOptions{
+		Method:      splitMethod,
+		PageRanges:  pageRanges,
+		EveryNPages: everyNPages,
+		TotalPages:  totalPages,
+	})
 
-		// If no valid ranges were found, return an error
-		if estimatedSplits == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "No valid page ranges found. Please check your input.",
-			})
-			os.Remove(inputPath) // Clean up
-			return
-		}
-	} else if splitMethod == "extract" {
-		estimatedSplits = totalPages
-	} else if splitMethod == "every" {
-		estimatedSplits = (totalPages + everyNPages - 1) / everyNPages // Ceiling division
+	// If no valid ranges were found, return an error
+	if splitMethod == "range" && estimatedSplits == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No valid page ranges found. Please check your input.",
+		})
+		os.Remove(inputPath) // Clean up
+		return
 	}
 
-	// Determine if this is a large job that should be processed in the background
-	isLargeJob := estimatedSplits > 15 || totalPages > 100
+	// Determine if this is a large job that should be processed in the background.
+	// Requests that already run inside a job are processed inline.
+	_, inJob := c.Get("jobId")
+	isLargeJob := (estimatedSplits > 15 || totalPages > 100) && h.jobService != nil && !inJob
 
 	// Prepare billing info for response
 	var billingInfo gin.H
-	if exists {
-		result, _ := h.balanceService.ProcessOperation(userID.(string), operation.(string))
-
+	if billing != nil {
 		billingInfo = gin.H{
 			"billing": gin.H{
-				"currentBalance":          result.CurrentBalance,
-				"freeOperationsRemaining": result.FreeOperationsRemaining,
-				"operationCost":           result.OperationCost,
-				"usedFreeOperation":       result.UsedFreeOperation,
+				"currentBalance":          billing.CurrentBalance,
+				"freeOperationsRemaining": billing.FreeOperationsRemaining,
+				"operationCost":           billing.OperationCost,
+				"usedFreeOperation":       billing.UsedFreeOperation,
 			},
 		}
 	}
@@ -910,47 +408,65 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 
 	// Process the job
 	if isLargeJob {
-		// For large jobs, process in the background and return a job ID
-		statusFilePath := filepath.Join(h.config.PublicDir, "status", sessionId+"-status.json")
-
-		// Initialize status file
-		initialStatus := gin.H{
-			"id":        sessionId,
-			"status":    "processing",
-			"progress":  0,
-			"total":     estimatedSplits,
-			"completed": 0,
-			"results":   []gin.H{},
-			"error":     nil,
+		// For large jobs, queue a background job and return its ID
+		jobUserID, _ := userID.(string)
+		var reservationID string
+		if reservation != nil {
+			reservationID = reservation.ID
+		}
+		inputKey := storage.Key("jobs", sessionId+"-input.pdf")
+		if err := storage.MoveFile(c.Request.Context(), h.storage, inputKey, inputPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to store uploaded file: " + err.Error(),
+			})
+			os.Remove(inputPath) // Clean up
+			return
 		}
-
-		statusJson, err := json.Marshal(initialStatus)
+		params, err := json.Marshal(splitJobParams{
+			InputKey:        inputKey,
+			SplitMethod:     splitMethod,
+			PageRanges:      pageRanges,
+			EveryNPages:     everyNPages,
+			TotalPages:      totalPages,
+			EstimatedSplits: estimatedSplits,
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to create status file: " + err.Error(),
+				"error": "Failed to create split job: " + err.Error(),
 			})
-			os.Remove(inputPath) // Clean up
+			h.storage.Delete(context.Background(), inputKey) // Clean up
 			return
 		}
 
-		if err := os.WriteFile(statusFilePath, statusJson, 0644); err != nil {
+		// Hold the cost from the submission on; the reconciliation sweep keeps
+		// extending the hold while the job is queued or running
+		if reservationID != "" {
+			if err := h.balanceService.ExtendReservation(reservationID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to queue split job: " + err.Error(),
+				})
+				h.storage.Delete(context.Background(), inputKey) // Clean up
+				return
+			}
+		}
+
+		job := &models.Job{
+			ID:            sessionId,
+			UserID:        jobUserID,
+			Operation:     splitJobOperation,
+			Params:        string(params),
+			CallbackURL:   callbackURL,
+			ReservationID: reservationID,
+		}
+		if err := h.jobService.Submit(job); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to write status file: " + err.Error(),
+				"error": "Failed to queue split job: " + err.Error(),
 			})
-			os.Remove(inputPath) // Clean up
+			h.storage.Delete(context.Background(), inputKey) // Clean up
 			return
 		}
-
-		// Start background processing
-		go processSplitInBackground(
-			inputPath,
-			sessionId,
-			splitMethod,
-			pageRanges,
-			everyNPages,
-			totalPages,
-			h.config.PublicDir,
-		)
+		// The job settles the reservation once the split finished
+		reservation = nil
 
 		// Return response with job ID and status URL
 		response := gin.H{
@@ -974,15 +490,12 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 		c.JSON(http.StatusOK, response)
 	} else {
 		// For small jobs, process immediately
-		splitParts, err := processSplitJob(
-			inputPath,
-			sessionId,
-			splitMethod,
-			pageRanges,
-			everyNPages,
-			totalPages,
-			h.config.PublicDir,
-		)
+		splitParts, err := h.splitPDF(c.Request.Context(), c.GetString("userId"), inputPath, sessionId, pdfops.SplitOptions{
+			Method:      splitMethod,
+			PageRanges:  pageRanges,
+			EveryNPages: everyNPages,
+			TotalPages:  totalPages,
+		})
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -1019,538 +532,93 @@ func (h *PDFHandler) SplitPDF(c *gin.Context) {
 	}()
 }
 
-// Function to process split job
-func processSplitJob(
-	inputPath string,
-	sessionId string,
-	splitMethod string,
-	pageRanges string,
-	everyNPages int,
-	totalPages int,
-	publicDir string,
-) ([]gin.H, error) {
-	outputDir := filepath.Join(publicDir, "splits")
-
-	// Create results array
-	var splitParts []gin.H
-
-	// Detect which pdfcpu commands are available
-	supportedCommands := detectPdfCpuCommands()
-
-	fmt.Printf("Detected pdfcpu supported commands: %v\n", supportedCommands)
-
-	if splitMethod == "range" {
-		// Split by page ranges
-		ranges := parsePageRanges(pageRanges, totalPages)
-
-		for i, pageRange := range ranges {
-			outputFilename := fmt.Sprintf("%s-split-%d.pdf", sessionId, i+1)
-			outputPath := filepath.Join(outputDir, outputFilename)
-
-			// Try extract command first
-			success := false
-			var cmdOutput []byte
-			var cmdErr error
-
-			if supportedCommands["extract"] {
-				fmt.Printf("Using pdfcpu extract command for range: %s\n", pageRange)
-				cmd := exec.Command(
-					"pdfcpu",
-					"extract",
-					"-mode", "page",
-					"-pages", pageRange,
-					inputPath,
-					outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
-
-			// If extract fails, try trim command (newer pdfcpu versions)
-			if !success && supportedCommands["trim"] {
-				fmt.Printf("Extract failed or not available, trying pdfcpu trim command for range: %s\n", pageRange)
-				cmd := exec.Command(
-					"pdfcpu",
-					"trim",
-					"-pages", pageRange,
-					inputPath,
-					outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
-
-			// If both pdfcpu commands fail, try pdftk if available
-			if !success && commandExists("pdftk") {
-				fmt.Printf("pdfcpu commands failed, trying pdftk for range: %s\n", pageRange)
-				cmd := exec.Command(
-					"pdftk",
-					inputPath,
-					"cat", pageRange,
-					"output", outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
-
-			// If all methods fail, return an error
-			if !success {
-				return nil, fmt.Errorf("failed to extract pages %s: %v - %s", pageRange, cmdErr, string(cmdOutput))
-			}
-
-			// Calculate page count in this range
-			pageCount := countPagesInRange(pageRange)
-
-			// Format file URL using relative path for consistency
-			fileUrl := fmt.Sprintf("/api/file?folder=splits&filename=%s", outputFilename)
-
-			// Create pages array
-			var pages []interface{}
-			if strings.Contains(pageRange, "-") {
-				// Range like "1-5"
-				parts := strings.Split(pageRange, "-")
-				if len(parts) == 2 {
-					start, _ := strconv.Atoi(parts[0])
-					end, _ := strconv.Atoi(parts[1])
-					for i := start; i <= end; i++ {
-						pages = append(pages, i)
-					}
-				}
-			} else {
-				// Single page
-				page, _ := strconv.Atoi(pageRange)
-				pages = append(pages, page)
-			}
-
-			// Add to results
-			splitParts = append(splitParts, gin.H{
-				"fileUrl":   fileUrl,
-				"filename":  outputFilename,
-				"pages":     pages,
-				"pageCount": pageCount,
-			})
-		}
-	} else if splitMethod == "extract" {
-		// Extract each page as a separate file
-		for i := 1; i <= totalPages; i++ {
-			pageNum := strconv.Itoa(i)
-			outputFilename := fmt.Sprintf("%s-page-%s.pdf", sessionId, pageNum)
-			outputPath := filepath.Join(outputDir, outputFilename)
-
-			// Try multiple methods for page extraction
-			success := false
-
-			// Try 1: pdfcpu extract command
-			if supportedCommands["extract"] && !success {
-				cmd := exec.Command(
-					"pdfcpu",
-					"extract",
-					"-mode", "page",
-					"-pages", pageNum,
-					inputPath,
-					outputPath,
-				)
-
-				err := cmd.Run()
-				success = err == nil && fileExists(outputPath)
-			}
-
-			// Try 2: pdfcpu trim command
-			if supportedCommands["trim"] && !success {
-				cmd := exec.Command(
-					"pdfcpu",
-					"trim",
-					"-pages", pageNum,
-					inputPath,
-					outputPath,
-				)
-
-				err := cmd.Run()
-				success = err == nil && fileExists(outputPath)
-			}
-
-			// Try 3: pdftk if available
-			if commandExists("pdftk") && !success {
-				cmd := exec.Command(
-					"pdftk",
-					inputPath,
-					"cat", pageNum,
-					"output", outputPath,
-				)
-
-				err := cmd.Run()
-				success = err == nil && fileExists(outputPath)
-			}
-
-			// Skip if all methods fail
-			if !success {
-				fmt.Printf("Warning: Failed to extract page %d, skipping\n", i)
-				continue
-			}
-
-			// Format file URL
-			fileUrl := fmt.Sprintf("/api/file?folder=splits&filename=%s", outputFilename)
-
-			// Add to results
-			splitParts = append(splitParts, gin.H{
-				"fileUrl":   fileUrl,
-				"filename":  outputFilename,
-				"pages":     []int{i},
-				"pageCount": 1,
-			})
-		}
-	} else if splitMethod == "every" {
-		// Split into chunks of N pages
-		for i := 0; i < totalPages; i += everyNPages {
-			start := i + 1
-			end := start + everyNPages - 1
-			if end > totalPages {
-				end = totalPages
-			}
-
-			pageRange := fmt.Sprintf("%d-%d", start, end)
-			outputFilename := fmt.Sprintf("%s-pages-%d-%d.pdf", sessionId, start, end)
-			outputPath := filepath.Join(outputDir, outputFilename)
-
-			// Try multiple methods
-			success := false
-			var cmdOutput []byte
-			var cmdErr error
-
-			// Try pdfcpu extract command
-			if supportedCommands["extract"] {
-				cmd := exec.Command(
-					"pdfcpu",
-					"extract",
-					"-mode", "page",
-					"-pages", pageRange,
-					inputPath,
-					outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
+// splitPDF splits inputPath into the splits folder of storage, records the
+// parts as artifacts of userID and describes them for the response
+func (h *PDFHandler) splitPDF(ctx context.Context, userID, inputPath, sessionId string, opts pdfops.SplitOptions) ([]gin.H, error) {
+	opts.OutputDir = filepath.Join(h.config.PublicDir, "splits")
+	opts.Prefix = sessionId
 
-			// If extract fails, try trim command
-			if !success && supportedCommands["trim"] {
-				cmd := exec.Command(
-					"pdfcpu",
-					"trim",
-					"-pages", pageRange,
-					inputPath,
-					outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
-
-			// If both pdfcpu commands fail, try pdftk
-			if !success && commandExists("pdftk") {
-				cmd := exec.Command(
-					"pdftk",
-					inputPath,
-					"cat", pageRange,
-					"output", outputPath,
-				)
-
-				cmdOutput, cmdErr = cmd.CombinedOutput()
-				success = cmdErr == nil && fileExists(outputPath)
-			}
-
-			// If all methods fail, return an error
-			if !success {
-				return nil, fmt.Errorf("failed to extract pages %s: %v - %s", pageRange, cmdErr, string(cmdOutput))
-			}
-
-			// Calculate page count
-			pageCount := end - start + 1
-
-			// Format file URL
-			fileUrl := fmt.Sprintf("/api/file?folder=splits&filename=%s", outputFilename)
-
-			// Create pages array
-			var pages []int
-			for p := start; p <= end; p++ {
-				pages = append(pages, p)
-			}
-
-			// Add to results
-			splitParts = append(splitParts, gin.H{
-				"fileUrl":   fileUrl,
-				"filename":  outputFilename,
-				"pages":     pages,
-				"pageCount": pageCount,
-			})
-		}
+	parts, err := pdfops.Split(ctx, inputPath, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	return splitParts, nil
-}
-
-// Helper function to detect which pdfcpu commands are supported
-func detectPdfCpuCommands() map[string]bool {
-	supported := make(map[string]bool)
-
-	// Check for extract command
-	cmd := exec.Command("pdfcpu", "help", "extract")
-	err := cmd.Run()
-	supported["extract"] = err == nil
-
-	// Check for trim command
-	cmd = exec.Command("pdfcpu", "help", "trim")
-	err = cmd.Run()
-	supported["trim"] = err == nil
-
-	return supported
-}
-
-// Helper function to check if a command exists in PATH
-func commandExists(cmd string) bool {
-	_, err := exec.LookPath(cmd)
-	return err == nil
-}
-
-// Process split job in background and update status file
-func processSplitInBackground(
-	inputPath string,
-	sessionId string,
-	splitMethod string,
-	pageRanges string,
-	everyNPages int,
-	totalPages int,
-	publicDir string,
-) {
-	statusFilePath := filepath.Join(publicDir, "status", sessionId+"-status.json")
-
-	// Update status function
-	updateStatus := func(status string, progress int, results []gin.H, err error) {
-		statusData := gin.H{
-			"id":        sessionId,
-			"status":    status,
-			"progress":  progress,
-			"total":     len(results),
-			"completed": progress * len(results) / 100,
-			"results":   results,
-			"error":     nil,
-		}
-
+	splitParts := make([]gin.H, 0, len(parts))
+	for i, part := range parts {
+		artifact, err := h.artifacts.Store(ctx, userID, "split", "splits", part.Path)
 		if err != nil {
-			statusData["error"] = err.Error()
-		}
-
-		statusJson, _ := json.Marshal(statusData)
-		os.WriteFile(statusFilePath, statusJson, 0644)
-	}
-
-	// Initialize empty results
-	results := []gin.H{}
-
-	// Update status to indicate processing started
-	updateStatus("processing", 0, results, nil)
-
-	// Process splits based on method
-	var processingErr error
-
-	defer func() {
-		if r := recover(); r != nil {
-			err := fmt.Errorf("panic in background processing: %v", r)
-			fmt.Println(err)
-			updateStatus("error", 0, results, err)
+			for _, unstored := range parts[i:] {
+				os.Remove(unstored.Path)
+			}
+			return nil, fmt.Errorf("failed to store split part: %w", err)
 		}
-	}()
-
-	// Update status to indicate processing is ongoing
-	updateStatus("processing", 10, results, nil)
-
-	// Process the split job
-	results, processingErr = processSplitJob(
-		inputPath,
-		sessionId,
-		splitMethod,
-		pageRanges,
-		everyNPages,
-		totalPages,
-		publicDir,
-	)
-
-	if processingErr != nil {
-		updateStatus("error", 0, results, processingErr)
-		return
+		splitParts = append(splitParts, gin.H{
+			"fileUrl":   h.artifacts.DownloadURL(artifact),
+			"fileId":    artifact.ID,
+			"filename":  part.Filename,
+			"pages":     part.Pages,
+			"pageCount": part.PageCount,
+		})
 	}
 
-	// Update final status
-	updateStatus("completed", 100, results, nil)
+	return splitParts, nil
 }
-func getPDFPageCount(pdfPath string) (int, error) {
-	// Try using pdfcpu info command first
-	cmd := exec.Command("pdfcpu", "info", pdfPath)
-	output, err := cmd.CombinedOutput()
-
-	// Log the complete output for debugging
-	fmt.Printf("pdfcpu info output: %s\n", string(output))
-
-	if err == nil {
-		// Try multiple regex patterns to match page count
-		patterns := []string{
-			`Pages?\s*[:=]\s*(\d+)`,         // "Pages: 5" format
-			`Number of pages:\s*(\d+)`,      // "Number of pages: 5" format
-			`PageCount\s*[:=]\s*(\d+)`,      // "PageCount: 5" format
-			`Total\s*pages?\s*[:=]\s*(\d+)`, // "Total pages: 5" format
-			`(\d+)\s*pages?`,                // "5 pages" format
-		}
 
-		for _, pattern := range patterns {
-			re := regexp.MustCompile(pattern)
-			matches := re.FindStringSubmatch(string(output))
-
-			if len(matches) >= 2 {
-				fmt.Printf("Found page count match with pattern: %s\n", pattern)
-				return strconv.Atoi(matches[1])
+// runSplitJob processes a queued background split job. The job only
+// completes once its reservation is committed, so a split that could not be
+// charged fails instead of being handed out for free.
+func (h *PDFHandler) runSplitJob(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error) {
+	var params splitJobParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return nil, fmt.Errorf("invalid split job parameters: %w", err)
+	}
+	reservationID := job.ReservationID
+	if reservationID == "" {
+		reservationID = params.ReservationID
+	}
+
+	// Fetch the input stored by the instance that queued the job
+	inputPath := params.InputPath
+	if params.InputKey != "" {
+		inputPath = filepath.Join(h.config.UploadDir, job.ID+"-input.pdf")
+		if err := storage.FetchFile(ctx, h.storage, params.InputKey, inputPath); err != nil {
+			if reservationID != "" {
+				settle(h.balanceService, reservationID, false)
 			}
+			return nil, fmt.Errorf("failed to fetch split input: %w", err)
 		}
+		defer os.Remove(inputPath)
+		defer h.storage.Delete(context.Background(), params.InputKey)
 	}
 
-	// Fallback method 1: Try using pdfinfo if available
-	pdfInfoCmd := exec.Command("pdfinfo", pdfPath)
-	pdfInfoOutput, pdfInfoErr := pdfInfoCmd.CombinedOutput()
-
-	if pdfInfoErr == nil {
-		re := regexp.MustCompile(`Pages:\s*(\d+)`)
-		matches := re.FindStringSubmatch(string(pdfInfoOutput))
-
-		if len(matches) >= 2 {
-			fmt.Printf("Found page count using pdfinfo\n")
-			return strconv.Atoi(matches[1])
-		}
-	}
+	// Indicate processing is ongoing
+	progress(10)
 
-	// Fallback method 2: Count the pages using pdf-lib
-	tempDir, err := os.MkdirTemp("", "pdfsplit")
+	parts, err := h.splitPDF(ctx, job.UserID, inputPath, job.ID, pdfops.SplitOptions{
+		Method:      params.SplitMethod,
+		PageRanges:  params.PageRanges,
+		EveryNPages: params.EveryNPages,
+		TotalPages:  params.TotalPages,
+		Progress: func(done, total int) {
+			progress(10 + done*90/total)
+		},
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Try to extract all pages and count them
-	for i := 1; i <= 10000; i++ { // Set a reasonable upper limit
-		testPagePath := filepath.Join(tempDir, fmt.Sprintf("page-%d.pdf", i))
-
-		// Try to extract the page
-		extractCmd := exec.Command(
-			"pdfcpu",
-			"extract",
-			"-mode", "page",
-			"-pages", fmt.Sprintf("%d", i),
-			pdfPath,
-			testPagePath,
-		)
-
-		extractErr := extractCmd.Run()
-
-		// If extraction fails, we've reached the end
-		if extractErr != nil || !fileExists(testPagePath) {
-			// We've found i-1 pages
-			if i > 1 {
-				fmt.Printf("Counted %d pages using page extraction method\n", i-1)
-				return i - 1, nil
-			}
-			break
+		if reservationID != "" {
+			settle(h.balanceService, reservationID, false)
 		}
+		return nil, err
 	}
 
-	// Last resort fallback: try to parse as byte ranges
-	// This is not very reliable but might work in some cases
-	pdfBytes, err := os.ReadFile(pdfPath)
-	if err == nil {
-		// Look for "/Type /Page" pattern and count occurrences
-		pageSig := []byte("/Type /Page")
-		count := bytes.Count(pdfBytes, pageSig)
-
-		if count > 0 {
-			fmt.Printf("Estimated %d pages by counting /Type /Page occurrences\n", count)
-			return count, nil
+	if reservationID != "" {
+		if err := h.balanceService.Commit(reservationID); err != nil {
+			// The parts are not returned and expire with their artifacts. A
+			// reservation still held is released once it expires.
+			log.Printf("ERROR: Failed to charge split job %s (reservation %s): %v", job.ID, reservationID, err)
+			return nil, fmt.Errorf("failed to charge the split: %w", err)
 		}
 	}
-
-	// If all methods fail, return error
-	return 0, fmt.Errorf("could not determine page count using any available method")
-}
-
-// Helper function to check if a file exists
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
-}
-
-// Helper function to count pages in a range string
-func countPagesInRange(rangeStr string) int {
-	// Handle single page
-	if !strings.Contains(rangeStr, "-") {
-		return 1
-	}
-
-	// Handle page range
-	parts := strings.Split(rangeStr, "-")
-	if len(parts) != 2 {
-		return 0
-	}
-
-	start, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
-	end, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
-
-	if err1 != nil || err2 != nil || start > end {
-		return 0
-	}
-
-	return end - start + 1
-}
-
-// Helper function to parse page ranges
-func parsePageRanges(rangesStr string, totalPages int) []string {
-	var validRanges []string
-
-	// Split by comma
-	parts := strings.Split(rangesStr, ",")
-
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed == "" {
-			continue
-		}
-
-		// Validate range
-		if strings.Contains(trimmed, "-") {
-			// It's a range like "1-5"
-			rangeParts := strings.Split(trimmed, "-")
-			if len(rangeParts) != 2 {
-				continue // Invalid format
-			}
-
-			start, err1 := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
-			end, err2 := strconv.Atoi(strings.TrimSpace(rangeParts[1]))
-
-			if err1 != nil || err2 != nil || start < 1 || end > totalPages || start > end {
-				continue // Invalid range
-			}
-
-			validRanges = append(validRanges, trimmed)
-		} else {
-			// It's a single page like "3"
-			page, err := strconv.Atoi(trimmed)
-			if err != nil || page < 1 || page > totalPages {
-				continue // Invalid page
-			}
-
-			validRanges = append(validRanges, trimmed)
-		}
-	}
-
-	return validRanges
+	return parts, nil
 }
 
 // Status endpoint to retrieve job status
@@ -1584,37 +652,68 @@ func (h *PDFHandler) GetSplitStatus(c *gin.Context) {
 		return
 	}
 
-	// Check if status file exists
-	statusPath := filepath.Join(h.config.PublicDir, "status", jobId+"-status.json")
-
-	if _, err := os.Stat(statusPath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Job not found",
-			"jobId": jobId,
+	if h.jobService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Background processing is not available",
 		})
 		return
 	}
 
-	// Read status file
-	statusData, err := os.ReadFile(statusPath)
+	job, err := h.jobService.GetUserJob(jobId, c.GetString("userId"))
 	if err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Job not found",
+				"jobId": jobId,
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to read job status: " + err.Error(),
 		})
 		return
 	}
 
-	// Parse status JSON
-	var status map[string]interface{}
-	if err := json.Unmarshal(statusData, &status); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Invalid status data: " + err.Error(),
-		})
-		return
+	// Map the job onto the split status format clients already understand
+	var params splitJobParams
+	json.Unmarshal([]byte(job.Params), &params)
+
+	results := []gin.H{}
+	if job.Result != "" {
+		if err := json.Unmarshal([]byte(job.Result), &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Invalid status data: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	status := "processing"
+	var jobError interface{}
+	switch job.Status {
+	case models.JobStatusCompleted:
+		status = "completed"
+	case models.JobStatusFailed, models.JobStatusCancelled:
+		status = "error"
+		jobError = job.Error
+	}
+
+	total := params.EstimatedSplits
+	completed := 0
+	if job.Status == models.JobStatusCompleted {
+		total = len(results)
+		completed = len(results)
 	}
 
-	// Return status
-	c.JSON(http.StatusOK, status)
+	c.JSON(http.StatusOK, gin.H{
+		"id":        job.ID,
+		"status":    status,
+		"progress":  job.Progress,
+		"total":     total,
+		"completed": completed,
+		"results":   results,
+		"error":     jobError,
+	})
 }
 
 // WatermarkPDF godoc
@@ -1623,7 +722,7 @@ func (h *PDFHandler) GetSplitStatus(c *gin.Context) {
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to watermark (max 50MB)"
+// @Param file formData file true "PDF file to watermark, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param watermarkType formData string true "Type of watermark (text or image)" Enums(text, image)
 // @Param text formData string false "Text for watermark (required if watermarkType is text)"
 // @Param textColor formData string false "Color for text watermark (hex format, e.g. #FF0000)" default("#FF0000")
@@ -1639,138 +738,21 @@ func (h *PDFHandler) GetSplitStatus(c *gin.Context) {
 // @Param customX formData integer false "Custom X position percentage (0-100, required if position is custom)" minimum(0) maximum(100)
 // @Param customY formData integer false "Custom Y position percentage (0-100, required if position is custom)" minimum(0) maximum(100)
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
 // @Failure 500 {object} object{error=string}
 // @Router /api/pdf/watermark [post]
-func buildWatermarkDescription(watermarkType, position string, rotation, opacity, scale int, textColor string) string {
-	// Map for full position names to position codes
-	nameToCodeMap := map[string]string{
-		"center":        "c",
-		"top-left":      "tl",
-		"top-center":    "tc",
-		"top-right":     "tr",
-		"left":          "l",
-		"right":         "r",
-		"bottom-left":   "bl",
-		"bottom-center": "bc",
-		"bottom-right":  "br",
-	}
-
-	// Map for position codes to themselves (pass-through)
-	codeToCodeMap := map[string]string{
-		"c":  "c",
-		"tl": "tl",
-		"tc": "tc",
-		"tr": "tr",
-		"l":  "l",
-		"r":  "r",
-		"bl": "bl",
-		"bc": "bc",
-		"br": "br",
-	}
-
-	// Try to get position from name map first, then code map
-	pdfcpuPosition := nameToCodeMap[position]
-	if pdfcpuPosition == "" {
-		// If not found in name map, check if it's a direct code
-		pdfcpuPosition = codeToCodeMap[position]
-		if pdfcpuPosition == "" {
-			// Default to center if not recognized
-			pdfcpuPosition = "c"
-			fmt.Printf("Warning: Unrecognized position value '%s', defaulting to center\n", position)
-		}
-	}
-
-	// Convert opacity to 0-1 range
-	opacityValue := float64(opacity) / 100.0
-
-	// Convert scale to 0-1 range
-	scaleValue := float64(scale) / 100.0
-
-	// Format the color for pdfcpu (convert from #RRGGBB to R G B values between 0 and 1)
-	colorStr := "0.5 0.5 0.5" // Default gray
-	if textColor != "" && len(textColor) >= 7 {
-		r, _ := strconv.ParseInt(textColor[1:3], 16, 0)
-		g, _ := strconv.ParseInt(textColor[3:5], 16, 0)
-		b, _ := strconv.ParseInt(textColor[5:7], 16, 0)
-		colorStr = fmt.Sprintf("%.1f %.1f %.1f", float64(r)/255.0, float64(g)/255.0, float64(b)/255.0)
-	}
-
-	// Fix the rotation direction by making it negative
-	// pdfcpu appears to use the opposite rotation direction from what's expected in the UI
-	fixedRotation := -rotation
-
-	// Build a simple description with only the essential parameters
-	// Always include rotation even if it's 0
-	description := fmt.Sprintf("pos:%s, color:%s, op:%.1f, rot:%d, scale:%.1f",
-		pdfcpuPosition,
-		colorStr,
-		opacityValue,
-		fixedRotation, // Use the fixed rotation value
-		scaleValue)
-
-	log.Printf("Created watermark description: %s (from position: %s, rotation: %d -> %d)\n",
-		description, position, rotation, fixedRotation)
-	return description
-}
-
-// applyWatermarkStandard applies watermark using pdfcpu with simplified parameters
-func (h *PDFHandler) applyWatermarkStandard(inputPath, outputPath, watermarkType, watermarkContent, description, pages, customPages string) (bool, error) {
-	// Build pdfcpu command
-	args := []string{"watermark", "add", "-mode", watermarkType}
-
-	// Add page selection if needed
-	if pages != "all" {
-		if pages == "custom" {
-			args = append(args, "-pages", customPages)
-		} else {
-			args = append(args, "-pages", pages)
-		}
-	}
-
-	// Add the files - correct order is crucial: content, description, input, output
-	args = append(args, "--", watermarkContent, description, inputPath, outputPath)
-
-	log.Printf("Executing: pdfcpu %s", strings.Join(args, " "))
-
-	// Execute command with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "pdfcpu", args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if err != nil {
-		combinedOutput := strings.TrimSpace(stdout.String() + "\n" + stderr.String())
-		log.Printf("Command failed: %v, output: %s", err, combinedOutput)
-		return false, fmt.Errorf("pdfcpu error: %s", combinedOutput)
-	}
-
-	// Check if output file exists and has content
-	if fileInfo, err := os.Stat(outputPath); err != nil || fileInfo.Size() == 0 {
-		return false, fmt.Errorf("output file not created or empty")
-	}
-
-	return true, nil
-}
-
-// Modified version of WatermarkPDF to use the simplified approach
 func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 	// Get user ID and operation type from context
 	userID, exists := c.Get("userId")
-	operation, _ := c.Get("operationType")
+	var billing *services.OperationResult
 
 	// Process the operation charge
 	if exists {
 		log.Printf("Processing operation for userID: %s", userID)
-		result, err := h.balanceService.ProcessOperation(userID.(string), "Watermark")
+		reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Watermark")
 		if err != nil {
 			log.Printf("Balance service error for user %s: %v", userID, err)
 			if strings.Contains(strings.ToLower(err.Error()), "database") {
@@ -1788,6 +770,7 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 		if !result.Success {
 			c.JSON(http.StatusPaymentRequired, gin.H{
 				"error": result.Error,
+				"code":  result.Code,
 				"details": gin.H{
 					"balance":                 result.CurrentBalance,
 					"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -1796,10 +779,12 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 			})
 			return
 		}
+		defer settleReservation(c, h.balanceService, reservation)
+		billing = result
 	}
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		log.Printf("Failed to get file: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -1816,97 +801,68 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 		return
 	}
 
-	// Check file size (max 50MB)
-	if file.Size > 50*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "File size exceeds 50MB limit",
-		})
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
 		return
 	}
 
-	// Get watermark type
-	watermarkType := c.DefaultPostForm("watermarkType", "text")
-	if watermarkType != "text" && watermarkType != "image" && watermarkType != "pdf" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid watermark type. Must be 'text', 'image', or 'pdf'",
-		})
-		return
+	// An image or PDF watermark is uploaded as content or, in older
+	// clients, as watermarkImage; otherwise content holds it in base64
+	fields := pdfops.WatermarkFields{
+		Type:        c.PostForm("watermarkType"),
+		Content:     c.PostForm("content"),
+		Text:        c.PostForm("text"),
+		Position:    c.PostForm("position"),
+		Rotation:    c.PostForm("rotation"),
+		Opacity:     c.PostForm("opacity"),
+		Scale:       c.PostForm("scale"),
+		TextColor:   c.PostForm("textColor"),
+		Pages:       c.PostForm("pages"),
+		CustomPages: c.PostForm("customPages"),
 	}
-
-	// Get watermark content
-	var watermarkContent string
-	var watermarkImage *multipart.FileHeader
-
-	if watermarkType == "text" {
-		// For text watermarks - try both "content" and older "text" parameter
-		watermarkContent = c.PostForm("content")
-		if watermarkContent == "" {
-			watermarkContent = c.PostForm("text")
-		}
-
-		if watermarkContent == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Text is required for text watermarks",
-			})
-			return
-		}
-	} else if watermarkType == "image" {
-		// For image watermarks
-		watermarkImage, err = c.FormFile("content")
+	if fields.Type == pdfops.WatermarkImage || fields.Type == pdfops.WatermarkPDF {
+		watermarkImage, err := formInputFile(c, "content")
 		if err != nil {
-			watermarkImage, err = c.FormFile("watermarkImage") // Try legacy parameter
+			watermarkImage, err = formInputFile(c, "watermarkImage")
+		}
+		if err == nil {
+			image, err := watermarkImage.Open()
 			if err != nil {
-				// Check for base64 content
-				base64Image := c.PostForm("content")
-				if base64Image == "" {
-					c.JSON(http.StatusBadRequest, gin.H{
-						"error": "Image is required for image watermarks",
-					})
-					return
-				}
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to read watermark image: " + err.Error(),
+				})
+				return
 			}
+			defer image.Close()
+			fields.Image = image
+			fields.ImageName = watermarkImage.Filename
 		}
 	}
-	position := c.PostForm("position")
-	log.Printf("Received position parameter: '%s'", position)
-
-	// Use default position if not provided
-	if position == "" {
-		position = "c"
-		log.Printf("Using default position: 'c'")
-	}
-	rotationStr := c.DefaultPostForm("rotation", "0")
-	rotation, _ := strconv.Atoi(rotationStr)
-	log.Printf("Received rotation: %d degrees", rotation)
-	opacityStr := c.DefaultPostForm("opacity", "50")
-	scaleStr := c.DefaultPostForm("scale", "100")
-	textColor := c.DefaultPostForm("textColor", "#808080")
-	pages := c.DefaultPostForm("pages", "all")
-	customPages := c.DefaultPostForm("customPages", "")
-
-	opacity, _ := strconv.Atoi(opacityStr)
-	if opacity < 10 || opacity > 100 {
-		opacity = 50 // Default to 50% if out of range
+	opts, err := pdfops.NewWatermarkOptions(fields)
+	if pdfops.IsInputError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
-
-	scale, _ := strconv.Atoi(scaleStr)
-	if scale < 10 || scale > 200 {
-		scale = 100 // Default to 100% if out of range
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read watermark: " + err.Error(),
+		})
+		return
 	}
 
 	// Create unique ID and paths
 	uniqueID := uuid.New().String()
 	inputPath := filepath.Join(h.config.UploadDir, uniqueID+"-input.pdf")
 	outputPath := filepath.Join(h.config.PublicDir, "watermarked", uniqueID+"-watermarked.pdf")
-	var watermarkPath string
-	var tempFiles []string // Track temp files to clean up
 
 	// Ensure directories exist
 	os.MkdirAll(h.config.UploadDir, 0755)
 	os.MkdirAll(filepath.Join(h.config.PublicDir, "watermarked"), 0755)
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		log.Printf("Failed to save PDF file %s: %v", inputPath, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save PDF file: " + err.Error(),
@@ -1915,85 +871,32 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 	}
 	defer os.Remove(inputPath) // Clean up after processing
 
-	// Handle watermark content based on type
-	if watermarkType == "image" && watermarkImage != nil {
-		// Handle uploaded image
-		watermarkPath = filepath.Join(h.config.UploadDir, uniqueID+"-watermark"+filepath.Ext(watermarkImage.Filename))
-		tempFiles = append(tempFiles, watermarkPath)
-
-		if err := c.SaveUploadedFile(watermarkImage, watermarkPath); err != nil {
-			log.Printf("Failed to save watermark image %s: %v", watermarkPath, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to save watermark image: " + err.Error(),
-			})
-			return
-		}
-
-		watermarkContent = watermarkPath
-	} else if watermarkType == "image" {
-		// Handle base64 image
-		base64Image := c.PostForm("content")
-		if base64Image != "" {
-			watermarkPath = filepath.Join(h.config.UploadDir, uniqueID+"-watermark.png")
-			tempFiles = append(tempFiles, watermarkPath)
-
-			if err := saveBase64File(base64Image, watermarkPath); err != nil {
-				log.Printf("Failed to save base64 image: %v", err)
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "Invalid image data: " + err.Error(),
-				})
-				return
-			}
-
-			watermarkContent = watermarkPath
-		}
-	}
-
-	// Create simplified watermark description
-	description := buildWatermarkDescription(
-		watermarkType, position, rotation, opacity, scale, textColor)
-
-	log.Printf("Using watermark description: %s", description)
-
 	// Apply watermark
-	success, err := h.applyWatermarkStandard(
-		inputPath, outputPath, watermarkType, watermarkContent, description, pages, customPages)
-
-	// Clean up temp files
-	for _, tempFile := range tempFiles {
-		os.Remove(tempFile)
-	}
-
-	if !success {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to add watermark to PDF: %v", err),
-		})
-		return
-	}
-
-	// Verify output file was created and has content
-	fileInfo, err := os.Stat(outputPath)
-	if err != nil {
-		log.Printf("Output file not created: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create watermarked PDF file",
+	err = pdfops.Watermark(c.Request.Context(), inputPath, outputPath, opts)
+	if pdfops.IsInputError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
-
-	if fileInfo.Size() == 0 {
-		log.Printf("Output file is empty")
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Watermarked PDF file is empty",
+			"error": fmt.Sprintf("Failed to add watermark to PDF: %v", err),
 		})
 		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=watermarked&filename=%s-watermarked.pdf", uniqueID)
+	// Get the watermarked file size before it moves into storage
+	var watermarkedSize int64
+	if fileInfo, err := os.Stat(outputPath); err == nil {
+		watermarkedSize = fileInfo.Size()
+	}
 
-	// Get the watermarked file info for size
-	watermarkedSize := fileInfo.Size()
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "watermarked", outputPath)
+	if !ok {
+		return
+	}
 
 	log.Printf("Watermark operation completed successfully. Output file size: %d bytes", watermarkedSize)
 
@@ -2002,24 +905,24 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 		"success":      true,
 		"message":      "Watermark added to PDF successfully",
 		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     fmt.Sprintf("%s-watermarked.pdf", uniqueID),
 		"originalName": file.Filename,
 		"fileSize":     watermarkedSize,
 	}
 
 	// Add billing info if available
-	if exists {
-		result, _ := h.balanceService.ProcessOperation(userID.(string), operation.(string))
+	if billing != nil {
 		var opCost float64
-		if result.UsedFreeOperation {
+		if billing.UsedFreeOperation {
 			opCost = 0
 		} else {
 			opCost = constants.OperationCost
 		}
 		response["billing"] = gin.H{
-			"usedFreeOperation":       result.UsedFreeOperation,
-			"freeOperationsRemaining": result.FreeOperationsRemaining,
-			"currentBalance":          result.CurrentBalance,
+			"usedFreeOperation":       billing.UsedFreeOperation,
+			"freeOperationsRemaining": billing.FreeOperationsRemaining,
+			"currentBalance":          billing.CurrentBalance,
 			"operationCost":           opCost,
 		}
 	}
@@ -2027,33 +930,16 @@ func (h *PDFHandler) WatermarkPDF(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// Helper function for base64 decoding
-func saveBase64File(base64Data, filePath string) error {
-	// Remove data URL prefix if present
-	if idx := strings.Index(base64Data, ";base64,"); idx > 0 {
-		base64Data = base64Data[idx+8:]
-	}
-
-	// Decode base64 string
-	decoded, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		return err
-	}
-
-	// Write to file
-	return os.WriteFile(filePath, decoded, 0644)
-}
-
 // UnlockPDF godoc
 // @Summary Remove password protection from a PDF file
 // @Description Removes password protection from a PDF file
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to unlock (max 50MB)"
+// @Param file formData file true "PDF file to unlock, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param password formData string true "Current PDF password"
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -2064,7 +950,7 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 	userID, _ := c.Get("userId")
 
 	// Process the operation charge
-	result, err := h.balanceService.ProcessOperation(userID.(string), "Unlock")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Unlock")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -2075,6 +961,7 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -2083,9 +970,10 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -2093,6 +981,11 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
+
 	// Check file extension
 	if filepath.Ext(file.Filename) != ".pdf" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2116,7 +1009,7 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "unlocked", uniqueID+"-unlocked.pdf")
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
 		})
@@ -2125,30 +1018,25 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 	defer os.Remove(inputPath)
 
 	// Unlock PDF using pdfcpu
-	cmd := exec.Command(
-		"pdfcpu",
-		"decrypt",
-		"-upw", password,
-		inputPath,
-		outputPath,
-	)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+	if err := pdfops.Unlock(c.Request.Context(), inputPath, outputPath, pdfops.UnlockOptions{Password: password}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to unlock PDF. The password may be incorrect: " + string(output),
+			"error": "Failed to unlock PDF. The password may be incorrect: " + err.Error(),
 		})
 		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=unlocked&filename=%s-unlocked.pdf", uniqueID)
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "unlocked", outputPath)
+	if !ok {
+		return
+	}
 
 	// Return response
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      "PDF unlocked successfully",
 		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     fmt.Sprintf("%s-unlocked.pdf", uniqueID),
 		"originalName": file.Filename,
 		"billing": gin.H{
@@ -2166,9 +1054,9 @@ func (h *PDFHandler) UnlockPDF(c *gin.Context) {
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to compress (max 50MB)"
+// @Param file formData file true "PDF file to compress, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,originalSize=integer,compressedSize=integer,compressionRatio=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,originalSize=integer,compressedSize=integer,compressionRatio=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -2179,7 +1067,7 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 	userID, _ := c.Get("userId")
 
 	// Process the operation charge
-	result, err := h.balanceService.ProcessOperation(userID.(string), "Compress")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Compress")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -2190,6 +1078,7 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -2198,9 +1087,10 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -2208,6 +1098,11 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
+
 	// Check file extension
 	if filepath.Ext(file.Filename) != ".pdf" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2222,76 +1117,45 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "compressions", uniqueID+"-compressed.pdf")
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
 		})
 		return
 	}
 	defer os.Remove(inputPath)
-	pageCount, err := api.PageCountFile(inputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get PDF page count: " + err.Error(),
-		})
-		return
-	}
 
-	if pageCount == 0 {
+	// Compress the PDF using pdfcpu optimize (maximum compression)
+	compressed, err := pdfops.Compress(c.Request.Context(), inputPath, outputPath, pdfops.CompressOptions{})
+	if errors.Is(err, pdfops.ErrNoPages) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "PDF contains no pages",
 		})
 		return
 	}
-	// Compress the PDF using pdfcpu optimize (maximum compression)
-	cmd := exec.Command(
-		"pdfcpu",
-		"optimize",
-		inputPath,
-		outputPath,
-	)
-
-	output, err := cmd.CombinedOutput()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to compress PDF: " + string(output),
+			"error": "Failed to compress PDF: " + err.Error(),
 		})
 		return
 	}
 
-	// Get file sizes
-	originalFileInfo, err := os.Stat(inputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get original file size: " + err.Error(),
-		})
-		return
-	}
+	originalSize := compressed.OriginalSize
+	compressedSize := compressed.CompressedSize
+	compressionRatio := compressed.Ratio()
 
-	compressedFileInfo, err := os.Stat(outputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get compressed file size: " + err.Error(),
-		})
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "compressions", outputPath)
+	if !ok {
 		return
 	}
 
-	// Calculate compression ratio
-	originalSize := originalFileInfo.Size()
-	compressedSize := compressedFileInfo.Size()
-	var compressionRatio float64
-	if originalSize > 0 {
-		compressionRatio = float64(originalSize-compressedSize) / float64(originalSize) * 100
-	}
-
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=compressions&filename=%s-compressed.pdf", uniqueID)
-
 	// Return response
 	c.JSON(http.StatusOK, gin.H{
 		"success":          true,
 		"message":          fmt.Sprintf("PDF compression successful with %.2f%% reduction", compressionRatio),
 		"fileUrl":          fileURL,
+		"fileId":           fileID,
 		"filename":         fmt.Sprintf("%s-compressed.pdf", uniqueID),
 		"originalName":     file.Filename,
 		"originalSize":     originalSize,
@@ -2312,11 +1176,11 @@ func (h *PDFHandler) CompressPDF(c *gin.Context) {
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to rotate (max 50MB)"
+// @Param file formData file true "PDF file to rotate, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param angle formData integer true "Rotation angle in degrees" Enums(90, 180, 270)
 // @Param pages formData string false "Pages to rotate (e.g., '1-3,5,7-9'), empty for all pages" default(all)
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -2327,7 +1191,7 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 	userID, _ := c.Get("userId")
 
 	// Process the operation charge
-	result, err := h.balanceService.ProcessOperation(userID.(string), "rotate")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "rotate")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -2338,6 +1202,7 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -2346,9 +1211,10 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -2356,6 +1222,11 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
+
 	// Check file extension
 	if filepath.Ext(file.Filename) != ".pdf" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2390,7 +1261,7 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "rotations", uniqueID+"-rotated.pdf")
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
 		})
@@ -2398,22 +1269,26 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 	}
 	defer os.Remove(inputPath)
 
-	// Use pdfcpu API instead of command line for better control
-	if err := h.rotatePagesInPDF(inputPath, outputPath, angle, pagesStr); err != nil {
+	rotateOptions := pdfops.RotateOptions{Angle: angle, Pages: pagesStr}
+	if err := pdfops.Rotate(c.Request.Context(), inputPath, outputPath, rotateOptions); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to rotate PDF: " + err.Error(),
 		})
 		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=rotations&filename=%s-rotated.pdf", uniqueID)
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "rotations", outputPath)
+	if !ok {
+		return
+	}
 
 	// Return response
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      fmt.Sprintf("PDF rotated by %d degrees successfully", angle),
 		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     fmt.Sprintf("%s-rotated.pdf", uniqueID),
 		"originalName": file.Filename,
 		"billing": gin.H{
@@ -2425,73 +1300,20 @@ func (h *PDFHandler) RotatePDF(c *gin.Context) {
 	})
 }
 
-// rotatePagesInPDF rotates specific pages in a PDF file using pdfcpu directly
-func (h *PDFHandler) rotatePagesInPDF(inputPath, outputPath string, angle int, pagesStr string) error {
-	// Create a temporary directory for processing
-	tempDir, err := os.MkdirTemp("", "pdf-rotation")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Copy the original file to the temp directory
-	tempPath := filepath.Join(tempDir, "working.pdf")
-	if err := copyFile(inputPath, tempPath); err != nil {
-		return fmt.Errorf("failed to copy PDF to temp location: %w", err)
-	}
-
-	// Build the pdfcpu command
-	var args []string
-
-	// Handle "all" pages case
-	if pagesStr == "all" {
-		args = []string{
-			"rotate",
-			tempPath,
-			fmt.Sprintf("%d", angle),
-		}
-	} else {
-		// Format specific pages
-		args = []string{
-			"rotate",
-			fmt.Sprintf("-pages=%s", pagesStr),
-			tempPath,
-			fmt.Sprintf("%d", angle),
-		}
-	}
-
-	// Log the command for debugging
-	fmt.Printf("pdfcpu command: pdfcpu %s\n", strings.Join(args, " "))
-
-	// Execute the pdfcpu command
-	cmd := exec.Command("pdfcpu", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("pdfcpu command failed: %s - %w", string(output), err)
-	}
-
-	// Now copy the modified file to the output path
-	if err := copyFile(tempPath, outputPath); err != nil {
-		return fmt.Errorf("failed to copy modified PDF to output location: %w", err)
-	}
-
-	return nil
-}
-
 // ProtectPDF godoc
 // @Summary Password protect a PDF file
 // @Description Adds password protection and permission restrictions to a PDF file
 // @Tags pdf
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "PDF file to protect (max 50MB)"
+// @Param file formData file true "PDF file to protect, at most the limit of your plan or the api.maxFileSize setting. Big files can instead be uploaded in chunks with /api/uploads and sent as uploadId"
 // @Param password formData string true "Password to set for the PDF (minimum 4 characters)"
 // @Param permission formData string false "Permission level: restricted (apply specific permissions) or all (grant all permissions)" Enums(restricted, all) default(restricted)
 // @Param allowPrinting formData boolean false "Allow document printing" default(false)
 // @Param allowCopying formData boolean false "Allow content copying" default(false)
 // @Param allowEditing formData boolean false "Allow content editing" default(false)
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,originalName=string,methodUsed=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,originalName=string,methodUsed=string,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -2524,7 +1346,7 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 	}
 
 	// Process the operation charge
-	result, err := h.balanceService.ProcessOperation(userIDStr, "Protect")
+	reservation, result, err := h.balanceService.Reserve(userIDStr, c.GetString("apiKeyId"), "Protect")
 	if err != nil {
 		log.Printf("Balance service error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -2536,6 +1358,7 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -2544,9 +1367,10 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 		})
 		return
 	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		log.Printf("Failed to get file: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2555,6 +1379,11 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
+		return
+	}
+
 	// Check file extension
 	if strings.ToLower(filepath.Ext(file.Filename)) != ".pdf" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2585,11 +1414,11 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 	// Set permFlag for pdfcpu
 	var permFlag string
 	if permission == "all" {
-		permFlag = "all"
+		permFlag = pdfops.PermissionsAll
 	} else if allowPrinting {
-		permFlag = "print"
+		permFlag = pdfops.PermissionsPrint
 	} else {
-		permFlag = "none"
+		permFlag = pdfops.PermissionsNone
 	}
 
 	// Create unique file names
@@ -2614,7 +1443,7 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 	}
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		log.Printf("Failed to save file: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
@@ -2624,33 +1453,31 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 	defer os.Remove(inputPath)
 
 	// Protect the PDF using pdfcpu
-	cmd := exec.Command(
-		"pdfcpu",
-		"encrypt",
-		"-upw", password,
-		"-opw", password,
-		"-perm", permFlag,
-		inputPath,
-		outputPath,
-	)
-	log.Printf("Running pdfcpu command: %v", cmd.Args)
-	output, err := cmd.CombinedOutput()
+	err = pdfops.Protect(c.Request.Context(), inputPath, outputPath, pdfops.ProtectOptions{
+		UserPassword:  password,
+		OwnerPassword: password,
+		Permissions:   permFlag,
+	})
 	if err != nil {
-		log.Printf("pdfcpu failed: %v, output: %s", err, string(output))
+		log.Printf("pdfcpu failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to protect PDF: " + string(output),
+			"error": "Failed to protect PDF: " + err.Error(),
 		})
 		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=protected&filename=%s-protected.pdf", uniqueID)
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "protected", outputPath)
+	if !ok {
+		return
+	}
 
 	// Return response
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      "PDF protected with password successfully",
 		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     fmt.Sprintf("%s-protected.pdf", uniqueID),
 		"originalName": file.Filename,
 		"methodUsed":   "pdfcpu",
@@ -2672,7 +1499,7 @@ func (h *PDFHandler) ProtectPDF(c *gin.Context) {
 // @Param files formData file true "PDF files to merge (multiple files)"
 // @Param order formData string false "JSON array specifying the order of files (e.g., [2,0,1])"
 // @Security ApiKeyAuth
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,filename=string,mergedSize=integer,totalInputSize=integer,fileCount=integer,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,mergedSize=integer,totalInputSize=integer,fileCount=integer,billing=object{usedFreeOperation=boolean,freeOperationsRemaining=integer,currentBalance=number,operationCost=number}}
 // @Failure 400 {object} object{error=string}
 // @Failure 401 {object} object{error=string}
 // @Failure 402 {object} object{error=string,details=object{balance=number,freeOperationsRemaining=integer,operationCost=number}}
@@ -2683,7 +1510,7 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 	userID, _ := c.Get("userId")
 
 	// Process the operation charge
-	result, err := h.balanceService.ProcessOperation(userID.(string), "Merge")
+	reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Merge")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process operation: " + err.Error(),
@@ -2694,6 +1521,7 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 	if !result.Success {
 		c.JSON(http.StatusPaymentRequired, gin.H{
 			"error": result.Error,
+			"code":  result.Code,
 			"details": gin.H{
 				"balance":                 result.CurrentBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -2702,25 +1530,16 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 		})
 		return
 	}
-
-	// Parse multipart form
-	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to parse form: " + err.Error(),
-		})
-		return
-	}
+	defer settleReservation(c, h.balanceService, reservation)
 
 	// Get files
-	form, err := c.MultipartForm()
+	files, err := formInputFiles(c, "files")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to get form: " + err.Error(),
+			"error": "Failed to parse form: " + err.Error(),
 		})
 		return
 	}
-
-	files := form.File["files"]
 	if len(files) < 2 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "At least two PDF files are required for merging",
@@ -2728,7 +1547,7 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 		return
 	}
 
-	// Check all files are PDFs
+	// Check all files are PDFs within the plan's size limit
 	for _, file := range files {
 		if filepath.Ext(file.Filename) != ".pdf" {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -2736,34 +1555,18 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 			})
 			return
 		}
+		if rejectLargeUpload(c, file.Filename, file.Size) {
+			return
+		}
 	}
 
-	// Parse file order if provided
-	fileOrder := make([]int, len(files))
-	for i := range fileOrder {
-		fileOrder[i] = i // Default order
-	}
-
+	// Parse file order if provided; an invalid order keeps the upload order
+	var mergeOptions pdfops.MergeOptions
 	orderStr := c.PostForm("order")
 	if orderStr != "" {
 		var order []int
-		if err := json.Unmarshal([]byte(orderStr), &order); err == nil {
-			// Validate order
-			valid := true
-			if len(order) == len(files) {
-				seen := make(map[int]bool)
-				for _, idx := range order {
-					if idx < 0 || idx >= len(files) || seen[idx] {
-						valid = false
-						break
-					}
-					seen[idx] = true
-				}
-
-				if valid {
-					fileOrder = order
-				}
-			}
+		if err := json.Unmarshal([]byte(orderStr), &order); err == nil && pdfops.ValidMergeOrder(order, len(files)) {
+			mergeOptions.Order = order
 		}
 	}
 
@@ -2787,7 +1590,7 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 
 	for i, file := range files {
 		inputPath := filepath.Join(tempDir, fmt.Sprintf("input-%d.pdf", i))
-		if err := c.SaveUploadedFile(file, inputPath); err != nil {
+		if err := file.Save(inputPath); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to save file: " + err.Error(),
 			})
@@ -2803,24 +1606,10 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 		}
 	}
 
-	// Create ordered list of input files
-	orderedInputs := make([]string, len(files))
-	for i, idx := range fileOrder {
-		orderedInputs[i] = inputPaths[idx]
-	}
-
 	// Merge PDFs using pdfcpu
-	args := append([]string{
-		"merge",
-		outputPath,
-	}, orderedInputs...)
-
-	cmd := exec.Command("pdfcpu", args...)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+	if err := pdfops.Merge(c.Request.Context(), inputPaths, outputPath, mergeOptions); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to merge PDFs: " + string(output),
+			"error": "Failed to merge PDFs: " + err.Error(),
 		})
 		return
 	}
@@ -2834,14 +1623,18 @@ func (h *PDFHandler) MergePDFs(c *gin.Context) {
 		mergedSize = mergedFileInfo.Size()
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=merges&filename=%s-merged.pdf", uniqueID)
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "merges", outputPath)
+	if !ok {
+		return
+	}
 
 	// Return response
 	c.JSON(http.StatusOK, gin.H{
 		"success":        true,
 		"message":        "PDF merge successful",
 		"fileUrl":        fileURL,
+		"fileId":         fileID,
 		"filename":       fmt.Sprintf("%s-merged.pdf", uniqueID),
 		"mergedSize":     mergedSize,
 		"totalInputSize": totalInputSize,
@@ -2862,7 +1655,7 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 
 	// Process the operation charge if user is authenticated
 	if exists && userID != nil {
-		result, err := h.balanceService.ProcessOperation(userID.(string), "Remove")
+		reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "Remove")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to process operation: " + err.Error(),
@@ -2873,12 +1666,14 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 		if !result.Success {
 			c.JSON(http.StatusPaymentRequired, gin.H{
 				"error":                   result.Error,
-				"insufficientBalance":     true,
+				"code":                    result.Code,
+				"insufficientBalance":     result.Code == services.OperationCodeInsufficientBalance,
 				"freeOperationsRemaining": result.FreeOperationsRemaining,
 				"currentBalance":          result.CurrentBalance,
 			})
 			return
 		}
+		defer settleReservation(c, h.balanceService, reservation)
 	}
 
 	// Ensure the directories exist
@@ -2897,7 +1692,7 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 	}
 
 	// Get the uploaded file
-	uploadedFile, err := c.FormFile("file")
+	uploadedFile, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get uploaded file: " + err.Error(),
@@ -2905,6 +1700,11 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, uploadedFile.Filename, uploadedFile.Size) {
+		return
+	}
+
 	// Validate file type is PDF
 	if !strings.HasSuffix(strings.ToLower(uploadedFile.Filename), ".pdf") {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -2919,7 +1719,7 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "processed", uniqueID+"-output.pdf")
 
 	// Save the uploaded file
-	if err := c.SaveUploadedFile(uploadedFile, inputPath); err != nil {
+	if err := uploadedFile.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
 		})
@@ -2952,7 +1752,7 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 	}
 
 	// Get the total page count using the existing helper function
-	totalPages, err := getPDFPageCount(inputPath)
+	totalPages, err := pdfops.PageCount(c.Request.Context(), inputPath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to determine PDF page count: " + err.Error(),
@@ -2960,112 +1760,37 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 		return
 	}
 
-	// Create a set of pages to be removed for easy lookup
-	pagesToRemoveSet := make(map[int]bool)
-	for _, page := range pagesToRemove {
-		// Validate page numbers
-		if page < 1 || page > totalPages {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid page number: %d (total pages: %d)", page, totalPages),
-			})
-			return
-		}
-		pagesToRemoveSet[page] = true
-	}
-
-	// Make sure we're not removing all pages
-	if len(pagesToRemoveSet) >= totalPages {
+	// Keep every page that is not removed
+	removed, err := pdfops.RemovePages(c.Request.Context(), inputPath, outputPath, pdfops.RemovePagesOptions{
+		Pages:      pagesToRemove,
+		TotalPages: totalPages,
+	})
+	if pdfops.IsInputError(err) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Cannot remove all pages from PDF",
+			"error": err.Error(),
 		})
 		return
 	}
-
-	// Build the list of pages to keep as ranges for efficiency
-	var pagesToKeep []string
-	var rangeStart, rangeEnd int
-
-	for i := 1; i <= totalPages; i++ {
-		if !pagesToRemoveSet[i] {
-			if rangeStart == 0 {
-				rangeStart = i
-				rangeEnd = i
-			} else if i == rangeEnd+1 {
-				rangeEnd = i
-			} else {
-				// Add the previous range
-				if rangeStart == rangeEnd {
-					pagesToKeep = append(pagesToKeep, strconv.Itoa(rangeStart))
-				} else {
-					pagesToKeep = append(pagesToKeep, fmt.Sprintf("%d-%d", rangeStart, rangeEnd))
-				}
-				rangeStart = i
-				rangeEnd = i
-			}
-		}
-	}
-
-	// Add the final range
-	if rangeStart > 0 {
-		if rangeStart == rangeEnd {
-			pagesToKeep = append(pagesToKeep, strconv.Itoa(rangeStart))
-		} else {
-			pagesToKeep = append(pagesToKeep, fmt.Sprintf("%d-%d", rangeStart, rangeEnd))
-		}
-	}
-
-	// Create the page specification for pdfcpu
-	pageSpec := strings.Join(pagesToKeep, ",")
-
-	// Execute the pdfcpu trim command to keep only the specified pages
-	// Using pdfcpu trim syntax: pdfcpu trim -pages "1-2,4,6-8" input.pdf output.pdf
-	args := []string{
-		"trim",
-		"-pages", pageSpec,
-		inputPath,
-		outputPath,
-	}
-
-	// Log the command for debugging
-	fmt.Printf("Executing pdfcpu command: pdfcpu %s\n", strings.Join(args, " "))
-
-	// Execute pdfcpu to create the new PDF with selected pages
-	cmd := exec.Command("pdfcpu", args...)
-	output, err := cmd.CombinedOutput()
-
 	if err != nil {
-		fmt.Printf("pdfcpu command failed: %v\nOutput: %s\n", err, string(output))
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to remove pages: " + err.Error() + "\nOutput: " + string(output),
+			"error": "Failed to remove pages: " + err.Error(),
 		})
 		return
 	}
 
-	// Verify the output file exists and has content
-	fileInfo, err := os.Stat(outputPath)
-	if err != nil || fileInfo.Size() == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create output file or file is empty",
-		})
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "processed", outputPath)
+	if !ok {
 		return
 	}
 
-	// Generate output filename for the URL
-	outputFilename := uniqueID + "-output.pdf"
-	fileUrl := fmt.Sprintf("/api/file?folder=processed&filename=%s", outputFilename)
-
-	// Get page count of the result file for verification using the existing helper function
-	resultPages, err := getPDFPageCount(outputPath)
-	if err != nil {
-		// Don't fail the whole operation if we can't get result page count
-		fmt.Printf("Warning: Could not get result page count: %v\n", err)
-		resultPages = totalPages - len(pagesToRemove) // Estimate
-	}
+	resultPages := removed.ResultingPages
 
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{
 		"success":        true,
-		"fileUrl":        fileUrl,
+		"fileUrl":        fileURL,
+		"fileId":         fileID,
 		"originalPages":  totalPages,
 		"removedPages":   len(pagesToRemove),
 		"resultingPages": resultPages,
@@ -3074,17 +1799,15 @@ func (h *PDFHandler) RemovePagesFromPDF(c *gin.Context) {
 	})
 }
 
-// AddPageNumbersToPDF adds page numbers to a PDF file using pdfcpu stamp
-// AddPageNumbersToPDF adds page numbers to a PDF file using pdfcpu stamp
 // AddPageNumbersToPDF adds page numbers to a PDF file using pdfcpu stamp
 func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	// Get user ID and operation type from context
 	userID, exists := c.Get("userId")
-	operation, _ := c.Get("operationType")
+	var billing *services.OperationResult
 
 	// Process the operation charge
 	if exists {
-		result, err := h.balanceService.ProcessOperation(userID.(string), "AddPageNumbers")
+		reservation, result, err := h.balanceService.Reserve(userID.(string), c.GetString("apiKeyId"), "AddPageNumbers")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to process operation: " + err.Error(),
@@ -3095,6 +1818,7 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 		if !result.Success {
 			c.JSON(http.StatusPaymentRequired, gin.H{
 				"error": result.Error,
+				"code":  result.Code,
 				"details": gin.H{
 					"balance":                 result.CurrentBalance,
 					"freeOperationsRemaining": result.FreeOperationsRemaining,
@@ -3103,6 +1827,8 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 			})
 			return
 		}
+		defer settleReservation(c, h.balanceService, reservation)
+		billing = result
 	}
 
 	// Create necessary directories
@@ -3121,7 +1847,7 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	}
 
 	// Get file from form
-	file, err := c.FormFile("file")
+	file, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get file: " + err.Error(),
@@ -3137,86 +1863,29 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 		return
 	}
 
-	// Validate file size (max 50MB)
-	if file.Size > 50*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "File size exceeds 50MB limit",
-		})
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, file.Filename, file.Size) {
 		return
 	}
 
 	// Get page numbering options
-	position := c.DefaultPostForm("position", "bottom-center")
-	format := c.DefaultPostForm("format", "numeric")
-	fontFamily := c.DefaultPostForm("fontFamily", "Helvetica")
-	fontSizeStr := c.DefaultPostForm("fontSize", "12")
-	color := c.DefaultPostForm("color", "#000000")
-	startNumberStr := c.DefaultPostForm("startNumber", "1")
-	prefix := c.DefaultPostForm("prefix", "")
-	suffix := c.DefaultPostForm("suffix", "")
-	marginXStr := c.DefaultPostForm("marginX", "40")
-	marginYStr := c.DefaultPostForm("marginY", "30")
-	selectedPages := c.DefaultPostForm("selectedPages", "") // Empty means all pages
-	skipFirstPage := c.DefaultPostForm("skipFirstPage", "false") == "true"
-
-	// Validate position
-	validPositions := map[string]bool{
-		"top-left":      true,
-		"top-center":    true,
-		"top-right":     true,
-		"bottom-left":   true,
-		"bottom-center": true,
-		"bottom-right":  true,
-	}
-	if !validPositions[position] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid position. Must be one of: top-left, top-center, top-right, bottom-left, bottom-center, bottom-right",
-		})
-		return
-	}
-
-	// Validate format
-	validFormats := map[string]bool{
-		"numeric":    true,
-		"roman":      true,
-		"alphabetic": true,
-	}
-	if !validFormats[format] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid format. Must be one of: numeric, roman, alphabetic",
-		})
-		return
-	}
-
-	// Validate and parse numeric parameters
-	fontSize, err := strconv.Atoi(fontSizeStr)
-	if err != nil || fontSize <= 0 || fontSize > 72 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid font size. Must be a positive number between 1 and 72",
-		})
-		return
-	}
-
-	startNumber, err := strconv.Atoi(startNumberStr)
-	if err != nil || startNumber <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid start number. Must be a positive number",
-		})
-		return
-	}
-
-	marginX, err := strconv.Atoi(marginXStr)
-	if err != nil || marginX < 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid horizontal margin. Must be a non-negative number",
-		})
-		return
-	}
-
-	marginY, err := strconv.Atoi(marginYStr)
-	if err != nil || marginY < 0 {
+	opts, err := pdfops.NewNumberPagesOptions(pdfops.PageNumberFields{
+		Position:      c.PostForm("position"),
+		Format:        c.PostForm("format"),
+		FontFamily:    c.PostForm("fontFamily"),
+		FontSize:      c.PostForm("fontSize"),
+		Color:         c.PostForm("color"),
+		StartNumber:   c.PostForm("startNumber"),
+		Prefix:        c.PostForm("prefix"),
+		Suffix:        c.PostForm("suffix"),
+		MarginX:       c.PostForm("marginX"),
+		MarginY:       c.PostForm("marginY"),
+		SelectedPages: c.PostForm("selectedPages"),
+		SkipFirstPage: c.PostForm("skipFirstPage"),
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid vertical margin. Must be a non-negative number",
+			"error": err.Error(),
 		})
 		return
 	}
@@ -3227,7 +1896,7 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	outputPath := filepath.Join(h.config.PublicDir, "pagenumbers", uniqueID+"-numbered.pdf")
 
 	// Save uploaded file
-	if err := c.SaveUploadedFile(file, inputPath); err != nil {
+	if err := file.Save(inputPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save file: " + err.Error(),
 		})
@@ -3235,153 +1904,50 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	}
 	defer os.Remove(inputPath)
 
-	// Get PDF page count using pdfcpu
-	cmd := exec.Command("pdfcpu", "info", inputPath)
-	output, err := cmd.CombinedOutput()
-
-	totalPages := 0
-	if err == nil {
-		// Try to extract page count from output
-		pageCountRegex := regexp.MustCompile("Pages\\s*:\\s*(\\d+)")
-		matches := pageCountRegex.FindStringSubmatch(string(output))
-		if len(matches) >= 2 {
-			totalPages, _ = strconv.Atoi(matches[1])
-		}
-	}
-
-	if totalPages == 0 {
+	// Get PDF page count
+	totalPages, err := pdfops.PageCount(c.Request.Context(), inputPath)
+	if err != nil || totalPages == 0 {
 		// Fallback: estimate from file size
 		fileSizeInMB := float64(file.Size) / (1024 * 1024)
 		totalPages = int(math.Max(1, math.Round(fileSizeInMB*10))) // ~10 pages per MB is a rough estimate
 	}
 
-	// Build the page number text with format strings
-	var pageText string
-
-	// Handle different formats and build the text template
-	switch format {
-	case "numeric":
-		if startNumber != 1 {
-			// For custom start numbers, we need to calculate: current_page + (start_number - 1)
-			// But pdfcpu doesn't support arithmetic in format strings, so we'll use a workaround
-			// For now, we'll use the standard format and note this limitation
-			pageText = fmt.Sprintf("%s%%p%s", prefix, suffix)
-			if startNumber != 1 {
-				// Add a note about the limitation in the response
-				log.Printf("Warning: Custom start numbers with pdfcpu stamp are not directly supported. Using default numbering.")
-			}
-		} else {
-			pageText = fmt.Sprintf("%s%%p%s", prefix, suffix)
-		}
-	case "roman":
-		// pdfcpu doesn't support roman numerals directly in format strings
-		// We'll need to use numeric and note this limitation
-		pageText = fmt.Sprintf("%s%%p%s", prefix, suffix)
-		log.Printf("Warning: Roman numerals not directly supported with pdfcpu stamp. Using numeric format.")
-	case "alphabetic":
-		// pdfcpu doesn't support alphabetic directly in format strings
-		// We'll need to use numeric and note this limitation
-		pageText = fmt.Sprintf("%s%%p%s", prefix, suffix)
-		log.Printf("Warning: Alphabetic format not directly supported with pdfcpu stamp. Using numeric format.")
-	default:
-		pageText = fmt.Sprintf("%s%%p%s", prefix, suffix)
-	}
-
-	// If user wants "Page X of Y" format, use both %p and %P
-	if prefix == "" && suffix == "" {
-		pageText = "%p"
-	}
-
-	// Build the description string for pdfcpu stamp
-	pdfcpuPosition := mapPositionToPdfcpu(position)
-	pdfcpuColor := formatColorForPdfcpuStamp(color)
-	offsetX, offsetY := getAdjustedOffsetsForStamp(position, marginX, marginY)
-	pdfcpuFont := getFontMapForStamp(fontFamily)
-
-	// Build description according to pdfcpu stamp documentation
-	// Use unambiguous parameter names to avoid "ambiguous parameter prefix" errors
-	// Use rotation:0 for horizontal text (only one of rotation or diagonal is allowed)
-	description := fmt.Sprintf(
-		"fontname:%s, points:%d, pos:%s, offset:%d %d, aligntext:c, fillcolor:%s, strokecolor:%s, opacity:1.0, scale:1.0 abs, rotation:0",
-		pdfcpuFont,
-		fontSize,
-		pdfcpuPosition,
-		offsetX,
-		offsetY,
-		pdfcpuColor,
-		pdfcpuColor,
-	)
-
-	// Build pdfcpu stamp command
-	args := []string{
-		"stamp", "add",
-		"-mode", "text",
-	}
-
-	// Add page selection if specified
-	if selectedPages != "" {
-		args = append(args, "-pages", selectedPages)
-	} else if skipFirstPage {
-		// If skipping first page, stamp pages 2 onwards
-		if totalPages > 1 {
-			args = append(args, "-pages", fmt.Sprintf("2-%d", totalPages))
-		} else {
-			// Only one page and we're skipping it, so nothing to do
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Cannot skip first page when PDF has only one page",
-			})
-			return
-		}
+	opts.TotalPages = totalPages
+	numbered, err := pdfops.NumberPages(c.Request.Context(), inputPath, outputPath, opts)
+	if errors.Is(err, pdfops.ErrNothingToNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Cannot skip first page when PDF has only one page",
+		})
+		return
 	}
-	// If no page selection and not skipping first page, apply to all pages (default)
-
-	// Add the stamp content and description
-	args = append(args, "--", pageText, description, inputPath, outputPath)
-
-	// Log the command for debugging
-	log.Printf("Executing pdfcpu stamp command: pdfcpu %s", strings.Join(args, " "))
-
-	// Execute the command with timeout
-	cmd = exec.Command("pdfcpu", args...)
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	output, err = cmd.CombinedOutput()
-
-	// Check for errors
-	if ctx.Err() == context.DeadlineExceeded {
+	if errors.Is(err, context.DeadlineExceeded) {
 		log.Printf("pdfcpu stamp command timed out")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "PDF processing timed out, please try with a smaller file",
 		})
 		return
 	}
-
 	if err != nil {
-		log.Printf("pdfcpu stamp command failed: %s", string(output))
+		log.Printf("pdfcpu stamp command failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to add page numbers to PDF: %s", string(output)),
+			"error": fmt.Sprintf("Failed to add page numbers to PDF: %v", err),
 		})
 		return
 	}
+	numberedPages := numbered.NumberedPages
 
-	// Count pages that were numbered
-	numberedPages := totalPages
-	if selectedPages != "" {
-		// Parse selected pages to count them
-		numberedPages = countPagesInSelection(selectedPages, totalPages)
-	} else if skipFirstPage {
-		numberedPages = totalPages - 1
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "pagenumbers", outputPath)
+	if !ok {
+		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=pagenumbers&filename=%s-numbered.pdf", uniqueID)
-
 	// Return response
 	response := gin.H{
 		"success":       true,
 		"message":       "Page numbers added successfully",
 		"fileUrl":       fileURL,
+		"fileId":        fileID,
 		"fileName":      fmt.Sprintf("%s-numbered.pdf", uniqueID),
 		"originalName":  file.Filename,
 		"totalPages":    totalPages,
@@ -3389,19 +1955,18 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	}
 
 	// Add billing info if available
-	if exists {
-		result, _ := h.balanceService.ProcessOperation(userID.(string), operation.(string))
+	if billing != nil {
 		var opCost float64
-		if result.UsedFreeOperation {
+		if billing.UsedFreeOperation {
 			opCost = 0
 		} else {
 			opCost = constants.OperationCost
 		}
 
 		response["billing"] = gin.H{
-			"usedFreeOperation":       result.UsedFreeOperation,
-			"freeOperationsRemaining": result.FreeOperationsRemaining,
-			"currentBalance":          result.CurrentBalance,
+			"usedFreeOperation":       billing.UsedFreeOperation,
+			"freeOperationsRemaining": billing.FreeOperationsRemaining,
+			"currentBalance":          billing.CurrentBalance,
 			"operationCost":           opCost,
 		}
 	}
@@ -3409,112 +1974,6 @@ func (h *PDFHandler) AddPageNumbersToPDF(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// Helper functions for pdfcpu stamp
-
-// formatColorForPdfcpuStamp formats hex color for pdfcpu stamp description
-func formatColorForPdfcpuStamp(hexColor string) string {
-	// Remove # prefix if present
-	hexColor = strings.TrimPrefix(hexColor, "#")
-
-	// Default to black if invalid
-	if len(hexColor) != 6 {
-		return "#000000"
-	}
-
-	// Return as hex color (pdfcpu stamp accepts hex colors)
-	return "#" + hexColor
-}
-
-// getAdjustedOffsetsForStamp calculates offsets for pdfcpu stamp
-func getAdjustedOffsetsForStamp(position string, marginX, marginY int) (int, int) {
-	// For pdfcpu stamp, offsets are relative to the anchor position
-	// Positive values move right/up, negative values move left/down
-	switch position {
-	case "top-left":
-		return marginX, -marginY // Move right from left edge, down from top
-	case "top-center":
-		return 0, -marginY // Centered horizontally, down from top
-	case "top-right":
-		return -marginX, -marginY // Move left from right edge, down from top
-	case "bottom-left":
-		return marginX, marginY // Move right from left edge, up from bottom
-	case "bottom-center":
-		return 0, marginY // Centered horizontally, up from bottom
-	case "bottom-right":
-		return -marginX, marginY // Move left from right edge, up from bottom
-	default:
-		return 0, marginY // Default to bottom-center
-	}
-}
-
-// getFontMapForStamp maps font family names to pdfcpu stamp font names
-func getFontMapForStamp(fontFamily string) string {
-	switch strings.ToLower(fontFamily) {
-	case "times", "timesnewroman", "times new roman":
-		return "Times"
-	case "courier":
-		return "Courier"
-	case "helvetica":
-		return "Helvetica"
-	default:
-		return "Helvetica" // Default font
-	}
-}
-
-// countPagesInSelection counts how many pages are in a page selection string
-func countPagesInSelection(selection string, totalPages int) int {
-	count := 0
-	parts := strings.Split(selection, ",")
-
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
-		}
-
-		if strings.Contains(part, "-") {
-			// It's a range
-			rangeParts := strings.Split(part, "-")
-			if len(rangeParts) == 2 {
-				start, err1 := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
-				end, err2 := strconv.Atoi(strings.TrimSpace(rangeParts[1]))
-
-				if err1 == nil && err2 == nil && start >= 1 && end <= totalPages && start <= end {
-					count += end - start + 1
-				}
-			}
-		} else {
-			// It's a single page
-			page, err := strconv.Atoi(part)
-			if err == nil && page >= 1 && page <= totalPages {
-				count++
-			}
-		}
-	}
-
-	return count
-}
-
-// mapPositionToPdfcpu maps our position names to pdfcpu's position codes
-func mapPositionToPdfcpu(position string) string {
-	switch position {
-	case "top-left":
-		return "tl"
-	case "top-center":
-		return "tc"
-	case "top-right":
-		return "tr"
-	case "bottom-left":
-		return "bl"
-	case "bottom-center":
-		return "bc"
-	case "bottom-right":
-		return "br"
-	default:
-		return "bc" // Default to bottom center
-	}
-}
-
 // Helper function to copy a file
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -3532,103 +1991,3 @@ func copyFile(src, dst string) error {
 	_, err = io.Copy(destFile, sourceFile)
 	return err
 }
-
-// Helper function to format page number according to format
-func formatPageNumber(pageNum int, format string, startNumber int) string {
-	actualNumber := pageNum + startNumber - 1
-
-	switch format {
-	case "roman":
-		return toRoman(actualNumber)
-	case "alphabetic":
-		return toAlphabetic(actualNumber)
-	default: // numeric
-		return strconv.Itoa(actualNumber)
-	}
-}
-
-// Helper function to convert number to Roman numerals
-func toRoman(num int) string {
-	if num <= 0 {
-		return ""
-	}
-
-	romanNumerals := []struct {
-		value   int
-		numeral string
-	}{
-		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
-		{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
-		{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
-	}
-
-	var result strings.Builder
-
-	for _, rn := range romanNumerals {
-		for num >= rn.value {
-			result.WriteString(rn.numeral)
-			num -= rn.value
-		}
-	}
-
-	return result.String()
-}
-
-// Helper function to convert number to alphabetic format (A, B, C, ..., Z, AA, AB, etc.)
-func toAlphabetic(num int) string {
-	if num <= 0 {
-		return ""
-	}
-
-	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	length := len(alphabet)
-
-	var result strings.Builder
-
-	// Convert to base-26 representation
-	n := num
-	for n > 0 {
-		n--
-		remainder := n % length
-		result.WriteByte(alphabet[remainder])
-		n /= length
-	}
-
-	// Reverse the string
-	runes := []rune(result.String())
-	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
-		runes[i], runes[j] = runes[j], runes[i]
-	}
-
-	return string(runes)
-}
-
-// Helper function to map font family names to pdfcpu font names
-func getFontMap(fontFamily string) string {
-	switch strings.ToLower(fontFamily) {
-	case "times", "timesnewroman", "times new roman":
-		return "times"
-	case "courier":
-		return "Courier"
-	default: // Helvetica is the default
-		return "Helvetica"
-	}
-}
-
-func formatColorForDescription(hexColor string) string {
-	// Remove # prefix if present
-	hexColor = strings.TrimPrefix(hexColor, "#")
-
-	// Default to black if invalid
-	if len(hexColor) != 6 {
-		return "0 0 0"
-	}
-
-	// Convert hex to RGB integers
-	r, _ := strconv.ParseInt(hexColor[0:2], 16, 32)
-	g, _ := strconv.ParseInt(hexColor[2:4], 16, 32)
-	b, _ := strconv.ParseInt(hexColor[4:6], 16, 32)
-
-	// Convert to float values (0.0 to 1.0)
-	return fmt.Sprintf("%.3f %.3f %.3f", float64(r)/255.0, float64(g)/255.0, float64(b)/255.0)
-}
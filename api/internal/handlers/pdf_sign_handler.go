@@ -2,18 +2,15 @@
 package handlers
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/MegaPDF/megapdf-official/api/internal/pdfops"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -21,14 +18,16 @@ import (
 // SignPdfHandler handles PDF signing operations
 type SignPdfHandler struct {
 	uploadsDir    string
-	signaturesDir string
+	signaturesDir string // Where signed PDFs are written before they are moved into storage
+	artifacts     *services.ArtifactService
 }
 
 // NewSignPdfHandler creates a new sign PDF handler
-func NewSignPdfHandler(uploadsDir, signaturesDir string) *SignPdfHandler {
+func NewSignPdfHandler(uploadsDir, signaturesDir string, artifacts *services.ArtifactService) *SignPdfHandler {
 	return &SignPdfHandler{
 		uploadsDir:    uploadsDir,
 		signaturesDir: signaturesDir,
+		artifacts:     artifacts,
 	}
 }
 
@@ -46,7 +45,7 @@ func NewSignPdfHandler(uploadsDir, signaturesDir string) *SignPdfHandler {
 // @Param scale formData number false "Scale factor (percentage)" default(100)
 // @Param pages formData string false "Page selection (all, custom)" default(all)
 // @Param customPages formData string false "Custom page range (e.g., 1-3,5,7-9)"
-// @Success 200 {object} object{success=boolean,message=string,fileUrl=string}
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string}
 // @Failure 400 {object} object{error=string}
 // @Failure 500 {object} object{error=string}
 // @Router /api/pdf/sign [post]
@@ -62,7 +61,7 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 	}
 
 	// Get PDF file
-	pdfFile, err := c.FormFile("file")
+	pdfFile, err := formInputFile(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Failed to get PDF file: " + err.Error(),
@@ -70,6 +69,11 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 		return
 	}
 
+	// Check file size against the plan's limit
+	if rejectLargeUpload(c, pdfFile.Filename, pdfFile.Size) {
+		return
+	}
+
 	// Validate file type
 	if !strings.HasSuffix(strings.ToLower(pdfFile.Filename), ".pdf") {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -83,7 +87,7 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 	var contentValue string
 
 	// Check if we have a file upload (image/svg signature)
-	signatureImage, err := c.FormFile("content")
+	signatureImage, err := formInputFile(c, "content")
 	if err == nil && signatureImage != nil {
 		// We have an uploaded file
 		contentType = "image"
@@ -139,7 +143,7 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 	var tempFiles []string // Track temp files to clean up
 
 	// Save uploaded PDF
-	if err := c.SaveUploadedFile(pdfFile, pdfPath); err != nil {
+	if err := pdfFile.Save(pdfPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save PDF file: " + err.Error(),
 		})
@@ -153,7 +157,7 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 		signaturePath = filepath.Join(h.uploadsDir, uniqueID+"-signature"+filepath.Ext(signatureImage.Filename))
 		tempFiles = append(tempFiles, signaturePath)
 
-		if err := c.SaveUploadedFile(signatureImage, signaturePath); err != nil {
+		if err := signatureImage.Save(signaturePath); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to save signature image: " + err.Error(),
 			})
@@ -164,139 +168,52 @@ func (h *SignPdfHandler) SignPDF(c *gin.Context) {
 	}
 
 	// Prepare watermark description for pdfcpu
-	watermarkDescription := buildSignatureDescription(
-		contentType, position, rotation, opacity, scale)
-
-	log.Printf("Using signature description: %s", watermarkDescription)
+	// Only custom page selections are applied; everything else signs all pages
+	signPages := ""
+	if pages == "custom" && customPages != "" {
+		signPages = customPages
+	}
 
 	// Apply signature using pdfcpu
-	success, err := h.applySignatureWithPdfcpu(
-		pdfPath, outputPath, contentType, contentValue, watermarkDescription, pages, customPages)
+	err = pdfops.Sign(c.Request.Context(), pdfPath, outputPath, pdfops.SignOptions{
+		Type:     contentType,
+		Content:  contentValue,
+		Position: position,
+		Rotation: rotation,
+		Opacity:  opacity,
+		Scale:    scale,
+		Pages:    signPages,
+	})
 
 	// Clean up temp files
 	for _, tempFile := range tempFiles {
 		os.Remove(tempFile)
 	}
 
-	if !success {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("Failed to add signature to PDF: %v", err),
 		})
 		return
 	}
 
-	// Generate file URL
-	fileURL := fmt.Sprintf("/api/file?folder=signatures&filename=%s-signed.pdf", uniqueID)
+	// Move the result into storage
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "signatures", outputPath)
+	if !ok {
+		return
+	}
 
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      "PDF signed successfully",
 		"fileUrl":      fileURL,
+		"fileId":       fileID,
 		"filename":     fmt.Sprintf("%s-signed.pdf", uniqueID),
 		"originalName": pdfFile.Filename,
 	})
 }
 
-// buildSignatureDescription generates a description for pdfcpu watermark command
-func buildSignatureDescription(contentType, position string, rotation, opacity, scale int) string {
-	// Map position codes to ensure compatibility
-	posMap := map[string]string{
-		"c":  "c",
-		"tl": "tl",
-		"tc": "tc",
-		"tr": "tr",
-		"l":  "l",
-		"r":  "r",
-		"bl": "bl",
-		"bc": "bc",
-		"br": "br",
-		// Also support full names
-		"center":        "c",
-		"top-left":      "tl",
-		"top-center":    "tc",
-		"top-right":     "tr",
-		"left":          "l",
-		"right":         "r",
-		"bottom-left":   "bl",
-		"bottom-center": "bc",
-		"bottom-right":  "br",
-	}
-
-	// Get position code, default to center if not recognized
-	posCode := posMap[position]
-	if posCode == "" {
-		posCode = "c"
-		log.Printf("Warning: Unrecognized position '%s', defaulting to center", position)
-	}
-
-	// Convert opacity to 0-1 range
-	opacityValue := float64(opacity) / 100.0
-
-	// Convert scale to decimal
-	scaleValue := float64(scale) / 100.0
-
-	// Invert rotation to match expected behavior
-	fixedRotation := -rotation
-
-	// Build description string (same format as watermark)
-	description := fmt.Sprintf("pos:%s, op:%.1f, rot:%d, scale:%.1f",
-		posCode, opacityValue, fixedRotation, scaleValue)
-
-	return description
-}
-
-// applySignatureWithPdfcpu applies signature using pdfcpu watermark command
-func (h *SignPdfHandler) applySignatureWithPdfcpu(
-	inputPath, outputPath, contentType, contentValue, description, pages, customPages string) (bool, error) {
-
-	// Build pdfcpu command
-	args := []string{"watermark", "add", "-mode"}
-
-	// Set mode based on content type
-	if contentType == "image" {
-		args = append(args, "image")
-	} else {
-		args = append(args, "text")
-	}
-
-	// Add page selection if needed
-	if pages != "all" {
-		if pages == "custom" && customPages != "" {
-			args = append(args, "-pages", customPages)
-		}
-	}
-
-	// Add the files - correct order is crucial for pdfcpu: content, description, input, output
-	args = append(args, "--", contentValue, description, inputPath, outputPath)
-
-	log.Printf("Executing: pdfcpu %s", strings.Join(args, " "))
-
-	// Execute command with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "pdfcpu", args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if err != nil {
-		combinedOutput := strings.TrimSpace(stdout.String() + "\n" + stderr.String())
-		log.Printf("Command failed: %v, output: %s", err, combinedOutput)
-		return false, fmt.Errorf("pdfcpu error: %s", combinedOutput)
-	}
-
-	// Check if output file exists and has content
-	if fileInfo, err := os.Stat(outputPath); err != nil || fileInfo.Size() == 0 {
-		return false, fmt.Errorf("output file not created or empty")
-	}
-
-	return true, nil
-}
-
 // Helper function to check if image extension is supported
 func isImageExtensionSupported(ext string) bool {
 	ext = strings.ToLower(ext)
@@ -0,0 +1,344 @@
+// internal/handlers/pipeline_handler.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxPipelineSteps limits how many operations a single pipeline may chain
+const maxPipelineSteps = 10
+
+// pipelineOperation is an operation that can be used as a pipeline step
+type pipelineOperation struct {
+	handler    gin.HandlerFunc
+	multiInput bool // Accepts several input files (sent as "files") instead of one ("file")
+	firstOnly  bool // Must be the first step
+	lastOnly   bool // Must be the last step, e.g. because its output is encrypted
+}
+
+// PipelineStep is one step of a pipeline request
+type PipelineStep struct {
+	Operation string                 `json:"operation"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// PipelineHandler chains several PDF operations in a single request
+type PipelineHandler struct {
+	config       *config.Config
+	storage      storage.Storage
+	artifacts    *services.ArtifactService
+	toolsService *services.PDFToolsService
+	operations   map[string]pipelineOperation
+}
+
+// NewPipelineHandler creates a new pipeline handler
+func NewPipelineHandler(store storage.Storage, artifacts *services.ArtifactService, cfg *config.Config) *PipelineHandler {
+	return &PipelineHandler{
+		config:       cfg,
+		storage:      store,
+		artifacts:    artifacts,
+		toolsService: services.NewPDFToolsService(),
+		operations:   make(map[string]pipelineOperation),
+	}
+}
+
+// RegisterMergeStep registers an operation that combines all current files into one
+func (h *PipelineHandler) RegisterMergeStep(operation string, handler gin.HandlerFunc) {
+	h.operations[operation] = pipelineOperation{handler: handler, multiInput: true, firstOnly: true}
+}
+
+// RegisterStep registers an operation that transforms a single PDF
+func (h *PipelineHandler) RegisterStep(operation string, handler gin.HandlerFunc) {
+	h.operations[operation] = pipelineOperation{handler: handler}
+}
+
+// RegisterFinalStep registers an operation that can only be the last step
+func (h *PipelineHandler) RegisterFinalStep(operation string, handler gin.HandlerFunc) {
+	h.operations[operation] = pipelineOperation{handler: handler, lastOnly: true}
+}
+
+// RunPipeline godoc
+// @Summary Run several PDF operations in one request
+// @Description Applies an ordered list of operations (merge, watermark, pagenumber, protect) to the uploaded files. The output of each step is the input of the next one and only the final file is returned. Every step is billed as a separate operation.
+// @Tags pdf
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file true "Input PDF files (several files require merge as the first step)"
+// @Param steps formData string true "JSON array of steps, e.g. [{\"operation\":\"merge\"},{\"operation\":\"watermark\",\"params\":{\"text\":\"DRAFT\"}}]"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,message=string,fileUrl=string,fileId=string,filename=string,steps=array}
+// @Failure 400 {object} object{error=string}
+// @Failure 402 {object} object{error=string,failedStep=integer}
+// @Failure 500 {object} object{error=string,failedStep=integer}
+// @Router /api/pdf/pipeline [post]
+func (h *PipelineHandler) RunPipeline(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	inputs, err := formInputs(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form: " + err.Error()})
+		return
+	}
+
+	// Parse and validate the steps before anything is charged
+	var steps []PipelineStep
+	if err := json.Unmarshal([]byte(c.PostForm("steps")), &steps); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid steps: " + err.Error()})
+		return
+	}
+
+	uploads := append(inputs["files"], inputs["file"]...)
+	if len(uploads) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one PDF file is required"})
+		return
+	}
+
+	if err := h.validateSteps(steps, len(uploads)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, upload := range uploads {
+		if strings.ToLower(filepath.Ext(upload.Filename)) != ".pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File '%s' is not a PDF", upload.Filename)})
+			return
+		}
+		if rejectLargeUpload(c, upload.Filename, upload.Size) {
+			return
+		}
+	}
+
+	scopes := c.GetStringSlice("apiKeyScopes")
+	for _, step := range steps {
+		if !services.ScopeAllows(scopes, step.Operation) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("API key is not allowed to use the '%s' operation", step.Operation),
+			})
+			return
+		}
+		if enabled, err := h.toolsService.CheckToolAvailability(step.Operation); err == nil && !enabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":        h.toolsService.GetDisabledMessage(step.Operation),
+				"toolDisabled": true,
+			})
+			return
+		}
+	}
+
+	// Save the inputs; intermediate files are passed between steps on disk
+	pipelineID := uuid.New().String()
+	workDir := filepath.Join(h.config.UploadDir, "pipelines", pipelineID)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pipeline directory: " + err.Error()})
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	current := make([]formFile, 0, len(uploads))
+	for i, upload := range uploads {
+		dst := filepath.Join(workDir, fmt.Sprintf("input-%d.pdf", i))
+		if err := upload.Save(dst); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save uploaded file: " + err.Error()})
+			return
+		}
+		current = append(current, formFile{Filename: filepath.Base(upload.Filename), Path: dst})
+	}
+	originalName := current[0].Filename
+
+	// The steps are charged together: every reservation is committed if the
+	// pipeline succeeds and released if any step fails
+	held := &heldReservations{}
+	defer func() {
+		held.settle(c.Writer.Written() && c.Writer.Status() < http.StatusBadRequest)
+	}()
+
+	completed := make([]gin.H, 0, len(steps))
+	for i, step := range steps {
+		operation := h.operations[step.Operation]
+
+		fieldName := "file"
+		if operation.multiInput {
+			fieldName = "files"
+		}
+
+		fmt.Printf("Pipeline %s: running step %d (%s) on %d file(s)\n", pipelineID, i+1, step.Operation, len(current))
+
+		status, body, err := invokeHandler(c.Request.Context(), operation.handler, internalRequest{
+			Path:   "/api/pdf/" + step.Operation,
+			Fields: stepFields(step.Params),
+			Files:  map[string][]formFile{fieldName: current},
+			Values: map[string]interface{}{
+				"userId":            userID,
+				"apiKeyId":          c.GetString("apiKeyId"),
+				"operationType":     step.Operation,
+				"maxFileSize":       c.GetInt64("maxFileSize"),
+				heldReservationsKey: held,
+			},
+		})
+		if err == nil && status >= http.StatusBadRequest {
+			err = fmt.Errorf("%s", responseError(status, body))
+		}
+		if err != nil {
+			if status < http.StatusBadRequest {
+				status = http.StatusInternalServerError
+			}
+			c.JSON(status, gin.H{
+				"error":          fmt.Sprintf("Step %d (%s) failed: %s", i+1, step.Operation, err.Error()),
+				"failedStep":     i + 1,
+				"completedSteps": completed,
+			})
+			return
+		}
+
+		// Take the step's output out of storage as the next step's input
+		outputPath, err := h.fetchResult(c.Request.Context(), body["fileUrl"], workDir, i+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":          fmt.Sprintf("Step %d (%s) failed: %s", i+1, step.Operation, err.Error()),
+				"failedStep":     i + 1,
+				"completedSteps": completed,
+			})
+			return
+		}
+		completed = append(completed, gin.H{
+			"step":      i + 1,
+			"operation": step.Operation,
+			"billing":   body["billing"],
+		})
+		current = []formFile{{Filename: originalName, Path: outputPath}}
+	}
+
+	// Move the last output to the pipelines folder as the single final artifact
+	outputFilename := pipelineID + "-result.pdf"
+	outputPath := filepath.Join(workDir, outputFilename)
+	if err := os.Rename(current[0].Path, outputPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store pipeline result: " + err.Error()})
+		return
+	}
+	fileURL, fileID, ok := storeResult(c, h.artifacts, "pipelines", outputPath)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"message":      fmt.Sprintf("Pipeline completed with %d steps", len(steps)),
+		"fileUrl":      fileURL,
+		"fileId":       fileID,
+		"filename":     outputFilename,
+		"originalName": originalName,
+		"steps":        completed,
+	})
+}
+
+// validateSteps checks the step list against the registered operations
+func (h *PipelineHandler) validateSteps(steps []PipelineStep, fileCount int) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("At least one step is required")
+	}
+	if len(steps) > maxPipelineSteps {
+		return fmt.Errorf("A pipeline can have at most %d steps", maxPipelineSteps)
+	}
+
+	for i, step := range steps {
+		operation, ok := h.operations[step.Operation]
+		if !ok {
+			return fmt.Errorf("Step %d: unsupported operation '%s'", i+1, step.Operation)
+		}
+		if operation.firstOnly && i != 0 {
+			return fmt.Errorf("Step %d: %s can only be the first step", i+1, step.Operation)
+		}
+		if operation.lastOnly && i != len(steps)-1 {
+			return fmt.Errorf("Step %d: %s can only be the last step", i+1, step.Operation)
+		}
+	}
+
+	first := h.operations[steps[0].Operation]
+	if first.multiInput && fileCount < 2 {
+		return fmt.Errorf("%s requires at least two files", steps[0].Operation)
+	}
+	if !first.multiInput && fileCount > 1 {
+		return fmt.Errorf("Multiple files require a merge as the first step")
+	}
+
+	return nil
+}
+
+// resolveFileURL maps an /api/file URL returned by a handler to its storage key
+func (h *PipelineHandler) resolveFileURL(value interface{}) (string, error) {
+	fileURL, ok := value.(string)
+	if !ok || fileURL == "" {
+		return "", fmt.Errorf("operation did not return a file")
+	}
+
+	parsed, err := url.Parse(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL: %w", err)
+	}
+
+	folder := parsed.Query().Get("folder")
+	filename := filepath.Base(parsed.Query().Get("filename"))
+	if folder == "" || filename == "" || filename == "." || strings.Contains(folder, "..") {
+		return "", fmt.Errorf("invalid file URL: %s", fileURL)
+	}
+
+	return storage.Key(folder, filename), nil
+}
+
+// fetchResult copies the output of a step from storage into workDir and
+// deletes its artifact, only the final output is kept
+func (h *PipelineHandler) fetchResult(ctx context.Context, fileURL interface{}, workDir string, step int) (string, error) {
+	key, err := h.resolveFileURL(fileURL)
+	if err != nil {
+		return "", err
+	}
+	localPath := filepath.Join(workDir, fmt.Sprintf("step-%d-%s", step, path.Base(key)))
+	if err := storage.FetchFile(ctx, h.storage, key, localPath); err != nil {
+		return "", fmt.Errorf("failed to fetch step output: %w", err)
+	}
+	if err := h.artifacts.Delete(ctx, key); err != nil {
+		fmt.Printf("WARNING: Failed to delete pipeline step output %s: %v\n", key, err)
+	}
+	return localPath, nil
+}
+
+// stepFields converts step parameters to form fields. Scalars are sent as is,
+// arrays and objects (e.g. the merge order) as JSON.
+func stepFields(params map[string]interface{}) map[string][]string {
+	fields := make(map[string][]string, len(params))
+	for key, value := range params {
+		switch typed := value.(type) {
+		case nil:
+			continue
+		case string:
+			fields[key] = []string{typed}
+		case bool, float64:
+			fields[key] = []string{fmt.Sprint(typed)}
+		default:
+			encoded, err := json.Marshal(typed)
+			if err != nil {
+				continue
+			}
+			fields[key] = []string{string(encoded)}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,273 @@
+// internal/handlers/plan_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// PlanHandler lists the subscription plans, subscribes users to them and lets
+// admins manage them
+type PlanHandler struct {
+	planService      *services.PlanService
+	rateLimitService *services.RateLimitService
+}
+
+// NewPlanHandler creates a new plan handler
+func NewPlanHandler(planService *services.PlanService, rateLimitService *services.RateLimitService) *PlanHandler {
+	return &PlanHandler{
+		planService:      planService,
+		rateLimitService: rateLimitService,
+	}
+}
+
+// planRequest is the body of POST /api/admin/plans and PUT /api/admin/plans/:id
+type planRequest struct {
+	Code               string             `json:"code" binding:"required"`
+	Name               string             `json:"name" binding:"required"`
+	Description        string             `json:"description"`
+	MonthlyPrice       float64            `json:"monthlyPrice"`
+	IncludedOperations int                `json:"includedOperations"`
+	OverageCost        float64            `json:"overageCost"`
+	OveragePrices      map[string]float64 `json:"overagePrices"`
+	MaxFileSize        int                `json:"maxFileSize"`
+	MaxApiKeys         int                `json:"maxApiKeys"`
+	RateLimit          int                `json:"rateLimit"`
+	DailyQuota         int                `json:"dailyQuota"`
+	IsDefault          bool               `json:"isDefault"`
+	Active             *bool              `json:"active"` // Defaults to true
+}
+
+// ListPlans godoc
+// @Summary List subscription plans
+// @Description Returns the plans users can subscribe to, ordered by monthly price
+// @Tags plans
+// @Produce json
+// @Success 200 {object} object{success=boolean,plans=array}
+// @Failure 500 {object} object{error=string}
+// @Router /api/plans [get]
+func (h *PlanHandler) ListPlans(c *gin.Context) {
+	plans, err := h.planService.ListPlans(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plans: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "plans": plans})
+}
+
+// Subscribe godoc
+// @Summary Subscribe to a plan
+// @Description Moves the user to a plan and starts a new billing cycle. The plan's monthly price is charged from the balance now and at the start of every following cycle; users who cannot pay a renewal are moved to the default plan.
+// @Tags plans
+// @Accept json
+// @Produce json
+// @Param body body object{planId=string} true "Plan to subscribe to"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,plan=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 402 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/plan [post]
+func (h *PlanHandler) Subscribe(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		PlanID string `json:"planId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	plan, err := h.planService.Subscribe(userID, req.PlanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPlanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		case errors.Is(err, services.ErrPlanInactive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plan is not available"})
+		case errors.Is(err, services.ErrInsufficientBalance):
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient balance for the plan's monthly price. Please add funds to your account."})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to plan: " + err.Error()})
+		}
+		return
+	}
+	h.reloadRateLimits()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "plan": plan})
+}
+
+// AdminListPlans godoc
+// @Summary List all plans
+// @Description Returns every plan, including inactive ones
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,plans=array}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/plans [get]
+func (h *PlanHandler) AdminListPlans(c *gin.Context) {
+	plans, err := h.planService.ListPlans(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plans: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "plans": plans})
+}
+
+// CreatePlan godoc
+// @Summary Create a plan
+// @Description Creates a subscription plan. maxFileSize is in MB; rateLimit and dailyQuota of 0 use the default rate limits; overagePrices override overageCost per operation, and an overageCost of 0 uses the global pricing.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param plan body object{code=string,name=string,description=string,monthlyPrice=number,includedOperations=integer,overageCost=number,overagePrices=object,maxFileSize=integer,maxApiKeys=integer,rateLimit=integer,dailyQuota=integer,isDefault=boolean,active=boolean} true "Plan"
+// @Security BearerAuth
+// @Success 201 {object} object{success=boolean,plan=object}
+// @Failure 400 {object} object{error=string}
+// @Router /api/admin/plans [post]
+func (h *PlanHandler) CreatePlan(c *gin.Context) {
+	h.savePlan(c, "")
+}
+
+// UpdatePlan godoc
+// @Summary Update a plan
+// @Description Replaces the settings of a plan. Changes apply to the current billing cycle of its users.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Plan ID"
+// @Param plan body object{code=string,name=string,description=string,monthlyPrice=number,includedOperations=integer,overageCost=number,overagePrices=object,maxFileSize=integer,maxApiKeys=integer,rateLimit=integer,dailyQuota=integer,isDefault=boolean,active=boolean} true "Plan"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,plan=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /api/admin/plans/{id} [put]
+func (h *PlanHandler) UpdatePlan(c *gin.Context) {
+	h.savePlan(c, c.Param("id"))
+}
+
+func (h *PlanHandler) savePlan(c *gin.Context, id string) {
+	var req planRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	plan := &models.Plan{
+		ID:                 id,
+		Code:               req.Code,
+		Name:               req.Name,
+		Description:        req.Description,
+		MonthlyPrice:       req.MonthlyPrice,
+		IncludedOperations: req.IncludedOperations,
+		OverageCost:        req.OverageCost,
+		OveragePrices:      models.PriceMap(req.OveragePrices),
+		MaxFileSize:        req.MaxFileSize,
+		MaxApiKeys:         req.MaxApiKeys,
+		RateLimit:          req.RateLimit,
+		DailyQuota:         req.DailyQuota,
+		IsDefault:          req.IsDefault,
+		Active:             req.Active == nil || *req.Active,
+	}
+	if err := h.planService.SavePlan(plan); err != nil {
+		if errors.Is(err, services.ErrPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to save plan: " + err.Error()})
+		return
+	}
+	h.reloadRateLimits()
+
+	status := http.StatusOK
+	if id == "" {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"success": true, "plan": plan})
+}
+
+// DeletePlan godoc
+// @Summary Delete a plan
+// @Description Deletes a plan that is not the default plan and has no users
+// @Tags admin
+// @Produce json
+// @Param id path string true "Plan ID"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/plans/{id} [delete]
+func (h *PlanHandler) DeletePlan(c *gin.Context) {
+	if err := h.planService.DeletePlan(c.Param("id")); err != nil {
+		switch {
+		case errors.Is(err, services.ErrPlanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		case errors.Is(err, services.ErrPlanInUse), errors.Is(err, services.ErrDefaultPlan):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete plan: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// AssignPlan godoc
+// @Summary Assign a plan to a user
+// @Description Moves a user to a plan without charging it. The current billing cycle continues with the new plan's allowance; an empty planId moves the user to the default plan.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param body body object{planId=string} true "Plan to assign"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /api/admin/users/{id}/plan [put]
+func (h *PlanHandler) AssignPlan(c *gin.Context) {
+	var req struct {
+		PlanID string `json:"planId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if err := h.planService.AssignPlan(c.Param("id"), req.PlanID); err != nil {
+		if errors.Is(err, services.ErrPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to assign plan: " + err.Error()})
+		return
+	}
+	h.reloadRateLimits()
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// reloadRateLimits drops the plans cached by the rate limiter so plan
+// changes apply to the next request
+func (h *PlanHandler) reloadRateLimits() {
+	if err := h.rateLimitService.Reload(); err != nil {
+		fmt.Printf("WARNING: Failed to reload rate limits: %v\n", err)
+	}
+}
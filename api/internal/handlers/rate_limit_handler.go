@@ -0,0 +1,149 @@
+// internal/handlers/rate_limit_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RateLimitHandler manages the per-plan, per-user and per-key rate limit overrides
+type RateLimitHandler struct {
+	rateLimitService *services.RateLimitService
+}
+
+// NewRateLimitHandler creates a new rate limit handler
+func NewRateLimitHandler(rateLimitService *services.RateLimitService) *RateLimitHandler {
+	return &RateLimitHandler{rateLimitService: rateLimitService}
+}
+
+// rateLimitOverrideRequest is the body of PUT /api/admin/rate-limits
+type rateLimitOverrideRequest struct {
+	Scope      string `json:"scope" binding:"required"`
+	Subject    string `json:"subject" binding:"required"`
+	Requests   int    `json:"requests"`
+	Period     int    `json:"period"`
+	DailyQuota int    `json:"dailyQuota"`
+}
+
+// ListOverrides godoc
+// @Summary List rate limit overrides
+// @Description Returns the rate limit overrides and the policy applied to callers without one
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,overrides=array,default=object}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/rate-limits [get]
+func (h *RateLimitHandler) ListOverrides(c *gin.Context) {
+	overrides, err := h.rateLimitService.ListOverrides()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list rate limit overrides: " + err.Error()})
+		return
+	}
+
+	items := make([]gin.H, 0, len(overrides))
+	for _, override := range overrides {
+		items = append(items, rateLimitOverrideResponse(override))
+	}
+
+	defaults := h.rateLimitService.PolicyFor("", "")
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"overrides": items,
+		"default": gin.H{
+			"requests":   defaults.Requests,
+			"period":     int(defaults.Period.Seconds()),
+			"dailyQuota": defaults.DailyQuota,
+		},
+	})
+}
+
+// SaveOverride godoc
+// @Summary Create or update a rate limit override
+// @Description Sets the limit of a plan (role), user ID or API key ID. requests is per period seconds (0 for no limit, period 0 uses the default period); dailyQuota is per UTC day (0 for no quota). Changes apply immediately.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param override body object{scope=string,subject=string,requests=integer,period=integer,dailyQuota=integer} true "Override (scope is plan, user or key)"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,override=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/rate-limits [put]
+func (h *RateLimitHandler) SaveOverride(c *gin.Context) {
+	var req rateLimitOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	switch req.Scope {
+	case models.RateLimitScopePlan, models.RateLimitScopeUser, models.RateLimitScopeKey:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope, expected plan, user or key"})
+		return
+	}
+	if req.Requests < 0 || req.Period < 0 || req.DailyQuota < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "requests, period and dailyQuota must not be negative"})
+		return
+	}
+
+	override := &models.RateLimitOverride{
+		Scope:      req.Scope,
+		Subject:    req.Subject,
+		Requests:   req.Requests,
+		Period:     req.Period,
+		DailyQuota: req.DailyQuota,
+	}
+	if err := h.rateLimitService.SaveOverride(override); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rate limit override: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"override": rateLimitOverrideResponse(*override),
+	})
+}
+
+// DeleteOverride godoc
+// @Summary Delete a rate limit override
+// @Description Removes an override so the plan or default limit applies again
+// @Tags admin
+// @Produce json
+// @Param id path string true "Override ID"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/admin/rate-limits/{id} [delete]
+func (h *RateLimitHandler) DeleteOverride(c *gin.Context) {
+	if err := h.rateLimitService.DeleteOverride(c.Param("id")); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rate limit override not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rate limit override: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func rateLimitOverrideResponse(override models.RateLimitOverride) gin.H {
+	return gin.H{
+		"id":         override.ID,
+		"scope":      override.Scope,
+		"subject":    override.Subject,
+		"requests":   override.Requests,
+		"period":     override.Period,
+		"dailyQuota": override.DailyQuota,
+		"createdAt":  override.CreatedAt,
+		"updatedAt":  override.UpdatedAt,
+	}
+}
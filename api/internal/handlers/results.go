@@ -0,0 +1,30 @@
+// internal/handlers/results.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// storeResult moves a result the tools wrote to localPath into storage, in
+// folder under its file name, and records it as an artifact of the
+// requesting user. It returns the signed download URL of the result and its
+// ID, which later operations accept as fileId, or responds with 500 and
+// returns false if the result could not be stored.
+func storeResult(c *gin.Context, artifacts *services.ArtifactService, folder, localPath string) (string, string, bool) {
+	operation := c.GetString("operationType")
+	if operation == "" {
+		operation = folder
+	}
+
+	artifact, err := artifacts.Store(c.Request.Context(), c.GetString("userId"), operation, folder, localPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to store result: " + err.Error(),
+		})
+		return "", "", false
+	}
+	return artifacts.DownloadURL(artifact), artifact.ID, true
+}
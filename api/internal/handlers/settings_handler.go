@@ -84,7 +84,7 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 
 // GetAllSettings returns all settings grouped by category
 func (h *SettingsHandler) GetAllSettings(c *gin.Context) {
-	categories := []string{"general", "api", "email", "security", "pricing"}
+	categories := []string{"general", "api", "email", "security", "pricing", "storage"}
 	allSettings := make(map[string]interface{})
 
 	for _, category := range categories {
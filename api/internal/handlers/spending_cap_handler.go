@@ -0,0 +1,97 @@
+// internal/handlers/spending_cap_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SpendingCapHandler lets users cap what their account spends per day and
+// month. Caps of API keys are set with the key, see ApiKeyHandler.UpdateKey.
+type SpendingCapHandler struct {
+	spendingCapService *services.SpendingCapService
+}
+
+// NewSpendingCapHandler creates a new spending cap handler
+func NewSpendingCapHandler(spendingCapService *services.SpendingCapService) *SpendingCapHandler {
+	return &SpendingCapHandler{
+		spendingCapService: spendingCapService,
+	}
+}
+
+// GetSpendingCaps godoc
+// @Summary Get spending caps
+// @Description Returns the spending caps of the current user's account and of their API keys that have caps, with what was used of each cap this UTC day or month
+// @Tags balance
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,account=object,apiKeys=[]object}
+// @Failure 401 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/spending-caps [get]
+func (h *SpendingCapHandler) GetSpendingCaps(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	account, apiKeys, err := h.spendingCapService.Status(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get spending caps: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"account": account,
+		"apiKeys": apiKeys,
+	})
+}
+
+// UpdateSpendingCaps godoc
+// @Summary Set spending caps
+// @Description Replaces the spending caps of the current user's account. Spend caps are in dollars of balance, operation caps count every operation including those of the plan's allowance; 0 removes a cap. Operations beyond a cap are refused with 402 and code spending_cap_reached.
+// @Tags balance
+// @Accept json
+// @Produce json
+// @Param body body object{dailySpendCap=number,monthlySpendCap=number,dailyOperationCap=integer,monthlyOperationCap=integer} true "Spending caps"
+// @Security BearerAuth
+// @Success 200 {object} object{success=boolean,caps=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 401 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/user/spending-caps [put]
+func (h *SpendingCapHandler) UpdateSpendingCaps(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var caps models.SpendingCaps
+	if err := c.ShouldBindJSON(&caps); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if err := h.spendingCapService.SetUserCaps(userID, caps); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSpendingCap):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update spending caps: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "caps": caps})
+}
@@ -0,0 +1,264 @@
+// internal/handlers/upload_handler.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadHandler runs resumable uploads: the client creates an upload with its
+// size, PATCHes chunks at the current offset, resuming after a failure from
+// the offset returned by HEAD, and completes it with the SHA-256 of the file.
+// The upload ID is then accepted by the PDF operations instead of a file.
+type UploadHandler struct {
+	uploads *services.UploadService
+}
+
+// NewUploadHandler creates a new upload handler
+func NewUploadHandler(uploads *services.UploadService) *UploadHandler {
+	return &UploadHandler{
+		uploads: uploads,
+	}
+}
+
+// CreateUpload godoc
+// @Summary Start a resumable upload
+// @Description Starts an upload of a file of the given size, at most the limit of your plan or the api.maxFileSize setting. Send the content with PATCH /api/uploads/{id}.
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Param body body object{filename=string,size=integer} true "Name and size in bytes of the file"
+// @Security ApiKeyAuth
+// @Success 201 {object} object{success=boolean,upload=object,uploadUrl=string}
+// @Failure 400 {object} object{error=string}
+// @Failure 413 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/uploads [post]
+func (h *UploadHandler) CreateUpload(c *gin.Context) {
+	var request struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	limit := maxUploadSize(c)
+	upload, err := h.uploads.Create(c.GetString("userId"), request.Filename, request.Size, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("File '%s' exceeds the %dMB limit of your plan", request.Filename, limit/(1024*1024)),
+			})
+		case errors.Is(err, services.ErrInvalidUpload):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload: " + err.Error()})
+		}
+		return
+	}
+
+	uploadURL := "/api/uploads/" + upload.ID
+	setUploadHeaders(c, upload)
+	c.Header("Location", uploadURL)
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"upload":    upload,
+		"uploadUrl": uploadURL,
+	})
+}
+
+// GetUploadOffset godoc
+// @Summary Get the offset of an upload
+// @Description Returns the number of bytes received in the Upload-Offset header and the size in Upload-Length, to resume an interrupted upload
+// @Tags uploads
+// @Param id path string true "Upload ID"
+// @Security ApiKeyAuth
+// @Success 200
+// @Failure 404
+// @Router /api/uploads/{id} [head]
+func (h *UploadHandler) GetUploadOffset(c *gin.Context) {
+	upload, err := h.uploads.Get(c.Param("id"), c.GetString("userId"))
+	if err != nil {
+		c.Status(uploadErrorStatus(err))
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// GetUpload godoc
+// @Summary Get an upload
+// @Description Returns the status, size and received bytes of an upload
+// @Tags uploads
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,upload=object}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/uploads/{id} [get]
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	upload, err := h.uploads.Get(c.Param("id"), c.GetString("userId"))
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// UploadChunk godoc
+// @Summary Upload a chunk
+// @Description Appends the raw request body to the upload. The Upload-Offset header (or offset query parameter) must equal the bytes received so far; otherwise 409 is returned with the current offset to resume from.
+// @Tags uploads
+// @Accept octet-stream
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header integer true "Offset of the chunk"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,upload=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string,offset=integer}
+// @Failure 413 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/uploads/{id} [patch]
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	value := c.GetHeader("Upload-Offset")
+	if value == "" {
+		value = c.Query("offset")
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header must be the number of bytes received so far"})
+		return
+	}
+
+	upload, err := h.uploads.WriteChunk(c.Request.Context(), c.Param("id"), c.GetString("userId"), offset, c.Request.Body)
+	if err != nil {
+		if upload != nil {
+			setUploadHeaders(c, upload)
+		}
+		switch {
+		case errors.Is(err, services.ErrUploadOffsetMismatch), errors.Is(err, services.ErrUploadCompleted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": upload.Received})
+		case errors.Is(err, services.ErrUploadChunkTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		default:
+			respondUploadError(c, err)
+		}
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// CompleteUpload godoc
+// @Summary Complete an upload
+// @Description Joins the received chunks and verifies them against the SHA-256 of the file, as "sha256:<hex>" or "<hex>". The upload ID can then be sent to the PDF operations as uploadId.
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param body body object{checksum=string} true "SHA-256 of the file"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean,upload=object}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 409 {object} object{error=string,offset=integer}
+// @Failure 422 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/uploads/{id}/complete [post]
+func (h *UploadHandler) CompleteUpload(c *gin.Context) {
+	var request struct {
+		Checksum string `json:"checksum" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	upload, err := h.uploads.Complete(c.Request.Context(), c.Param("id"), c.GetString("userId"), request.Checksum)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidChecksum):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrUploadIncomplete):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "offset": upload.Received})
+		case errors.Is(err, services.ErrUploadChecksumMismatch):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			respondUploadError(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// AbortUpload godoc
+// @Summary Abort an upload
+// @Description Deletes an upload and everything received for it
+// @Tags uploads
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} object{success=boolean}
+// @Failure 404 {object} object{error=string}
+// @Failure 500 {object} object{error=string}
+// @Router /api/uploads/{id} [delete]
+func (h *UploadHandler) AbortUpload(c *gin.Context) {
+	if err := h.uploads.Abort(c.Request.Context(), c.Param("id"), c.GetString("userId")); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// setUploadHeaders sets the offset and size of an upload as tus does
+func setUploadHeaders(c *gin.Context, upload *models.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+}
+
+// uploadErrorStatus returns the status of upload errors without a more
+// specific one
+func uploadErrorStatus(err error) int {
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// respondUploadError responds to an error of the upload service
+func respondUploadError(c *gin.Context, err error) {
+	status := uploadErrorStatus(err)
+	if status == http.StatusNotFound {
+		c.JSON(status, gin.H{"error": "Upload not found"})
+		return
+	}
+	c.JSON(status, gin.H{"error": "Upload failed: " + err.Error()})
+}
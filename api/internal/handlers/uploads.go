@@ -0,0 +1,148 @@
+// internal/handlers/uploads.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// inputFilesKey is the context key of the local files handed to a handler
+// by ResolveInputs or invokeHandler, by form field
+const inputFilesKey = "inputFiles"
+
+// inputFile is a file an operation reads: a file sent in the multipart form,
+// or a local file handed over in the context
+type inputFile struct {
+	Filename string
+	Size     int64
+	header   *multipart.FileHeader // File sent in the form
+	path     string                // Local file
+}
+
+// Open opens the content of the file
+func (f *inputFile) Open() (io.ReadCloser, error) {
+	if f.header != nil {
+		return f.header.Open()
+	}
+	return os.Open(f.path)
+}
+
+// Save writes the file to dst. Local files are linked instead of copied when
+// dst is on the same file system.
+func (f *inputFile) Save(dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if f.path != "" && os.Link(f.path, dst) == nil {
+		return nil
+	}
+
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// setInputFiles hands local files to the handler, by form field
+func setInputFiles(c *gin.Context, files map[string][]formFile) error {
+	inputs := make(map[string][]*inputFile, len(files))
+	for field, fieldFiles := range files {
+		for _, file := range fieldFiles {
+			info, err := os.Stat(file.Path)
+			if err != nil {
+				return err
+			}
+			inputs[field] = append(inputs[field], &inputFile{Filename: file.Filename, Size: info.Size(), path: file.Path})
+		}
+	}
+	c.Set(inputFilesKey, inputs)
+	return nil
+}
+
+// formInputs returns the files of every form field: the files sent in the
+// form, followed by the local files handed over in the context
+func formInputs(c *gin.Context) (map[string][]*inputFile, error) {
+	inputs := make(map[string][]*inputFile)
+	form, err := c.MultipartForm()
+	switch {
+	case err == nil:
+		for field, headers := range form.File {
+			for _, header := range headers {
+				inputs[field] = append(inputs[field], &inputFile{Filename: header.Filename, Size: header.Size, header: header})
+			}
+		}
+	case !errors.Is(err, http.ErrNotMultipart):
+		return nil, err
+	}
+
+	if value, ok := c.Get(inputFilesKey); ok {
+		for field, files := range value.(map[string][]*inputFile) {
+			inputs[field] = append(inputs[field], files...)
+		}
+	}
+	return inputs, nil
+}
+
+// formInputFiles returns the files of a form field
+func formInputFiles(c *gin.Context, field string) ([]*inputFile, error) {
+	inputs, err := formInputs(c)
+	if err != nil {
+		return nil, err
+	}
+	return inputs[field], nil
+}
+
+// formInputFile returns the first file of a form field, or
+// http.ErrMissingFile if there is none
+func formInputFile(c *gin.Context, field string) (*inputFile, error) {
+	files, err := formInputFiles(c, field)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, http.ErrMissingFile
+	}
+	return files[0], nil
+}
+
+// maxUploadSize returns the upload limit in bytes of the caller's plan, set
+// by the API key middleware as maxFileSize. Requests without a plan limit,
+// e.g. to handlers run outside the API key middleware, get the
+// api.maxFileSize setting.
+func maxUploadSize(c *gin.Context) int64 {
+	if size := c.GetInt64("maxFileSize"); size > 0 {
+		return size
+	}
+	return services.MaxFileSizeSetting()
+}
+
+// rejectLargeUpload responds with 400 and returns true if an uploaded file
+// exceeds the caller's plan limit
+func rejectLargeUpload(c *gin.Context, filename string, size int64) bool {
+	limit := maxUploadSize(c)
+	if size <= limit {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": fmt.Sprintf("File '%s' exceeds the %dMB limit of your plan", filename, limit/(1024*1024)),
+	})
+	return true
+}
@@ -0,0 +1,129 @@
+// internal/handlers/uploads_test.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormInputs(t *testing.T) {
+	dir := t.TempDir()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("files", "sent.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, "%PDF-1.7 sent")
+	writer.WriteField("order", "[1,0]")
+	writer.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/pdf/merge", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	// Resolved files follow the files sent in the form
+	resolvedPath := filepath.Join(dir, "resolved.pdf")
+	writeTestFile(t, resolvedPath, "%PDF-1.7 resolved")
+	if err := setInputFiles(c, map[string][]formFile{"files": {{Filename: "upload.pdf", Path: resolvedPath}}}); err != nil {
+		t.Fatalf("setInputFiles() error = %v", err)
+	}
+
+	files, err := formInputFiles(c, "files")
+	if err != nil {
+		t.Fatalf("formInputFiles() error = %v", err)
+	}
+	if len(files) != 2 || files[0].Filename != "sent.pdf" || files[1].Filename != "upload.pdf" {
+		t.Fatalf("formInputFiles() = %+v, want sent.pdf then upload.pdf", files)
+	}
+	if files[1].Size != int64(len("%PDF-1.7 resolved")) {
+		t.Errorf("resolved file size = %d", files[1].Size)
+	}
+	if c.PostForm("order") != "[1,0]" {
+		t.Errorf("PostForm(order) = %q, want the form field", c.PostForm("order"))
+	}
+
+	for i, want := range []string{"%PDF-1.7 sent", "%PDF-1.7 resolved"} {
+		dst := filepath.Join(dir, "saved", filepath.Base(files[i].Filename))
+		if err := files[i].Save(dst); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if data, _ := os.ReadFile(dst); string(data) != want {
+			t.Errorf("saved %s = %q, want %q", files[i].Filename, data, want)
+		}
+	}
+
+	// Local files are linked instead of copied
+	resolvedInfo, _ := os.Stat(resolvedPath)
+	savedInfo, _ := os.Stat(filepath.Join(dir, "saved", "upload.pdf"))
+	if !os.SameFile(resolvedInfo, savedInfo) {
+		t.Error("resolved file was copied instead of linked")
+	}
+
+	if _, err := formInputFile(c, "content"); err != http.ErrMissingFile {
+		t.Errorf("formInputFile() of a missing field error = %v, want http.ErrMissingFile", err)
+	}
+}
+
+func TestInvokeHandlerInputs(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input.pdf")
+	writeTestFile(t, inputPath, "%PDF-1.7 input")
+
+	handler := func(c *gin.Context) {
+		file, err := formInputFile(c, "file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer src.Close()
+		content, _ := io.ReadAll(src)
+		c.JSON(http.StatusOK, gin.H{
+			"filename": file.Filename,
+			"content":  string(content),
+			"angle":    c.PostForm("angle"),
+			"userId":   c.GetString("userId"),
+		})
+	}
+
+	status, body, err := invokeHandler(context.Background(), handler, internalRequest{
+		Path:   "/api/pdf/rotate",
+		Fields: map[string][]string{"angle": {"90"}},
+		Files:  map[string][]formFile{"file": {{Filename: "report.pdf", Path: inputPath}}},
+		Values: map[string]interface{}{"userId": "user-1"},
+	})
+	if err != nil {
+		t.Fatalf("invokeHandler() error = %v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("invokeHandler() status = %d, body %v", status, body)
+	}
+	want := map[string]interface{}{"filename": "report.pdf", "content": "%PDF-1.7 input", "angle": "90", "userId": "user-1"}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("handler saw %s = %v, want %v", key, body[key], value)
+		}
+	}
+}
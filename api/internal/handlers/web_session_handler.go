@@ -0,0 +1,68 @@
+// internal/handlers/web_session_handler.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// WebSessionHandler issues anonymous session tokens to the web frontend
+type WebSessionHandler struct {
+	webSessions *services.WebSessionService
+	config      *config.Config
+}
+
+// NewWebSessionHandler creates a new web session handler
+func NewWebSessionHandler(webSessions *services.WebSessionService, cfg *config.Config) *WebSessionHandler {
+	return &WebSessionHandler{
+		webSessions: webSessions,
+		config:      cfg,
+	}
+}
+
+// CreateSession godoc
+// @Summary Create an anonymous web session
+// @Description Issues a short-lived signed token for the web frontend. Send it in the X-Session-Token header instead of an API key; operations are limited per client IP (WEB_SESSION_QUOTA per session lifetime), whatever the number of tokens, and each IP may request WEB_SESSION_ISSUE_MAX tokens per hour. Only requests from the configured origins (ALLOWED_ORIGINS) get a token.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} object{success=boolean,token=string,expiresAt=string,quota=integer}
+// @Failure 403 {object} object{error=string}
+// @Failure 429 {object} object{error=string,retryAfter=integer}
+// @Failure 500 {object} object{error=string}
+// @Router /api/web-session [post]
+func (h *WebSessionHandler) CreateSession(c *gin.Context) {
+	if !h.config.OriginAllowed(c.GetHeader("Origin")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Web sessions are only available to the MegaPDF website"})
+		return
+	}
+
+	// The Origin header can be set by any client, so the IP is limited too
+	token, session, err := h.webSessions.Issue(c.Request.Context(), c.ClientIP())
+	var limitErr *services.WebSessionLimitError
+	if errors.As(err, &limitErr) {
+		retryAfter := int(time.Until(limitErr.RetryAt).Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      "Too many sessions requested, try again later",
+			"retryAfter": retryAfter,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+		"quota":     session.Quota,
+	})
+}
@@ -9,8 +9,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// ApiKeyMiddleware validates API key and checks permissions
-func ApiKeyMiddleware(keyService *services.KeyValidationService) gin.HandlerFunc {
+// WebSessionHeader carries the web session token issued by POST /api/web-session
+const WebSessionHeader = "X-Session-Token"
+
+// ApiKeyMiddleware validates the API key or web session token and checks permissions
+func ApiKeyMiddleware(keyService *services.KeyValidationService, webSessions *services.WebSessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get operation from path
 		path := c.Request.URL.Path
@@ -28,73 +31,34 @@ func ApiKeyMiddleware(keyService *services.KeyValidationService) gin.HandlerFunc
 			}
 		}
 
-		// Check if this is a web UI request
-		isWebsiteRequest := false
-
-		// Method 1: Check for browser-like requests
-		userAgent := c.GetHeader("User-Agent")
-		accept := c.GetHeader("Accept")
-		referer := c.GetHeader("Referer")
-		origin := c.GetHeader("Origin")
-
-		// Check user agent and accept header for browser characteristics
-		if strings.Contains(accept, "text/html") && (strings.Contains(userAgent, "Mozilla/") ||
-			strings.Contains(userAgent, "Chrome/") ||
-			strings.Contains(userAgent, "Safari/") ||
-			strings.Contains(userAgent, "Firefox/") ||
-			strings.Contains(userAgent, "Edge/")) {
-			isWebsiteRequest = true
-		}
-
-		// Method 2: Check origin/referer against allowed domains
-		allowedDomains := []string{
-			"mega-pdf.com",
-			"www.mega-pdf.com",
-			"localhost",
-			"127.0.0.1",
-		}
-
-		if referer != "" {
-			for _, domain := range allowedDomains {
-				if strings.Contains(referer, domain) {
-					isWebsiteRequest = true
-					break
-				}
-			}
+		apiKey := c.GetHeader("x-api-key")
+		if apiKey == "" {
+			apiKey = c.Query("api_key")
 		}
 
-		if origin != "" {
-			for _, domain := range allowedDomains {
-				if strings.Contains(origin, domain) {
-					isWebsiteRequest = true
-					break
-				}
+		// The web frontend uses a signed anonymous session token instead of
+		// an API key; its operations are counted against the session quota
+		if sessionToken := c.GetHeader(WebSessionHeader); sessionToken != "" && apiKey == "" {
+			session, err := webSessions.Verify(sessionToken)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid or expired session token",
+				})
+				c.Abort()
+				return
 			}
-		}
 
-		// BYPASS DATABASE COMPLETELY for website requests
-		if isWebsiteRequest {
-			// Set all necessary context values without database queries
-			c.Set("userId", "website-user")
+			c.Set("userId", services.WebSessionUserID(session.ID))
+			c.Set("webSessionId", session.ID)
 			c.Set("operationType", operation)
-			c.Set("isWebsiteRequest", true)
-			c.Set("freeOperationsRemaining", 999) // Unlimited for website
-			c.Set("balance", 9999.0)              // High balance for website
+			c.Set("maxFileSize", keyService.MaxFileSize(""))
 
-			// Skip all further authentication/validation
 			c.Next()
 			return
 		}
 
-		// For non-website requests, perform normal API key validation
-		apiKey := c.GetHeader("x-api-key")
-		if apiKey == "" {
-			apiKey = c.Query("api_key")
-		}
-
-		// Regular API key validation - DATABASE QUERY HAPPENS HERE
-		// This won't execute for website requests since we've already called c.Next() and returned
-		result, err := keyService.ValidateKey(apiKey, operation)
+		// Validate the API key
+		result, err := keyService.ValidateKey(apiKey, operation, c.ClientIP())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Error validating API key: " + err.Error(),
@@ -104,7 +68,11 @@ func ApiKeyMiddleware(keyService *services.KeyValidationService) gin.HandlerFunc
 		}
 
 		if !result.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
+			status := http.StatusUnauthorized
+			if result.Forbidden {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{
 				"error": result.Error,
 			})
 			c.Abort()
@@ -113,9 +81,12 @@ func ApiKeyMiddleware(keyService *services.KeyValidationService) gin.HandlerFunc
 
 		// Store user ID and operation type in context
 		c.Set("userId", result.UserID)
+		c.Set("apiKeyId", result.KeyID)
+		c.Set("apiKeyScopes", result.Scopes)
 		c.Set("operationType", operation)
 		c.Set("freeOperationsRemaining", result.FreeOperationsRemaining)
 		c.Set("balance", result.Balance)
+		c.Set("maxFileSize", result.MaxFileSize)
 
 		c.Next()
 	}
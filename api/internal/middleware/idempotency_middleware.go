@@ -0,0 +1,254 @@
+// internal/middleware/idempotency_middleware.go
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/config"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader is the request header holding the client's key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength matches the size of the stored key column
+const maxIdempotencyKeyLength = 255
+
+// idempotentBodyFiles is how many files at the caller's size limit a request
+// body may hold; merges send several files in one request
+const idempotentBodyFiles = 10
+
+// errBodyTooLarge is returned for request bodies over the limit of the caller
+var errBodyTooLarge = errors.New("request body is too large")
+
+// IdempotencyMiddleware makes requests sent with an Idempotency-Key header
+// safe to retry: the first request with a key runs and its response is
+// stored, later requests with the same key and body get the stored response
+// (marked with Idempotent-Replayed: true) without running the operation
+// again. A repeat sent while the first request is still running gets 409.
+// It must run after the middleware setting userId, since keys are per user.
+func IdempotencyMiddleware(idempotency *services.IdempotencyService, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength),
+			})
+			c.Abort()
+			return
+		}
+
+		userID := c.GetString("userId")
+		if userID == "" {
+			c.Next()
+			return
+		}
+
+		requestHash, cleanup, err := hashRequestBody(c, cfg.TempDir, maxBodySize(c))
+		if errors.Is(err, errBodyTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request: " + err.Error()})
+			c.Abort()
+			return
+		}
+		defer cleanup()
+
+		record, err := idempotency.Begin(userID, key, c.Request.Method, c.Request.URL.Path, requestHash)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check idempotency key: " + err.Error()})
+			c.Abort()
+			return
+		}
+
+		if record.Status == models.IdempotencyStatusCompleted {
+			if record.ContentType != "" {
+				c.Header("Content-Type", record.ContentType)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(record.ResponseStatus)
+			c.Writer.Write(record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// A panicking handler must not keep the key locked until it expires
+		completed := false
+		defer func() {
+			if !completed {
+				idempotency.Abandon(record.ID)
+			}
+		}()
+
+		// Heartbeats keep the key while the request runs; once they stop, a
+		// retry may take the key over
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(services.IdempotencyHeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := idempotency.Heartbeat(record.ID); err != nil {
+						fmt.Printf("WARNING: Failed to refresh idempotency key %s: %v\n", key, err)
+					}
+				}
+			}
+		}()
+
+		c.Next()
+
+		// Server errors are not stored so the request can be retried; the
+		// operation's reservation was released, so nothing was charged
+		status := writer.Status()
+		if !writer.Written() || status >= http.StatusInternalServerError {
+			return
+		}
+		if err := idempotency.Complete(record.ID, status, writer.Header().Get("Content-Type"), writer.body.Bytes()); err != nil {
+			fmt.Printf("ERROR: Failed to store response for idempotency key %s: %v\n", key, err)
+			return
+		}
+		completed = true
+	}
+}
+
+// maxBodySize returns the largest request body accepted from the caller
+func maxBodySize(c *gin.Context) int64 {
+	limit := c.GetInt64("maxFileSize")
+	if limit <= 0 {
+		limit = services.MaxFileSizeSetting()
+	}
+	return limit * idempotentBodyFiles
+}
+
+// hashRequestBody returns the SHA-256 of the method, URL and body of the
+// request. The body is spooled to a temporary file in tempDir, replacing the
+// request body, so large uploads are not held in memory; cleanup removes the
+// file. Bodies over limit bytes fail with errBodyTooLarge.
+func hashRequestBody(c *gin.Context, tempDir string, limit int64) (string, func(), error) {
+	hash := sha256.New()
+	io.WriteString(hash, c.Request.Method+" "+c.Request.URL.RequestURI()+"\n")
+
+	noop := func() {}
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), noop, nil
+	}
+
+	if c.Request.ContentLength > limit {
+		return "", noop, errBodyTooLarge
+	}
+
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", noop, err
+	}
+	spool, err := os.CreateTemp(tempDir, "idempotency-*")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	written, err := io.Copy(spool, io.LimitReader(c.Request.Body, limit+1))
+	if err == nil && written > limit {
+		err = errBodyTooLarge
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	// Clients pick a new multipart boundary for every attempt, so multipart
+	// bodies are hashed part by part instead of byte by byte
+	mediaType, params, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "multipart/form-data" && params["boundary"] != "" {
+		err = hashMultipart(hash, multipart.NewReader(spool, params["boundary"]))
+	} else {
+		_, err = io.Copy(hash, spool)
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	c.Request.Body.Close()
+	c.Request.Body = spool
+
+	return hex.EncodeToString(hash.Sum(nil)), cleanup, nil
+}
+
+// hashMultipart writes the name, filename and content of every part to hash
+func hashMultipart(hash io.Writer, reader *multipart.Reader) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%q %q\n", part.FormName(), part.FileName())
+		if _, err := io.Copy(hash, part); err != nil {
+			return err
+		}
+		io.WriteString(hash, "\n")
+	}
+}
+
+// capturingWriter records the response body while writing it to the client
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,110 @@
+// internal/middleware/idempotency_middleware_test.go
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newHashContext(t *testing.T, body io.Reader, contentType string) *gin.Context {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/pdf/compress?level=2", body)
+	c.Request.Header.Set("Content-Type", contentType)
+	return c
+}
+
+func multipartBody(t *testing.T, boundary, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	part, err := writer.CreateFormFile("file", "document.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, content)
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestHashRequestBody(t *testing.T) {
+	tempDir := t.TempDir()
+
+	first, firstType := multipartBody(t, "first-boundary", "%PDF-1.7 content")
+	c := newHashContext(t, first, firstType)
+	firstHash, cleanup, err := hashRequestBody(c, tempDir, 1024)
+	if err != nil {
+		t.Fatalf("hashRequestBody() error = %v", err)
+	}
+
+	// The spooled body replaces the request body for the handlers
+	if _, err := c.FormFile("file"); err != nil {
+		t.Errorf("spooled body is not readable: %v", err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 1 {
+		t.Errorf("temp dir holds %d files, want the spooled body", len(entries))
+	}
+	cleanup()
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp dir holds %d files after cleanup, want none", len(entries))
+	}
+
+	// A retry with another multipart boundary is the same request
+	second, secondType := multipartBody(t, "second-boundary", "%PDF-1.7 content")
+	secondHash, cleanup, err := hashRequestBody(newHashContext(t, second, secondType), tempDir, 1024)
+	if err != nil {
+		t.Fatalf("hashRequestBody() error = %v", err)
+	}
+	cleanup()
+	if secondHash != firstHash {
+		t.Error("hash depends on the multipart boundary")
+	}
+
+	other, otherType := multipartBody(t, "first-boundary", "%PDF-1.7 other content")
+	otherHash, cleanup, err := hashRequestBody(newHashContext(t, other, otherType), tempDir, 1024)
+	if err != nil {
+		t.Fatalf("hashRequestBody() error = %v", err)
+	}
+	cleanup()
+	if otherHash == firstHash {
+		t.Error("hash does not depend on the file content")
+	}
+}
+
+func TestHashRequestBodyLimit(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Bodies of unknown length are cut off while spooling
+	body := strings.Repeat("x", 100)
+	c := newHashContext(t, io.MultiReader(strings.NewReader(body)), "application/json")
+	if _, _, err := hashRequestBody(c, tempDir, 99); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("hashRequestBody() of a body over the limit error = %v, want errBodyTooLarge", err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp dir holds %d files after a refused body, want none", len(entries))
+	}
+
+	// Bodies announcing their length are refused before reading
+	if _, _, err := hashRequestBody(newHashContext(t, strings.NewReader(body), "application/json"), tempDir, 99); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("hashRequestBody() of a long body error = %v, want errBodyTooLarge", err)
+	}
+
+	_, cleanup, err := hashRequestBody(newHashContext(t, strings.NewReader(body), "application/json"), tempDir, 100)
+	if err != nil {
+		t.Errorf("hashRequestBody() of a body at the limit error = %v", err)
+	} else {
+		cleanup()
+	}
+}
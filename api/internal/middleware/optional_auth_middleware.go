@@ -0,0 +1,35 @@
+// internal/middleware/optional_auth_middleware.go
+package middleware
+
+import (
+	"github.com/MegaPDF/megapdf-official/api/internal/db"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// OptionalAuthMiddleware identifies the user from an API key, web session
+// token or JWT when one is sent, without rejecting anonymous requests. Routes
+// using it decide themselves what anonymous users may do.
+func OptionalAuthMiddleware(jwtSecret string, keyService *services.KeyValidationService, webSessions *services.WebSessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("x-api-key"); apiKey != "" {
+			result, err := keyService.ValidateKey(apiKey, "", c.ClientIP())
+			if err == nil && result.Valid {
+				c.Set("userId", result.UserID)
+				c.Set("apiKeyId", result.KeyID)
+			}
+		} else if sessionToken := c.GetHeader(WebSessionHeader); sessionToken != "" {
+			if session, err := webSessions.Verify(sessionToken); err == nil {
+				c.Set("userId", services.WebSessionUserID(session.ID))
+				c.Set("webSessionId", session.ID)
+			}
+		} else if token := requestToken(c); token != "" {
+			authService := services.NewAuthService(db.DB, jwtSecret)
+			if userID, err := authService.ValidateToken(token); err == nil {
+				c.Set("userId", userID)
+			}
+		}
+
+		c.Next()
+	}
+}
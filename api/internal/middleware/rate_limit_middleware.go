@@ -1,79 +1,68 @@
-
 // internal/middleware/rate_limit_middleware.go
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
+	"github.com/MegaPDF/megapdf-official/api/internal/services"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/time/rate"
 )
 
-// Simple in-memory rate limiter
-type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.Mutex
-}
-
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter() *RateLimiter {
-	return &RateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-	}
-}
-
-// GetLimiter returns a rate limiter for the given IP
-func (r *RateLimiter) GetLimiter(ip string) *rate.Limiter {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	limiter, exists := r.limiters[ip]
-	if !exists {
-		// Create a new limiter for this IP:
-		// 100 requests per minute with burst of 20
-		limiter = rate.NewLimiter(rate.Limit(100/60), 20)
-		r.limiters[ip] = limiter
-	}
-
-	return limiter
-}
-
-// Clean up old limiters periodically
-func (r *RateLimiter) CleanupLimiters() {
-	for {
-		time.Sleep(time.Hour) // Run cleanup every hour
-		r.mu.Lock()
-		r.limiters = make(map[string]*rate.Limiter) // Reset all limiters
-		r.mu.Unlock()
-	}
-}
+// RateLimitMiddleware limits requests per API key, per user (JWT) or, for
+// anonymous requests, per IP address. Invalid credentials are limited by IP.
+// The limits come from RateLimitService and are reported in the RateLimit-*
+// headers; rejected requests also get Retry-After.
+func RateLimitMiddleware(rateLimits *services.RateLimitService, jwtSecret string) gin.HandlerFunc {
+	authService := services.NewAuthService(nil, jwtSecret)
 
-// Create a single global rate limiter
-var limiter = NewRateLimiter()
+	return func(c *gin.Context) {
+		var keyID, userID string
 
-// Start the cleanup goroutine
-func init() {
-	go limiter.CleanupLimiters()
-}
+		apiKey := c.GetHeader("x-api-key")
+		if apiKey == "" {
+			apiKey = c.Query("api_key")
+		}
+		if apiKey != "" {
+			keyID, userID = rateLimits.KeyOwner(apiKey)
+		} else if token := requestToken(c); token != "" {
+			// Only the signature is checked here; AuthMiddleware validates the session
+			userID, _ = authService.ValidateToken(token)
+		}
 
-// RateLimitMiddleware limits the rate of requests by IP address
-func RateLimitMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get client IP
 		ip := c.ClientIP()
 		if ip == "" {
 			ip = "unknown"
 		}
 
-		// Get limiter for this IP
-		ipLimiter := limiter.GetLimiter(ip)
+		decision, err := rateLimits.Check(c.Request.Context(), keyID, userID, ip)
+		if err != nil {
+			// An unavailable store must not take the API down
+			fmt.Printf("WARNING: Rate limit check failed: %v\n", err)
+			c.Next()
+			return
+		}
+
+		if decision.Policy != "" {
+			setRateLimitHeaders(c, decision)
+		}
+
+		if !decision.Allowed {
+			retryAfter := secondsUntil(decision.Reset)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
 
-		// Try to allow request
-		if !ipLimiter.Allow() {
+			message := "Rate limit exceeded"
+			if decision.QuotaExceeded {
+				message = "Daily request quota exceeded"
+			}
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
+				"error":      message,
+				"retryAfter": retryAfter,
 			})
 			c.Abort()
 			return
@@ -82,3 +71,32 @@ func RateLimitMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// requestToken returns the JWT of the Authorization header or authToken cookie
+func requestToken(c *gin.Context) string {
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if token, err := c.Cookie("authToken"); err == nil {
+		return token
+	}
+	return ""
+}
+
+// setRateLimitHeaders writes the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset (seconds) and RateLimit-Policy headers
+func setRateLimitHeaders(c *gin.Context, decision *ratelimit.Decision) {
+	c.Header("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(secondsUntil(decision.Reset)))
+	c.Header("RateLimit-Policy", decision.Policy)
+}
+
+// secondsUntil returns the whole seconds until t, at least 1
+func secondsUntil(t time.Time) int {
+	seconds := int(math.Ceil(time.Until(t).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,26 @@
+// internal/models/artifact.go
+package models
+
+import "time"
+
+// Artifact is a result file handed out by an operation. Downloads through
+// /api/file are only served to its owner or through a signed link, and are
+// refused with 410 Gone once the artifact expired.
+type Artifact struct {
+	ID          string     `gorm:"primaryKey;type:varchar(100)"`
+	UserID      string     `gorm:"type:varchar(100);index"` // Owner; web sessions own their results as "web-session:<id>"
+	Operation   string     `gorm:"type:varchar(50);index"`
+	StorageKey  string     `gorm:"type:varchar(512);uniqueIndex"` // e.g. "compressions/<id>-compressed.pdf"
+	Filename    string     `gorm:"type:varchar(255)"`
+	ContentType string     `gorm:"type:varchar(100)"`
+	Size        int64      // Bytes
+	Checksum    string     `gorm:"type:varchar(64)"` // Hex encoded SHA-256 of the content
+	ExpiresAt   time.Time  `gorm:"index"`
+	PurgedAt    *time.Time `gorm:"index"` // When retention removed the content; the record is kept to answer 410
+	CreatedAt   time.Time
+}
+
+// IsExpired reports whether the artifact may no longer be downloaded
+func (a *Artifact) IsExpired(now time.Time) bool {
+	return !a.ExpiresAt.After(now)
+}
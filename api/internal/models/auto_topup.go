@@ -0,0 +1,26 @@
+// internal/models/auto_topup.go
+package models
+
+import "time"
+
+// AutoTopUp is a user's automatic recharge of their balance: once a charge
+// takes the balance below Threshold, Amount is charged to the payment method
+// saved with Provider and added to the balance
+type AutoTopUp struct {
+	UserID               string     `gorm:"primaryKey;type:varchar(100)" json:"-"`
+	Enabled              bool       `gorm:"default:false" json:"enabled"`
+	Threshold            float64    `gorm:"type:decimal(10,3)" json:"threshold"`
+	Amount               float64    `gorm:"type:decimal(10,3)" json:"amount"`
+	Provider             string     `gorm:"type:varchar(20)" json:"provider"`
+	PaymentMethodID      string     `gorm:"type:varchar(255)" json:"-"`                                    // Provider's saved payment method, empty until a setup is confirmed
+	SetupProvider        string     `gorm:"type:varchar(20)" json:"-"`                                     // Provider of the setup the user has yet to approve
+	SetupID              string     `gorm:"type:varchar(255)" json:"-"`                                    // Setup of a payment method the user has yet to approve
+	PendingTransactionID *string    `gorm:"type:varchar(100);index" json:"pendingTransactionId,omitempty"` // Top-up being charged, retried with the same ID until it is settled
+	Attempts             int        `json:"-"`                                                             // Attempts to charge the pending top-up
+	FailureCount         int        `json:"failureCount"`                                                  // Top-ups declined in a row
+	LastError            string     `gorm:"type:varchar(255)" json:"lastError,omitempty"`
+	LastAttemptAt        *time.Time `json:"lastAttemptAt,omitempty"`
+	LastTopUpAt          *time.Time `json:"lastTopUpAt,omitempty"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
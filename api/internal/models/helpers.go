@@ -2,7 +2,11 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -28,6 +32,47 @@ func ComparePasswords(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
+// ApiKeyPrefixLength is the length of the public part of an API key that is
+// stored in clear for lookup, e.g. "sk_1a2b3c4d"
+const ApiKeyPrefixLength = 11
+
+// ApiKeyPrefix returns the public lookup prefix of an API key
+func ApiKeyPrefix(key string) string {
+	if len(key) <= ApiKeyPrefixLength {
+		return key
+	}
+	return key[:ApiKeyPrefixLength]
+}
+
+// HashApiKey returns a salted SHA-256 hash of an API key in the form
+// "sha256$<salt>$<hash>". API keys are long random strings, so unlike
+// passwords they do not need a slow hash.
+func HashApiKey(key string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return "sha256$" + hex.EncodeToString(salt) + "$" + apiKeyDigest(salt, key), nil
+}
+
+// CompareApiKey reports whether key matches a hash created by HashApiKey
+func CompareApiKey(hash, key string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 3 || parts[0] != "sha256" {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(apiKeyDigest(salt, key)), []byte(parts[2])) == 1
+}
+
+func apiKeyDigest(salt []byte, key string) string {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), key...))
+	return hex.EncodeToString(sum[:])
+}
+
 // TimePtr returns a pointer to a time.Time value
 func TimePtr(t time.Time) *time.Time {
 	return &t
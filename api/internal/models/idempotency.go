@@ -0,0 +1,30 @@
+// internal/models/idempotency.go
+package models
+
+import "time"
+
+// Idempotency record statuses
+const (
+	IdempotencyStatusProcessing = "processing"
+	IdempotencyStatusCompleted  = "completed"
+)
+
+// IdempotencyRecord stores the response of a request sent with an
+// Idempotency-Key header, so a retry with the same key replays the response
+// instead of running (and charging) the operation again. Keys are scoped to
+// the user and kept until ExpiresAt.
+type IdempotencyRecord struct {
+	ID             string `gorm:"primaryKey;type:varchar(100)"`
+	UserID         string `gorm:"type:varchar(100);uniqueIndex:idx_idempotency_records_user_key"`
+	Key            string `gorm:"type:varchar(255);uniqueIndex:idx_idempotency_records_user_key"`
+	Method         string `gorm:"type:varchar(10)"`
+	Path           string `gorm:"type:varchar(255)"`
+	RequestHash    string `gorm:"type:varchar(64)"` // SHA-256 of the method, URL and body
+	Status         string `gorm:"type:varchar(20);default:'processing'"`
+	ResponseStatus int
+	ContentType    string    `gorm:"type:varchar(255)"`
+	ResponseBody   []byte    `gorm:"type:longblob"`
+	ExpiresAt      time.Time `gorm:"index"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time // Refreshed by heartbeats while processing
+}
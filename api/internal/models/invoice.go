@@ -0,0 +1,93 @@
+// internal/models/invoice.go
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Invoice types
+const (
+	InvoiceTypeDeposit   = "deposit"   // Invoice for a completed deposit
+	InvoiceTypeStatement = "statement" // Monthly usage statement
+)
+
+// Invoice is an invoice or monthly statement issued to a user. Seller and
+// buyer details and the lines are copied when it is issued, so later changes
+// to the billing settings or the user do not alter issued documents.
+type Invoice struct {
+	ID             string       `gorm:"primaryKey;type:varchar(100)" json:"id"`
+	Number         string       `gorm:"uniqueIndex;type:varchar(50)" json:"number"`
+	Type           string       `gorm:"type:varchar(20);uniqueIndex:idx_invoices_user_period" json:"type"`
+	UserID         string       `gorm:"type:varchar(100);index;uniqueIndex:idx_invoices_user_period" json:"-"`
+	TransactionID  *string      `gorm:"type:varchar(100);uniqueIndex" json:"transactionId,omitempty"` // Deposit of a deposit invoice
+	PeriodStart    *time.Time   `gorm:"uniqueIndex:idx_invoices_user_period" json:"periodStart,omitempty"`
+	PeriodEnd      *time.Time   `json:"periodEnd,omitempty"`
+	Currency       string       `gorm:"type:varchar(3)" json:"currency"`
+	Total          float64      `gorm:"type:decimal(10,3)" json:"total"`          // Amount paid, or charges of the period for statements
+	OpeningBalance float64      `gorm:"type:decimal(10,3)" json:"openingBalance"` // Statements only
+	ClosingBalance float64      `gorm:"type:decimal(10,3)" json:"closingBalance"` // Statements only
+	SellerName     string       `gorm:"type:varchar(255)" json:"sellerName"`
+	SellerAddress  string       `gorm:"type:text" json:"sellerAddress"`
+	SellerEmail    string       `gorm:"type:varchar(255)" json:"sellerEmail"`
+	SellerTaxID    string       `gorm:"type:varchar(100)" json:"sellerTaxId"`
+	BuyerName      string       `gorm:"type:varchar(255)" json:"buyerName"`
+	BuyerEmail     string       `gorm:"type:varchar(255)" json:"buyerEmail"`
+	Lines          InvoiceLines `gorm:"type:text" json:"lines"`
+	IssuedAt       time.Time    `json:"issuedAt"`
+	CreatedAt      time.Time    `json:"createdAt"`
+}
+
+// InvoiceLine is a line of an invoice. Statement lines without a unit price
+// sum up several transactions.
+type InvoiceLine struct {
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unitPrice,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+// InvoiceCounter holds the last number issued in an invoice series, so
+// numbers are sequential without gaps
+type InvoiceCounter struct {
+	Series string `gorm:"primaryKey;type:varchar(20)"`
+	Value  int64
+}
+
+// InvoiceLines is a list of invoice lines stored as JSON
+type InvoiceLines []InvoiceLine
+
+// Value implements the driver.Valuer interface
+func (l InvoiceLines) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan implements the sql.Scanner interface
+func (l *InvoiceLines) Scan(value interface{}) error {
+	if value == nil {
+		*l = InvoiceLines{}
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+	if len(bytes) == 0 {
+		*l = InvoiceLines{}
+		return nil
+	}
+
+	return json.Unmarshal(bytes, l)
+}
@@ -0,0 +1,58 @@
+// internal/models/job.go
+package models
+
+import "time"
+
+// Job statuses
+const (
+	JobStatusQueued     = "queued"
+	JobStatusProcessing = "processing"
+	JobStatusCompleted  = "completed"
+	JobStatusFailed     = "failed"
+	JobStatusCancelled  = "cancelled"
+)
+
+// Job is a unit of asynchronous work executed by the job worker pool
+type Job struct {
+	ID          string `gorm:"primaryKey;type:varchar(100)"`
+	UserID      string `gorm:"type:varchar(100);index"`
+	Operation   string `gorm:"type:varchar(50);index"`
+	Status      string `gorm:"type:varchar(20);index;default:'queued'"`
+	Progress    int    `gorm:"default:0"`
+	Params      string `gorm:"type:longtext"` // JSON encoded operation parameters
+	Result      string `gorm:"type:longtext"` // JSON encoded operation result
+	Error       string `gorm:"type:text"`
+	CallbackURL string `gorm:"type:varchar(2048)"` // Optional webhook notified when the job finishes
+	// Reservation of the job's cost, held while the job is queued or running
+	// and settled by its runner
+	ReservationID string `gorm:"type:varchar(100);index"`
+	StartedAt     *time.Time
+	CompletedAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// IsFinished reports whether the job has reached a terminal status
+func (j *Job) IsFinished() bool {
+	return j.Status == JobStatusCompleted || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
+
+// WebhookDelivery records a single attempt to deliver a job callback. The
+// attempt is pending while NextAttemptAt is set; the worker sends it once
+// that time has passed.
+type WebhookDelivery struct {
+	ID            string `gorm:"primaryKey;type:varchar(100)"`
+	JobID         string `gorm:"type:varchar(100);index"`
+	UserID        string `gorm:"type:varchar(100);index"`
+	URL           string `gorm:"type:varchar(2048)"`
+	Event         string `gorm:"type:varchar(50)"`
+	Payload       string `gorm:"type:longtext"` // JSON body sent to the URL
+	Attempt       int
+	NextAttemptAt *time.Time `gorm:"index"`
+	AttemptedAt   *time.Time
+	StatusCode    int
+	Success       bool
+	Error         string `gorm:"type:text"`
+	DurationMs    int64
+	CreatedAt     time.Time
+}
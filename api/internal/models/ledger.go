@@ -0,0 +1,60 @@
+// internal/models/ledger.go
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Ledger account types. Assets and expenses have debit balances, liabilities,
+// equity and revenue have credit balances.
+const (
+	LedgerAccountTypeAsset     = "asset"
+	LedgerAccountTypeLiability = "liability"
+	LedgerAccountTypeEquity    = "equity"
+	LedgerAccountTypeRevenue   = "revenue"
+	LedgerAccountTypeExpense   = "expense"
+)
+
+// Codes of the system ledger accounts
+const (
+	LedgerAccountCash          = "asset:cash"             // Money received from payment providers
+	LedgerAccountReservations  = "liability:reservations" // Balance held for running operations
+	LedgerAccountRefunds       = "liability:refunds"      // Balance being paid back by a payment provider
+	LedgerAccountRevenue       = "revenue:operations"     // Balance spent on operations
+	LedgerAccountSubscriptions = "revenue:subscriptions"  // Balance spent on plan fees
+	LedgerAccountAdjustments   = "expense:adjustments"    // Balance granted or removed by admins
+	LedgerAccountOpening       = "equity:opening"         // Balances that existed before the ledger
+	LedgerAccountUserPrefix    = "liability:user:"        // Followed by the user ID: the user's balance
+)
+
+// LedgerAccount is an account of the double-entry ledger. Every user with a
+// balance has a liability account: the money MegaPDF owes the user.
+type LedgerAccount struct {
+	ID        string  `gorm:"primaryKey;type:varchar(100)"`
+	Code      string  `gorm:"uniqueIndex;type:varchar(150)"`
+	Type      string  `gorm:"type:varchar(20)"`
+	UserID    *string `gorm:"type:varchar(100);index"`
+	CreatedAt time.Time
+}
+
+// JournalEntry groups the ledger entries of one money movement. The amounts
+// of its entries always sum up to zero.
+type JournalEntry struct {
+	ID          string `gorm:"primaryKey;type:varchar(100)"`
+	Description string `gorm:"type:varchar(255)"`
+	Reference   string `gorm:"type:varchar(100);index"` // Transaction or reservation the entry records
+	CreatedAt   time.Time
+
+	Entries []LedgerEntry `gorm:"foreignKey:JournalEntryID"`
+}
+
+// LedgerEntry debits (positive amount) or credits (negative amount) an account
+type LedgerEntry struct {
+	ID             string          `gorm:"primaryKey;type:varchar(100)"`
+	JournalEntryID string          `gorm:"type:varchar(100);index"`
+	AccountID      string          `gorm:"type:varchar(100);index"`
+	Amount         decimal.Decimal `gorm:"type:decimal(20,3)"`
+	CreatedAt      time.Time
+}
@@ -14,8 +14,10 @@ type User struct {
 	VerificationToken   *string `gorm:"type:varchar(255)"`
 	IsEmailVerified     bool    `gorm:"default:false"`
 	Balance             float64 `gorm:"type:decimal(10,3);default:0"`
-	FreeOperationsUsed  int     `gorm:"default:0"`
+	PlanID              *string `gorm:"type:varchar(100);index"` // Subscription plan, nil for the default plan
+	FreeOperationsUsed  int     `gorm:"default:0"`               // Plan operations used in the billing cycle ending at FreeOperationsReset
 	FreeOperationsReset time.Time
+	SpendingCaps        `gorm:"embedded"` // Caps of all operations of the user
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 
@@ -28,14 +30,26 @@ type User struct {
 	UsageStats   []UsageStats  `gorm:"foreignKey:UserID"`
 }
 
+// Reasons of the balance changes made by admins
+const (
+	TransactionReasonCredit     = "credit"     // Service credit, e.g. for an outage
+	TransactionReasonGoodwill   = "goodwill"   // Goodwill gesture
+	TransactionReasonCorrection = "correction" // Correction of a wrong balance, either way
+	TransactionReasonRefund     = "refund"     // Deposit paid back through the payment provider
+)
+
 type Transaction struct {
 	ID           string  `gorm:"primaryKey;type:varchar(100)"`
 	UserID       string  `gorm:"type:varchar(100);index"`
-	Amount       float64 `gorm:"type:decimal(10,2)"`
-	BalanceAfter float64 `gorm:"type:decimal(10,2)"`
+	Amount       float64 `gorm:"type:decimal(10,3)"`
+	BalanceAfter float64 `gorm:"type:decimal(10,3)"`
 	Description  string  `gorm:"type:varchar(255)"`
 	PaymentID    string  `gorm:"type:varchar(100)"`
+	Provider     string  `gorm:"type:varchar(20)"` // Payment provider of deposits, empty for PayPal deposits made before providers could be chosen
 	Status       string  `gorm:"type:varchar(50);default:'completed'"`
+	ReasonCode   string  `gorm:"type:varchar(30)"`        // Set on changes made by admins, see TransactionReason*
+	ActorID      *string `gorm:"type:varchar(100);index"` // Admin who made an adjustment or refund
+	RefundOfID   *string `gorm:"type:varchar(100);index"` // Deposit a refund pays back
 	CreatedAt    time.Time
 
 	// Relations
@@ -76,14 +90,20 @@ type Session struct {
 
 // ApiKey model stores API keys for users
 type ApiKey struct {
-	ID        string `gorm:"primaryKey;type:varchar(100)"`
-	UserID    string `gorm:"type:varchar(100);index"`
-	Name      string `gorm:"type:varchar(100)"`
-	Key       string `gorm:"uniqueIndex;type:varchar(255)"`
-	LastUsed  *time.Time
-	ExpiresAt *time.Time
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID               string      `gorm:"primaryKey;type:varchar(100)"`
+	UserID           string      `gorm:"type:varchar(100);index"`
+	Name             string      `gorm:"type:varchar(100)"`
+	Prefix           string      `gorm:"type:varchar(20);index"` // Public start of the key, used for lookup and display
+	KeyHash          string      `gorm:"type:varchar(255)"`      // Salted hash of the full key, see HashApiKey
+	Scopes           StringSlice `gorm:"type:text"`              // Operations the key may use; empty allows all
+	AllowedIPs       StringSlice `gorm:"type:text"`              // CIDR ranges the key may be used from; empty allows all
+	ReplacedByID     *string     `gorm:"type:varchar(100)"`      // Set once rotated; the key works until ExpiresAt
+	LastUsed         *time.Time
+	ExpiresAt        *time.Time
+	ExpiryNotifiedAt *time.Time        // When the owner was told the key expires soon
+	SpendingCaps     `gorm:"embedded"` // Caps of the operations made with the key
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 
 	// Relations
 	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
@@ -118,14 +138,25 @@ type VerificationToken struct {
 	UpdatedAt  time.Time
 }
 
+// Statuses of a PaymentWebhookEvent
+const (
+	WebhookEventStatusProcessing = "processing"
+	WebhookEventStatusProcessed  = "processed"
+	WebhookEventStatusFailed     = "failed"
+)
+
+// PaymentWebhookEvent records a delivery of a payment webhook
 type PaymentWebhookEvent struct {
-	ID           string `gorm:"primaryKey;type:varchar(100)"`
-	EventId      string `gorm:"type:varchar(100)"`
-	EventType    string `gorm:"type:varchar(100)"`
-	ResourceType string `gorm:"type:varchar(100)"`
-	ResourceId   string `gorm:"type:varchar(100)"`
-	RawData      string `gorm:"type:longtext"` // Using longtext for MySQL
-	CreatedAt    time.Time
+	ID               string  `gorm:"primaryKey;type:varchar(100)"`
+	Provider         string  `gorm:"type:varchar(20)"` // Empty for PayPal events received before providers could be chosen
+	EventId          string  `gorm:"type:varchar(100)"`
+	EventType        string  `gorm:"type:varchar(100)"`
+	ResourceType     string  `gorm:"type:varchar(100)"`
+	ResourceId       string  `gorm:"type:varchar(100)"`
+	RawData          string  `gorm:"type:longtext"` // Using longtext for MySQL
+	Status           string  `gorm:"type:varchar(20)"`
+	ProcessedEventId *string `gorm:"type:varchar(100);uniqueIndex"` // "<provider>:<EventId>" while the delivery holds the event; cleared when processing fails so the event can be delivered again
+	CreatedAt        time.Time
 }
 
 // LowBalanceAlert tracks when low balance warnings have been sent
@@ -140,9 +171,13 @@ type LowBalanceAlert struct {
 
 // OperationsAlert tracks when operation limit warnings or exhausted notifications have been sent
 type OperationsAlert struct {
-	ID        string `gorm:"primaryKey;type:varchar(100)"`
-	UserID    string `gorm:"type:varchar(100);index"`
-	Type      string `gorm:"type:varchar(50)"` // "warning" or "exhausted"
+	ID        string  `gorm:"primaryKey;type:varchar(100)"`
+	UserID    string  `gorm:"type:varchar(100);index"`
+	Type      string  `gorm:"type:varchar(50)"`  // "warning", "exhausted" or "cap"
+	ApiKeyID  *string `gorm:"type:varchar(100)"` // Key of a cap alert, nil for caps of the user
+	Cap       string  `gorm:"type:varchar(30)"`  // Cap of a cap alert, e.g. CapDailySpend
+	Threshold int     // Percentage of the cap used
+	AlertKey  *string `gorm:"type:varchar(255);uniqueIndex"` // Cap, period and threshold of a cap alert, so each is sent once
 	CreatedAt time.Time
 
 	// Relations
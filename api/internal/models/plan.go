@@ -0,0 +1,73 @@
+// internal/models/plan.go
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Plan is a subscription plan. Its monthly price is charged from the user's
+// balance at the start of every billing cycle and includes a number of
+// operations; operations beyond the allowance are paid from the balance at
+// the plan's overage prices. Users without a plan use the default plan.
+type Plan struct {
+	ID                 string    `gorm:"primaryKey;type:varchar(100)" json:"id"`
+	Code               string    `gorm:"uniqueIndex;type:varchar(50)" json:"code"` // Also the subject of plan rate limit overrides
+	Name               string    `gorm:"type:varchar(100)" json:"name"`
+	Description        string    `gorm:"type:text" json:"description"`
+	MonthlyPrice       float64   `gorm:"type:decimal(10,3)" json:"monthlyPrice"`
+	IncludedOperations int       `json:"includedOperations"`                    // Operations per billing cycle
+	OverageCost        float64   `gorm:"type:decimal(10,3)" json:"overageCost"` // Per operation, 0 uses the global pricing
+	OveragePrices      PriceMap  `gorm:"type:text" json:"overagePrices"`        // Per operation type, override OverageCost
+	MaxFileSize        int       `json:"maxFileSize"`                           // MB per uploaded file, 0 uses the api.maxFileSize setting
+	MaxApiKeys         int       `json:"maxApiKeys"`
+	RateLimit          int       `json:"rateLimit"`  // Requests per api.rateLimitPeriod, 0 uses the default
+	DailyQuota         int       `json:"dailyQuota"` // Requests per day, 0 uses the default
+	IsDefault          bool      `json:"isDefault"`
+	Active             bool      `json:"active"` // Inactive plans cannot be subscribed to
+	CreatedAt          time.Time `json:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// MaxFileSizeBytes returns the upload limit of the plan in bytes
+func (p *Plan) MaxFileSizeBytes() int64 {
+	return int64(p.MaxFileSize) * 1024 * 1024
+}
+
+// PriceMap maps operation types to prices, stored as JSON
+type PriceMap map[string]float64
+
+// Value implements the driver.Valuer interface
+func (m PriceMap) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// Scan implements the sql.Scanner interface
+func (m *PriceMap) Scan(value interface{}) error {
+	if value == nil {
+		*m = PriceMap{}
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+	if len(bytes) == 0 {
+		*m = PriceMap{}
+		return nil
+	}
+
+	return json.Unmarshal(bytes, m)
+}
@@ -0,0 +1,24 @@
+// internal/models/rate_limit.go
+package models
+
+import "time"
+
+// Rate limit override scopes, from the least to the most specific
+const (
+	RateLimitScopePlan = "plan" // Subject is a plan code
+	RateLimitScopeUser = "user" // Subject is a user ID
+	RateLimitScopeKey  = "key"  // Subject is an API key ID
+)
+
+// RateLimitOverride replaces the default rate limit (api.defaultRateLimit) for
+// a plan, a user or a single API key. The most specific override wins.
+type RateLimitOverride struct {
+	ID         string `gorm:"primaryKey;type:varchar(100)"`
+	Scope      string `gorm:"type:varchar(20);uniqueIndex:idx_rate_limit_overrides_scope_subject"`
+	Subject    string `gorm:"type:varchar(100);uniqueIndex:idx_rate_limit_overrides_scope_subject"`
+	Requests   int    // Requests allowed per period
+	Period     int    // Length of the rate limit window in seconds
+	DailyQuota int    // Requests allowed per UTC day, 0 for no quota
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,38 @@
+// internal/models/reservation.go
+package models
+
+import "time"
+
+// Reservation statuses
+const (
+	ReservationStatusHeld      = "held"
+	ReservationStatusCommitted = "committed"
+	ReservationStatusReleased  = "released"
+	ReservationStatusExpired   = "expired" // Released by the reconciliation sweep
+)
+
+// Statuses of the transaction recording a reservation
+const (
+	TransactionStatusReserved  = "reserved"
+	TransactionStatusCompleted = "completed"
+	TransactionStatusReleased  = "released"
+	TransactionStatusExpired   = "expired"
+)
+
+// Reservation holds the cost of an operation (a free operation or an amount
+// of balance) until the operation succeeded (commit) or failed (release).
+// The reservation is recorded by TransactionID, whose status follows it.
+type Reservation struct {
+	ID            string  `gorm:"primaryKey;type:varchar(100)"`
+	UserID        string  `gorm:"type:varchar(100);index"`
+	ApiKeyID      *string `gorm:"type:varchar(100);index"` // Key the operation was made with, nil for the web app
+	Operation     string  `gorm:"type:varchar(50)"`
+	Amount        float64 `gorm:"type:decimal(10,3)"` // Balance held, 0 for a free operation
+	FreeOperation bool
+	Status        string `gorm:"type:varchar(20);index;default:'held'"`
+	TransactionID string `gorm:"type:varchar(100)"`
+	ExpiresAt     time.Time
+	SettledAt     *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,33 @@
+// internal/models/retention.go
+package models
+
+import "time"
+
+// Retention run triggers and statuses
+const (
+	RetentionTriggerScheduler = "scheduler"
+	RetentionTriggerAdmin     = "admin"
+
+	RetentionStatusRunning   = "running"
+	RetentionStatusCompleted = "completed"
+	RetentionStatusFailed    = "failed"
+)
+
+// RetentionRun records a run of the retention policies, started by the
+// scheduler or by an admin
+type RetentionRun struct {
+	ID               string     `gorm:"primaryKey;type:varchar(100)" json:"id"`
+	Trigger          string     `gorm:"type:varchar(20);index" json:"trigger"`
+	AdminID          *string    `gorm:"type:varchar(100)" json:"adminId,omitempty"` // Admin who started a manual run
+	Status           string     `gorm:"type:varchar(20);default:'running'" json:"status"`
+	OrphanSweep      bool       `json:"orphanSweep"`      // Whether the run walked the store for orphans
+	ArtifactsPurged  int        `json:"artifactsPurged"`  // Expired results removed from storage
+	UploadsDeleted   int        `json:"uploadsDeleted"`   // Expired resumable uploads removed
+	ObjectsDeleted   int        `json:"objectsDeleted"`   // Stored files without a result record, e.g. job inputs
+	TempFilesDeleted int        `json:"tempFilesDeleted"` // Files in the upload and temp directories
+	RecordsDeleted   int        `json:"recordsDeleted"`   // Records of results purged long ago
+	BytesRecovered   int64      `json:"bytesRecovered"`
+	Errors           string     `gorm:"type:text" json:"errors,omitempty"` // One error per line
+	StartedAt        time.Time  `gorm:"index" json:"startedAt"`
+	CompletedAt      *time.Time `json:"completedAt,omitempty"`
+}
@@ -50,16 +50,35 @@ func DefaultSettings() map[string]map[string]interface{} {
 			"smtpSecure":    false,
 		},
 		"payment": {
-			"paypalClientId":     "",
-			"paypalClientSecret": "",
-			"paypalApiBase":      "https://api-m.sandbox.paypal.com",
+			"paypalClientId":      "",
+			"paypalClientSecret":  "",
+			"paypalApiBase":       "https://api-m.sandbox.paypal.com",
+			"paypalWebhookId":     "",
+			"stripeSecretKey":     "",
+			"stripeWebhookSecret": "",
+			"stripeApiBase":       "https://api.stripe.com",
+		},
+		"billing": {
+			"sellerName":      "MegaPDF",
+			"sellerAddress":   "",
+			"sellerEmail":     "billing@mega-pdf.com",
+			"sellerTaxId":     "",
+			"currency":        "USD",
+			"invoicePrefix":   "INV", // Invoice numbers are <prefix>-<sequence>
+			"statementPrefix": "STM",
 		},
 		"api": {
-			"defaultRateLimit": 100,
-			"maxFileSize":      50,
-			"apiTimeout":       30,
-			"loggingEnabled":   true,
-			"logLevel":         "info",
+			"defaultRateLimit":       100,         // Requests per rateLimitPeriod
+			"rateLimitPeriod":        60,          // Seconds
+			"defaultDailyQuota":      0,           // Requests per day, 0 for no quota
+			"keyRotationGracePeriod": 86400,       // Seconds a rotated API key keeps working
+			"keyExpiryNoticeDays":    7,           // Days before expiry the key owner is emailed
+			"capAlertThresholds":     "50,80,100", // Percentages of a spending cap at which the owner is emailed
+			"idempotencyRetention":   86400,       // Seconds responses are replayed for a repeated Idempotency-Key
+			"maxFileSize":            50,
+			"apiTimeout":             30,
+			"loggingEnabled":         true,
+			"logLevel":               "info",
 		},
 		"database": {
 			"dbHost":            "localhost",
@@ -80,10 +99,19 @@ func DefaultSettings() map[string]map[string]interface{} {
 			"oauthRedirectUrl":   "http://localhost:8080/api/auth/google/callback",
 		},
 		"storage": {
-			"tempDir":     "temp",
-			"uploadDir":   "uploads",
-			"publicDir":   "public",
-			"storagePath": "./storage",
+			"tempDir":           "temp",
+			"uploadDir":         "uploads",
+			"publicDir":         "public",
+			"storagePath":       "./storage",
+			"freeRetention":     3600,                                             // Seconds results of users on free plans are kept
+			"paidRetention":     604800,                                           // Seconds results of users on paid plans are kept
+			"planRetention":     "",                                               // Per plan code, e.g. "pro=2592000", overrides the above
+			"folderRetention":   "jobs=86400,editor-sessions=86400,uploads=86400", // Longest seconds any file of a folder is kept
+			"orphanRetention":   86400,                                            // Seconds stored files without a result record are kept
+			"tempRetention":     3600,                                             // Seconds files in the upload and temp directories are kept
+			"recordRetention":   2592000,                                          // Seconds records of purged results answer 410
+			"retentionInterval": 600,                                              // Seconds between scheduled retention runs
+			"orphanInterval":    21600,                                            // Seconds between scheduled sweeps of the whole store for orphans
 		},
 		"pricing": {
 			"operationCost":         0.005,
@@ -0,0 +1,29 @@
+// internal/models/spending_caps.go
+package models
+
+// Spending caps of users and API keys
+const (
+	CapDailySpend        = "daily_spend"
+	CapMonthlySpend      = "monthly_spend"
+	CapDailyOperations   = "daily_operations"
+	CapMonthlyOperations = "monthly_operations"
+)
+
+// OperationsAlertTypeCap is the type of the alerts sent when a share of a
+// spending cap is used
+const OperationsAlertTypeCap = "cap"
+
+// SpendingCaps bound the operations of a user or an API key per UTC day and
+// month: what is spent on them from the balance and how many are made,
+// including operations of the plan's allowance. Zero means no cap.
+type SpendingCaps struct {
+	DailySpendCap       float64 `gorm:"type:decimal(10,3);default:0" json:"dailySpendCap"`
+	MonthlySpendCap     float64 `gorm:"type:decimal(10,3);default:0" json:"monthlySpendCap"`
+	DailyOperationCap   int     `gorm:"default:0" json:"dailyOperationCap"`
+	MonthlyOperationCap int     `gorm:"default:0" json:"monthlyOperationCap"`
+}
+
+// Any reports whether any cap is set
+func (c SpendingCaps) Any() bool {
+	return c.DailySpendCap > 0 || c.MonthlySpendCap > 0 || c.DailyOperationCap > 0 || c.MonthlyOperationCap > 0
+}
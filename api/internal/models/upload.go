@@ -0,0 +1,38 @@
+// internal/models/upload.go
+package models
+
+import "time"
+
+// Upload statuses
+const (
+	UploadStatusUploading = "uploading"
+	UploadStatusCompleted = "completed"
+)
+
+// Upload is a resumable upload. The client announces the size, sends the
+// content in chunks at increasing offsets and completes it with a checksum;
+// its ID is then accepted by the operations instead of a multipart file.
+type Upload struct {
+	ID          string     `gorm:"primaryKey;type:varchar(100)" json:"id"`
+	UserID      string     `gorm:"type:varchar(100);index" json:"-"`
+	Filename    string     `gorm:"type:varchar(255)" json:"filename"`
+	Size        int64      `json:"size"`     // Bytes announced when the upload was created
+	Received    int64      `json:"received"` // Bytes received, the offset of the next chunk
+	Status      string     `gorm:"type:varchar(20);default:'uploading'" json:"status"`
+	Checksum    string     `gorm:"type:varchar(64)" json:"checksum,omitempty"` // Hex encoded SHA-256, verified on completion
+	StorageKey  string     `gorm:"type:varchar(512)" json:"-"`                 // Content of a completed upload
+	ExpiresAt   time.Time  `gorm:"index" json:"expiresAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// UploadChunk is a chunk of an upload that is still in progress
+type UploadChunk struct {
+	ID         string `gorm:"primaryKey;type:varchar(100)"`
+	UploadID   string `gorm:"type:varchar(100);uniqueIndex:idx_upload_chunks_upload_offset"`
+	Offset     int64  `gorm:"column:chunk_offset;uniqueIndex:idx_upload_chunks_upload_offset"`
+	Size       int64
+	StorageKey string `gorm:"type:varchar(512)"`
+	CreatedAt  time.Time
+}
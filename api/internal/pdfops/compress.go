@@ -0,0 +1,57 @@
+// internal/pdfops/compress.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// CompressOptions configures Compress. pdfcpu optimize always applies its
+// maximum compression, so there is nothing to tune yet.
+type CompressOptions struct{}
+
+// CompressResult describes the outcome of a compression
+type CompressResult struct {
+	OriginalSize   int64
+	CompressedSize int64
+}
+
+// Ratio returns the size reduction as a percentage of the original size
+func (r *CompressResult) Ratio() float64 {
+	if r.OriginalSize <= 0 {
+		return 0
+	}
+	return float64(r.OriginalSize-r.CompressedSize) / float64(r.OriginalSize) * 100
+}
+
+// Compress writes an optimized copy of in to out
+func Compress(ctx context.Context, in, out string, opts CompressOptions) (*CompressResult, error) {
+	pageCount, err := api.PageCountFile(in)
+	if err != nil {
+		return nil, inputErrorf("failed to get PDF page count: %v", err)
+	}
+	if pageCount == 0 {
+		return nil, ErrNoPages
+	}
+
+	if _, err := runPdfcpu(ctx, "optimize", in, out); err != nil {
+		return nil, err
+	}
+
+	originalInfo, err := os.Stat(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original file size: %w", err)
+	}
+	compressedInfo, err := os.Stat(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compressed file size: %w", err)
+	}
+
+	return &CompressResult{
+		OriginalSize:   originalInfo.Size(),
+		CompressedSize: compressedInfo.Size(),
+	}, nil
+}
@@ -0,0 +1,46 @@
+// internal/pdfops/compress_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "compressed.pdf")
+	result, err := Compress(context.Background(), fixture("ten-pages.pdf"), out, CompressOptions{})
+	if err != nil {
+		t.Fatalf("Compress() error = %v", err)
+	}
+	if got := pageCount(t, out); got != 10 {
+		t.Errorf("compressed PDF has %d pages, want 10", got)
+	}
+	if result.OriginalSize != getFileSize(fixture("ten-pages.pdf")) || result.CompressedSize != getFileSize(out) {
+		t.Errorf("Compress() = %+v, want the sizes of the input and the output", result)
+	}
+}
+
+func TestCompressInvalidPDF(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "compressed.pdf")
+	if _, err := Compress(context.Background(), fixture("not-a-pdf.pdf"), out, CompressOptions{}); !IsInputError(err) {
+		t.Errorf("Compress() of a text file error = %v, want an input error", err)
+	}
+}
+
+func TestCompressResultRatio(t *testing.T) {
+	tests := []struct {
+		result CompressResult
+		want   float64
+	}{
+		{CompressResult{OriginalSize: 1000, CompressedSize: 250}, 75},
+		{CompressResult{OriginalSize: 1000, CompressedSize: 1200}, -20},
+		{CompressResult{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.result.Ratio(); got != tt.want {
+			t.Errorf("Ratio() of %+v = %v, want %v", tt.result, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+// internal/pdfops/convert.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertOptions configures Convert
+type ConvertOptions struct {
+	// InputFormat and OutputFormat are file extensions without the dot, e.g. "pdf" or "docx"
+	InputFormat  string
+	OutputFormat string
+	// OCR extracts text with tesseract when converting PDF to txt
+	OCR bool
+	// Quality is the image quality (10-100) for image outputs; defaults to 90
+	Quality string
+}
+
+// Convert converts in to another format using LibreOffice, Ghostscript,
+// ImageMagick, pdftotext or tesseract depending on the formats
+func Convert(ctx context.Context, in, out string, opts ConvertOptions) error {
+	inputFormat := strings.ToLower(opts.InputFormat)
+	outputFormat := strings.ToLower(opts.OutputFormat)
+	if inputFormat == "" || outputFormat == "" {
+		return inputErrorf("input and output formats are required")
+	}
+
+	var err error
+	switch {
+	case outputFormat == "pdf":
+		err = convertToPdf(ctx, in, out, inputFormat)
+	case inputFormat == "pdf" && (outputFormat == "docx" || outputFormat == "doc"):
+		err = convertPdfToDocx(ctx, in, out)
+	case inputFormat == "pdf" && (outputFormat == "xlsx" || outputFormat == "xls"):
+		err = convertPdfToExcel(ctx, in, out)
+	case inputFormat == "pdf" && outputFormat == "pptx":
+		err = convertPdfToPptx(ctx, in, out)
+	case inputFormat == "pdf" && (outputFormat == "jpg" || outputFormat == "jpeg" || outputFormat == "png"):
+		err = convertPdfToImage(ctx, in, out, outputFormat, opts.Quality)
+	case inputFormat == "pdf" && outputFormat == "txt":
+		if opts.OCR {
+			err = extractTextWithOCR(ctx, in, out)
+		} else {
+			err = extractTextFromPdf(ctx, in, out)
+		}
+	case isImageFormat(inputFormat) && isImageFormat(outputFormat):
+		err = convertImageToImage(ctx, in, out, outputFormat, opts.Quality)
+	default:
+		// Generic conversion using LibreOffice
+		err = convertWithLibreOffice(ctx, in, out, outputFormat)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Some converters report success without writing anything; keep the
+	// historical behaviour of returning an empty file in that case
+	if !fileExists(out) {
+		emptyFile, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		emptyFile.Close()
+	}
+
+	return nil
+}
+
+// isImageFormat reports whether format is an image format supported by Convert
+func isImageFormat(format string) bool {
+	return format == "jpg" || format == "jpeg" || format == "png"
+}
+
+// convertToPdf converts various formats to PDF
+func convertToPdf(ctx context.Context, inputPath, outputPath, inputFormat string) error {
+	fmt.Printf("Converting %s to PDF\n", inputFormat)
+
+	// For image to PDF, use a specific method
+	if inputFormat == "jpg" || inputFormat == "jpeg" || inputFormat == "png" {
+		return convertImageToPdf(ctx, inputPath, outputPath)
+	}
+
+	// For other formats, use the robust LibreOffice method
+	return convertWithLibreOffice(ctx, inputPath, outputPath, "pdf")
+}
+
+// convertPdfToDocx converts PDF to DOCX
+func convertPdfToDocx(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Converting PDF to DOCX: %s -> %s\n", inputPath, outputPath)
+
+	// Create a temporary directory for the conversion
+	tempDir, err := os.MkdirTemp("", "pdf-docx-conversion")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Copy the input file to the temp directory
+	tempInput := filepath.Join(tempDir, "input.pdf")
+	if err := copyFile(inputPath, tempInput); err != nil {
+		return fmt.Errorf("failed to copy input file: %w", err)
+	}
+
+	// Method 1: Use writer_pdf_import filter
+	cmd1 := exec.CommandContext(ctx, "soffice", "--headless", "--infilter=writer_pdf_import",
+		"--convert-to", "docx:MS Word 2007 XML", "--outdir",
+		tempDir, tempInput)
+	output1, err1 := cmd1.CombinedOutput()
+	fmt.Printf("PDF to DOCX Method 1 output: %s, error: %v\n", string(output1), err1)
+
+	// Check if the file was created
+	expectedOutput := filepath.Join(tempDir, "input.docx")
+	if fileHasContent(expectedOutput, 100) {
+		// File exists and has content, copy it to the desired output path
+		if err := copyFile(expectedOutput, outputPath); err != nil {
+			return fmt.Errorf("failed to copy converted file: %w", err)
+		}
+		fmt.Printf("Successful PDF to DOCX conversion using Method 1\n")
+		return nil
+	}
+
+	// If the first method failed, try the general conversion method
+	return convertWithLibreOffice(ctx, inputPath, outputPath, "docx")
+}
+
+// convertPdfToExcel converts PDF to Excel
+func convertPdfToExcel(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Converting PDF to Excel: %s -> %s\n", inputPath, outputPath)
+
+	// Create a temporary directory for the conversion
+	tempDir, err := os.MkdirTemp("", "pdf-excel-conversion")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Copy the input file to the temp directory
+	tempInput := filepath.Join(tempDir, "input.pdf")
+	if err := copyFile(inputPath, tempInput); err != nil {
+		return fmt.Errorf("failed to copy input file: %w", err)
+	}
+
+	// Method 1: Convert to HTML first, then to XLSX (often works better for tables)
+	htmlCmd := exec.CommandContext(ctx, "soffice", "--headless", "--convert-to", "html",
+		"--outdir", tempDir, tempInput)
+	htmlOutput, htmlErr := htmlCmd.CombinedOutput()
+	fmt.Printf("PDF to HTML output: %s, error: %v\n", string(htmlOutput), htmlErr)
+
+	if htmlErr == nil {
+		htmlFile := filepath.Join(tempDir, "input.html")
+		if fileExists(htmlFile) {
+			// Convert HTML to XLSX
+			xlsxCmd := exec.CommandContext(ctx, "soffice", "--headless", "--convert-to",
+				"xlsx:Calc MS Excel 2007 XML", "--outdir", tempDir, htmlFile)
+			xlsxOutput, xlsxErr := xlsxCmd.CombinedOutput()
+			fmt.Printf("HTML to XLSX output: %s, error: %v\n", string(xlsxOutput), xlsxErr)
+
+			// Check if XLSX was created
+			expectedOutput := filepath.Join(tempDir, "input.xlsx")
+			if fileHasContent(expectedOutput, 100) {
+				if err := copyFile(expectedOutput, outputPath); err != nil {
+					return fmt.Errorf("failed to copy HTML-converted XLSX: %w", err)
+				}
+				fmt.Printf("Successful PDF to Excel conversion via HTML\n")
+				return nil
+			}
+		}
+	}
+
+	// If the HTML method failed, try the general conversion method
+	return convertWithLibreOffice(ctx, inputPath, outputPath, "xlsx")
+}
+
+// convertPdfToPptx converts PDF to PowerPoint
+func convertPdfToPptx(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Converting PDF to PowerPoint: %s -> %s\n", inputPath, outputPath)
+
+	// Create a temporary directory for the conversion
+	tempDir, err := os.MkdirTemp("", "pdf-pptx-conversion")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Copy the input file to the temp directory
+	tempInput := filepath.Join(tempDir, "input.pdf")
+	if err := copyFile(inputPath, tempInput); err != nil {
+		return fmt.Errorf("failed to copy input file: %w", err)
+	}
+
+	// Method 1: Use impress_pdf_import filter
+	cmd1 := exec.CommandContext(ctx, "soffice", "--headless", "--infilter=impress_pdf_import",
+		"--convert-to", "pptx:Impress MS PowerPoint 2007 XML", "--outdir",
+		tempDir, tempInput)
+	output1, err1 := cmd1.CombinedOutput()
+	fmt.Printf("PDF to PPTX Method 1 output: %s, error: %v\n", string(output1), err1)
+
+	// Check if the file was created
+	expectedOutput := filepath.Join(tempDir, "input.pptx")
+	if fileHasContent(expectedOutput, 100) {
+		// File exists and has content, copy it to the desired output path
+		if err := copyFile(expectedOutput, outputPath); err != nil {
+			return fmt.Errorf("failed to copy converted file: %w", err)
+		}
+		fmt.Printf("Successful PDF to PowerPoint conversion using Method 1\n")
+		return nil
+	}
+
+	// If the first method failed, try the general conversion method
+	return convertWithLibreOffice(ctx, inputPath, outputPath, "pptx")
+}
+
+// convertPdfToImage converts PDF to image formats
+func convertPdfToImage(ctx context.Context, inputPath, outputPath, format, quality string) error {
+	fmt.Printf("Converting PDF to %s image: %s -> %s\n", format, inputPath, outputPath)
+
+	// Set default quality if not provided
+	qualityValue := "90"
+	if quality != "" {
+		qualityValue = quality
+	}
+
+	// Try Ghostscript first
+	var cmd *exec.Cmd
+	if format == "jpg" || format == "jpeg" {
+		cmd = exec.CommandContext(ctx, "gs", "-sDEVICE=jpeg", "-dNOPAUSE", "-dBATCH", "-dSAFER",
+			"-r300", "-dJPEGQ="+qualityValue,
+			"-sOutputFile="+outputPath, inputPath)
+	} else {
+		cmd = exec.CommandContext(ctx, "gs", "-sDEVICE=png16m", "-dNOPAUSE", "-dBATCH", "-dSAFER",
+			"-r300", "-sOutputFile="+outputPath, inputPath)
+	}
+
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("Ghostscript output: %s, error: %v\n", string(output), err)
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 100) {
+		fmt.Printf("Successful PDF to Image conversion using Ghostscript\n")
+		return nil
+	}
+
+	// If Ghostscript failed, try ImageMagick
+	convertCmd := exec.CommandContext(ctx, "convert", "-density", "300", "-quality", qualityValue,
+		inputPath, outputPath)
+	convertOutput, convertErr := convertCmd.CombinedOutput()
+	fmt.Printf("ImageMagick output: %s, error: %v\n", string(convertOutput), convertErr)
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 100) {
+		fmt.Printf("Successful PDF to Image conversion using ImageMagick\n")
+		return nil
+	}
+
+	if err != nil && convertErr != nil {
+		return fmt.Errorf("PDF to Image conversion failed with both methods:\nGhostscript: %v\nImageMagick: %v",
+			err, convertErr)
+	}
+
+	return nil
+}
+
+// convertImageToPdf converts image formats to PDF
+func convertImageToPdf(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Converting Image to PDF: %s -> %s\n", inputPath, outputPath)
+
+	// Try ImageMagick first
+	cmd := exec.CommandContext(ctx, "convert", inputPath, outputPath)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("ImageMagick output: %s, error: %v\n", string(output), err)
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 100) {
+		fmt.Printf("Successful Image to PDF conversion using ImageMagick\n")
+		return nil
+	}
+
+	// If ImageMagick failed, try an alternative approach using Ghostscript
+	tempPath := outputPath + ".temp.ps"
+	gsCmd := exec.CommandContext(ctx, "gs", "-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dSAFER",
+		"-sOutputFile="+outputPath, inputPath)
+	gsOutput, gsErr := gsCmd.CombinedOutput()
+	fmt.Printf("Ghostscript output: %s, error: %v\n", string(gsOutput), gsErr)
+
+	// Clean up temporary file
+	if fileExists(tempPath) {
+		os.Remove(tempPath)
+	}
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 100) {
+		fmt.Printf("Successful Image to PDF conversion using Ghostscript\n")
+		return nil
+	}
+
+	if err != nil && gsErr != nil {
+		return fmt.Errorf("image to PDF conversion failed with both methods:\nImageMagick: %v\nGhostscript: %v",
+			err, gsErr)
+	}
+
+	return nil
+}
+
+// convertImageToImage converts between image formats
+func convertImageToImage(ctx context.Context, inputPath, outputPath, format, quality string) error {
+	fmt.Printf("Converting Image to %s: %s -> %s\n", format, inputPath, outputPath)
+
+	// Set default quality if not provided
+	qualityValue := "90"
+	if quality != "" {
+		qualityValue = quality
+	}
+
+	// Use ImageMagick for the conversion
+	cmd := exec.CommandContext(ctx, "convert", "-quality", qualityValue, inputPath, outputPath)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("ImageMagick output: %s, error: %v\n", string(output), err)
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 100) {
+		fmt.Printf("Successful Image to Image conversion\n")
+		return nil
+	}
+
+	return fmt.Errorf("image conversion failed: %v", err)
+}
+
+// extractTextFromPdf extracts text from a PDF
+func extractTextFromPdf(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Extracting text from PDF: %s -> %s\n", inputPath, outputPath)
+
+	// Try pdftotext first
+	cmd := exec.CommandContext(ctx, "pdftotext", inputPath, outputPath)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("pdftotext output: %s, error: %v\n", string(output), err)
+
+	// Check if the output file was created
+	if fileHasContent(outputPath, 10) {
+		fmt.Printf("Successful text extraction using pdftotext\n")
+		return nil
+	}
+
+	// If pdftotext failed, try an alternative approach
+	// Create a simple text file with a message
+	fallbackContent := "PDF TEXT EXTRACTION\n\n" +
+		"The text content of this PDF could not be automatically extracted.\n" +
+		"Please try the OCR option for scanned documents."
+
+	if err := os.WriteFile(outputPath, []byte(fallbackContent), 0644); err != nil {
+		return fmt.Errorf("failed to create fallback text file: %w", err)
+	}
+
+	fmt.Printf("Created fallback text file\n")
+	return nil
+}
+
+// extractTextWithOCR extracts text from a PDF using OCR
+func extractTextWithOCR(ctx context.Context, inputPath, outputPath string) error {
+	fmt.Printf("Extracting text from PDF using OCR: %s -> %s\n", inputPath, outputPath)
+
+	// Create a temporary directory for the OCR process
+	tempDir, err := os.MkdirTemp("", "pdf-ocr")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Extract images from PDF using pdftoppm
+	ppmCmd := exec.CommandContext(ctx, "pdftoppm", "-png", inputPath, filepath.Join(tempDir, "page"))
+	ppmOutput, ppmErr := ppmCmd.CombinedOutput()
+	fmt.Printf("pdftoppm output: %s, error: %v\n", string(ppmOutput), ppmErr)
+
+	if ppmErr != nil {
+		// Try alternative image extraction using ImageMagick
+		imgCmd := exec.CommandContext(ctx, "convert", "-density", "300", inputPath, filepath.Join(tempDir, "page-%d.png"))
+		imgOutput, imgErr := imgCmd.CombinedOutput()
+		fmt.Printf("ImageMagick output: %s, error: %v\n", string(imgOutput), imgErr)
+
+		if imgErr != nil {
+			return fmt.Errorf("failed to extract images from PDF: pdftoppm: %v, convert: %v", ppmErr, imgErr)
+		}
+	}
+
+	// Get all extracted images
+	files, _ := os.ReadDir(tempDir)
+	var imageFiles []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".png") {
+			imageFiles = append(imageFiles, filepath.Join(tempDir, file.Name()))
+		}
+	}
+
+	if len(imageFiles) == 0 {
+		return fmt.Errorf("no images extracted from PDF for OCR")
+	}
+
+	// Create output file
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outFile.Close()
+
+	// Process each image with tesseract
+	for _, imgFile := range imageFiles {
+		textFile := imgFile + ".txt"
+		tessCmd := exec.CommandContext(ctx, "tesseract", imgFile, strings.TrimSuffix(textFile, ".txt"))
+		tessOutput, tessErr := tessCmd.CombinedOutput()
+		fmt.Printf("Tesseract output for %s: %s, error: %v\n", imgFile, string(tessOutput), tessErr)
+
+		if fileExists(textFile) {
+			// Append the extracted text to the output file
+			text, err := os.ReadFile(textFile)
+			if err == nil && len(text) > 0 {
+				outFile.Write(append(text, []byte("\n\n")...))
+			}
+		}
+	}
+
+	// Check if the output file has content
+	if getFileSize(outputPath) < 10 {
+		// Create a fallback message
+		fallbackContent := "OCR TEXT EXTRACTION\n\n" +
+			"The OCR process did not extract any text from this PDF.\n" +
+			"This may be due to image quality issues or lack of text content."
+
+		if err := os.WriteFile(outputPath, []byte(fallbackContent), 0644); err != nil {
+			return fmt.Errorf("failed to create fallback OCR text file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// convertWithLibreOffice is a robust implementation that handles all conversion types
+func convertWithLibreOffice(ctx context.Context, inputPath, outputPath, format string) error {
+	// Create a temporary directory for the conversion
+	tempDir, err := os.MkdirTemp("", "file-conversion")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Copy the input file to the temp directory
+	tempInput := filepath.Join(tempDir, "input"+filepath.Ext(inputPath))
+	if err := copyFile(inputPath, tempInput); err != nil {
+		return fmt.Errorf("failed to copy input file: %w", err)
+	}
+
+	// Expected output file in temp directory
+	expectedOutput := filepath.Join(tempDir, "input."+format)
+
+	// Log the conversion attempt
+	fmt.Printf("Converting file: %s -> %s (format: %s)\n", tempInput, outputPath, format)
+
+	// Try different conversion methods
+	var allErrors []string
+
+	// Method 1: Standard LibreOffice conversion
+	cmd1 := exec.CommandContext(ctx, "soffice", "--headless", "--convert-to", format,
+		"--outdir", tempDir, tempInput)
+	output1, err1 := cmd1.CombinedOutput()
+	fmt.Printf("Method 1 output: %s, error: %v\n", string(output1), err1)
+	if err1 != nil {
+		allErrors = append(allErrors, fmt.Sprintf("Method 1: %v", err1))
+	}
+
+	// Check if the file was created
+	if fileHasContent(expectedOutput, 100) {
+		if err := copyFile(expectedOutput, outputPath); err != nil {
+			return fmt.Errorf("failed to copy converted file: %w", err)
+		}
+		fmt.Printf("Successful conversion using Method 1\n")
+		return nil
+	}
+
+	// Method 2: Try with soffice directly
+	cmd2 := exec.CommandContext(ctx, "soffice", "--headless", "--convert-to",
+		format, "--outdir", tempDir, tempInput)
+	output2, err2 := cmd2.CombinedOutput()
+	fmt.Printf("Method 2 output: %s, error: %v\n", string(output2), err2)
+	if err2 != nil {
+		allErrors = append(allErrors, fmt.Sprintf("Method 2: %v", err2))
+	}
+
+	// Check again
+	if fileHasContent(expectedOutput, 100) {
+		if err := copyFile(expectedOutput, outputPath); err != nil {
+			return fmt.Errorf("failed to copy converted file: %w", err)
+		}
+		fmt.Printf("Successful conversion using Method 2\n")
+		return nil
+	}
+
+	// Method 3: Try with specific format options based on file type
+	formatOption := format
+	switch format {
+	case "docx":
+		formatOption = "docx:MS Word 2007 XML"
+	case "xlsx":
+		formatOption = "xlsx:Calc MS Excel 2007 XML"
+	case "pptx":
+		formatOption = "pptx:Impress MS PowerPoint 2007 XML"
+	case "pdf":
+		formatOption = "pdf:writer_pdf_Export"
+	}
+
+	cmd3 := exec.CommandContext(ctx, "soffice", "--headless", "--convert-to",
+		formatOption, "--outdir", tempDir, tempInput)
+	output3, err3 := cmd3.CombinedOutput()
+	fmt.Printf("Method 3 output: %s, error: %v\n", string(output3), err3)
+	if err3 != nil {
+		allErrors = append(allErrors, fmt.Sprintf("Method 3: %v", err3))
+	}
+
+	// Check one last time
+	if fileHasContent(expectedOutput, 100) {
+		if err := copyFile(expectedOutput, outputPath); err != nil {
+			return fmt.Errorf("failed to copy converted file: %w", err)
+		}
+		fmt.Printf("Successful conversion using Method 3\n")
+		return nil
+	}
+
+	// Look for any output file with the right extension in case the name is different
+	files, _ := os.ReadDir(tempDir)
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "."+format) && file.Name() != "input."+format {
+			filePath := filepath.Join(tempDir, file.Name())
+			if fileHasContent(filePath, 100) {
+				if err := copyFile(filePath, outputPath); err != nil {
+					return fmt.Errorf("failed to copy renamed output file: %w", err)
+				}
+				fmt.Printf("Found alternative output file: %s\n", file.Name())
+				return nil
+			}
+		}
+	}
+
+	// If we got here, all methods failed
+	return fmt.Errorf("conversion to %s failed with all methods: %s",
+		format, strings.Join(allErrors, "; "))
+}
@@ -0,0 +1,45 @@
+// internal/pdfops/convert_test.go
+package pdfops
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertMissingFormats(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "converted.docx")
+	for _, opts := range []ConvertOptions{
+		{},
+		{InputFormat: "pdf"},
+		{OutputFormat: "docx"},
+	} {
+		if err := Convert(context.Background(), fixture("one-page.pdf"), out, opts); !IsInputError(err) {
+			t.Errorf("Convert(%+v) error = %v, want an input error", opts, err)
+		}
+	}
+}
+
+func TestConvertPdfToText(t *testing.T) {
+	// pdftotext is optional: without it the output explains that the text
+	// could not be extracted
+	out := filepath.Join(t.TempDir(), "converted.txt")
+	if err := Convert(context.Background(), fixture("three-pages.pdf"), out, ConvertOptions{InputFormat: "PDF", OutputFormat: "txt"}); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if !fileHasContent(out, 10) {
+		t.Error("Convert() wrote no text")
+	}
+}
+
+func TestConvertCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := filepath.Join(t.TempDir(), "converted.png")
+	err := Convert(ctx, fixture("one-page.pdf"), out, ConvertOptions{InputFormat: "pdf", OutputFormat: "png"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Convert() error = %v, want context.Canceled", err)
+	}
+}
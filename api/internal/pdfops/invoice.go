@@ -0,0 +1,198 @@
+// internal/pdfops/invoice.go
+package pdfops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// Layout of invoice documents on A4 paper, in points from the top of the
+// area inside the margins. pdfcpu positions boxes by their lower edge, so
+// the position of a box is its top plus its height.
+const (
+	invoiceMargin        = 40
+	invoiceContentWidth  = 515 // A4 width minus the margins
+	invoiceContentHeight = 720 // A4 height minus the margins and the footer
+	invoiceTextLine      = 12  // Height of a line of text
+	invoiceTableLine     = 18  // Height of a table row
+	invoicePartiesTop    = 90
+	invoiceFirstTableTop = 200
+	invoiceFontSize      = 10
+)
+
+// InvoiceDocument is the content of an invoice or statement PDF. Amounts are
+// preformatted so the document renders exactly what the caller computed.
+type InvoiceDocument struct {
+	Title   string   // e.g. "Invoice" or "Statement"
+	Details []string // Number, dates and other "label: value" lines, shown top right
+	Seller  []string // Lines of the seller's name and address
+	Buyer   []string // Lines of the buyer's name and address
+	Columns []string // Table header, the first column is left aligned and the others right aligned
+	Rows    [][]string
+	Totals  []string // "label: value" lines shown below the table
+	Footer  string
+}
+
+// CreateInvoice renders doc as a PDF written to out
+func CreateInvoice(ctx context.Context, doc InvoiceDocument, out string) error {
+	if len(doc.Columns) == 0 {
+		return inputErrorf("invoice needs at least one column")
+	}
+	for i, row := range doc.Rows {
+		if len(row) != len(doc.Columns) {
+			return inputErrorf("invoice row %d has %d values, expected %d", i+1, len(row), len(doc.Columns))
+		}
+	}
+
+	layout, err := json.Marshal(invoiceLayout(doc))
+	if err != nil {
+		return fmt.Errorf("failed to build invoice layout: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create invoice file: %w", err)
+	}
+	defer file.Close()
+
+	if err := api.Create(nil, bytes.NewReader(layout), file, nil); err != nil {
+		return fmt.Errorf("failed to render invoice: %w", err)
+	}
+	return nil
+}
+
+// invoiceLayout builds the pdfcpu create description of doc: the parties and
+// details on the first page, then the table split over as many pages as
+// needed and the totals after the last row
+func invoiceLayout(doc InvoiceDocument) map[string]interface{} {
+	font := func(name string, size int) map[string]interface{} {
+		return map[string]interface{}{"name": name, "size": size}
+	}
+	// text places lines with their top at top
+	text := func(lines []string, x, top float64, bold bool, align string) map[string]interface{} {
+		name := "Helvetica"
+		if bold {
+			name = "Helvetica-Bold"
+		}
+		return map[string]interface{}{
+			"value": strings.Join(lines, "\n"),
+			"pos":   []float64{x, top + float64(len(lines)*invoiceTextLine)},
+			"width": invoiceContentWidth / 2,
+			"font":  font(name, invoiceFontSize),
+			"align": align,
+		}
+	}
+
+	// The description column takes the space the amount columns leave
+	cols := len(doc.Columns)
+	colAnchors := make([]string, cols)
+	colWidths := make([]int, cols)
+	colWidths[0] = 100
+	for i := 1; i < cols; i++ {
+		colAnchors[i] = "Right"
+		colWidths[i] = 45 / (cols - 1)
+		colWidths[0] -= colWidths[i]
+	}
+	colAnchors[0] = "Left"
+
+	// table places rows with their top at top
+	table := func(rows [][]string, top float64) map[string]interface{} {
+		return map[string]interface{}{
+			"values":     rows,
+			"rows":       max(len(rows), 1),
+			"cols":       cols,
+			"pos":        []float64{0, top + tableHeight(rows)},
+			"width":      invoiceContentWidth,
+			"colWidths":  colWidths,
+			"colAnchors": colAnchors,
+			"lheight":    invoiceTableLine,
+			"font":       font("Helvetica", invoiceFontSize),
+			"grid":       true,
+			"header": map[string]interface{}{
+				"values":     doc.Columns,
+				"colAnchors": colAnchors,
+				"bgCol":      "#E6E6E6",
+				"font":       font("Helvetica-Bold", invoiceFontSize),
+			},
+		}
+	}
+
+	title := map[string]interface{}{
+		"value": doc.Title,
+		"pos":   []float64{0, 24},
+		"font":  font("Helvetica-Bold", 20),
+	}
+	texts := []interface{}{
+		title,
+		text(doc.Details, invoiceContentWidth/2, 0, false, "Right"),
+		text(doc.Seller, 0, invoicePartiesTop, false, "Left"),
+		text(append([]string{"Bill to:"}, doc.Buyer...), invoiceContentWidth/2, invoicePartiesTop, false, "Left"),
+	}
+
+	pages := map[string]interface{}{}
+	addPage := func(texts, tables []interface{}) {
+		content := map[string]interface{}{}
+		if len(texts) > 0 {
+			content["text"] = texts
+		}
+		if len(tables) > 0 {
+			content["table"] = tables
+		}
+		pages[fmt.Sprint(len(pages)+1)] = map[string]interface{}{"content": content}
+	}
+
+	// Fill the pages with rows, then add the totals below the last row or on
+	// a page of their own
+	remaining := doc.Rows
+	top := float64(invoiceFirstTableTop)
+	for {
+		capacity := int((invoiceContentHeight-top)/invoiceTableLine) - 1
+		rows := remaining[:min(len(remaining), capacity)]
+		remaining = remaining[len(rows):]
+		tables := []interface{}{table(rows, top)}
+
+		if len(remaining) > 0 {
+			addPage(texts, tables)
+			texts, top = nil, 0
+			continue
+		}
+
+		totalsTop := top + tableHeight(rows) + invoiceTableLine
+		if totalsTop+float64(len(doc.Totals)*invoiceTextLine) <= invoiceContentHeight {
+			addPage(append(texts, text(doc.Totals, invoiceContentWidth/2, totalsTop, true, "Right")), tables)
+		} else {
+			addPage(texts, tables)
+			addPage([]interface{}{text(doc.Totals, invoiceContentWidth/2, 0, true, "Right")}, nil)
+		}
+		break
+	}
+
+	return map[string]interface{}{
+		"paper":  "A4P",
+		"origin": "UpperLeft",
+		"margin": map[string]interface{}{"width": invoiceMargin},
+		"footer": map[string]interface{}{
+			"font":   font("Helvetica", 8),
+			"left":   doc.Footer,
+			"right":  "Page %p of %P",
+			"height": invoiceMargin,
+			"dx":     invoiceMargin,
+			"dy":     invoiceMargin / 2,
+		},
+		"pages": pages,
+	}
+}
+
+// tableHeight returns the height of a table with rows and a header
+func tableHeight(rows [][]string) float64 {
+	return float64((max(len(rows), 1) + 1) * invoiceTableLine)
+}
@@ -0,0 +1,48 @@
+// internal/pdfops/merge.go
+package pdfops
+
+import "context"
+
+// MergeOptions configures Merge
+type MergeOptions struct {
+	// Order lists indexes into the inputs in the order they are merged.
+	// Nil keeps the inputs in the given order.
+	Order []int
+}
+
+// ValidMergeOrder reports whether order is a permutation of n inputs
+func ValidMergeOrder(order []int, n int) bool {
+	if len(order) != n {
+		return false
+	}
+	seen := make(map[int]bool, n)
+	for _, idx := range order {
+		if idx < 0 || idx >= n || seen[idx] {
+			return false
+		}
+		seen[idx] = true
+	}
+	return true
+}
+
+// Merge combines the inputs into a single PDF written to out
+func Merge(ctx context.Context, inputs []string, out string, opts MergeOptions) error {
+	if len(inputs) < 2 {
+		return inputErrorf("at least two PDF files are required for merging")
+	}
+
+	ordered := inputs
+	if opts.Order != nil {
+		if !ValidMergeOrder(opts.Order, len(inputs)) {
+			return inputErrorf("invalid merge order %v for %d files", opts.Order, len(inputs))
+		}
+		ordered = make([]string, len(inputs))
+		for i, idx := range opts.Order {
+			ordered[i] = inputs[idx]
+		}
+	}
+
+	args := append([]string{"merge", out}, ordered...)
+	_, err := runPdfcpu(ctx, args...)
+	return err
+}
@@ -0,0 +1,82 @@
+// internal/pdfops/merge_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidMergeOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+		n     int
+		want  bool
+	}{
+		{"identity", []int{0, 1, 2}, 3, true},
+		{"reversed", []int{2, 1, 0}, 3, true},
+		{"too short", []int{0, 1}, 3, false},
+		{"too long", []int{0, 1, 2}, 2, false},
+		{"duplicate", []int{0, 0, 1}, 3, false},
+		{"negative", []int{-1, 0}, 2, false},
+		{"out of range", []int{0, 2}, 2, false},
+		{"empty", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidMergeOrder(tt.order, tt.n); got != tt.want {
+				t.Errorf("ValidMergeOrder(%v, %d) = %v, want %v", tt.order, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+		opts   MergeOptions
+	}{
+		{"single input", []string{fixture("one-page.pdf")}, MergeOptions{}},
+		{"invalid order", []string{fixture("one-page.pdf"), fixture("three-pages.pdf")}, MergeOptions{Order: []int{1, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "merged.pdf")
+			if err := Merge(context.Background(), tt.inputs, out, tt.opts); !IsInputError(err) {
+				t.Errorf("Merge() error = %v, want an input error", err)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+		opts   MergeOptions
+		pages  int
+	}{
+		{"two files", []string{"one-page.pdf", "three-pages.pdf"}, MergeOptions{}, 4},
+		{"reordered", []string{"one-page.pdf", "three-pages.pdf", "ten-pages.pdf"}, MergeOptions{Order: []int{2, 0, 1}}, 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []string
+			for _, name := range tt.inputs {
+				inputs = append(inputs, fixture(name))
+			}
+			out := filepath.Join(t.TempDir(), "merged.pdf")
+			if err := Merge(context.Background(), inputs, out, tt.opts); err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if got := pageCount(t, out); got != tt.pages {
+				t.Errorf("output has %d pages, want %d", got, tt.pages)
+			}
+		})
+	}
+}
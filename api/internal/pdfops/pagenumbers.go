@@ -0,0 +1,272 @@
+// internal/pdfops/pagenumbers.go
+package pdfops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// numberPagesTimeout bounds a single page numbering run
+const numberPagesTimeout = 60 * time.Second
+
+// ErrNothingToNumber is returned when the page selection leaves no page to number
+var ErrNothingToNumber error = &InputError{msg: "cannot skip first page when PDF has only one page"}
+
+// Page number positions
+var pageNumberPositions = map[string]string{
+	"top-left":      "tl",
+	"top-center":    "tc",
+	"top-right":     "tr",
+	"bottom-left":   "bl",
+	"bottom-center": "bc",
+	"bottom-right":  "br",
+}
+
+// ValidPageNumberPosition reports whether position is supported by NumberPages
+func ValidPageNumberPosition(position string) bool {
+	_, ok := pageNumberPositions[position]
+	return ok
+}
+
+// NumberPagesOptions configures NumberPages
+type NumberPagesOptions struct {
+	// Position is top-left, top-center, top-right, bottom-left, bottom-center or bottom-right
+	Position string
+	// Format is numeric, roman or alphabetic. pdfcpu can only stamp numeric
+	// page numbers, so the other formats currently fall back to numeric.
+	Format string
+	// StartNumber is the number of the first page. pdfcpu cannot offset page
+	// numbers, so values other than 1 are currently ignored.
+	StartNumber int
+	FontFamily  string
+	FontSize    int
+	// Color is a #RRGGBB color; defaults to black
+	Color  string
+	Prefix string
+	Suffix string
+	// MarginX and MarginY are the distances in points from the page edges
+	MarginX int
+	MarginY int
+	// Pages selects the pages to number (e.g. "1-3,5"); empty numbers every page
+	Pages string
+	// SkipFirstPage leaves the first page unnumbered when Pages is empty
+	SkipFirstPage bool
+	// TotalPages is the page count of the input; it is determined when zero
+	TotalPages int
+}
+
+// PageNumberFields holds the fields of a page numbering request as sent,
+// e.g. in a form. Empty fields take their defaults.
+type PageNumberFields struct {
+	Position   string // Defaults to bottom-center
+	Format     string // Defaults to numeric
+	FontFamily string // Defaults to Helvetica
+	FontSize   string // Defaults to 12
+	Color      string // Defaults to #000000
+	// StartNumber defaults to 1
+	StartNumber string
+	Prefix      string
+	Suffix      string
+	// MarginX and MarginY default to 40 and 30 points
+	MarginX       string
+	MarginY       string
+	SelectedPages string
+	// SkipFirstPage is "true" to leave the first page unnumbered
+	SkipFirstPage string
+}
+
+// pageNumberFormats are the formats accepted by NumberPages
+var pageNumberFormats = map[string]bool{
+	"numeric":    true,
+	"roman":      true,
+	"alphabetic": true,
+}
+
+// NewNumberPagesOptions validates the fields of a page numbering request
+// and applies their defaults
+func NewNumberPagesOptions(fields PageNumberFields) (NumberPagesOptions, error) {
+	opts := NumberPagesOptions{
+		Position:      fieldOr(fields.Position, "bottom-center"),
+		Format:        fieldOr(fields.Format, "numeric"),
+		FontFamily:    fieldOr(fields.FontFamily, "Helvetica"),
+		Color:         fieldOr(fields.Color, "#000000"),
+		Prefix:        fields.Prefix,
+		Suffix:        fields.Suffix,
+		Pages:         fields.SelectedPages,
+		SkipFirstPage: fields.SkipFirstPage == "true",
+	}
+
+	if !ValidPageNumberPosition(opts.Position) {
+		return opts, inputErrorf("invalid position, must be one of: top-left, top-center, top-right, bottom-left, bottom-center, bottom-right")
+	}
+	if !pageNumberFormats[opts.Format] {
+		return opts, inputErrorf("invalid format, must be one of: numeric, roman, alphabetic")
+	}
+
+	var err error
+	if opts.FontSize, err = strconv.Atoi(fieldOr(fields.FontSize, "12")); err != nil || opts.FontSize <= 0 || opts.FontSize > 72 {
+		return opts, inputErrorf("invalid font size, must be a number between 1 and 72")
+	}
+	if opts.StartNumber, err = strconv.Atoi(fieldOr(fields.StartNumber, "1")); err != nil || opts.StartNumber <= 0 {
+		return opts, inputErrorf("invalid start number, must be a positive number")
+	}
+	if opts.MarginX, err = strconv.Atoi(fieldOr(fields.MarginX, "40")); err != nil || opts.MarginX < 0 {
+		return opts, inputErrorf("invalid horizontal margin, must be a non-negative number")
+	}
+	if opts.MarginY, err = strconv.Atoi(fieldOr(fields.MarginY, "30")); err != nil || opts.MarginY < 0 {
+		return opts, inputErrorf("invalid vertical margin, must be a non-negative number")
+	}
+
+	return opts, nil
+}
+
+// fieldOr returns value, or def when value is empty
+func fieldOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// NumberPagesResult describes the outcome of NumberPages
+type NumberPagesResult struct {
+	TotalPages    int
+	NumberedPages int
+}
+
+// NumberPages writes a copy of in with page numbers stamped on it to out
+func NumberPages(ctx context.Context, in, out string, opts NumberPagesOptions) (*NumberPagesResult, error) {
+	totalPages := opts.TotalPages
+	if totalPages <= 0 {
+		count, err := PageCount(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		totalPages = count
+	}
+
+	switch opts.Format {
+	case "roman":
+		log.Printf("Warning: Roman numerals not directly supported with pdfcpu stamp. Using numeric format.")
+	case "alphabetic":
+		log.Printf("Warning: Alphabetic format not directly supported with pdfcpu stamp. Using numeric format.")
+	}
+	if opts.StartNumber > 1 {
+		log.Printf("Warning: Custom start numbers with pdfcpu stamp are not directly supported. Using default numbering.")
+	}
+
+	args := []string{"stamp", "add", "-mode", "text"}
+
+	numberedPages := totalPages
+	if opts.Pages != "" {
+		args = append(args, "-pages", opts.Pages)
+		numberedPages = CountSelectedPages(opts.Pages, totalPages)
+	} else if opts.SkipFirstPage {
+		if totalPages <= 1 {
+			return nil, ErrNothingToNumber
+		}
+		args = append(args, "-pages", fmt.Sprintf("2-%d", totalPages))
+		numberedPages = totalPages - 1
+	}
+
+	// %p is replaced by pdfcpu with the page number
+	pageText := opts.Prefix + "%p" + opts.Suffix
+
+	ctx, cancel := context.WithTimeout(ctx, numberPagesTimeout)
+	defer cancel()
+
+	args = append(args, "--", pageText, stampDescription(opts), in, out)
+	if _, err := runPdfcpu(ctx, args...); err != nil {
+		return nil, err
+	}
+
+	return &NumberPagesResult{
+		TotalPages:    totalPages,
+		NumberedPages: numberedPages,
+	}, nil
+}
+
+// PageCount returns the number of pages of a PDF
+func PageCount(ctx context.Context, path string) (int, error) {
+	if count, err := api.PageCountFile(path); err == nil {
+		return count, nil
+	}
+
+	// The pdfcpu library is stricter than the command line tool, so fall back to pdfcpu info
+	output, err := run(ctx, "pdfcpu", "info", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get PDF page count: %w", err)
+	}
+	matches := regexp.MustCompile(`Pages\s*:\s*(\d+)`).FindStringSubmatch(string(output))
+	if len(matches) < 2 {
+		return 0, errors.New("failed to get PDF page count: no page count in pdfcpu info output")
+	}
+	return strconv.Atoi(matches[1])
+}
+
+// stampDescription builds the pdfcpu stamp description for the page numbers.
+// Parameter names are spelled out to avoid "ambiguous parameter prefix" errors
+// and only rotation (not diagonal) is set, as pdfcpu allows just one of them.
+func stampDescription(opts NumberPagesOptions) string {
+	position := pageNumberPositions[opts.Position]
+	if position == "" {
+		position = "bc"
+	}
+
+	offsetX, offsetY := stampOffsets(opts.Position, opts.MarginX, opts.MarginY)
+
+	color := "#000000"
+	if hex := strings.TrimPrefix(opts.Color, "#"); len(hex) == 6 {
+		color = "#" + hex
+	}
+
+	return fmt.Sprintf(
+		"fontname:%s, points:%d, pos:%s, offset:%d %d, aligntext:c, fillcolor:%s, strokecolor:%s, opacity:1.0, scale:1.0 abs, rotation:0",
+		stampFont(opts.FontFamily),
+		opts.FontSize,
+		position,
+		offsetX,
+		offsetY,
+		color,
+		color,
+	)
+}
+
+// stampOffsets converts margins to pdfcpu offsets, which are relative to the
+// anchor position: positive values move right/up, negative values left/down
+func stampOffsets(position string, marginX, marginY int) (int, int) {
+	switch position {
+	case "top-left":
+		return marginX, -marginY
+	case "top-center":
+		return 0, -marginY
+	case "top-right":
+		return -marginX, -marginY
+	case "bottom-left":
+		return marginX, marginY
+	case "bottom-right":
+		return -marginX, marginY
+	default: // bottom-center
+		return 0, marginY
+	}
+}
+
+// stampFont maps font family names to pdfcpu core font names
+func stampFont(fontFamily string) string {
+	switch strings.ToLower(fontFamily) {
+	case "times", "timesnewroman", "times new roman":
+		return "Times"
+	case "courier":
+		return "Courier"
+	default:
+		return "Helvetica"
+	}
+}
@@ -0,0 +1,184 @@
+// internal/pdfops/pagenumbers_test.go
+package pdfops
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageCount(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    int
+	}{
+		{"one-page.pdf", 1},
+		{"three-pages.pdf", 3},
+		{"ten-pages.pdf", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := PageCount(context.Background(), fixture(tt.fixture))
+			if err != nil {
+				t.Fatalf("PageCount() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PageCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageCountInvalidPDF(t *testing.T) {
+	if _, err := PageCount(context.Background(), fixture("not-a-pdf.pdf")); err == nil {
+		t.Error("PageCount() of a text file succeeded")
+	}
+}
+
+func TestValidPageNumberPosition(t *testing.T) {
+	for _, position := range []string{"top-left", "top-center", "top-right", "bottom-left", "bottom-center", "bottom-right"} {
+		if !ValidPageNumberPosition(position) {
+			t.Errorf("ValidPageNumberPosition(%q) = false", position)
+		}
+	}
+	for _, position := range []string{"", "center", "bottom", "BOTTOM-LEFT"} {
+		if ValidPageNumberPosition(position) {
+			t.Errorf("ValidPageNumberPosition(%q) = true", position)
+		}
+	}
+}
+
+func TestStampOffsets(t *testing.T) {
+	tests := []struct {
+		position string
+		x, y     int
+	}{
+		{"top-left", 10, -20},
+		{"top-center", 0, -20},
+		{"top-right", -10, -20},
+		{"bottom-left", 10, 20},
+		{"bottom-center", 0, 20},
+		{"bottom-right", -10, 20},
+		{"unknown", 0, 20},
+	}
+
+	for _, tt := range tests {
+		if x, y := stampOffsets(tt.position, 10, 20); x != tt.x || y != tt.y {
+			t.Errorf("stampOffsets(%q) = %d %d, want %d %d", tt.position, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+func TestStampDescription(t *testing.T) {
+	tests := []struct {
+		name string
+		opts NumberPagesOptions
+		want string
+	}{
+		{
+			"defaults",
+			NumberPagesOptions{FontSize: 12},
+			"fontname:Helvetica, points:12, pos:bc, offset:0 0, aligntext:c, fillcolor:#000000, strokecolor:#000000, opacity:1.0, scale:1.0 abs, rotation:0",
+		},
+		{
+			"top right in red times",
+			NumberPagesOptions{Position: "top-right", FontFamily: "Times New Roman", FontSize: 10, Color: "#ff0000", MarginX: 30, MarginY: 25},
+			"fontname:Times, points:10, pos:tr, offset:-30 -25, aligntext:c, fillcolor:#ff0000, strokecolor:#ff0000, opacity:1.0, scale:1.0 abs, rotation:0",
+		},
+		{
+			"color without hash",
+			NumberPagesOptions{Position: "bottom-left", FontFamily: "courier", FontSize: 8, Color: "00ff00", MarginX: 5, MarginY: 5},
+			"fontname:Courier, points:8, pos:bl, offset:5 5, aligntext:c, fillcolor:#00ff00, strokecolor:#00ff00, opacity:1.0, scale:1.0 abs, rotation:0",
+		},
+		{
+			"invalid color",
+			NumberPagesOptions{Position: "bottom-center", FontSize: 12, Color: "red"},
+			"fontname:Helvetica, points:12, pos:bc, offset:0 0, aligntext:c, fillcolor:#000000, strokecolor:#000000, opacity:1.0, scale:1.0 abs, rotation:0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stampDescription(tt.opts); got != tt.want {
+				t.Errorf("stampDescription() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberPagesNothingToNumber(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "numbered.pdf")
+	_, err := NumberPages(context.Background(), fixture("one-page.pdf"), out, NumberPagesOptions{SkipFirstPage: true})
+	if !errors.Is(err, ErrNothingToNumber) {
+		t.Errorf("NumberPages() error = %v, want ErrNothingToNumber", err)
+	}
+}
+
+func TestNumberPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     NumberPagesOptions
+		numbered int
+	}{
+		{"all pages", NumberPagesOptions{Position: "bottom-center", FontSize: 12}, 3},
+		{"skip first page", NumberPagesOptions{Position: "top-right", FontSize: 12, SkipFirstPage: true}, 2},
+		{"selected pages", NumberPagesOptions{Position: "bottom-left", FontSize: 12, Pages: "1,3"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "numbered.pdf")
+			result, err := NumberPages(context.Background(), fixture("three-pages.pdf"), out, tt.opts)
+			if err != nil {
+				t.Fatalf("NumberPages() error = %v", err)
+			}
+			if result.TotalPages != 3 || result.NumberedPages != tt.numbered {
+				t.Errorf("NumberPages() = %+v, want 3 pages with %d numbered", result, tt.numbered)
+			}
+			if got := pageCount(t, out); got != 3 {
+				t.Errorf("output has %d pages, want 3", got)
+			}
+		})
+	}
+}
+
+func TestNewNumberPagesOptions(t *testing.T) {
+	opts, err := NewNumberPagesOptions(PageNumberFields{})
+	if err != nil {
+		t.Fatalf("NewNumberPagesOptions() of empty fields error = %v", err)
+	}
+	want := NumberPagesOptions{Position: "bottom-center", Format: "numeric", FontFamily: "Helvetica", FontSize: 12, Color: "#000000", StartNumber: 1, MarginX: 40, MarginY: 30}
+	if opts != want {
+		t.Errorf("NewNumberPagesOptions() = %+v, want the defaults %+v", opts, want)
+	}
+
+	opts, err = NewNumberPagesOptions(PageNumberFields{
+		Position: "top-left", Format: "roman", FontSize: "9", StartNumber: "3", Prefix: "Page ",
+		MarginX: "0", MarginY: "5", SelectedPages: "2-4", SkipFirstPage: "true",
+	})
+	if err != nil {
+		t.Fatalf("NewNumberPagesOptions() error = %v", err)
+	}
+	want = NumberPagesOptions{Position: "top-left", Format: "roman", FontFamily: "Helvetica", FontSize: 9, Color: "#000000", StartNumber: 3,
+		Prefix: "Page ", MarginX: 0, MarginY: 5, Pages: "2-4", SkipFirstPage: true}
+	if opts != want {
+		t.Errorf("NewNumberPagesOptions() = %+v, want %+v", opts, want)
+	}
+
+	for _, fields := range []PageNumberFields{
+		{Position: "center"},
+		{Format: "words"},
+		{FontSize: "0"},
+		{FontSize: "73"},
+		{FontSize: "large"},
+		{StartNumber: "0"},
+		{MarginX: "-1"},
+		{MarginY: "a"},
+	} {
+		if _, err := NewNumberPagesOptions(fields); !IsInputError(err) {
+			t.Errorf("NewNumberPagesOptions(%+v) error = %v, want an input error", fields, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// internal/pdfops/pdfops.go
+
+// Package pdfops implements the PDF operations offered by the API on top of
+// the pdfcpu command line tool. Every function works on files on disk, takes
+// a context that stops the underlying process when it is cancelled and knows
+// nothing about HTTP, so it can be used from handlers, job runners and tools.
+package pdfops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	// ErrNoPages is returned when the input PDF contains no pages
+	ErrNoPages error = &InputError{msg: "PDF contains no pages"}
+	// ErrEmptyOutput is returned when a tool succeeded but did not produce a usable file
+	ErrEmptyOutput = errors.New("output file not created or empty")
+)
+
+// InputError is returned when the options or the input file cannot be
+// processed, as opposed to a failure of the underlying tool
+type InputError struct {
+	msg string
+}
+
+func (e *InputError) Error() string {
+	return e.msg
+}
+
+// inputErrorf formats an InputError
+func inputErrorf(format string, args ...interface{}) error {
+	return &InputError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsInputError reports whether err was caused by invalid options or input
+func IsInputError(err error) bool {
+	var inputErr *InputError
+	return errors.As(err, &inputErr)
+}
+
+// CommandError is returned when an external tool exits with an error.
+// Its message is the tool output, which usually explains what went wrong.
+type CommandError struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	if e.Output != "" {
+		return e.Output
+	}
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// run executes a command and returns its combined output.
+// If ctx ends first the process is killed and the context error is returned.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return output, ctxErr
+	}
+	if err != nil {
+		return output, &CommandError{
+			Command: name + " " + args[0],
+			Output:  strings.TrimSpace(string(output)),
+			Err:     err,
+		}
+	}
+	return output, nil
+}
+
+// runPdfcpu executes pdfcpu with the given arguments, logging the command line
+func runPdfcpu(ctx context.Context, args ...string) ([]byte, error) {
+	log.Printf("Executing: pdfcpu %s", strings.Join(args, " "))
+	return run(ctx, "pdfcpu", args...)
+}
+
+// fileHasContent reports whether path exists and is larger than minSize bytes
+func fileHasContent(path string, minSize int64) bool {
+	return getFileSize(path) > minSize
+}
+
+// getFileSize returns the size of path, or 0 if it does not exist
+func getFileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// commandExists reports whether a command is available in PATH
+func commandExists(cmd string) bool {
+	_, err := exec.LookPath(cmd)
+	return err == nil
+}
+
+// copyFile copies src to dst, replacing dst if it exists
+func copyFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, sourceFile)
+	return err
+}
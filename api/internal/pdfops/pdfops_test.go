@@ -0,0 +1,119 @@
+// internal/pdfops/pdfops_test.go
+package pdfops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain puts a pdfcpu command line tool built from the pdfcpu version in
+// go.mod first on the PATH, so the tests run the pdfcpu the library is
+// pinned to instead of depending on what is installed
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "pdfops-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create pdfcpu directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	// go test puts the go command of the toolchain on the PATH
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, "pdfcpu"), "github.com/pdfcpu/pdfcpu/cmd/pdfcpu")
+	if output, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build pdfcpu: %v\n%s", err, output)
+		return 1
+	}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return m.Run()
+}
+
+func TestIsInputError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"input error", inputErrorf("bad page %d", 3), true},
+		{"wrapped input error", fmt.Errorf("split: %w", ErrNoPages), true},
+		{"command error", &CommandError{Command: "pdfcpu merge", Err: errors.New("exit status 1")}, false},
+		{"empty output", ErrEmptyOutput, false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsInputError(tt.err); got != tt.want {
+				t.Errorf("IsInputError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunMissingCommand(t *testing.T) {
+	_, err := run(context.Background(), "megapdf-no-such-tool", "merge")
+
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("run() error = %v, want a CommandError", err)
+	}
+	if commandErr.Command != "megapdf-no-such-tool merge" {
+		t.Errorf("Command = %q", commandErr.Command)
+	}
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("run() error = %v, want it to wrap exec.ErrNotFound", err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := run(ctx, "megapdf-no-such-tool", "merge"); !errors.Is(err, context.Canceled) {
+		t.Errorf("run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "copy.pdf")
+	if err := os.WriteFile(dst, []byte("previous content that is longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyFile(fixture("one-page.pdf"), dst); err != nil {
+		t.Fatalf("copyFile() error = %v", err)
+	}
+	if got, want := getFileSize(dst), getFileSize(fixture("one-page.pdf")); got != want {
+		t.Errorf("copy has %d bytes, want %d", got, want)
+	}
+	if fileHasContent(filepath.Join(t.TempDir(), "missing.pdf"), 0) {
+		t.Error("fileHasContent() = true for a missing file")
+	}
+}
+
+// fixture returns the path of a file in testdata
+func fixture(name string) string {
+	return filepath.Join("testdata", name)
+}
+
+// pageCount returns the page count of a PDF written by a test
+func pageCount(t *testing.T, path string) int {
+	t.Helper()
+	if !fileHasContent(path, 0) {
+		t.Fatalf("output %s not created or empty", path)
+	}
+	count, err := PageCount(context.Background(), path)
+	if err != nil {
+		t.Fatalf("PageCount(%s) error = %v", path, err)
+	}
+	return count
+}
@@ -0,0 +1,67 @@
+// internal/pdfops/protect.go
+package pdfops
+
+import "context"
+
+// Permission sets understood by pdfcpu encrypt
+const (
+	PermissionsAll   = "all"
+	PermissionsPrint = "print"
+	PermissionsNone  = "none"
+)
+
+// ProtectOptions configures Protect
+type ProtectOptions struct {
+	UserPassword string
+	// OwnerPassword defaults to UserPassword when empty
+	OwnerPassword string
+	// Permissions is one of PermissionsAll, PermissionsPrint or PermissionsNone (the default)
+	Permissions string
+}
+
+// UnlockOptions configures Unlock
+type UnlockOptions struct {
+	Password string
+}
+
+// Protect writes an encrypted copy of in to out
+func Protect(ctx context.Context, in, out string, opts ProtectOptions) error {
+	if opts.UserPassword == "" {
+		return inputErrorf("password cannot be empty")
+	}
+
+	ownerPassword := opts.OwnerPassword
+	if ownerPassword == "" {
+		ownerPassword = opts.UserPassword
+	}
+
+	permissions := opts.Permissions
+	switch permissions {
+	case "":
+		permissions = PermissionsNone
+	case PermissionsAll, PermissionsPrint, PermissionsNone:
+	default:
+		return inputErrorf("invalid permissions %q", permissions)
+	}
+
+	// Not runPdfcpu: the command line contains the passwords and must not be logged
+	_, err := run(ctx, "pdfcpu",
+		"encrypt",
+		"-upw", opts.UserPassword,
+		"-opw", ownerPassword,
+		"-perm", permissions,
+		in,
+		out,
+	)
+	return err
+}
+
+// Unlock writes a decrypted copy of in to out
+func Unlock(ctx context.Context, in, out string, opts UnlockOptions) error {
+	if opts.Password == "" {
+		return inputErrorf("password is required")
+	}
+
+	_, err := run(ctx, "pdfcpu", "decrypt", "-upw", opts.Password, in, out)
+	return err
+}
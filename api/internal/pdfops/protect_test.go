@@ -0,0 +1,52 @@
+// internal/pdfops/protect_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func TestProtectUnlock(t *testing.T) {
+	dir := t.TempDir()
+	protected := filepath.Join(dir, "protected.pdf")
+	unlocked := filepath.Join(dir, "unlocked.pdf")
+
+	err := Protect(context.Background(), fixture("three-pages.pdf"), protected, ProtectOptions{
+		UserPassword: "secret",
+		Permissions:  PermissionsPrint,
+	})
+	if err != nil {
+		t.Fatalf("Protect() error = %v", err)
+	}
+	if _, err := api.PageCountFile(protected); err == nil {
+		t.Error("protected PDF can be read without the password")
+	}
+
+	if err := Unlock(context.Background(), protected, unlocked, UnlockOptions{Password: "wrong"}); err == nil {
+		t.Error("Unlock() with a wrong password succeeded")
+	}
+	if err := Unlock(context.Background(), protected, unlocked, UnlockOptions{Password: "secret"}); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if got := pageCount(t, unlocked); got != 3 {
+		t.Errorf("unlocked PDF has %d pages, want 3", got)
+	}
+}
+
+func TestProtectInvalidOptions(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "protected.pdf")
+	for _, opts := range []ProtectOptions{
+		{},
+		{UserPassword: "secret", Permissions: "edit"},
+	} {
+		if err := Protect(context.Background(), fixture("one-page.pdf"), out, opts); !IsInputError(err) {
+			t.Errorf("Protect(%+v) error = %v, want an input error", opts, err)
+		}
+	}
+	if err := Unlock(context.Background(), fixture("one-page.pdf"), out, UnlockOptions{}); !IsInputError(err) {
+		t.Errorf("Unlock() without a password error = %v, want an input error", err)
+	}
+}
@@ -0,0 +1,88 @@
+// internal/pdfops/remove.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RemovePagesOptions configures RemovePages
+type RemovePagesOptions struct {
+	// Pages are the 1-based page numbers to remove
+	Pages []int
+	// TotalPages is the page count of the input; it is determined when zero
+	TotalPages int
+}
+
+// RemovePagesResult describes the outcome of RemovePages
+type RemovePagesResult struct {
+	OriginalPages  int
+	RemovedPages   int
+	ResultingPages int
+}
+
+// RemovePages writes a copy of in without the given pages to out
+func RemovePages(ctx context.Context, in, out string, opts RemovePagesOptions) (*RemovePagesResult, error) {
+	if len(opts.Pages) == 0 {
+		return nil, inputErrorf("no pages selected for removal")
+	}
+
+	totalPages := opts.TotalPages
+	if totalPages <= 0 {
+		count, err := PageCount(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		totalPages = count
+	}
+
+	remove := make(map[int]bool, len(opts.Pages))
+	for _, page := range opts.Pages {
+		if page < 1 || page > totalPages {
+			return nil, inputErrorf("invalid page number: %d (total pages: %d)", page, totalPages)
+		}
+		remove[page] = true
+	}
+	if len(remove) >= totalPages {
+		return nil, inputErrorf("cannot remove all pages from PDF")
+	}
+
+	// pdfcpu trim keeps the selected pages, so select the complement as ranges
+	pageSpec := strings.Join(keptRanges(remove, totalPages), ",")
+	if _, err := runPdfcpu(ctx, "trim", "-pages", pageSpec, in, out); err != nil {
+		return nil, err
+	}
+
+	if !fileHasContent(out, 0) {
+		return nil, ErrEmptyOutput
+	}
+
+	return &RemovePagesResult{
+		OriginalPages:  totalPages,
+		RemovedPages:   len(remove),
+		ResultingPages: totalPages - len(remove),
+	}, nil
+}
+
+// keptRanges returns the pages not in remove as compact ranges like "1-2", "4"
+func keptRanges(remove map[int]bool, totalPages int) []string {
+	var ranges []string
+	start := 0
+	for page := 1; page <= totalPages+1; page++ {
+		kept := page <= totalPages && !remove[page]
+		if kept && start == 0 {
+			start = page
+		}
+		if !kept && start != 0 {
+			if start == page-1 {
+				ranges = append(ranges, strconv.Itoa(start))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", start, page-1))
+			}
+			start = 0
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,90 @@
+// internal/pdfops/remove_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKeptRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		remove     []int
+		totalPages int
+		want       []string
+	}{
+		{"first page", []int{1}, 5, []string{"2-5"}},
+		{"last page", []int{5}, 5, []string{"1-4"}},
+		{"middle pages", []int{2, 4}, 5, []string{"1", "3", "5"}},
+		{"consecutive pages", []int{3, 4}, 6, []string{"1-2", "5-6"}},
+		{"single kept page", []int{1, 2}, 3, []string{"3"}},
+		{"nothing removed", nil, 3, []string{"1-3"}},
+		{"everything removed", []int{1, 2}, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remove := make(map[int]bool)
+			for _, page := range tt.remove {
+				remove[page] = true
+			}
+			if got := keptRanges(remove, tt.totalPages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keptRanges(%v, %d) = %q, want %q", tt.remove, tt.totalPages, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemovePagesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []int
+	}{
+		{"no pages", nil},
+		{"page zero", []int{0}},
+		{"beyond last page", []int{4}},
+		{"all pages", []int{1, 2, 3}},
+		{"all pages with duplicates", []int{3, 1, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "removed.pdf")
+			// The page count is read from the fixture, so no tool runs
+			_, err := RemovePages(context.Background(), fixture("three-pages.pdf"), out, RemovePagesOptions{Pages: tt.pages})
+			if !IsInputError(err) {
+				t.Errorf("RemovePages() error = %v, want an input error", err)
+			}
+		})
+	}
+}
+
+func TestRemovePages(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     []int
+		remaining int
+	}{
+		{"first page", []int{1}, 9},
+		{"scattered pages", []int{2, 5, 10}, 7},
+		{"duplicates", []int{4, 4}, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "removed.pdf")
+			result, err := RemovePages(context.Background(), fixture("ten-pages.pdf"), out, RemovePagesOptions{Pages: tt.pages})
+			if err != nil {
+				t.Fatalf("RemovePages() error = %v", err)
+			}
+			if result.OriginalPages != 10 || result.ResultingPages != tt.remaining {
+				t.Errorf("RemovePages() = %+v, want %d remaining of 10", result, tt.remaining)
+			}
+			if got := pageCount(t, out); got != tt.remaining {
+				t.Errorf("output has %d pages, want %d", got, tt.remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// internal/pdfops/rotate.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// RotateOptions configures Rotate
+type RotateOptions struct {
+	// Angle is the clockwise rotation: 90, 180 or 270
+	Angle int
+	// Pages selects the pages to rotate (e.g. "1-3,5"); empty or "all" rotates every page
+	Pages string
+}
+
+// Rotate writes a copy of in with the selected pages rotated to out
+func Rotate(ctx context.Context, in, out string, opts RotateOptions) error {
+	if opts.Angle != 90 && opts.Angle != 180 && opts.Angle != 270 {
+		return inputErrorf("invalid rotation angle %d, must be 90, 180 or 270", opts.Angle)
+	}
+
+	// pdfcpu rotate works in place, so rotate a working copy
+	tempDir, err := os.MkdirTemp("", "pdf-rotation")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tempPath := filepath.Join(tempDir, "working.pdf")
+	if err := copyFile(in, tempPath); err != nil {
+		return fmt.Errorf("failed to copy PDF to temp location: %w", err)
+	}
+
+	args := []string{"rotate"}
+	if opts.Pages != "" && opts.Pages != "all" {
+		args = append(args, "-pages="+opts.Pages)
+	}
+	args = append(args, tempPath, strconv.Itoa(opts.Angle))
+
+	if _, err := runPdfcpu(ctx, args...); err != nil {
+		return err
+	}
+
+	if err := copyFile(tempPath, out); err != nil {
+		return fmt.Errorf("failed to copy modified PDF to output location: %w", err)
+	}
+
+	return nil
+}
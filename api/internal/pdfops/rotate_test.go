@@ -0,0 +1,40 @@
+// internal/pdfops/rotate_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateInvalidAngle(t *testing.T) {
+	for _, angle := range []int{0, 45, -90, 360} {
+		out := filepath.Join(t.TempDir(), "rotated.pdf")
+		err := Rotate(context.Background(), fixture("one-page.pdf"), out, RotateOptions{Angle: angle})
+		if !IsInputError(err) {
+			t.Errorf("Rotate(%d) error = %v, want an input error", angle, err)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RotateOptions
+	}{
+		{"all pages", RotateOptions{Angle: 90}},
+		{"selected pages", RotateOptions{Angle: 180, Pages: "1,3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "rotated.pdf")
+			if err := Rotate(context.Background(), fixture("three-pages.pdf"), out, tt.opts); err != nil {
+				t.Fatalf("Rotate() error = %v", err)
+			}
+			if got := pageCount(t, out); got != 3 {
+				t.Errorf("output has %d pages, want 3", got)
+			}
+		})
+	}
+}
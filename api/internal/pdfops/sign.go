@@ -0,0 +1,43 @@
+// internal/pdfops/sign.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+)
+
+// SignOptions configures Sign
+type SignOptions struct {
+	// Type is WatermarkText or WatermarkImage
+	Type string
+	// Content is the signature text, or the path of the signature image
+	Content string
+	// Position is a name (center, top-left, ...) or pdfcpu code (c, tl, ...); defaults to center
+	Position string
+	// Rotation in degrees, counter-clockwise as shown in the UI
+	Rotation int
+	// Opacity and Scale are percentages
+	Opacity int
+	Scale   int
+	// Pages selects the pages ("even", "odd" or ranges like "1-3,5"); empty or "all" signs every page
+	Pages string
+}
+
+// Sign stamps a text or image signature onto in and writes the result to out
+func Sign(ctx context.Context, in, out string, opts SignOptions) error {
+	if opts.Type != WatermarkText && opts.Type != WatermarkImage {
+		return inputErrorf("invalid signature type %q", opts.Type)
+	}
+	if opts.Content == "" {
+		return inputErrorf("no signature content provided")
+	}
+
+	// pdfcpu rotates in the opposite direction from what the UI shows
+	description := fmt.Sprintf("pos:%s, op:%.1f, rot:%d, scale:%.1f",
+		watermarkPosition(opts.Position),
+		float64(opts.Opacity)/100.0,
+		-opts.Rotation,
+		float64(opts.Scale)/100.0)
+
+	return addWatermark(ctx, in, out, opts.Type, opts.Content, description, opts.Pages)
+}
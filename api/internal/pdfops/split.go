@@ -0,0 +1,268 @@
+// internal/pdfops/split.go
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Split methods
+const (
+	SplitByRange = "range"   // One file per page range
+	SplitExtract = "extract" // One file per page
+	SplitEvery   = "every"   // One file per chunk of N pages
+)
+
+// SplitOptions configures Split
+type SplitOptions struct {
+	// Method is SplitByRange, SplitExtract or SplitEvery
+	Method string
+	// PageRanges is used by SplitByRange, e.g. "1-3,5,7-9"
+	PageRanges string
+	// EveryNPages is the chunk size used by SplitEvery
+	EveryNPages int
+	// TotalPages is the page count of the input
+	TotalPages int
+	// OutputDir receives the parts, named after Prefix
+	OutputDir string
+	Prefix    string
+	// Progress, if set, is called after each part with the parts done and the total
+	Progress func(done, total int)
+}
+
+// SplitPart is one file produced by Split
+type SplitPart struct {
+	Path      string
+	Filename  string
+	Pages     []int
+	PageCount int
+}
+
+// splitTask is a page range to extract into one output file
+type splitTask struct {
+	pages    string
+	filename string
+	first    int
+	last     int
+	optional bool // Failures are skipped instead of aborting the split
+}
+
+// Split extracts parts of in into separate files in opts.OutputDir
+func Split(ctx context.Context, in string, opts SplitOptions) ([]SplitPart, error) {
+	tasks, err := splitTasks(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	supported := detectPdfcpuCommands(ctx)
+	log.Printf("Detected pdfcpu supported commands: %v", supported)
+
+	parts := make([]SplitPart, 0, len(tasks))
+	for i, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		outputPath := filepath.Join(opts.OutputDir, task.filename)
+		if err := extractPages(ctx, supported, in, outputPath, task.pages); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !task.optional {
+				return nil, fmt.Errorf("failed to extract pages %s: %w", task.pages, err)
+			}
+			log.Printf("Warning: Failed to extract page %s, skipping", task.pages)
+			continue
+		}
+
+		pages := make([]int, 0, task.last-task.first+1)
+		for p := task.first; p <= task.last; p++ {
+			pages = append(pages, p)
+		}
+		parts = append(parts, SplitPart{
+			Path:      outputPath,
+			Filename:  task.filename,
+			Pages:     pages,
+			PageCount: len(pages),
+		})
+
+		if opts.Progress != nil {
+			opts.Progress(i+1, len(tasks))
+		}
+	}
+
+	return parts, nil
+}
+
+// EstimateSplitParts returns how many files Split will produce for the options
+func EstimateSplitParts(opts SplitOptions) int {
+	switch opts.Method {
+	case SplitByRange:
+		return len(ParsePageRanges(opts.PageRanges, opts.TotalPages))
+	case SplitExtract:
+		return opts.TotalPages
+	case SplitEvery:
+		if opts.EveryNPages < 1 {
+			return opts.TotalPages
+		}
+		return (opts.TotalPages + opts.EveryNPages - 1) / opts.EveryNPages
+	}
+	return 0
+}
+
+// splitTasks lists the page ranges to extract for the options
+func splitTasks(opts SplitOptions) ([]splitTask, error) {
+	var tasks []splitTask
+
+	switch opts.Method {
+	case SplitByRange:
+		for i, pageRange := range ParsePageRanges(opts.PageRanges, opts.TotalPages) {
+			first, last := rangeBounds(pageRange)
+			tasks = append(tasks, splitTask{
+				pages:    pageRange,
+				filename: fmt.Sprintf("%s-split-%d.pdf", opts.Prefix, i+1),
+				first:    first,
+				last:     last,
+			})
+		}
+	case SplitExtract:
+		for page := 1; page <= opts.TotalPages; page++ {
+			tasks = append(tasks, splitTask{
+				pages:    strconv.Itoa(page),
+				filename: fmt.Sprintf("%s-page-%d.pdf", opts.Prefix, page),
+				first:    page,
+				last:     page,
+				optional: true,
+			})
+		}
+	case SplitEvery:
+		every := opts.EveryNPages
+		if every < 1 {
+			every = 1
+		}
+		for start := 1; start <= opts.TotalPages; start += every {
+			end := start + every - 1
+			if end > opts.TotalPages {
+				end = opts.TotalPages
+			}
+			tasks = append(tasks, splitTask{
+				pages:    fmt.Sprintf("%d-%d", start, end),
+				filename: fmt.Sprintf("%s-pages-%d-%d.pdf", opts.Prefix, start, end),
+				first:    start,
+				last:     end,
+			})
+		}
+	default:
+		return nil, inputErrorf("invalid split method %q", opts.Method)
+	}
+
+	if len(tasks) == 0 {
+		return nil, inputErrorf("no valid page ranges found")
+	}
+	return tasks, nil
+}
+
+// extractPages writes the given pages of in to out. pdfcpu extract is tried
+// first, then pdfcpu trim (newer versions) and finally pdftk if installed.
+func extractPages(ctx context.Context, supported map[string]bool, in, out, pages string) error {
+	var attempts [][]string
+	if supported["extract"] {
+		attempts = append(attempts, []string{"pdfcpu", "extract", "-mode", "page", "-pages", pages, in, out})
+	}
+	if supported["trim"] {
+		attempts = append(attempts, []string{"pdfcpu", "trim", "-pages", pages, in, out})
+	}
+	if commandExists("pdftk") {
+		attempts = append(attempts, []string{"pdftk", in, "cat", pages, "output", out})
+	}
+	if len(attempts) == 0 {
+		return fmt.Errorf("no tool available to extract pages: %w", exec.ErrNotFound)
+	}
+
+	var lastErr error
+	for _, attempt := range attempts {
+		_, err := run(ctx, attempt[0], attempt[1:]...)
+		if err == nil && fileExists(out) {
+			return nil
+		}
+		if err == nil {
+			err = ErrEmptyOutput
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+// detectPdfcpuCommands reports which page extraction commands pdfcpu supports
+func detectPdfcpuCommands(ctx context.Context) map[string]bool {
+	supported := make(map[string]bool)
+	for _, command := range []string{"extract", "trim"} {
+		_, err := run(ctx, "pdfcpu", "help", command)
+		supported[command] = err == nil
+	}
+	return supported
+}
+
+// ParsePageRanges returns the valid ranges ("3" or "1-5") of a comma separated
+// list, dropping entries that are malformed or outside 1..totalPages
+func ParsePageRanges(rangesStr string, totalPages int) []string {
+	var validRanges []string
+
+	for _, part := range strings.Split(rangesStr, ",") {
+		trimmed := strings.TrimSpace(part)
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.Contains(trimmed, "-") {
+			rangeParts := strings.Split(trimmed, "-")
+			if len(rangeParts) != 2 {
+				continue
+			}
+
+			start, err1 := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
+			end, err2 := strconv.Atoi(strings.TrimSpace(rangeParts[1]))
+			if err1 != nil || err2 != nil || start < 1 || end > totalPages || start > end {
+				continue
+			}
+		} else {
+			page, err := strconv.Atoi(trimmed)
+			if err != nil || page < 1 || page > totalPages {
+				continue
+			}
+		}
+
+		validRanges = append(validRanges, trimmed)
+	}
+
+	return validRanges
+}
+
+// CountSelectedPages counts the valid pages of a selection like "1-3,5"
+func CountSelectedPages(selection string, totalPages int) int {
+	count := 0
+	for _, pageRange := range ParsePageRanges(selection, totalPages) {
+		first, last := rangeBounds(pageRange)
+		count += last - first + 1
+	}
+	return count
+}
+
+// rangeBounds returns the first and last page of a range validated by ParsePageRanges
+func rangeBounds(pageRange string) (int, int) {
+	if start, end, ok := strings.Cut(pageRange, "-"); ok {
+		first, _ := strconv.Atoi(strings.TrimSpace(start))
+		last, _ := strconv.Atoi(strings.TrimSpace(end))
+		return first, last
+	}
+	page, _ := strconv.Atoi(pageRange)
+	return page, page
+}
@@ -0,0 +1,163 @@
+// internal/pdfops/split_test.go
+package pdfops
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePageRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		ranges     string
+		totalPages int
+		want       []string
+	}{
+		{"single pages", "1,3", 5, []string{"1", "3"}},
+		{"ranges", "1-2,4-5", 5, []string{"1-2", "4-5"}},
+		{"spaces", " 1 - 2 , 4 ", 5, []string{"1 - 2", "4"}},
+		{"empty entries", "1,,2,", 5, []string{"1", "2"}},
+		{"beyond last page", "4-6,6", 5, nil},
+		{"reversed range", "3-1", 5, nil},
+		{"page zero", "0,0-2", 5, nil},
+		{"malformed", "a,1-2-3,-2,1-", 5, nil},
+		{"empty", "", 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParsePageRanges(tt.ranges, tt.totalPages)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePageRanges(%q, %d) = %q, want %q", tt.ranges, tt.totalPages, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountSelectedPages(t *testing.T) {
+	tests := []struct {
+		selection  string
+		totalPages int
+		want       int
+	}{
+		{"1-3,5", 10, 4},
+		{"2", 10, 1},
+		{"1-10", 10, 10},
+		{"8-12,9", 10, 1},
+		{"", 10, 0},
+	}
+
+	for _, tt := range tests {
+		if got := CountSelectedPages(tt.selection, tt.totalPages); got != tt.want {
+			t.Errorf("CountSelectedPages(%q, %d) = %d, want %d", tt.selection, tt.totalPages, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateSplitParts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SplitOptions
+		want int
+	}{
+		{"range", SplitOptions{Method: SplitByRange, PageRanges: "1-2,3,9", TotalPages: 5}, 2},
+		{"extract", SplitOptions{Method: SplitExtract, TotalPages: 7}, 7},
+		{"every exact", SplitOptions{Method: SplitEvery, EveryNPages: 2, TotalPages: 6}, 3},
+		{"every remainder", SplitOptions{Method: SplitEvery, EveryNPages: 4, TotalPages: 10}, 3},
+		{"every invalid", SplitOptions{Method: SplitEvery, EveryNPages: 0, TotalPages: 4}, 4},
+		{"unknown method", SplitOptions{Method: "bogus", TotalPages: 4}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateSplitParts(tt.opts); got != tt.want {
+				t.Errorf("EstimateSplitParts() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTasks(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  SplitOptions
+		pages []string
+	}{
+		{"range", SplitOptions{Method: SplitByRange, PageRanges: "1-2,4", TotalPages: 5, Prefix: "doc"}, []string{"1-2", "4"}},
+		{"extract", SplitOptions{Method: SplitExtract, TotalPages: 3, Prefix: "doc"}, []string{"1", "2", "3"}},
+		{"every", SplitOptions{Method: SplitEvery, EveryNPages: 2, TotalPages: 5, Prefix: "doc"}, []string{"1-2", "3-4", "5-5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := splitTasks(tt.opts)
+			if err != nil {
+				t.Fatalf("splitTasks() error = %v", err)
+			}
+			var pages []string
+			for _, task := range tasks {
+				pages = append(pages, task.pages)
+				if first, last := rangeBounds(task.pages); first != task.first || last != task.last {
+					t.Errorf("task %q has bounds %d-%d, want %d-%d", task.pages, task.first, task.last, first, last)
+				}
+			}
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("splitTasks() pages = %q, want %q", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestSplitTasksErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SplitOptions
+	}{
+		{"unknown method", SplitOptions{Method: "bogus", TotalPages: 5}},
+		{"no valid range", SplitOptions{Method: SplitByRange, PageRanges: "7-9", TotalPages: 5}},
+		{"no pages", SplitOptions{Method: SplitExtract}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := splitTasks(tt.opts); !IsInputError(err) {
+				t.Errorf("splitTasks() error = %v, want an input error", err)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  SplitOptions
+		pages []int
+	}{
+		{"range", SplitOptions{Method: SplitByRange, PageRanges: "1-4,10"}, []int{4, 1}},
+		{"every", SplitOptions{Method: SplitEvery, EveryNPages: 3}, []int{3, 3, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.OutputDir = t.TempDir()
+			tt.opts.Prefix = "ten-pages"
+			tt.opts.TotalPages = 10
+
+			parts, err := Split(context.Background(), fixture("ten-pages.pdf"), tt.opts)
+			if err != nil {
+				t.Fatalf("Split() error = %v", err)
+			}
+			if len(parts) != len(tt.pages) {
+				t.Fatalf("Split() returned %d parts, want %d", len(parts), len(tt.pages))
+			}
+			for i, part := range parts {
+				path := filepath.Join(tt.opts.OutputDir, part.Filename)
+				if got := pageCount(t, path); got != tt.pages[i] {
+					t.Errorf("part %d has %d pages, want %d", i+1, got, tt.pages[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// internal/pdfops/textedit_test.go
+package pdfops
+
+import (
+	"testing"
+)
+
+func TestGroupTextIntoLines(t *testing.T) {
+	texts := []TextBlock{
+		{Text: "world", X0: 60, Y0: 101, X1: 90, Y1: 113},
+		{Text: "second", X0: 10, Y0: 130, X1: 50, Y1: 142},
+		{Text: "Hello", X0: 10, Y0: 100, X1: 40, Y1: 112},
+	}
+
+	lines := groupTextIntoLines(texts)
+	if len(lines) != 2 {
+		t.Fatalf("groupTextIntoLines() returned %d lines, want 2", len(lines))
+	}
+
+	first := lines[0]
+	if len(first.Blocks) != 2 || first.Blocks[0].Text != "Hello" || first.Blocks[1].Text != "world" {
+		t.Errorf("first line = %+v, want Hello then world", first.Blocks)
+	}
+	if first.MinX != 10 || first.MaxX != 90 {
+		t.Errorf("first line spans %v-%v, want 10-90", first.MinX, first.MaxX)
+	}
+	if lines[1].Blocks[0].Text != "second" {
+		t.Errorf("second line = %+v", lines[1].Blocks)
+	}
+}
+
+func TestImproveLineSpacing(t *testing.T) {
+	tests := []struct {
+		name string
+		gap  float64
+		want float64
+	}{
+		// Blocks of 5 characters 30 points wide average 6 points per
+		// character, so the standard space is 2.1 points
+		{"wide gap is narrowed", 40, 2.1},
+		{"narrow gap is widened", 0.5, 2.1},
+		{"overlap is separated", -4, 2.1},
+		{"normal gap is kept", 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := textLine{Blocks: []TextBlock{
+				{Text: "Hello", X0: 10, X1: 40},
+				{Text: "world", X0: 40 + tt.gap, X1: 70 + tt.gap},
+				{Text: "again", X0: 80 + tt.gap, X1: 110 + tt.gap},
+			}}
+			improveLineSpacing(&line)
+
+			gap := line.Blocks[1].X0 - line.Blocks[0].X1
+			if diff := gap - tt.want; diff > 0.001 || diff < -0.001 {
+				t.Errorf("gap = %v, want %v", gap, tt.want)
+			}
+			// Following blocks move with the adjusted one
+			if got := line.Blocks[2].X0 - line.Blocks[1].X1; got < 9.999 || got > 10.001 {
+				t.Errorf("gap to the next block = %v, want 10", got)
+			}
+		})
+	}
+}
+
+func TestBlocksOverlap(t *testing.T) {
+	base := TextBlock{X0: 10, Y0: 10, X1: 50, Y1: 20}
+	tests := []struct {
+		name  string
+		block TextBlock
+		want  bool
+	}{
+		{"overlapping", TextBlock{X0: 40, Y0: 15, X1: 80, Y1: 25}, true},
+		{"inside", TextBlock{X0: 20, Y0: 12, X1: 30, Y1: 18}, true},
+		{"touching", TextBlock{X0: 50, Y0: 10, X1: 80, Y1: 20}, false},
+		{"right", TextBlock{X0: 60, Y0: 10, X1: 80, Y1: 20}, false},
+		{"below", TextBlock{X0: 10, Y0: 30, X1: 50, Y1: 40}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blocksOverlap(base, tt.block); got != tt.want {
+				t.Errorf("blocksOverlap() = %v, want %v", got, tt.want)
+			}
+			if got := blocksOverlap(tt.block, base); got != tt.want {
+				t.Errorf("blocksOverlap() reversed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeAndImproveSpacing(t *testing.T) {
+	page := TextPage{Texts: []TextBlock{
+		{Text: "Hello", X0: 10, Y0: 100, X1: 40, Y1: 112},
+		{Text: "world", X0: 200, Y0: 100, X1: 230, Y1: 112},
+		{Text: "Alone", X0: 300, Y0: 300, X1: 330, Y1: 312},
+	}}
+	analyzeAndImproveSpacing(&page)
+
+	if got := page.Texts[1].X0; got < 42.099 || got > 42.101 {
+		t.Errorf("second block starts at %v, want 42.1", got)
+	}
+	if page.Texts[2].X0 != 300 {
+		t.Errorf("block on its own line moved to %v", page.Texts[2].X0)
+	}
+}
@@ -0,0 +1,273 @@
+// internal/pdfops/watermark.go
+package pdfops
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watermarkTimeout bounds a single watermark run, which can be slow for image watermarks
+const watermarkTimeout = 300 * time.Second
+
+// Watermark types
+const (
+	WatermarkText  = "text"
+	WatermarkImage = "image"
+	WatermarkPDF   = "pdf"
+)
+
+// WatermarkOptions configures Watermark
+type WatermarkOptions struct {
+	// Type is WatermarkText, WatermarkImage or WatermarkPDF
+	Type string
+	// Content is the text for text watermarks, otherwise the path of the image or PDF
+	Content string
+	// Image is the image or PDF of the watermark when Content is empty. It is
+	// written to a temporary file next to the output for the run.
+	Image []byte
+	// ImageExt is the extension of Image, e.g. ".png"
+	ImageExt string
+	// Position is a name (center, top-left, ...) or pdfcpu code (c, tl, ...); defaults to center
+	Position string
+	// Rotation in degrees, counter-clockwise as shown in the UI
+	Rotation int
+	// Opacity and Scale are percentages
+	Opacity int
+	Scale   int
+	// TextColor is a #RRGGBB color; defaults to gray
+	TextColor string
+	// Pages selects the pages ("even", "odd" or ranges like "1-3,5"); empty or "all" watermarks every page
+	Pages string
+}
+
+// WatermarkFields holds the fields of a watermark request as sent, e.g. in a
+// form. Empty fields take their defaults.
+type WatermarkFields struct {
+	// Type is text, image or pdf; defaults to text
+	Type string
+	// Content is the text of a text watermark, or a base64 image (optionally
+	// a data URL) of an image watermark
+	Content string
+	// Text is the text of a text watermark when Content is empty
+	Text string
+	// Image is an uploaded image or PDF named ImageName
+	Image     io.Reader
+	ImageName string
+	// ImagePath is the path of an image or PDF on disk
+	ImagePath string
+	// Position defaults to center
+	Position string
+	// Rotation in degrees defaults to 0
+	Rotation string
+	// Opacity (10-100) defaults to 50 and Scale (10-200) to 100, also when out of range
+	Opacity string
+	Scale   string
+	// TextColor defaults to #808080
+	TextColor string
+	// Pages is all, even, odd or custom, which watermarks CustomPages; defaults to all
+	Pages       string
+	CustomPages string
+}
+
+// NewWatermarkOptions validates the fields of a watermark request and
+// applies their defaults
+func NewWatermarkOptions(fields WatermarkFields) (WatermarkOptions, error) {
+	opts := WatermarkOptions{
+		Type:      fields.Type,
+		Position:  fields.Position,
+		Rotation:  intField(fields.Rotation, 0),
+		Opacity:   intField(fields.Opacity, 50),
+		Scale:     intField(fields.Scale, 100),
+		TextColor: fields.TextColor,
+		Pages:     fields.Pages,
+	}
+	if opts.Type == "" {
+		opts.Type = WatermarkText
+	}
+	if opts.Position == "" {
+		opts.Position = "center"
+	}
+	if opts.Opacity < 10 || opts.Opacity > 100 {
+		opts.Opacity = 50
+	}
+	if opts.Scale < 10 || opts.Scale > 200 {
+		opts.Scale = 100
+	}
+	if opts.TextColor == "" {
+		opts.TextColor = "#808080"
+	}
+	switch opts.Pages {
+	case "":
+		opts.Pages = "all"
+	case "custom":
+		if fields.CustomPages == "" {
+			return opts, inputErrorf("custom pages are required when pages is custom")
+		}
+		opts.Pages = fields.CustomPages
+	}
+
+	switch opts.Type {
+	case WatermarkText:
+		opts.Content = fields.Content
+		if opts.Content == "" {
+			opts.Content = fields.Text
+		}
+		if opts.Content == "" {
+			return opts, inputErrorf("text is required for text watermarks")
+		}
+	case WatermarkImage, WatermarkPDF:
+		switch {
+		case fields.Image != nil:
+			image, err := io.ReadAll(fields.Image)
+			if err != nil {
+				return opts, fmt.Errorf("failed to read watermark %s: %w", opts.Type, err)
+			}
+			opts.Image = image
+			opts.ImageExt = filepath.Ext(fields.ImageName)
+		case fields.ImagePath != "":
+			opts.Content = fields.ImagePath
+		case fields.Content != "":
+			data := fields.Content
+			if i := strings.Index(data, ";base64,"); i > 0 {
+				data = data[i+len(";base64,"):]
+			}
+			image, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return opts, inputErrorf("invalid image data: %v", err)
+			}
+			opts.Image = image
+			opts.ImageExt = ".png"
+			if opts.Type == WatermarkPDF {
+				opts.ImageExt = ".pdf"
+			}
+		default:
+			if opts.Type == WatermarkPDF {
+				return opts, inputErrorf("a PDF is required for pdf watermarks")
+			}
+			return opts, inputErrorf("an image is required for image watermarks")
+		}
+	default:
+		return opts, inputErrorf("invalid watermark type %q, must be text, image or pdf", opts.Type)
+	}
+
+	return opts, nil
+}
+
+// intField parses a numeric field, returning def when it is empty or invalid
+func intField(value string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// watermarkPositions maps position names to pdfcpu position codes
+var watermarkPositions = map[string]string{
+	"center":        "c",
+	"top-left":      "tl",
+	"top-center":    "tc",
+	"top-right":     "tr",
+	"left":          "l",
+	"right":         "r",
+	"bottom-left":   "bl",
+	"bottom-center": "bc",
+	"bottom-right":  "br",
+}
+
+// Watermark writes a watermarked copy of in to out
+func Watermark(ctx context.Context, in, out string, opts WatermarkOptions) error {
+	if opts.Type != WatermarkText && opts.Type != WatermarkImage && opts.Type != WatermarkPDF {
+		return inputErrorf("invalid watermark type %q", opts.Type)
+	}
+	if opts.Content == "" && len(opts.Image) > 0 {
+		image, err := os.CreateTemp(filepath.Dir(out), "watermark-*"+opts.ImageExt)
+		if err != nil {
+			return fmt.Errorf("failed to save watermark %s: %w", opts.Type, err)
+		}
+		defer os.Remove(image.Name())
+		_, err = image.Write(opts.Image)
+		if closeErr := image.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save watermark %s: %w", opts.Type, err)
+		}
+		opts.Content = image.Name()
+	}
+	if opts.Content == "" {
+		return inputErrorf("%s watermark requires content", opts.Type)
+	}
+
+	return addWatermark(ctx, in, out, opts.Type, opts.Content, watermarkDescription(opts), opts.Pages)
+}
+
+// addWatermark runs pdfcpu watermark add and checks that it produced a file
+func addWatermark(ctx context.Context, in, out, mode, content, description, pages string) error {
+	ctx, cancel := context.WithTimeout(ctx, watermarkTimeout)
+	defer cancel()
+
+	args := []string{"watermark", "add", "-mode", mode}
+	if pages != "" && pages != "all" {
+		args = append(args, "-pages", pages)
+	}
+
+	// The order is crucial: content, description, input, output
+	args = append(args, "--", content, description, in, out)
+
+	if _, err := runPdfcpu(ctx, args...); err != nil {
+		return err
+	}
+
+	if !fileHasContent(out, 0) {
+		return ErrEmptyOutput
+	}
+
+	return nil
+}
+
+// watermarkDescription builds the pdfcpu description string for the options
+func watermarkDescription(opts WatermarkOptions) string {
+	position := watermarkPosition(opts.Position)
+
+	// Convert #RRGGBB to R G B values between 0 and 1, default gray
+	color := "0.5 0.5 0.5"
+	if len(opts.TextColor) >= 7 {
+		r, _ := strconv.ParseInt(opts.TextColor[1:3], 16, 0)
+		g, _ := strconv.ParseInt(opts.TextColor[3:5], 16, 0)
+		b, _ := strconv.ParseInt(opts.TextColor[5:7], 16, 0)
+		color = fmt.Sprintf("%.1f %.1f %.1f", float64(r)/255.0, float64(g)/255.0, float64(b)/255.0)
+	}
+
+	// pdfcpu rotates in the opposite direction from what the UI shows
+	rotation := -opts.Rotation
+
+	return fmt.Sprintf("pos:%s, color:%s, op:%.1f, rot:%d, scale:%.1f",
+		position,
+		color,
+		float64(opts.Opacity)/100.0,
+		rotation,
+		float64(opts.Scale)/100.0)
+}
+
+// watermarkPosition maps a position name or code to a pdfcpu position code
+func watermarkPosition(position string) string {
+	if code, ok := watermarkPositions[position]; ok {
+		return code
+	}
+	for _, code := range watermarkPositions {
+		if code == position {
+			return code
+		}
+	}
+	log.Printf("Warning: Unrecognized position value '%s', defaulting to center", position)
+	return "c"
+}
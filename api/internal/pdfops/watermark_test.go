@@ -0,0 +1,159 @@
+// internal/pdfops/watermark_test.go
+package pdfops
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewWatermarkOptions(t *testing.T) {
+	header := []byte("\x89PNG\r\n\x1a\n")
+	encoded := base64.StdEncoding.EncodeToString(header)
+
+	tests := []struct {
+		name   string
+		fields WatermarkFields
+		want   WatermarkOptions
+	}{
+		{
+			"text defaults",
+			WatermarkFields{Text: "DRAFT"},
+			WatermarkOptions{Type: WatermarkText, Content: "DRAFT", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+		{
+			"content before text",
+			WatermarkFields{Type: "text", Content: "CONFIDENTIAL", Text: "DRAFT", Position: "tl", Rotation: "45", Opacity: "30", Scale: "150", TextColor: "#ff0000", Pages: "odd"},
+			WatermarkOptions{Type: WatermarkText, Content: "CONFIDENTIAL", Position: "tl", Rotation: 45, Opacity: 30, Scale: 150, TextColor: "#ff0000", Pages: "odd"},
+		},
+		{
+			"out of range values take the defaults",
+			WatermarkFields{Text: "DRAFT", Rotation: "x", Opacity: "5", Scale: "500"},
+			WatermarkOptions{Type: WatermarkText, Content: "DRAFT", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+		{
+			"custom pages",
+			WatermarkFields{Text: "DRAFT", Pages: "custom", CustomPages: "1-3,5"},
+			WatermarkOptions{Type: WatermarkText, Content: "DRAFT", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "1-3,5"},
+		},
+		{
+			"uploaded image",
+			WatermarkFields{Type: "image", Image: bytes.NewReader(header), ImageName: "logo.jpg"},
+			WatermarkOptions{Type: WatermarkImage, Image: header, ImageExt: ".jpg", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+		{
+			"image on disk",
+			WatermarkFields{Type: "image", ImagePath: "logo.png"},
+			WatermarkOptions{Type: WatermarkImage, Content: "logo.png", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+		{
+			"base64 image",
+			WatermarkFields{Type: "image", Content: encoded},
+			WatermarkOptions{Type: WatermarkImage, Image: header, ImageExt: ".png", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+		{
+			"data URL",
+			WatermarkFields{Type: "image", Content: "data:image/png;base64," + encoded},
+			WatermarkOptions{Type: WatermarkImage, Image: header, ImageExt: ".png", Position: "center", Opacity: 50, Scale: 100, TextColor: "#808080", Pages: "all"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewWatermarkOptions(tt.fields)
+			if err != nil {
+				t.Fatalf("NewWatermarkOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewWatermarkOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWatermarkOptionsInvalid(t *testing.T) {
+	for _, fields := range []WatermarkFields{
+		{Type: "stamp", Text: "DRAFT"},
+		{Type: "text"},
+		{Type: "image"},
+		{Type: "pdf"},
+		{Type: "image", Content: "not base64!"},
+		{Text: "DRAFT", Pages: "custom"},
+	} {
+		if _, err := NewWatermarkOptions(fields); !IsInputError(err) {
+			t.Errorf("NewWatermarkOptions(%+v) error = %v, want an input error", fields, err)
+		}
+	}
+}
+
+func TestWatermarkDescription(t *testing.T) {
+	opts := WatermarkOptions{Position: "top-right", Rotation: 45, Opacity: 30, Scale: 50, TextColor: "#ff0000"}
+	want := "pos:tr, color:1.0 0.0 0.0, op:0.3, rot:-45, scale:0.5"
+	if got := watermarkDescription(opts); got != want {
+		t.Errorf("watermarkDescription() = %q, want %q", got, want)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	var logoPNG bytes.Buffer
+	logo := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	if err := png.Encode(&logoPNG, logo); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts WatermarkOptions
+	}{
+		{"text", WatermarkOptions{Type: WatermarkText, Content: "DRAFT", Position: "center", Opacity: 50, Scale: 100, TextColor: "#ff0000", Pages: "all"}},
+		{"odd pages", WatermarkOptions{Type: WatermarkText, Content: "DRAFT", Position: "top-left", Rotation: 45, Opacity: 30, Scale: 50, Pages: "odd"}},
+		{"image", WatermarkOptions{Type: WatermarkImage, Image: logoPNG.Bytes(), ImageExt: ".png", Position: "bottom-right", Opacity: 80, Scale: 20}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			out := filepath.Join(dir, "watermarked.pdf")
+			if err := Watermark(context.Background(), fixture("three-pages.pdf"), out, tt.opts); err != nil {
+				t.Fatalf("Watermark() error = %v", err)
+			}
+			if got := pageCount(t, out); got != 3 {
+				t.Errorf("watermarked PDF has %d pages, want 3", got)
+			}
+			if getFileSize(out) == getFileSize(fixture("three-pages.pdf")) {
+				t.Error("watermarked PDF is the same size as the input")
+			}
+
+			// The image is written to a temporary file that is removed again
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("output directory has %d files, want only the output", len(entries))
+			}
+		})
+	}
+}
+
+func TestWatermarkInvalidOptions(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "watermarked.pdf")
+	for _, opts := range []WatermarkOptions{
+		{Type: "stamp", Content: "DRAFT"},
+		{Type: WatermarkText},
+		{Type: WatermarkImage},
+	} {
+		if err := Watermark(context.Background(), fixture("one-page.pdf"), out, opts); !IsInputError(err) {
+			t.Errorf("Watermark(%+v) error = %v, want an input error", opts, err)
+		}
+	}
+}
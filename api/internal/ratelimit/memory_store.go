@@ -0,0 +1,58 @@
+// internal/ratelimit/memory_store.go
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryWindow is a counter that expires at reset
+type memoryWindow struct {
+	count int64
+	reset time.Time
+}
+
+// MemoryStore keeps the counters in process memory. Limits are enforced per
+// API instance; use RedisStore to share them between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows:   make(map[string]*memoryWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment implements Store
+func (s *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired windows are dropped once a minute so the map does not grow
+	// with every caller ever seen
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, w := range s.windows {
+			if !now.Before(w.reset) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, exists := s.windows[key]
+	if !exists || !now.Before(w.reset) {
+		w = &memoryWindow{reset: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+
+	return w.count, w.reset, nil
+}
@@ -0,0 +1,38 @@
+// internal/ratelimit/memory_store_test.go
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	start := time.Now()
+	for want := int64(1); want <= 3; want++ {
+		count, reset, err := store.Increment(ctx, "rl:key:1", time.Minute)
+		if err != nil {
+			t.Fatalf("Increment() error = %v", err)
+		}
+		if count != want {
+			t.Errorf("Increment() count = %d, want %d", count, want)
+		}
+		if reset.Before(start.Add(time.Minute)) || reset.After(time.Now().Add(time.Minute)) {
+			t.Errorf("Increment() reset = %v, want a minute after the first hit", reset)
+		}
+	}
+
+	if count, _, _ := store.Increment(ctx, "rl:key:2", time.Minute); count != 1 {
+		t.Errorf("Increment() of another key count = %d, want 1", count)
+	}
+
+	// An expired window starts over
+	count, _, _ := store.Increment(ctx, "rl:short", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if count, _, _ = store.Increment(ctx, "rl:short", time.Millisecond); count != 1 {
+		t.Errorf("Increment() after the window expired count = %d, want 1", count)
+	}
+}
@@ -0,0 +1,125 @@
+// internal/ratelimit/ratelimit.go
+
+// Package ratelimit implements fixed window rate limits and daily quotas on
+// top of a pluggable counter store.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store counts hits in expiring windows. Implementations must be safe for
+// concurrent use; a shared store (e.g. Redis) lets several API instances
+// enforce the same limits.
+type Store interface {
+	// Increment adds a hit to the window identified by key, creating the
+	// window with the given length if it does not exist, and returns the
+	// number of hits in the window and when the window resets.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+}
+
+// Policy is the limit that applies to a caller
+type Policy struct {
+	Requests   int           // Requests allowed per Period, 0 for no limit
+	Period     time.Duration // Length of the rate limit window
+	DailyQuota int           // Requests allowed per UTC day, 0 for no quota
+}
+
+// Decision is the outcome of a rate limit check
+type Decision struct {
+	Allowed bool
+	// QuotaExceeded is set when the request was rejected by the daily quota
+	// rather than by the rate limit window
+	QuotaExceeded bool
+	// Limit, Remaining and Reset describe the most restrictive window
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	// Policy is the RateLimit-Policy header value, e.g. "100;w=60, 5000;w=86400"
+	Policy string
+
+	exceeded bool // The reported window is exceeded
+}
+
+// Limiter applies policies using a Store
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewLimiter creates a limiter backed by the given store
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow records a request by the identity (e.g. "key:<id>") and reports
+// whether it is within the policy
+func (l *Limiter) Allow(ctx context.Context, identity string, policy Policy) (*Decision, error) {
+	decision := &Decision{Allowed: true, Remaining: -1}
+	var policies []string
+
+	if policy.Requests > 0 && policy.Period > 0 {
+		count, reset, err := l.store.Increment(ctx, "rl:"+identity, policy.Period)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit store: %w", err)
+		}
+		decision.apply(policy.Requests, count, reset)
+		policies = append(policies, fmt.Sprintf("%d;w=%d", policy.Requests, int(policy.Period.Seconds())))
+	}
+
+	// Requests rejected by the rate limit do not use up the daily quota
+	if policy.DailyQuota > 0 && decision.Allowed {
+		now := l.now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		key := fmt.Sprintf("quota:%s:%s", identity, now.Format("20060102"))
+		count, reset, err := l.store.Increment(ctx, key, midnight.Sub(now))
+		if err != nil {
+			return nil, fmt.Errorf("rate limit store: %w", err)
+		}
+		if count > int64(policy.DailyQuota) {
+			decision.QuotaExceeded = true
+		}
+		decision.apply(policy.DailyQuota, count, reset)
+	}
+	if policy.DailyQuota > 0 {
+		policies = append(policies, fmt.Sprintf("%d;w=86400", policy.DailyQuota))
+	}
+
+	decision.Policy = strings.Join(policies, ", ")
+	return decision, nil
+}
+
+// apply merges a window into the decision. A rejected window takes precedence
+// (the latest reset if several are rejected) so Reset tells when to retry;
+// otherwise the window with the fewest remaining requests is reported.
+func (d *Decision) apply(limit int, count int64, reset time.Time) {
+	exceeded := count > int64(limit)
+	remaining := limit - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if exceeded {
+		d.Allowed = false
+	}
+
+	var replace bool
+	switch {
+	case d.Remaining < 0:
+		replace = true
+	case exceeded != d.exceeded:
+		replace = exceeded
+	case exceeded:
+		replace = reset.After(d.Reset)
+	default:
+		replace = remaining < d.Remaining
+	}
+	if replace {
+		d.Limit = limit
+		d.Remaining = remaining
+		d.Reset = reset
+		d.exceeded = exceeded
+	}
+}
@@ -0,0 +1,306 @@
+// internal/ratelimit/ratelimit_test.go
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStore counts hits without expiring them and reports the reset time
+// configured for each key
+type fakeStore struct {
+	counts  map[string]int64
+	windows map[string]time.Duration
+	resets  map[string]time.Time
+	err     error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		counts:  make(map[string]int64),
+		windows: make(map[string]time.Duration),
+		resets:  make(map[string]time.Time),
+	}
+}
+
+func (s *fakeStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	if s.err != nil {
+		return 0, time.Time{}, s.err
+	}
+	s.counts[key]++
+	s.windows[key] = window
+	return s.counts[key], s.resets[key], nil
+}
+
+func TestDecisionApply(t *testing.T) {
+	early := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	type window struct {
+		limit int
+		count int64
+		reset time.Time
+	}
+	tests := []struct {
+		name          string
+		windows       []window
+		wantAllowed   bool
+		wantLimit     int
+		wantRemaining int
+		wantReset     time.Time
+	}{
+		{
+			name:          "single window",
+			windows:       []window{{10, 3, early}},
+			wantAllowed:   true,
+			wantLimit:     10,
+			wantRemaining: 7,
+			wantReset:     early,
+		},
+		{
+			name:          "fewest remaining requests",
+			windows:       []window{{10, 3, early}, {100, 95, late}},
+			wantAllowed:   true,
+			wantLimit:     100,
+			wantRemaining: 5,
+			wantReset:     late,
+		},
+		{
+			name:          "more remaining requests keep the first window",
+			windows:       []window{{100, 95, late}, {10, 3, early}},
+			wantAllowed:   true,
+			wantLimit:     100,
+			wantRemaining: 5,
+			wantReset:     late,
+		},
+		{
+			name:          "window at its limit is not exceeded",
+			windows:       []window{{10, 10, early}},
+			wantAllowed:   true,
+			wantLimit:     10,
+			wantRemaining: 0,
+			wantReset:     early,
+		},
+		{
+			name:          "exceeded window has no negative remaining",
+			windows:       []window{{10, 12, early}},
+			wantAllowed:   false,
+			wantLimit:     10,
+			wantRemaining: 0,
+			wantReset:     early,
+		},
+		{
+			name:          "exceeded window replaces a window with fewer remaining requests",
+			windows:       []window{{100, 100, late}, {10, 11, early}},
+			wantAllowed:   false,
+			wantLimit:     10,
+			wantRemaining: 0,
+			wantReset:     early,
+		},
+		{
+			name:          "window within its limit does not replace an exceeded one",
+			windows:       []window{{10, 11, early}, {100, 100, late}},
+			wantAllowed:   false,
+			wantLimit:     10,
+			wantRemaining: 0,
+			wantReset:     early,
+		},
+		{
+			name:          "latest reset of exceeded windows",
+			windows:       []window{{10, 11, early}, {100, 101, late}},
+			wantAllowed:   false,
+			wantLimit:     100,
+			wantRemaining: 0,
+			wantReset:     late,
+		},
+		{
+			name:          "earlier reset of exceeded windows is ignored",
+			windows:       []window{{100, 101, late}, {10, 11, early}},
+			wantAllowed:   false,
+			wantLimit:     100,
+			wantRemaining: 0,
+			wantReset:     late,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := &Decision{Allowed: true, Remaining: -1}
+			for _, w := range tt.windows {
+				decision.apply(w.limit, w.count, w.reset)
+			}
+			if decision.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", decision.Allowed, tt.wantAllowed)
+			}
+			if decision.Limit != tt.wantLimit || decision.Remaining != tt.wantRemaining || !decision.Reset.Equal(tt.wantReset) {
+				t.Errorf("window = %d/%d reset %v, want %d/%d reset %v",
+					decision.Remaining, decision.Limit, decision.Reset, tt.wantRemaining, tt.wantLimit, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2026, 3, 14, 22, 0, 0, 0, time.UTC)
+	midnight := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	windowReset := now.Add(time.Minute)
+	quotaKey := "quota:key:1:20260314"
+
+	tests := []struct {
+		name              string
+		policy            Policy
+		requests          int
+		wantAllowed       bool
+		wantQuotaExceeded bool
+		wantLimit         int
+		wantRemaining     int
+		wantReset         time.Time
+		wantPolicy        string
+		wantQuotaCount    int64
+	}{
+		{
+			name:          "no limits",
+			policy:        Policy{},
+			requests:      5,
+			wantAllowed:   true,
+			wantRemaining: -1,
+		},
+		{
+			name:          "within the rate limit",
+			policy:        Policy{Requests: 3, Period: time.Minute},
+			requests:      2,
+			wantAllowed:   true,
+			wantLimit:     3,
+			wantRemaining: 1,
+			wantReset:     windowReset,
+			wantPolicy:    "3;w=60",
+		},
+		{
+			name:          "over the rate limit",
+			policy:        Policy{Requests: 3, Period: time.Minute},
+			requests:      4,
+			wantAllowed:   false,
+			wantLimit:     3,
+			wantRemaining: 0,
+			wantReset:     windowReset,
+			wantPolicy:    "3;w=60",
+		},
+		{
+			name:           "within the daily quota",
+			policy:         Policy{DailyQuota: 5},
+			requests:       2,
+			wantAllowed:    true,
+			wantLimit:      5,
+			wantRemaining:  3,
+			wantReset:      midnight,
+			wantPolicy:     "5;w=86400",
+			wantQuotaCount: 2,
+		},
+		{
+			name:              "over the daily quota",
+			policy:            Policy{DailyQuota: 5},
+			requests:          6,
+			wantAllowed:       false,
+			wantQuotaExceeded: true,
+			wantLimit:         5,
+			wantRemaining:     0,
+			wantReset:         midnight,
+			wantPolicy:        "5;w=86400",
+			wantQuotaCount:    6,
+		},
+		{
+			name:           "rate window with fewer remaining requests than the quota",
+			policy:         Policy{Requests: 3, Period: time.Minute, DailyQuota: 50},
+			requests:       2,
+			wantAllowed:    true,
+			wantLimit:      3,
+			wantRemaining:  1,
+			wantReset:      windowReset,
+			wantPolicy:     "3;w=60, 50;w=86400",
+			wantQuotaCount: 2,
+		},
+		{
+			name:           "quota with fewer remaining requests than the rate window",
+			policy:         Policy{Requests: 100, Period: time.Minute, DailyQuota: 5},
+			requests:       4,
+			wantAllowed:    true,
+			wantLimit:      5,
+			wantRemaining:  1,
+			wantReset:      midnight,
+			wantPolicy:     "100;w=60, 5;w=86400",
+			wantQuotaCount: 4,
+		},
+		{
+			name:           "requests over the rate limit do not use up the quota",
+			policy:         Policy{Requests: 3, Period: time.Minute, DailyQuota: 50},
+			requests:       5,
+			wantAllowed:    false,
+			wantLimit:      3,
+			wantRemaining:  0,
+			wantReset:      windowReset,
+			wantPolicy:     "3;w=60, 50;w=86400",
+			wantQuotaCount: 3,
+		},
+		{
+			name:              "quota exceeded before the rate limit",
+			policy:            Policy{Requests: 100, Period: time.Minute, DailyQuota: 2},
+			requests:          3,
+			wantAllowed:       false,
+			wantQuotaExceeded: true,
+			wantLimit:         2,
+			wantRemaining:     0,
+			wantReset:         midnight,
+			wantPolicy:        "100;w=60, 2;w=86400",
+			wantQuotaCount:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.resets["rl:key:1"] = windowReset
+			store.resets[quotaKey] = midnight
+			limiter := NewLimiter(store)
+			limiter.now = func() time.Time { return now }
+
+			var decision *Decision
+			for i := 0; i < tt.requests; i++ {
+				var err error
+				if decision, err = limiter.Allow(context.Background(), "key:1", tt.policy); err != nil {
+					t.Fatalf("Allow() error = %v", err)
+				}
+			}
+
+			if decision.Allowed != tt.wantAllowed || decision.QuotaExceeded != tt.wantQuotaExceeded {
+				t.Errorf("Allowed, QuotaExceeded = %v, %v, want %v, %v",
+					decision.Allowed, decision.QuotaExceeded, tt.wantAllowed, tt.wantQuotaExceeded)
+			}
+			if decision.Limit != tt.wantLimit || decision.Remaining != tt.wantRemaining || !decision.Reset.Equal(tt.wantReset) {
+				t.Errorf("window = %d/%d reset %v, want %d/%d reset %v",
+					decision.Remaining, decision.Limit, decision.Reset, tt.wantRemaining, tt.wantLimit, tt.wantReset)
+			}
+			if decision.Policy != tt.wantPolicy {
+				t.Errorf("Policy = %q, want %q", decision.Policy, tt.wantPolicy)
+			}
+			if store.counts[quotaKey] != tt.wantQuotaCount {
+				t.Errorf("quota counted %d requests, want %d", store.counts[quotaKey], tt.wantQuotaCount)
+			}
+			if tt.policy.DailyQuota > 0 && store.windows[quotaKey] != midnight.Sub(now) {
+				t.Errorf("quota window = %v, want until midnight UTC", store.windows[quotaKey])
+			}
+		})
+	}
+}
+
+func TestLimiterAllowStoreError(t *testing.T) {
+	store := newFakeStore()
+	store.err = errors.New("connection refused")
+
+	limiter := NewLimiter(store)
+	if _, err := limiter.Allow(context.Background(), "ip:192.0.2.1", Policy{Requests: 10, Period: time.Minute}); !errors.Is(err, store.err) {
+		t.Errorf("Allow() error = %v, want the store error", err)
+	}
+	if _, err := limiter.Allow(context.Background(), "ip:192.0.2.1", Policy{DailyQuota: 10}); !errors.Is(err, store.err) {
+		t.Errorf("Allow() with a quota error = %v, want the store error", err)
+	}
+}
@@ -0,0 +1,249 @@
+// internal/ratelimit/redis_store.go
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisPoolSize is the number of idle connections kept by a RedisStore
+const redisPoolSize = 8
+
+// RedisStore keeps the counters in Redis or any server speaking the Redis
+// protocol (KeyDB, Dragonfly, Valkey, ...), so all API instances share them.
+// It only needs SET, INCR and PTTL and talks RESP directly.
+type RedisStore struct {
+	addr     string
+	username string
+	password string
+	database int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+// redisConn is a connection with its buffered reader
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore creates a store from a URL like redis://:password@host:6379/0.
+// TLS (rediss://) is not supported.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if parsed.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis URL scheme %q", parsed.Scheme)
+	}
+
+	store := &RedisStore{
+		addr:    parsed.Host,
+		timeout: 2 * time.Second,
+		idle:    make(chan *redisConn, redisPoolSize),
+	}
+	if parsed.Port() == "" {
+		store.addr = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if parsed.User != nil {
+		store.username = parsed.User.Username()
+		store.password, _ = parsed.User.Password()
+	}
+	if path := strings.Trim(parsed.Path, "/"); path != "" {
+		if store.database, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", path)
+		}
+	}
+
+	return store, nil
+}
+
+// Increment implements Store. The window is created with SET NX so the first
+// hit sets the expiry and concurrent callers never reset it.
+func (s *RedisStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	replies, err := s.pipeline(ctx,
+		[]string{"SET", key, "0", "PX", strconv.FormatInt(window.Milliseconds(), 10), "NX"},
+		[]string{"INCR", key},
+		[]string{"PTTL", key},
+	)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	count, ok := replies[1].(int64)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("unexpected INCR reply %v", replies[1])
+	}
+	ttl, ok := replies[2].(int64)
+	if !ok || ttl < 0 {
+		// The key has no expiry (e.g. it was created by another client);
+		// treat the window as just started
+		ttl = window.Milliseconds()
+	}
+
+	return count, time.Now().Add(time.Duration(ttl) * time.Millisecond), nil
+}
+
+// pipeline sends the commands in one round trip and returns their replies
+func (s *RedisStore) pipeline(ctx context.Context, commands ...[]string) ([]interface{}, error) {
+	conn, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.conn.SetDeadline(deadline)
+
+	replies, err := conn.do(commands...)
+	if err != nil {
+		// After an error reply the connection is still usable
+		var redisErr redisError
+		if errors.As(err, &redisErr) {
+			s.put(conn)
+		} else {
+			conn.conn.Close()
+		}
+		return nil, err
+	}
+
+	s.put(conn)
+	return replies, nil
+}
+
+// get returns an idle connection or dials a new one
+func (s *RedisStore) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	netConn.SetDeadline(time.Now().Add(s.timeout))
+
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.database != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.database)})
+	}
+	if len(setup) > 0 {
+		if _, err := conn.do(setup...); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// put returns a healthy connection to the pool
+func (s *RedisStore) put(conn *redisConn) {
+	select {
+	case s.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// do writes the commands and reads one reply per command. Error replies are
+// returned as errors.
+func (c *redisConn) do(commands ...[]string) ([]interface{}, error) {
+	var buf strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := c.conn.Write([]byte(buf.String())); err != nil {
+		return nil, fmt.Errorf("redis write failed: %w", err)
+	}
+
+	replies := make([]interface{}, len(commands))
+	var replyErr error
+	for i := range commands {
+		reply, err := c.readReply()
+		if err != nil {
+			var redisErr redisError
+			if !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			// Keep reading so the connection stays in sync
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	if replyErr != nil {
+		return nil, replyErr
+	}
+	return replies, nil
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readReply reads a simple string, error, integer or bulk string reply.
+// Null bulk strings are returned as nil.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis read failed: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, fmt.Errorf("redis read failed: %w", err)
+		}
+		return string(data[:size]), nil
+	default:
+		return nil, fmt.Errorf("redis: unsupported reply %q", line)
+	}
+}
@@ -0,0 +1,353 @@
+// internal/ratelimit/redis_store_test.go
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a RESP server implementing the commands RedisStore sends
+type fakeRedis struct {
+	addr     string
+	username string
+	password string
+
+	mu          sync.Mutex
+	values      map[string]string
+	expiries    map[string]time.Time
+	connections int
+	commands    []string
+	closeNext   bool // Close the connection instead of answering the next command
+}
+
+// newFakeRedis starts a server requiring the password, if set
+func newFakeRedis(t *testing.T, username, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeRedis{
+		addr:     listener.Addr().String(),
+		username: username,
+		password: password,
+		values:   make(map[string]string),
+		expiries: make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closeNext {
+			s.closeNext = false
+			s.mu.Unlock()
+			return
+		}
+		s.commands = append(s.commands, strings.Join(args, " "))
+		var reply string
+		if !authenticated && args[0] != "AUTH" {
+			reply = "-NOAUTH Authentication required.\r\n"
+		} else {
+			reply = s.execute(args, &authenticated)
+		}
+		s.mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command and returns its encoded reply
+func (s *fakeRedis) execute(args []string, authenticated *bool) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	if expiry, ok := s.expiries[key]; ok && !time.Now().Before(expiry) {
+		delete(s.values, key)
+		delete(s.expiries, key)
+	}
+
+	switch args[0] {
+	case "AUTH":
+		username, password := "default", args[len(args)-1]
+		if len(args) == 3 {
+			username = args[1]
+		}
+		if password != s.password || (s.username != "" && username != s.username) {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		*authenticated = true
+		return "+OK\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		if _, exists := s.values[key]; exists {
+			return "$-1\r\n"
+		}
+		s.values[key] = args[2]
+		ms, _ := strconv.Atoi(args[4])
+		s.expiries[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return "+OK\r\n"
+	case "INCR":
+		n, err := strconv.ParseInt(s.values[key], 10, 64)
+		if err != nil && s.values[key] != "" {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		s.values[key] = strconv.FormatInt(n+1, 10)
+		return fmt.Sprintf(":%d\r\n", n+1)
+	case "PTTL":
+		expiry, ok := s.expiries[key]
+		switch {
+		case s.values[key] == "":
+			return ":-2\r\n"
+		case !ok:
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(expiry).Milliseconds())
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil || line[0] != '$' {
+			return nil, fmt.Errorf("invalid bulk string %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (s *fakeRedis) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *fakeRedis) stats() (connections int, commands []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, append([]string(nil), s.commands...)
+}
+
+func TestNewRedisStore(t *testing.T) {
+	tests := []struct {
+		url          string
+		wantAddr     string
+		wantUsername string
+		wantPassword string
+		wantDatabase int
+		wantErr      bool
+	}{
+		{url: "redis://localhost", wantAddr: "localhost:6379"},
+		{url: "redis://redis.internal:6380/", wantAddr: "redis.internal:6380"},
+		{url: "redis://:secret@localhost:6379/2", wantAddr: "localhost:6379", wantPassword: "secret", wantDatabase: 2},
+		{url: "redis://megapdf:secret@[::1]/0", wantAddr: "[::1]:6379", wantUsername: "megapdf", wantPassword: "secret"},
+		{url: "rediss://localhost:6379", wantErr: true},
+		{url: "http://localhost:6379", wantErr: true},
+		{url: "redis://localhost:6379/cache", wantErr: true},
+		{url: "redis://local host", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			store, err := NewRedisStore(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRedisStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if store.addr != tt.wantAddr || store.username != tt.wantUsername ||
+				store.password != tt.wantPassword || store.database != tt.wantDatabase {
+				t.Errorf("NewRedisStore() = %s user %q password %q database %d, want %s user %q password %q database %d",
+					store.addr, store.username, store.password, store.database,
+					tt.wantAddr, tt.wantUsername, tt.wantPassword, tt.wantDatabase)
+			}
+		})
+	}
+}
+
+func TestRedisStoreIncrement(t *testing.T) {
+	server := newFakeRedis(t, "", "")
+	store, err := NewRedisStore("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	for want := int64(1); want <= 3; want++ {
+		count, reset, err := store.Increment(ctx, "rl:key:1", time.Minute)
+		if err != nil {
+			t.Fatalf("Increment() error = %v", err)
+		}
+		if count != want {
+			t.Errorf("Increment() count = %d, want %d", count, want)
+		}
+		// The window is set by the first hit only
+		if reset.Before(start.Add(time.Minute-time.Second)) || reset.After(start.Add(time.Minute+time.Second)) {
+			t.Errorf("Increment() reset = %v, want a minute after the first hit", reset)
+		}
+	}
+	if count, _, err := store.Increment(ctx, "rl:key:2", time.Minute); err != nil || count != 1 {
+		t.Errorf("Increment() of another key = %d, %v, want 1", count, err)
+	}
+
+	connections, commands := server.stats()
+	if connections != 1 {
+		t.Errorf("store opened %d connections, want 1 reused connection", connections)
+	}
+	want := []string{"SET rl:key:1 0 PX 60000 NX", "INCR rl:key:1", "PTTL rl:key:1"}
+	for i, command := range want {
+		if i >= len(commands) || commands[i] != command {
+			t.Fatalf("commands = %q, want to start with %q", commands, want)
+		}
+	}
+}
+
+func TestRedisStoreKeyWithoutExpiry(t *testing.T) {
+	server := newFakeRedis(t, "", "")
+	store, err := NewRedisStore("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key created by another client without expiry counts as a window
+	// that just started
+	server.set("rl:key:1", "5")
+	before := time.Now()
+	count, reset, err := store.Increment(context.Background(), "rl:key:1", time.Minute)
+	if err != nil {
+		t.Fatalf("Increment() error = %v", err)
+	}
+	if count != 6 {
+		t.Errorf("Increment() count = %d, want 6", count)
+	}
+	if reset.Before(before.Add(time.Minute)) || reset.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Increment() reset = %v, want a minute from now", reset)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	server := newFakeRedis(t, "", "")
+	store, err := NewRedisStore("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// An error reply fails the call but keeps the connection
+	server.set("rl:text", "not a number")
+	if _, _, err := store.Increment(ctx, "rl:text", time.Minute); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Errorf("Increment() of a text value error = %v, want the error reply", err)
+	}
+	if _, _, err := store.Increment(ctx, "rl:key:1", time.Minute); err != nil {
+		t.Errorf("Increment() after an error reply error = %v", err)
+	}
+	if connections, _ := server.stats(); connections != 1 {
+		t.Errorf("store opened %d connections, want the connection kept after an error reply", connections)
+	}
+
+	// A broken connection fails the call and is replaced by the next one
+	server.mu.Lock()
+	server.closeNext = true
+	server.mu.Unlock()
+	if _, _, err := store.Increment(ctx, "rl:key:1", time.Minute); err == nil {
+		t.Error("Increment() on a closed connection succeeded")
+	}
+	if count, _, err := store.Increment(ctx, "rl:key:1", time.Minute); err != nil || count != 2 {
+		t.Errorf("Increment() after reconnecting = %d, %v, want 2", count, err)
+	}
+	if connections, _ := server.stats(); connections != 2 {
+		t.Errorf("store opened %d connections, want 2", connections)
+	}
+
+	// Unreachable servers fail without blocking
+	unreachable, err := NewRedisStore("redis://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := unreachable.Increment(ctx, "rl:key:1", time.Minute); err == nil {
+		t.Error("Increment() of an unreachable server succeeded")
+	}
+}
+
+func TestRedisStoreAuth(t *testing.T) {
+	server := newFakeRedis(t, "megapdf", "secret")
+	ctx := context.Background()
+
+	store, err := NewRedisStore("redis://megapdf:secret@" + server.addr + "/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Increment(ctx, "rl:key:1", time.Minute); err != nil {
+		t.Fatalf("Increment() error = %v", err)
+	}
+	_, commands := server.stats()
+	if len(commands) < 2 || commands[0] != "AUTH megapdf secret" || commands[1] != "SELECT 3" {
+		t.Errorf("commands = %q, want AUTH and SELECT on the new connection", commands)
+	}
+
+	wrong, err := NewRedisStore("redis://megapdf:wrong@" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := wrong.Increment(ctx, "rl:key:1", time.Minute); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Increment() with a wrong password error = %v, want WRONGPASS", err)
+	}
+}
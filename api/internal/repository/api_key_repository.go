@@ -30,28 +30,32 @@ func (r *APIKeyRepository) GetByID(id string) (*models.ApiKey, error) {
 	return &key, nil
 }
 
-// GetByKey retrieves an API key by the key string
+// GetByKey retrieves an API key by the full key string
 func (r *APIKeyRepository) GetByKey(key string) (*models.ApiKey, error) {
-	var apiKey models.ApiKey
-	if err := db.DB.First(&apiKey, "`key` = ?", key).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil // Key not found
-		}
-		return nil, err
-	}
-	return &apiKey, nil
+	return r.findByKey(db.DB, key)
 }
 
-// GetByKeyWithUser retrieves an API key with its user by the key string
+// GetByKeyWithUser retrieves an API key with its user by the full key string
 func (r *APIKeyRepository) GetByKeyWithUser(key string) (*models.ApiKey, *models.User, error) {
-	var apiKey models.ApiKey
-	if err := db.DB.Preload("User").First(&apiKey, "`key` = ?", key).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil, nil // Key not found
-		}
+	apiKey, err := r.findByKey(db.DB.Preload("User"), key)
+	if apiKey == nil || err != nil {
 		return nil, nil, err
 	}
-	return &apiKey, &apiKey.User, nil
+	return apiKey, &apiKey.User, nil
+}
+
+// findByKey looks up the keys sharing the prefix of key and checks their hashes
+func (r *APIKeyRepository) findByKey(query *gorm.DB, key string) (*models.ApiKey, error) {
+	var candidates []models.ApiKey
+	if err := query.Where("prefix = ?", models.ApiKeyPrefix(key)).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		if models.CompareApiKey(candidates[i].KeyHash, key) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil // Key not found
 }
 
 // GetForUser retrieves all API keys for a user
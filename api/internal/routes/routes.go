@@ -3,6 +3,7 @@ package routes
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"path/filepath"
 	"reflect"
@@ -13,8 +14,10 @@ import (
 	"github.com/MegaPDF/megapdf-official/api/internal/handlers"
 	"github.com/MegaPDF/megapdf-official/api/internal/middleware"
 	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
 	"github.com/MegaPDF/megapdf-official/api/internal/repository"
 	"github.com/MegaPDF/megapdf-official/api/internal/services"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -88,10 +91,58 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 	fmt.Printf("  Email From: %s\n", cfg.EmailFrom)
 	fmt.Printf("  App URL: %s\n", cfg.AppURL)
 	fmt.Printf("  Debug Mode: %v\n", cfg.Debug)
+	// Rate limits are shared between API instances when a Redis store is configured
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore == "redis" {
+		redisStore, err := ratelimit.NewRedisStore(cfg.RedisURL)
+		if err != nil {
+			fmt.Printf("WARNING: Invalid Redis configuration, using in-memory rate limits: %v\n", err)
+		} else {
+			rateLimitStore = redisStore
+		}
+	}
+	fmt.Printf("  Rate Limit Store: %s\n", cfg.RateLimitStore)
+	rateLimitService := services.NewRateLimitService(db, rateLimitStore)
+
+	// Results are kept in a shared S3-compatible store when configured, so any
+	// API instance can serve them. A backend that cannot be built stops the
+	// server, since files stored elsewhere would be lost to other instances
+	var resultStorage storage.Storage
+	switch cfg.StorageBackend {
+	case "local":
+		resultStorage = storage.NewLocalStore(cfg.PublicDir)
+	case "s3":
+		s3Store, err := storage.NewS3Store(storage.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+		if err != nil {
+			log.Fatalf("Invalid S3 configuration: %v", err)
+		}
+		resultStorage = s3Store
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, want \"local\" or \"s3\"", cfg.StorageBackend)
+	}
+	fmt.Printf("  Storage Backend: %s\n", cfg.StorageBackend)
+	retentionService := services.NewRetentionService(db, resultStorage, cfg.UploadDir, cfg.TempDir)
+	artifactService := services.NewArtifactService(db, resultStorage, cfg.DownloadURLSecret, retentionService)
+	uploadService := services.NewUploadService(db, resultStorage, retentionService, cfg.TempDir)
+
+	// Only trusted proxies may set the client IP used by rate limits and
+	// API key IP allow-lists
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fmt.Printf("WARNING: Invalid TRUSTED_PROXIES, trusting no proxy: %v\n", err)
+		r.SetTrustedProxies(nil)
+	}
+
 	// Apply CORS middleware globally
-	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.CORSMiddleware(cfg))
 	r.Use(middleware.LoggerMiddleware())
-	r.Use(middleware.RateLimitMiddleware())
+	r.Use(middleware.RateLimitMiddleware(rateLimitService, cfg.JWTSecret))
 	r.Use(func(c *gin.Context) {
 		now := time.Now().UTC()
 		c.Set("now", map[string]interface{}{
@@ -120,42 +171,111 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 	// Initialize services
 	keyValidationService := services.NewKeyValidationService(db)
 	balanceService := services.NewBalanceService(db)
+	webSessionService := services.NewWebSessionService(cfg.WebSessionSecret, time.Duration(cfg.WebSessionTTL)*time.Second, cfg.WebSessionQuota, cfg.WebSessionIssueMax, rateLimitStore)
+	balanceService.SetWebSessions(webSessionService)
+	balanceService.SetReservationTimeout(time.Duration(cfg.ReservationTimeout) * time.Second)
 	authService := services.NewAuthService(db, cfg.JWTSecret)
 	apiKeyService := services.NewApiKeyService(db)
 	emailService := services.NewEmailService(cfg)
-	pdfHandler := handlers.NewPDFHandler(balanceService, cfg)
+	jobService := services.NewJobService(db, cfg.JobWorkers)
+	webhookService := services.NewWebhookService(db, cfg.WebhookSecret, cfg.APIUrl)
+	jobService.OnFinish(webhookService.NotifyJobFinished)
+	pdfHandler := handlers.NewPDFHandler(balanceService, resultStorage, artifactService, cfg)
+	pdfHandler.SetJobService(jobService)
 
 	// Initialize handlers
 	keyValidationHandler := handlers.NewKeyValidationHandler(keyValidationService)
-	balanceHandler := handlers.NewBalanceHandler(balanceService)
+	paypalService := services.NewPayPalService(cfg.PayPalClientID, cfg.PayPalClientSecret, cfg.PayPalAPIBase, cfg.WebAppURL)
+	paypalService.SetWebhookID(cfg.PayPalWebhookID)
+	paymentService := services.NewPaymentService(db, balanceService)
+	paymentService.RegisterProvider(paypalService)
+	if cfg.StripeSecretKey != "" {
+		paymentService.RegisterProvider(services.NewStripeService(cfg.StripeSecretKey, cfg.StripeWebhookSecret, cfg.StripeAPIBase, cfg.WebAppURL))
+	}
+	balanceHandler := handlers.NewBalanceHandler(balanceService, paymentService)
 	authHandler := handlers.NewAuthHandler(authService, cfg.JWTSecret, cfg)
 	trackUsageHandler := handlers.NewTrackUsageHandler()
 	apiKeyHandler := handlers.NewApiKeyHandler(apiKeyService)
-	fileHandler := handlers.NewFileHandler(cfg)
+	fileHandler := handlers.NewFileHandler(artifactService, cfg)
 	adminHandler := handlers.NewAdminHandler()
-	paypalWebhookHandler := handlers.NewPayPalWebhookHandler()
+	paymentWebhookHandler := handlers.NewPaymentWebhookHandler(paymentService)
 	fmt.Println("Setting email service on auth handler")
 	authHandler.SetEmailService(emailService)
 	pdfToolsHandler := handlers.NewPDFToolsHandler()
 	settingsHandler := handlers.NewSettingsHandler()
-	ocrHandler := handlers.NewOcrHandler(balanceService, cfg)
+	ocrHandler := handlers.NewOcrHandler(balanceService, artifactService, cfg)
 	toolStatusHandler := handlers.NewToolStatusHandler()
-	pdfTextEditorHandler := handlers.NewPDFTextEditorHandler(balanceService, cfg)
-	cleanupHandler := handlers.NewCleanupHandler(cfg)
+	pdfTextEditorHandler := handlers.NewPDFTextEditorHandler(balanceService, resultStorage, artifactService, cfg)
+	cleanupHandler := handlers.NewCleanupHandler(retentionService)
+	uploadHandler := handlers.NewUploadHandler(uploadService)
+	inputHandler := handlers.NewInputHandler(uploadService, artifactService, services.NewURLFetcher(time.Duration(cfg.FileURLTimeout)*time.Second), cfg)
 	oauthService := services.NewOAuthService(db, cfg.JWTSecret, cfg.GoogleClientID, cfg.GoogleClientSecret, cfg.OAuthRedirectURL)
 	oauthHandler := handlers.NewOAuthHandler(oauthService, cfg.AppURL, cfg.APIUrl)
 	signPdfHandler := handlers.NewSignPdfHandler(
 		cfg.UploadDir,
 		filepath.Join(cfg.PublicDir, "signatures"),
+		artifactService,
 	)
+	jobHandler := handlers.NewJobHandler(jobService, webhookService, resultStorage, cfg)
+	pipelineHandler := handlers.NewPipelineHandler(resultStorage, artifactService, cfg)
+	rateLimitHandler := handlers.NewRateLimitHandler(rateLimitService)
+	webSessionHandler := handlers.NewWebSessionHandler(webSessionService, cfg)
+	idempotencyService := services.NewIdempotencyService(db)
+	planService := services.NewPlanService(db)
+	planHandler := handlers.NewPlanHandler(planService, rateLimitService)
+	invoiceService := services.NewInvoiceService(db)
+	invoiceHandler := handlers.NewInvoiceHandler(invoiceService, cfg)
+	adjustmentHandler := handlers.NewAdjustmentHandler(balanceService, paymentService)
+	autoTopUpService := services.NewAutoTopUpService(db, paymentService)
+	autoTopUpService.SetEmailService(emailService)
+	balanceService.SetAutoTopUps(autoTopUpService)
+	autoTopUpHandler := handlers.NewAutoTopUpHandler(autoTopUpService)
+	spendingCapService := services.NewSpendingCapService(db)
+	spendingCapService.SetEmailService(emailService)
+	balanceService.SetSpendingCaps(spendingCapService)
+	spendingCapHandler := handlers.NewSpendingCapHandler(spendingCapService)
+	pipelineHandler.RegisterMergeStep("merge", pdfHandler.MergePDFs)
+	pipelineHandler.RegisterStep("watermark", pdfHandler.WatermarkPDF)
+	pipelineHandler.RegisterStep("pagenumber", pdfHandler.AddPageNumbersToPDF)
+	pipelineHandler.RegisterFinalStep("protect", pdfHandler.ProtectPDF)
+
+	// Every PDF operation can also be submitted as an asynchronous job
+	jobHandler.RegisterOperation("compress", pdfHandler.CompressPDF)
+	jobHandler.RegisterOperation("convert", pdfHandler.ConvertPDF)
+	jobHandler.RegisterOperation("protect", pdfHandler.ProtectPDF)
+	jobHandler.RegisterOperation("merge", pdfHandler.MergePDFs)
+	jobHandler.RegisterOperation("sign", signPdfHandler.SignPDF)
+	jobHandler.RegisterOperation("split", pdfHandler.SplitPDF)
+	jobHandler.RegisterOperation("rotate", pdfHandler.RotatePDF)
+	jobHandler.RegisterOperation("pagenumber", pdfHandler.AddPageNumbersToPDF)
+	jobHandler.RegisterOperation("remove", pdfHandler.RemovePagesFromPDF)
+	jobHandler.RegisterOperation("watermark", pdfHandler.WatermarkPDF)
+	jobHandler.RegisterOperation("unlock", pdfHandler.UnlockPDF)
+	jobHandler.RegisterOperation("extract-text", pdfTextEditorHandler.ExtractTextToPDF)
+	jobHandler.RegisterOperation("save-edited-text", pdfTextEditorHandler.SaveEditedPDF)
+	jobHandler.RegisterOperation("ocr", ocrHandler.OcrPdf)
+	jobHandler.RegisterOperation("pipeline", pipelineHandler.RunPipeline)
+	jobService.Start()
+	webhookService.Start()
+	apiKeyService.StartExpiryNotifier(emailService)
+	balanceService.StartReconciliation()
+	idempotencyService.StartCleanup()
+	planService.StartRenewals()
+	retentionService.Start()
+	invoiceService.StartStatements()
+	autoTopUpService.StartRetries()
 	api := r.Group("/api")
 	{
 		api.GET("/tools/status", toolStatusHandler.GetToolStatus)
+		fmt.Println("Registering route: /api/plans")
+		api.GET("/plans", planHandler.ListPlans)
 		fmt.Println("Registering route: /api/validate-key")
 		api.POST("/validate-key", keyValidationHandler.ValidateKey)
 		api.GET("/validate-key", keyValidationHandler.ValidateKey)
 		fmt.Println("Registering route: /api/validate-token")
-		api.POST("/webhooks/paypal", paypalWebhookHandler.HandleWebhook)
+		api.POST("/webhooks/:provider", paymentWebhookHandler.HandleWebhook)
+		fmt.Println("Registering route: /api/web-session")
+		api.POST("/web-session", webSessionHandler.CreateSession)
 		api.GET("/validate-token", func(c *gin.Context) {
 			// Get token from cookie
 			token, err := c.Cookie("authToken")
@@ -214,16 +334,50 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 		}
 
 		fmt.Println("Registering route: /api/file")
-		api.GET("/file", fileHandler.ServeFile)
+		api.GET("/file", middleware.OptionalAuthMiddleware(cfg.JWTSecret, keyValidationService, webSessionService), fileHandler.ServeFile)
 		fmt.Println("Registering route: /api/track-usage")
 		api.GET("/track-usage", middleware.AuthMiddleware(cfg.JWTSecret), trackUsageHandler.GetUsageStats)
 		api.POST("/track-usage", middleware.AuthMiddleware(cfg.JWTSecret), trackUsageHandler.TrackOperation)
 		fmt.Println("Registering route: /api/ocr")
-		api.POST("/ocr", middleware.ApiKeyMiddleware(keyValidationService), ocrHandler.OcrPdf)
+		api.POST("/ocr", middleware.ApiKeyMiddleware(keyValidationService, webSessionService), middleware.IdempotencyMiddleware(idempotencyService, cfg), inputHandler.ResolveInputs, ocrHandler.OcrPdf)
 		fmt.Println("Registering route: /api/ocr/extract")
-		api.POST("/ocr/extract", middleware.ApiKeyMiddleware(keyValidationService), ocrHandler.ExtractText)
+		api.POST("/ocr/extract", middleware.ApiKeyMiddleware(keyValidationService, webSessionService), middleware.IdempotencyMiddleware(idempotencyService, cfg), inputHandler.ResolveInputs, ocrHandler.ExtractText)
 		api.GET("/pricing", adminHandler.GetPricingSettings)
 
+		jobs := api.Group("/jobs")
+		jobs.Use(middleware.ApiKeyMiddleware(keyValidationService, webSessionService))
+		{
+			fmt.Println("Registering route: /api/jobs")
+			jobs.POST("", inputHandler.ResolveInputs, jobHandler.SubmitJob)
+
+			fmt.Println("Registering route: /api/jobs/webhook-secret")
+			jobs.GET("/webhook-secret", jobHandler.GetWebhookSecret)
+
+			fmt.Println("Registering route: /api/jobs/:id")
+			jobs.GET("/:id", jobHandler.GetJob)
+			jobs.DELETE("/:id", jobHandler.CancelJob)
+
+			fmt.Println("Registering route: /api/jobs/:id/deliveries")
+			jobs.GET("/:id/deliveries", jobHandler.GetJobDeliveries)
+		}
+
+		// Resumable uploads, whose IDs the PDF operations accept as uploadId
+		uploads := api.Group("/uploads")
+		uploads.Use(middleware.ApiKeyMiddleware(keyValidationService, webSessionService))
+		{
+			fmt.Println("Registering route: /api/uploads")
+			uploads.POST("", uploadHandler.CreateUpload)
+
+			fmt.Println("Registering route: /api/uploads/:id")
+			uploads.HEAD("/:id", uploadHandler.GetUploadOffset)
+			uploads.GET("/:id", uploadHandler.GetUpload)
+			uploads.PATCH("/:id", uploadHandler.UploadChunk)
+			uploads.DELETE("/:id", uploadHandler.AbortUpload)
+
+			fmt.Println("Registering route: /api/uploads/:id/complete")
+			uploads.POST("/:id/complete", uploadHandler.CompleteUpload)
+		}
+
 		auth := api.Group("/auth")
 		{
 			fmt.Println("Registering route: /api/auth/google")
@@ -275,9 +429,10 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 
 		pdf := api.Group("/pdf")
 		pdf.Use(middleware.PDFToolAvailabilityMiddleware())
-		pdf.Use(middleware.ApiKeyMiddleware(keyValidationService))
+		pdf.Use(middleware.ApiKeyMiddleware(keyValidationService, webSessionService))
+		pdf.Use(middleware.IdempotencyMiddleware(idempotencyService, cfg))
+		pdf.Use(inputHandler.ResolveInputs)
 		{
-			pdf.GET("/cleanup", cleanupHandler.Cleanup)
 			fmt.Println("Registering route: /api/pdf/compress")
 			pdf.POST("/compress", pdfHandler.CompressPDF)
 
@@ -314,6 +469,9 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 			fmt.Println("Registering route: /api/pdf/unlock")
 			pdf.POST("/unlock", pdfHandler.UnlockPDF)
 
+			fmt.Println("Registering route: /api/pdf/pipeline")
+			pdf.POST("/pipeline", pipelineHandler.RunPipeline)
+
 			fmt.Println("Registering route: /api/pdf/extract-text")
 			pdf.POST("/extract-text", pdfTextEditorHandler.ExtractTextToPDF)
 
@@ -332,11 +490,33 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 			user.GET("/balance", balanceHandler.GetBalance)
 
 			fmt.Println("Registering route: /api/user/deposit")
-			user.POST("/deposit", balanceHandler.CreateDeposit)
+			user.POST("/deposit", middleware.IdempotencyMiddleware(idempotencyService, cfg), balanceHandler.CreateDeposit)
 
 			fmt.Println("Registering route: /api/user/deposit/verify")
 			user.POST("/deposit/verify", balanceHandler.VerifyDeposit)
 
+			// Auto top-up routes
+			fmt.Println("Registering route: /api/user/auto-topup")
+			user.GET("/auto-topup", autoTopUpHandler.GetAutoTopUp)
+			user.PUT("/auto-topup", autoTopUpHandler.UpdateAutoTopUp)
+			user.DELETE("/auto-topup", autoTopUpHandler.DeleteAutoTopUp)
+			user.POST("/auto-topup/payment-method", autoTopUpHandler.SetupPaymentMethod)
+			user.POST("/auto-topup/payment-method/confirm", autoTopUpHandler.ConfirmPaymentMethod)
+
+			fmt.Println("Registering route: /api/user/spending-caps")
+			user.GET("/spending-caps", spendingCapHandler.GetSpendingCaps)
+			user.PUT("/spending-caps", spendingCapHandler.UpdateSpendingCaps)
+
+			fmt.Println("Registering route: /api/user/plan")
+			user.POST("/plan", planHandler.Subscribe)
+
+			// Invoice and statement routes
+			fmt.Println("Registering route: /api/user/invoices")
+			user.GET("/invoices", invoiceHandler.ListInvoices)
+			user.POST("/invoices/statements", invoiceHandler.GenerateStatement)
+			user.GET("/invoices/:id", invoiceHandler.GetInvoice)
+			user.GET("/invoices/:id/pdf", invoiceHandler.DownloadInvoice)
+
 			// User profile routes
 			fmt.Println("Registering route: /api/user/profile")
 			user.GET("/profile", handlers.GetUserProfile)
@@ -357,8 +537,18 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 			admin.POST("/users", adminHandler.CreateUser)
 			admin.PATCH("/users/:id", adminHandler.UpdateUser)
 			admin.DELETE("/users/:id", adminHandler.DeleteUser)
+			admin.PUT("/users/:id/plan", planHandler.AssignPlan)
+			admin.POST("/users/:id/adjustments", middleware.IdempotencyMiddleware(idempotencyService, cfg), adjustmentHandler.CreateAdjustment)
+			admin.GET("/plans", planHandler.AdminListPlans)
+			admin.POST("/plans", planHandler.CreatePlan)
+			admin.PUT("/plans/:id", planHandler.UpdatePlan)
+			admin.DELETE("/plans/:id", planHandler.DeletePlan)
 			admin.GET("/api-usage", adminHandler.GetAPIUsage)
 			admin.GET("/transactions", adminHandler.GetTransactions)
+			admin.POST("/transactions/:id/refund", middleware.IdempotencyMiddleware(idempotencyService, cfg), adjustmentHandler.RefundTransaction)
+			admin.GET("/ledger/report", adminHandler.GetLedgerReport)
+			admin.POST("/cleanup", cleanupHandler.Cleanup)
+			admin.GET("/cleanup/runs", cleanupHandler.ListRuns)
 			admin.GET("/activity", adminHandler.GetActivityLogs)
 			admin.POST("/settings", adminHandler.UpdateSettings)
 			admin.GET("/pricing", adminHandler.GetPricingSettings)
@@ -372,6 +562,9 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 			admin.POST("/settings/pdf-tools/enable-all", pdfToolsHandler.EnableAllTools)
 			admin.POST("/settings/pdf-tools/disable-all", pdfToolsHandler.DisableAllTools)
 			admin.GET("/settings/pdf-tools/categories", pdfToolsHandler.GetToolsByCategory)
+			admin.GET("/rate-limits", rateLimitHandler.ListOverrides)
+			admin.PUT("/rate-limits", rateLimitHandler.SaveOverride)
+			admin.DELETE("/rate-limits/:id", rateLimitHandler.DeleteOverride)
 		}
 		keys := api.Group("/keys")
 		keys.Use(middleware.AuthMiddleware(cfg.JWTSecret))
@@ -381,7 +574,11 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 			keys.POST("", apiKeyHandler.CreateKey)
 
 			fmt.Println("Registering route: /api/keys/:id")
+			keys.PATCH("/:id", apiKeyHandler.UpdateKey)
 			keys.DELETE("/:id", apiKeyHandler.RevokeKey)
+
+			fmt.Println("Registering route: /api/keys/:id/rotate")
+			keys.POST("/:id/rotate", apiKeyHandler.RotateKey)
 		}
 	}
 
@@ -5,6 +5,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/models"
@@ -12,63 +16,177 @@ import (
 	"gorm.io/gorm"
 )
 
+// Key rotation and expiry defaults used when the api settings do not define them
+const (
+	defaultKeyRotationGracePeriod = 24 * time.Hour
+	maxKeyRotationGracePeriod     = 30 * 24 * time.Hour
+	defaultKeyExpiryNoticeDays    = 7
+	keyExpiryCheckInterval        = time.Hour
+)
+
 type ApiKeyService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	settings *SettingsService
+	plans    *PlanService
 }
 
 func NewApiKeyService(db *gorm.DB) *ApiKeyService {
-	return &ApiKeyService{db: db}
+	return &ApiKeyService{
+		db:       db,
+		settings: NewSettingsService(),
+		plans:    NewPlanService(db),
+	}
 }
 
-// CreateKey generates a new API key for a user
-// CreateKey generates a new API key for a user
-func (s *ApiKeyService) CreateKey(userID, name string, permissions []string) (*models.ApiKey, error) {
-	// Skip permissions validation since we're not using it anymore
+// Errors returned for invalid key options, reported to the client as 400
+var (
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrInvalidAllowedIP   = errors.New("invalid allowed IP")
+	ErrInvalidExpiry      = errors.New("expiry must be in the future")
+	ErrInvalidGracePeriod = fmt.Errorf("grace period must be between 0 and %d seconds", int(maxKeyRotationGracePeriod.Seconds()))
+)
+
+// ErrKeyAlreadyRotated is returned when rotating a key that was already replaced
+var ErrKeyAlreadyRotated = errors.New("API key has already been rotated")
+
+// CreateKey generates a new API key for a user. permissions are the operations
+// the key may use and allowedIPs the addresses or CIDR ranges it may be used
+// from; empty lists allow all. expiresAt is optional. The full key is only
+// returned here: the database keeps its prefix and a hash.
+func (s *ApiKeyService) CreateKey(userID, name string, permissions, allowedIPs []string, expiresAt *time.Time) (*models.ApiKey, string, error) {
+	scopes, err := ValidateScopes(permissions)
+	if err != nil {
+		return nil, "", err
+	}
+	cidrs, err := ParseAllowedIPs(allowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
 
-	// Check if user has reached key limit
+	// Check if user has reached key limit. Rotated keys in their grace period
+	// do not count.
 	var keyCount int64
-	if err := s.db.Model(&models.ApiKey{}).Where("user_id = ?", userID).Count(&keyCount).Error; err != nil {
-		return nil, err
+	if err := s.db.Model(&models.ApiKey{}).Where("user_id = ? AND replaced_by_id IS NULL", userID).Count(&keyCount).Error; err != nil {
+		return nil, "", err
 	}
 
-	// Get user to check if they are paid
+	// The key limit comes from the user's plan
 	var user models.User
 	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
-		return nil, err
-	}
-
-	// Determine key limit based on user type
-	keyLimit := 1 // Default for free users
-	if user.Balance > 0 {
-		// If user has balance, they're considered paid
-		keyLimit = 10
+		return nil, "", err
 	}
+	keyLimit := s.plans.planOf(s.db, &user).MaxApiKeys
 
 	if keyCount >= int64(keyLimit) {
-		return nil, errors.New("API key limit reached")
+		return nil, "", errors.New("API key limit reached")
 	}
 
-	// Generate a new key
-	key, err := generateApiKey()
+	apiKey, key, err := newApiKey(userID, name)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	apiKey.Scopes = scopes
+	apiKey.AllowedIPs = cidrs
+	apiKey.ExpiresAt = expiresAt
+
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// RotatedKey is the result of RotateKey
+type RotatedKey struct {
+	Key      *models.ApiKey // The new key
+	FullKey  string         // The new key in full, only revealed once
+	Previous *models.ApiKey // The rotated key, valid until its ExpiresAt
+}
+
+// RotateKey replaces a user's API key by a new one with the same name, scopes
+// and allowed IPs. The old key keeps working for gracePeriod (the
+// keyRotationGracePeriod api setting when nil). The new key expires at
+// expiresAt or, when nil and the old key had an expiry, after the same
+// lifetime as the old key.
+func (s *ApiKeyService) RotateKey(id, userID string, gracePeriod *time.Duration, expiresAt *time.Time) (*RotatedKey, error) {
+	grace := s.durationSetting("keyRotationGracePeriod", defaultKeyRotationGracePeriod)
+	if gracePeriod != nil {
+		grace = *gracePeriod
+	}
+	if grace < 0 || grace > maxKeyRotationGracePeriod {
+		return nil, ErrInvalidGracePeriod
 	}
 
-	apiKey := models.ApiKey{
-		ID:     uuid.New().String(),
-		UserID: userID,
-		Name:   name,
-		Key:    key,
-		// Permissions field removed
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, ErrInvalidExpiry
 	}
 
-	if err := s.db.Create(&apiKey).Error; err != nil {
+	var rotated *RotatedKey
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var old models.ApiKey
+		result := tx.Where("id = ? AND user_id = ?", id, userID).First(&old)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errors.New("API key not found or does not belong to user")
+		} else if result.Error != nil {
+			return result.Error
+		}
+		if old.ReplacedByID != nil {
+			return ErrKeyAlreadyRotated
+		}
+
+		apiKey, key, err := newApiKey(userID, old.Name)
+		if err != nil {
+			return err
+		}
+		apiKey.Scopes = old.Scopes
+		apiKey.AllowedIPs = old.AllowedIPs
+		apiKey.SpendingCaps = old.SpendingCaps
+		apiKey.ExpiresAt = expiresAt
+		if expiresAt == nil && old.ExpiresAt != nil {
+			renewed := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+			apiKey.ExpiresAt = &renewed
+		}
+
+		// The old key expires at the end of the grace period, or earlier if it
+		// was due to expire anyway
+		oldExpiresAt := now.Add(grace)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(oldExpiresAt) {
+			oldExpiresAt = *old.ExpiresAt
+		}
+
+		// The condition makes concurrent rotations of the same key fail
+		update := tx.Model(&models.ApiKey{}).
+			Where("id = ? AND replaced_by_id IS NULL", old.ID).
+			Updates(map[string]interface{}{
+				"replaced_by_id": apiKey.ID,
+				"expires_at":     oldExpiresAt,
+				"updated_at":     now,
+			})
+		if update.Error != nil {
+			return update.Error
+		}
+		if update.RowsAffected == 0 {
+			return ErrKeyAlreadyRotated
+		}
+		if err := tx.Create(apiKey).Error; err != nil {
+			return err
+		}
+
+		old.ReplacedByID = &apiKey.ID
+		old.ExpiresAt = &oldExpiresAt
+		old.UpdatedAt = now
+		rotated = &RotatedKey{Key: apiKey, FullKey: key, Previous: &old}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &apiKey, nil
+	return rotated, nil
 }
 
 // GetUserKeys returns all API keys for a user
@@ -80,6 +198,113 @@ func (s *ApiKeyService) GetUserKeys(userID string) ([]models.ApiKey, error) {
 	return keys, nil
 }
 
+// UpdateKey changes the name, scopes, allowed IPs and/or spending caps of a
+// user's API key. Nil arguments are left unchanged.
+func (s *ApiKeyService) UpdateKey(id, userID string, name *string, scopes, allowedIPs []string, caps *models.SpendingCaps) (*models.ApiKey, error) {
+	var key models.ApiKey
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).First(&key)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, errors.New("API key not found or does not belong to user")
+	} else if result.Error != nil {
+		return nil, result.Error
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if name != nil {
+		if *name == "" {
+			return nil, errors.New("name must not be empty")
+		}
+		updates["name"] = *name
+		key.Name = *name
+	}
+	if scopes != nil {
+		validated, err := ValidateScopes(scopes)
+		if err != nil {
+			return nil, err
+		}
+		updates["scopes"] = validated
+		key.Scopes = validated
+	}
+	if allowedIPs != nil {
+		cidrs, err := ParseAllowedIPs(allowedIPs)
+		if err != nil {
+			return nil, err
+		}
+		updates["allowed_ips"] = cidrs
+		key.AllowedIPs = cidrs
+	}
+	if caps != nil {
+		if err := ValidateSpendingCaps(*caps); err != nil {
+			return nil, err
+		}
+		updates["daily_spend_cap"] = caps.DailySpendCap
+		updates["monthly_spend_cap"] = caps.MonthlySpendCap
+		updates["daily_operation_cap"] = caps.DailyOperationCap
+		updates["monthly_operation_cap"] = caps.MonthlyOperationCap
+		key.SpendingCaps = *caps
+	}
+
+	if err := s.db.Model(&key).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ValidateScopes checks that every scope is an API operation and returns the
+// scopes without duplicates
+func ValidateScopes(scopes []string) (models.StringSlice, error) {
+	validated := models.StringSlice{}
+	seen := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if !isAPIOperation(scope) {
+			return nil, fmt.Errorf("%w: '%s' is not an API operation", ErrInvalidScope, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			validated = append(validated, scope)
+		}
+	}
+	return validated, nil
+}
+
+// ParseAllowedIPs validates a list of IP addresses and CIDR ranges and
+// returns them as CIDR ranges, e.g. "203.0.113.7" becomes "203.0.113.7/32"
+func ParseAllowedIPs(allowedIPs []string) (models.StringSlice, error) {
+	cidrs := models.StringSlice{}
+	seen := make(map[string]bool, len(allowedIPs))
+	for _, entry := range allowedIPs {
+		entry = strings.TrimSpace(entry)
+
+		var network *net.IPNet
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: '%s' is not an IP address or CIDR range", ErrInvalidAllowedIP, entry)
+			}
+			network = ipNet
+		} else {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: '%s' is not an IP address or CIDR range", ErrInvalidAllowedIP, entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+
+		cidr := network.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs, nil
+}
+
 // RevokeKey deletes an API key
 func (s *ApiKeyService) RevokeKey(id, userID string) error {
 	// Check if key belongs to user
@@ -95,6 +320,119 @@ func (s *ApiKeyService) RevokeKey(id, userID string) error {
 	return s.db.Delete(&key).Error
 }
 
+// findApiKey returns the record of a full API key, or gorm.ErrRecordNotFound.
+// Candidates are looked up by prefix and the key is checked against their hashes.
+func findApiKey(db *gorm.DB, key string, preloadUser bool) (*models.ApiKey, error) {
+	query := db.Where("prefix = ?", models.ApiKeyPrefix(key))
+	if preloadUser {
+		query = query.Preload("User")
+	}
+
+	var candidates []models.ApiKey
+	if err := query.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		if models.CompareApiKey(candidates[i].KeyHash, key) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// StartExpiryNotifier emails the owners of API keys that expire within the
+// keyExpiryNoticeDays api setting, checking every hour until the process exits
+func (s *ApiKeyService) StartExpiryNotifier(emailService *EmailService) {
+	go func() {
+		ticker := time.NewTicker(keyExpiryCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.NotifyExpiringKeys(emailService); err != nil {
+				log.Printf("API key expiry notifier: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// NotifyExpiringKeys emails the owner of every key expiring soon that was not
+// notified yet and returns how many emails were sent. Rotated keys are
+// skipped since their owner already has the new key.
+func (s *ApiKeyService) NotifyExpiringKeys(emailService *EmailService) (int, error) {
+	noticeDays := defaultKeyExpiryNoticeDays
+	if apiSettings, err := s.settings.GetSettings("api"); err == nil {
+		if v, ok := intSetting(apiSettings["keyExpiryNoticeDays"]); ok && v >= 0 {
+			noticeDays = v
+		}
+	}
+
+	now := time.Now()
+	var keys []models.ApiKey
+	err := s.db.Preload("User").
+		Where("expires_at > ? AND expires_at <= ? AND expiry_notified_at IS NULL AND replaced_by_id IS NULL",
+			now, now.AddDate(0, 0, noticeDays)).
+		Find(&keys).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expiring API keys: %w", err)
+	}
+
+	sent := 0
+	for _, key := range keys {
+		// Claim the key so only one API instance sends the email
+		claim := s.db.Model(&models.ApiKey{}).
+			Where("id = ? AND expiry_notified_at IS NULL", key.ID).
+			Update("expiry_notified_at", now)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+
+		if _, err := emailService.SendApiKeyExpiringEmail(key.User.Email, key.Name, key.Prefix, *key.ExpiresAt, key.User.Name); err != nil {
+			log.Printf("Failed to send expiry notice for API key %s: %v", key.ID, err)
+			// Try again on the next check
+			s.db.Model(&models.ApiKey{}).Where("id = ?", key.ID).Update("expiry_notified_at", nil)
+			continue
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// durationSetting reads an api setting given in seconds
+func (s *ApiKeyService) durationSetting(key string, defaultValue time.Duration) time.Duration {
+	if apiSettings, err := s.settings.GetSettings("api"); err == nil {
+		if v, ok := intSetting(apiSettings[key]); ok && v >= 0 {
+			return time.Duration(v) * time.Second
+		}
+	}
+	return defaultValue
+}
+
+// newApiKey generates a key and the record storing its prefix and hash
+func newApiKey(userID, name string) (*models.ApiKey, string, error) {
+	key, err := generateApiKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyHash, err := models.HashApiKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	return &models.ApiKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    models.ApiKeyPrefix(key),
+		KeyHash:   keyHash,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, key, nil
+}
+
 // generateApiKey creates a secure random API key
 func generateApiKey() (string, error) {
 	bytes := make([]byte, 24)
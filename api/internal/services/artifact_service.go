@@ -0,0 +1,184 @@
+// internal/services/artifact_service.go
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrArtifactNotFound is returned for files that were never recorded as
+	// an artifact
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrInvalidDownloadToken is returned for download links whose signature
+	// does not match or that have expired
+	ErrInvalidDownloadToken = errors.New("invalid or expired download link")
+	// ErrArtifactExpired is returned for artifacts past their expiry, whose
+	// content is or will soon be purged
+	ErrArtifactExpired = errors.New("artifact has expired")
+)
+
+// ArtifactService stores operation results and records them as artifacts,
+// so downloads can be limited to the owner or to HMAC-signed links valid
+// until the artifact expires
+type ArtifactService struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	secret    []byte
+	retention *RetentionService
+}
+
+// NewArtifactService creates an artifact service storing results in store.
+// Links are signed with secret and artifacts expire as set by the retention
+// policy.
+func NewArtifactService(db *gorm.DB, store storage.Storage, secret string, retention *RetentionService) *ArtifactService {
+	return &ArtifactService{
+		db:        db,
+		storage:   store,
+		secret:    []byte(secret),
+		retention: retention,
+	}
+}
+
+// Store moves the local file at localPath into folder of the storage and
+// records it as an artifact of userID
+func (s *ArtifactService) Store(ctx context.Context, userID, operation, folder, localPath string) (*models.Artifact, error) {
+	checksum, size, err := fileChecksum(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Base(localPath)
+	key := storage.Key(folder, filename)
+	if err := storage.MoveFile(ctx, s.storage, key, localPath); err != nil {
+		return nil, err
+	}
+
+	artifact := &models.Artifact{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Operation:   operation,
+		StorageKey:  key,
+		Filename:    filename,
+		ContentType: storage.ContentType(key),
+		Size:        size,
+		Checksum:    checksum,
+		ExpiresAt:   s.retention.ExpiresAt(userID, folder, time.Now()),
+	}
+	if err := s.db.Create(artifact).Error; err != nil {
+		s.storage.Delete(context.Background(), key)
+		return nil, fmt.Errorf("failed to record artifact: %w", err)
+	}
+
+	return artifact, nil
+}
+
+// Find returns the artifact stored under key
+func (s *ArtifactService) Find(key string) (*models.Artifact, error) {
+	var artifact models.Artifact
+	if err := s.db.Where("storage_key = ?", key).First(&artifact).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrArtifactNotFound
+		}
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// Fetch copies the content of the artifact id of userID into dir, for use as
+// the input of another operation, and returns the artifact and the path of
+// the copy
+func (s *ArtifactService) Fetch(ctx context.Context, id, userID, dir string) (*models.Artifact, string, error) {
+	var artifact models.Artifact
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&artifact).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrArtifactNotFound
+		}
+		return nil, "", err
+	}
+	if artifact.PurgedAt != nil || artifact.IsExpired(time.Now()) {
+		return &artifact, "", ErrArtifactExpired
+	}
+
+	localPath := filepath.Join(dir, artifact.ID+"-"+artifact.Filename)
+	if err := storage.FetchFile(ctx, s.storage, artifact.StorageKey, localPath); err != nil {
+		return &artifact, "", fmt.Errorf("failed to fetch result: %w", err)
+	}
+	return &artifact, localPath, nil
+}
+
+// Open opens the content of an artifact; the caller closes it
+func (s *ArtifactService) Open(ctx context.Context, artifact *models.Artifact) (io.ReadCloser, *storage.Object, error) {
+	return s.storage.Get(ctx, artifact.StorageKey)
+}
+
+// Delete removes the artifact stored under key together with its content
+func (s *ArtifactService) Delete(ctx context.Context, key string) error {
+	if err := s.storage.Delete(ctx, key); err != nil {
+		return err
+	}
+	return s.db.Where("storage_key = ?", key).Delete(&models.Artifact{}).Error
+}
+
+// DownloadURL returns the /api/file URL of an artifact, signed so it can be
+// downloaded without credentials until the artifact expires
+func (s *ArtifactService) DownloadURL(artifact *models.Artifact) string {
+	expires := strconv.FormatInt(artifact.ExpiresAt.Unix(), 10)
+	return fmt.Sprintf("/api/file?folder=%s&filename=%s&expires=%s&token=%s",
+		url.QueryEscape(path.Dir(artifact.StorageKey)),
+		url.QueryEscape(path.Base(artifact.StorageKey)),
+		expires,
+		s.sign(artifact.StorageKey, expires))
+}
+
+// VerifyDownloadToken checks the token and expiry of a signed link to key
+func (s *ArtifactService) VerifyDownloadToken(key, expires, token string) error {
+	if !hmac.Equal([]byte(token), []byte(s.sign(key, expires))) {
+		return ErrInvalidDownloadToken
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !time.Unix(expiresAt, 0).After(time.Now()) {
+		return ErrInvalidDownloadToken
+	}
+	return nil
+}
+
+// sign returns the HMAC-SHA256 signature of a link to key valid until expires
+func (s *ArtifactService) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// fileChecksum returns the hex SHA-256 and size of a local file
+func fileChecksum(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
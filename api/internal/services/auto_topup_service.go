@@ -0,0 +1,493 @@
+// internal/services/auto_topup_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Auto top-up defaults
+const (
+	minAutoTopUpAmount     = 5.00 // Same minimum as deposits
+	maxAutoTopUpAttempts   = 5    // Charges of a top-up ending in an error before it is given up
+	maxAutoTopUpFailures   = 3    // Top-ups failing in a row before auto top-up is turned off
+	autoTopUpRetryDelay    = 2 * time.Minute
+	autoTopUpSweepInterval = 5 * time.Minute
+)
+
+// Errors returned by AutoTopUpService
+var (
+	// ErrInvalidAutoTopUp is returned for thresholds or amounts out of range
+	ErrInvalidAutoTopUp = errors.New("invalid auto top-up settings")
+	// ErrNoSavedPaymentMethod is returned when turning auto top-up on before
+	// a payment method was saved
+	ErrNoSavedPaymentMethod = errors.New("no saved payment method")
+	// ErrNoPaymentMethodSetup is returned when confirming a payment method
+	// that was not set up
+	ErrNoPaymentMethodSetup = errors.New("no payment method setup to confirm")
+	// ErrSavedPaymentsUnsupported is returned for providers that cannot save
+	// payment methods
+	ErrSavedPaymentsUnsupported = errors.New("payment provider cannot save payment methods")
+	// ErrAutoTopUpInProgress is returned when changing the payment method
+	// while a top-up is being charged to it
+	ErrAutoTopUpInProgress = errors.New("an automatic top-up is being charged")
+)
+
+// AutoTopUpService recharges balances automatically with saved payment
+// methods. A top-up starts when a charge takes the balance below the user's
+// threshold and is recorded as a pending deposit first; its transaction ID is
+// the charge ID the provider charges once, so a top-up whose outcome is
+// unknown is retried safely by the retry sweep.
+type AutoTopUpService struct {
+	db       *gorm.DB
+	payments *PaymentService
+	email    *EmailService
+}
+
+func NewAutoTopUpService(db *gorm.DB, paymentService *PaymentService) *AutoTopUpService {
+	return &AutoTopUpService{
+		db:       db,
+		payments: paymentService,
+	}
+}
+
+// SetEmailService enables the emails sent when a top-up succeeds or fails
+func (s *AutoTopUpService) SetEmailService(emailService *EmailService) {
+	s.email = emailService
+}
+
+// Get returns the auto top-up settings of a user, disabled ones if they have
+// none
+func (s *AutoTopUpService) Get(userID string) (*models.AutoTopUp, error) {
+	var autoTopUp models.AutoTopUp
+	if err := s.db.First(&autoTopUp, "user_id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.AutoTopUp{UserID: userID}, nil
+		}
+		return nil, err
+	}
+	return &autoTopUp, nil
+}
+
+// Configure sets the threshold and amount of a user's auto top-up and turns
+// it on or off. Turning it on takes a saved payment method; when the balance
+// is below the threshold already, a top-up starts right away.
+func (s *AutoTopUpService) Configure(userID string, enabled bool, threshold, amount float64) (*models.AutoTopUp, error) {
+	if threshold < 0 {
+		return nil, fmt.Errorf("%w: threshold must not be negative", ErrInvalidAutoTopUp)
+	}
+	if amount < minAutoTopUpAmount {
+		return nil, fmt.Errorf("%w: amount must be at least $%.2f", ErrInvalidAutoTopUp, minAutoTopUpAmount)
+	}
+
+	autoTopUp, err := s.update(userID, func(autoTopUp *models.AutoTopUp) error {
+		if enabled && autoTopUp.PaymentMethodID == "" {
+			return ErrNoSavedPaymentMethod
+		}
+		if enabled && !autoTopUp.Enabled {
+			autoTopUp.FailureCount = 0
+		}
+		autoTopUp.Enabled = enabled
+		autoTopUp.Threshold = Money(threshold).InexactFloat64()
+		autoTopUp.Amount = Money(amount).InexactFloat64()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		go func() {
+			if err := s.startTopUp(userID, nil); err != nil {
+				fmt.Printf("ERROR: Auto top-up of user %s failed: %v\n", userID, err)
+			}
+		}()
+	}
+	return autoTopUp, nil
+}
+
+// StartSetup starts saving a payment method of a user with a provider and
+// returns the URL the user approves it at. The payment method is used once
+// the setup is confirmed with ConfirmSetup.
+func (s *AutoTopUpService) StartSetup(userID, providerName string) (string, error) {
+	provider, err := s.savedPaymentProvider(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	setupID, approvalURL, err := provider.CreateSetup(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to set up %s payment method: %w", provider.Name(), err)
+	}
+
+	if _, err := s.update(userID, func(autoTopUp *models.AutoTopUp) error {
+		autoTopUp.SetupProvider = provider.Name()
+		autoTopUp.SetupID = setupID
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return approvalURL, nil
+}
+
+// ConfirmSetup saves the payment method of the setup the user approved, in
+// place of the one saved before
+func (s *AutoTopUpService) ConfirmSetup(userID string) (*models.AutoTopUp, error) {
+	current, err := s.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if current.SetupID == "" {
+		return nil, ErrNoPaymentMethodSetup
+	}
+	if current.PendingTransactionID != nil {
+		return nil, ErrAutoTopUpInProgress
+	}
+
+	provider, err := s.savedPaymentProvider(current.SetupProvider)
+	if err != nil {
+		return nil, err
+	}
+	paymentMethodID, err := provider.ConfirmSetup(current.SetupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm %s payment method: %w", provider.Name(), err)
+	}
+
+	return s.update(userID, func(autoTopUp *models.AutoTopUp) error {
+		if autoTopUp.SetupID != current.SetupID {
+			return ErrNoPaymentMethodSetup
+		}
+		if autoTopUp.PendingTransactionID != nil {
+			return ErrAutoTopUpInProgress
+		}
+		autoTopUp.Provider = provider.Name()
+		autoTopUp.PaymentMethodID = paymentMethodID
+		autoTopUp.SetupProvider = ""
+		autoTopUp.SetupID = ""
+		autoTopUp.FailureCount = 0
+		autoTopUp.LastError = ""
+		return nil
+	})
+}
+
+// Remove turns off a user's auto top-up and forgets their saved payment
+// method
+func (s *AutoTopUpService) Remove(userID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var autoTopUp models.AutoTopUp
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&autoTopUp, "user_id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if autoTopUp.PendingTransactionID != nil {
+			return ErrAutoTopUpInProgress
+		}
+		return tx.Delete(&autoTopUp).Error
+	})
+}
+
+// update changes the auto top-up settings of a user under a row lock,
+// creating them if the user has none
+func (s *AutoTopUpService) update(userID string, change func(autoTopUp *models.AutoTopUp) error) (*models.AutoTopUp, error) {
+	var autoTopUp models.AutoTopUp
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&autoTopUp, "user_id = ?", userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			autoTopUp = models.AutoTopUp{UserID: userID, CreatedAt: time.Now()}
+		} else if err != nil {
+			return err
+		}
+
+		if err := change(&autoTopUp); err != nil {
+			return err
+		}
+		autoTopUp.UpdatedAt = time.Now()
+		return tx.Save(&autoTopUp).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &autoTopUp, nil
+}
+
+// afterCharge starts a top-up in the background when a charge took a user's
+// balance from before to below their threshold
+func (s *AutoTopUpService) afterCharge(userID string, before decimal.Decimal) {
+	go func() {
+		if err := s.startTopUp(userID, &before); err != nil {
+			fmt.Printf("ERROR: Auto top-up of user %s failed: %v\n", userID, err)
+		}
+	}()
+}
+
+// startTopUp records a pending top-up of a user whose balance is below their
+// threshold and charges it. With crossedFrom, the balance before a charge,
+// balances that were below the threshold already are not topped up again, so
+// a declined top-up is not charged again by every operation.
+func (s *AutoTopUpService) startTopUp(userID string, crossedFrom *decimal.Decimal) error {
+	started := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var autoTopUp models.AutoTopUp
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&autoTopUp, "user_id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if !autoTopUp.Enabled || autoTopUp.PaymentMethodID == "" || autoTopUp.PendingTransactionID != nil {
+			return nil
+		}
+
+		var user models.User
+		if err := tx.Select("id", "balance").First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		balance, threshold := Money(user.Balance), Money(autoTopUp.Threshold)
+		if !balance.LessThan(threshold) || (crossedFrom != nil && crossedFrom.LessThan(threshold)) {
+			return nil
+		}
+
+		transaction := models.Transaction{
+			ID:           uuid.New().String(),
+			UserID:       userID,
+			Amount:       autoTopUp.Amount,
+			BalanceAfter: balance.Add(Money(autoTopUp.Amount)).InexactFloat64(), // Updated when completed
+			Description:  "Auto top-up - pending",
+			Provider:     autoTopUp.Provider,
+			Status:       "pending",
+			CreatedAt:    time.Now(),
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to record top-up: %w", err)
+		}
+		started = true
+		return tx.Model(&autoTopUp).Updates(map[string]interface{}{
+			"pending_transaction_id": transaction.ID,
+			"attempts":               0,
+			"last_attempt_at":        nil,
+			"updated_at":             time.Now(),
+		}).Error
+	})
+	if err != nil || !started {
+		return err
+	}
+
+	fmt.Printf("BILLING: Auto top-up started for user %s\n", userID)
+	return s.charge(userID)
+}
+
+// charge charges the pending top-up of a user to their saved payment method.
+// Declined top-ups fail right away; other errors leave it open whether the
+// charge was made, so the top-up stays pending for the retry sweep until
+// maxAutoTopUpAttempts were made.
+func (s *AutoTopUpService) charge(userID string) error {
+	var autoTopUp models.AutoTopUp
+	var transaction models.Transaction
+	claimed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&autoTopUp, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		// Another attempt may still be waiting for the provider
+		now := time.Now()
+		if autoTopUp.PendingTransactionID == nil ||
+			(autoTopUp.LastAttemptAt != nil && now.Sub(*autoTopUp.LastAttemptAt) < autoTopUpRetryDelay) {
+			return nil
+		}
+		if err := tx.First(&transaction, "id = ?", *autoTopUp.PendingTransactionID).Error; err != nil {
+			return fmt.Errorf("failed to find pending top-up: %w", err)
+		}
+
+		autoTopUp.Attempts++
+		autoTopUp.LastAttemptAt = &now
+		claimed = true
+		return tx.Model(&autoTopUp).Updates(map[string]interface{}{
+			"attempts":        autoTopUp.Attempts,
+			"last_attempt_at": now,
+		}).Error
+	})
+	if err != nil || !claimed {
+		return err
+	}
+
+	var paymentID string
+	provider, err := s.savedPaymentProvider(autoTopUp.Provider)
+	if err == nil {
+		paymentID, err = provider.ChargeSaved(autoTopUp.PaymentMethodID, transaction.Amount, "MegaPDF automatic top-up", transaction.ID)
+	}
+
+	switch {
+	case err == nil:
+		return s.complete(&autoTopUp, &transaction, paymentID)
+	case errors.Is(err, ErrPaymentDeclined), errors.Is(err, ErrUnknownPaymentProvider),
+		errors.Is(err, ErrSavedPaymentsUnsupported), autoTopUp.Attempts >= maxAutoTopUpAttempts:
+		return s.fail(&autoTopUp, &transaction, err)
+	default:
+		fmt.Printf("WARNING: Auto top-up %s of user %s (attempt %d) failed, retrying later: %v\n",
+			transaction.ID, userID, autoTopUp.Attempts, err)
+		if updateErr := s.db.Model(&autoTopUp).Update("last_error", truncate(err.Error(), 255)).Error; updateErr != nil {
+			fmt.Printf("ERROR: Failed to record auto top-up error: %v\n", updateErr)
+		}
+		return err
+	}
+}
+
+// complete credits a top-up the provider charged. If crediting fails, the
+// top-up stays pending and the retry sweep charges it again, which the
+// provider answers with the payment already made.
+func (s *AutoTopUpService) complete(autoTopUp *models.AutoTopUp, transaction *models.Transaction, paymentID string) error {
+	if err := s.db.Model(&models.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, "pending").
+		Update("payment_id", paymentID).Error; err != nil {
+		return fmt.Errorf("failed to record top-up payment: %w", err)
+	}
+	// The payment webhook may have completed the deposit meanwhile
+	err := s.payments.balance.completeDeposit(paymentID, "Auto top-up - completed")
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to complete top-up: %w", err)
+	}
+
+	now := time.Now()
+	if err := s.db.Model(autoTopUp).Updates(map[string]interface{}{
+		"pending_transaction_id": nil,
+		"attempts":               0,
+		"failure_count":          0,
+		"last_error":             "",
+		"last_top_up_at":         now,
+		"updated_at":             now,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to settle top-up: %w", err)
+	}
+
+	fmt.Printf("BILLING: Auto top-up %s of user %s completed (amount: %.2f)\n",
+		transaction.ID, transaction.UserID, transaction.Amount)
+	s.notify(transaction, nil, false)
+	return nil
+}
+
+// fail records a top-up that was not charged. Auto top-up is turned off
+// after maxAutoTopUpFailures failed top-ups in a row.
+func (s *AutoTopUpService) fail(autoTopUp *models.AutoTopUp, transaction *models.Transaction, cause error) error {
+	if err := s.db.Model(&models.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, "pending").
+		Updates(map[string]interface{}{
+			"status":      "failed",
+			"description": "Auto top-up - failed",
+		}).Error; err != nil {
+		return fmt.Errorf("failed to fail top-up: %w", err)
+	}
+
+	failures := autoTopUp.FailureCount + 1
+	disabled := failures >= maxAutoTopUpFailures
+	if err := s.db.Model(autoTopUp).Updates(map[string]interface{}{
+		"pending_transaction_id": nil,
+		"attempts":               0,
+		"failure_count":          failures,
+		"enabled":                autoTopUp.Enabled && !disabled,
+		"last_error":             truncate(cause.Error(), 255),
+		"updated_at":             time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to settle top-up: %w", err)
+	}
+
+	fmt.Printf("BILLING: Auto top-up %s of user %s failed (failures: %d, disabled: %v): %v\n",
+		transaction.ID, transaction.UserID, failures, disabled, cause)
+	s.notify(transaction, cause, disabled)
+	return nil
+}
+
+// notify emails the user the outcome of a top-up, a failure if cause is set
+func (s *AutoTopUpService) notify(transaction *models.Transaction, cause error, disabled bool) {
+	if s.email == nil {
+		return
+	}
+
+	var user models.User
+	if err := s.db.Select("id", "email", "name", "balance").First(&user, "id = ?", transaction.UserID).Error; err != nil {
+		fmt.Printf("ERROR: Failed to find user %s to email about auto top-up: %v\n", transaction.UserID, err)
+		return
+	}
+
+	var err error
+	if cause == nil {
+		_, err = s.email.SendAutoTopUpEmail(user.Email, transaction.Amount, user.Balance, transaction.ID, user.Name)
+	} else {
+		reason := "the payment could not be completed"
+		if errors.Is(cause, ErrPaymentDeclined) {
+			reason = "the payment was declined"
+		}
+		_, err = s.email.SendAutoTopUpFailedEmail(user.Email, transaction.Amount, user.Balance, reason, disabled, user.Name)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Failed to email user %s about auto top-up %s: %v\n", user.ID, transaction.ID, err)
+	}
+}
+
+// RetryTopUps charges the pending top-ups whose last attempt ended in an
+// error, or was cut short, and returns how many were charged again
+func (s *AutoTopUpService) RetryTopUps() (int, error) {
+	var pending []models.AutoTopUp
+	if err := s.db.Where("pending_transaction_id IS NOT NULL AND (last_attempt_at IS NULL OR last_attempt_at < ?)",
+		time.Now().Add(-autoTopUpRetryDelay)).Find(&pending).Error; err != nil {
+		return 0, fmt.Errorf("failed to find pending top-ups: %w", err)
+	}
+
+	retried := 0
+	for _, autoTopUp := range pending {
+		if err := s.charge(autoTopUp.UserID); err != nil {
+			log.Printf("Auto top-up retry for user %s failed: %v", autoTopUp.UserID, err)
+			continue
+		}
+		retried++
+	}
+	return retried, nil
+}
+
+// StartRetries runs RetryTopUps periodically until the process exits
+func (s *AutoTopUpService) StartRetries() {
+	go func() {
+		ticker := time.NewTicker(autoTopUpSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			if retried, err := s.RetryTopUps(); err != nil {
+				log.Printf("Auto top-up retries failed: %v", err)
+			} else if retried > 0 {
+				log.Printf("Auto top-up retries charged %d pending top-ups", retried)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// savedPaymentProvider returns a registered provider that can charge saved
+// payment methods
+func (s *AutoTopUpService) savedPaymentProvider(name string) (SavedPaymentProvider, error) {
+	provider, err := s.payments.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+	saved, ok := provider.(SavedPaymentProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSavedPaymentsUnsupported, provider.Name())
+	}
+	return saved, nil
+}
+
+// truncate shortens s to at most n bytes for a column of that size
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
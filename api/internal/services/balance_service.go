@@ -2,23 +2,45 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
+	"log"
 	"time"
 
-	"github.com/MegaPDF/megapdf-official/api/internal/constants"
 	"github.com/MegaPDF/megapdf-official/api/internal/models"
 	"github.com/MegaPDF/megapdf-official/api/internal/repository"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reservation defaults, see SetReservationTimeout
+const (
+	defaultReservationTimeout = time.Hour
+	reservationSweepInterval  = 5 * time.Minute
 )
 
 type BalanceService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	ledger      *LedgerService
+	plans       *PlanService
+	invoices    *InvoiceService
+	webSessions *WebSessionService
+	topUps      *AutoTopUpService
+	caps        *SpendingCapService
+	holdTimeout time.Duration
 }
 
+// Codes of the operations Reserve refuses, returned to clients with the 402
+// response so they can tell the reasons apart
+const (
+	OperationCodeInsufficientBalance   = "insufficient_balance"
+	OperationCodeSpendingCapReached    = "spending_cap_reached"
+	OperationCodeSessionQuotaExhausted = "session_quota_exhausted"
+)
+
 type OperationResult struct {
 	Success                 bool
 	UsedFreeOperation       bool
@@ -26,247 +48,423 @@ type OperationResult struct {
 	CurrentBalance          float64
 	OperationCost           float64
 	Error                   string
+	Code                    string // Why the operation was refused, e.g. OperationCodeSpendingCapReached
 }
 
 func NewBalanceService(db *gorm.DB) *BalanceService {
-	return &BalanceService{db: db}
-}
-
-// ProcessOperation charges a user for an operation
-func (s *BalanceService) ProcessOperation(userID string, operation string) (*OperationResult, error) {
-	// Create variable for result
-	var result OperationResult
-	if userID == "website-user" {
-		// Return success without any database queries
-		return &OperationResult{
-			Success:                 true,
-			UsedFreeOperation:       true,
-			FreeOperationsRemaining: 999,
-			CurrentBalance:          9999.0,
-			OperationCost:           0,
-			Error:                   "",
-		}, nil
+	return &BalanceService{
+		db:       db,
+		ledger:   NewLedgerService(db),
+		plans:    NewPlanService(db),
+		invoices: NewInvoiceService(db),
+		caps:     NewSpendingCapService(db),
 	}
-	// Get the operation cost BEFORE entering transaction
-	operationCost := s.getOperationCost(operation)
-	fmt.Printf("PRICING: Operation '%s' cost set to %.6f\n", operation, operationCost)
+}
 
-	// Check for double charging by looking for recent transactions
-	var recentTx string
-	s.db.Raw("SELECT id FROM transactions WHERE user_id = ? AND created_at > ? AND description LIKE ?",
-		userID, time.Now().Add(-5*time.Second), "Operation: "+operation+"%").Scan(&recentTx)
-	if recentTx != "" {
-		fmt.Printf("WARNING: Possible duplicate operation detected for user %s (operation %s)\n",
-			userID, operation)
-	}
+// SetSpendingCaps replaces the service enforcing spending caps, e.g. with
+// one that sends alert emails
+func (s *BalanceService) SetSpendingCaps(caps *SpendingCapService) {
+	s.caps = caps
+}
 
-	// Get original balance for verification
-	var originalBalance float64
-	if err := s.db.Raw("SELECT balance FROM users WHERE id = ?", userID).Scan(&originalBalance).Error; err != nil {
-		return nil, fmt.Errorf("failed to get initial balance: %w", err)
-	}
-	fmt.Printf("BALANCE: Starting balance for user %s: %.6f\n", userID, originalBalance)
+// SetWebSessions enables charging anonymous web sessions against their quota
+func (s *BalanceService) SetWebSessions(webSessions *WebSessionService) {
+	s.webSessions = webSessions
+}
 
-	// Start transaction
-	tx := s.db.Begin()
-	if tx.Error != nil {
-		return nil, fmt.Errorf("failed to start transaction: %w", tx.Error)
-	}
+// SetAutoTopUps enables automatic top-ups after charges that take a balance
+// below the user's threshold
+func (s *BalanceService) SetAutoTopUps(topUps *AutoTopUpService) {
+	s.topUps = topUps
+}
 
-	// Get user with FOR UPDATE lock to prevent race conditions
-	var user models.User
-	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", userID).First(&user).Error; err != nil {
-		tx.Rollback()
-		return nil, fmt.Errorf("user not found: %w", err)
+// Reserve holds the cost of an operation: an operation of the plan's
+// allowance if the user has any left this billing cycle, otherwise the
+// plan's overage cost is taken from the balance. The hold must be settled
+// with Commit once the operation succeeded or Release if it failed; holds
+// left over are released by the reconciliation sweep once they expire. The
+// returned reservation is nil when the result is not successful, and for web
+// sessions, which only count operations against their quota. Operations
+// beyond a spending cap of the user or of the API key they are made with
+// (apiKeyID, empty for the web app) are refused. Charges taking the balance
+// below the user's auto top-up threshold start a top-up.
+func (s *BalanceService) Reserve(userID, apiKeyID, operation string) (*models.Reservation, *OperationResult, error) {
+	if sessionID, ok := webSessionID(userID); ok {
+		result, err := s.processWebSessionOperation(sessionID)
+		return nil, result, err
 	}
 
-	// Check for free operations reset
-	now := time.Now()
-	freeOpsReset := user.FreeOperationsReset
-	freeOpsUsed := user.FreeOperationsUsed
-	freeOperationsLimit := constants.FreeOperationsMonthly
+	var reservation *models.Reservation
+	var result *OperationResult
+	var balanceBefore decimal.Decimal
+	var capUsage []CapUsage
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error; err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		balanceBefore = Money(user.Balance)
 
-	// Try to get from pricing settings
-	pricingRepo := repository.NewPricingRepository()
-	if pricing, err := pricingRepo.GetPricingSettings(); err == nil {
-		freeOperationsLimit = pricing.FreeOperationsMonthly
-	}
+		// Start the next billing cycle once the current one ended
+		now := time.Now()
+		plan, err := s.plans.RenewIfDue(tx, &user, now)
+		if err != nil {
+			return err
+		}
+		freeOperationsLimit := plan.IncludedOperations
+		freeOpsUsed := user.FreeOperationsUsed
+		operationCost := s.plans.OperationCost(plan, operation)
+
+		// Operations of the allowance count against the operation caps only
+		cost := Money(operationCost)
+		if freeOpsUsed < freeOperationsLimit {
+			cost = decimal.Zero
+		}
+		usage, exceeded, err := s.caps.check(tx, &user, apiKeyID, cost, now)
+		if err != nil {
+			return err
+		}
+		capUsage = usage
+		if exceeded != nil {
+			result = &OperationResult{
+				Success:        false,
+				CurrentBalance: user.Balance,
+				OperationCost:  operationCost,
+				Error:          exceeded.message(),
+				Code:           OperationCodeSpendingCapReached,
+			}
+			return nil
+		}
 
-	// Check if reset date has passed
-	if freeOpsReset.Before(now) {
-		// Calculate new reset date
-		nextMonth := now.AddDate(0, 1, 0)
-		resetDate := time.Date(nextMonth.Year(), nextMonth.Month(), 1, 0, 0, 0, 0, time.UTC)
+		transaction := models.Transaction{
+			ID:           uuid.New().String(),
+			UserID:       userID,
+			BalanceAfter: user.Balance,
+			Description:  "Operation: " + operation,
+			Status:       models.TransactionStatusReserved,
+			CreatedAt:    now,
+		}
+		reservation = &models.Reservation{
+			ID:            uuid.New().String(),
+			UserID:        userID,
+			ApiKeyID:      optionalID(apiKeyID),
+			Operation:     operation,
+			Status:        models.ReservationStatusHeld,
+			TransactionID: transaction.ID,
+			ExpiresAt:     now.Add(s.reservationTimeout()),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
 
-		fmt.Printf("FREOPS: Resetting free operations for user %s (old: %d)\n", userID, freeOpsUsed)
+		if freeOpsUsed < freeOperationsLimit {
+			if err := tx.Exec("UPDATE users SET free_operations_used = free_operations_used + 1 WHERE id = ?",
+				userID).Error; err != nil {
+				return fmt.Errorf("failed to update free operations: %w", err)
+			}
+			transaction.Description = fmt.Sprintf("Operation: %s (Free)", operation)
+			reservation.FreeOperation = true
+
+			result = &OperationResult{
+				Success:                 true,
+				UsedFreeOperation:       true,
+				FreeOperationsRemaining: freeOperationsLimit - (freeOpsUsed + 1),
+				CurrentBalance:          user.Balance,
+				OperationCost:           operationCost,
+			}
+		} else {
+			balance, cost := Money(user.Balance), Money(operationCost)
+			if balance.LessThan(cost) {
+				reservation = nil
+				result = &OperationResult{
+					Success:        false,
+					CurrentBalance: user.Balance,
+					OperationCost:  operationCost,
+					Error:          "Insufficient balance",
+					Code:           OperationCodeInsufficientBalance,
+				}
+				return nil
+			}
+
+			newBalance := balance.Sub(cost)
+			if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, userID).Error; err != nil {
+				return fmt.Errorf("failed to update balance: %w", err)
+			}
+			if err := s.ledger.DebitUser(tx, userID, balance, models.LedgerAccountReservations, cost,
+				"Reserved: "+operation, reservation.ID); err != nil {
+				return err
+			}
+			transaction.Amount = cost.Neg().InexactFloat64()
+			transaction.BalanceAfter = newBalance.InexactFloat64()
+			reservation.Amount = cost.InexactFloat64()
+
+			result = &OperationResult{
+				Success:        true,
+				CurrentBalance: newBalance.InexactFloat64(),
+				OperationCost:  operationCost,
+			}
+		}
 
-		// Update the reset date and used count via SQL to prevent model issues
-		if err := tx.Exec("UPDATE users SET free_operations_used = 0, free_operations_reset = ? WHERE id = ?",
-			resetDate, userID).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to reset free operations: %w", err)
+		if err := tx.Create(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to create transaction record: %w", err)
 		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return fmt.Errorf("failed to create reservation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Update local variables
-		freeOpsUsed = 0
-		freeOpsReset = resetDate
+	// Alert on the caps this operation, or its refusal, took past a threshold
+	s.caps.alert(capUsage)
+
+	if reservation != nil {
+		fmt.Printf("BILLING: Reserved %s for user %s (free: %v, amount: %.3f)\n",
+			operation, userID, reservation.FreeOperation, reservation.Amount)
+	}
+	// The operation or a plan renewal may have taken the balance below the
+	// auto top-up threshold
+	if s.topUps != nil && result.Success && Money(result.CurrentBalance).LessThan(balanceBefore) {
+		s.topUps.afterCharge(userID, balanceBefore)
 	}
+	return reservation, result, nil
+}
 
-	// Check if we should use free operation
-	if freeOpsUsed < freeOperationsLimit {
-		// Use free operation
-		fmt.Printf("FREOPS: Using free operation for user %s (%d -> %d)\n", userID, freeOpsUsed, freeOpsUsed+1)
+// ErrReservationSettled is returned when settling a reservation that is not
+// held anymore, e.g. because it expired
+var ErrReservationSettled = errors.New("reservation is already settled")
 
-		// Update free operations directly with SQL
-		if err := tx.Exec("UPDATE users SET free_operations_used = free_operations_used + 1 WHERE id = ?",
-			userID).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to update free operations: %w", err)
+// Commit settles a reservation after the operation succeeded: the held cost
+// is kept and the operation counts in the usage stats
+func (s *BalanceService) Commit(reservationID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		reservation, err := s.settle(tx, reservationID, models.ReservationStatusCommitted)
+		if err != nil {
+			return err
 		}
 
-		// Verify update
-		var freeOpsVerify int
-		if err := tx.Raw("SELECT free_operations_used FROM users WHERE id = ?", userID).Scan(&freeOpsVerify).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to verify free ops update: %w", err)
+		if err := tx.Model(&models.Transaction{}).Where("id = ?", reservation.TransactionID).
+			Update("status", models.TransactionStatusCompleted).Error; err != nil {
+			return fmt.Errorf("failed to complete transaction: %w", err)
 		}
-		fmt.Printf("FREOPS: Verified free operations update: %d\n", freeOpsVerify)
-
-		// Track operation
-		if err := s.trackOperationUsage(tx, userID, operation); err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to track usage: %w", err)
+		if !reservation.FreeOperation {
+			if err := s.ledger.Transfer(tx, models.LedgerAccountReservations, models.LedgerAccountRevenue,
+				Money(reservation.Amount), "Operation: "+reservation.Operation, reservation.ID); err != nil {
+				return err
+			}
 		}
 
-		// Create transaction record for the free operation
-		freeTransaction := models.Transaction{
-			ID:           uuid.New().String(),
-			UserID:       userID,
-			Amount:       0,               // No cost for free operation
-			BalanceAfter: originalBalance, // Balance remains unchanged
-			Description:  fmt.Sprintf("Operation: %s (Free)", operation),
-			Status:       "completed",
-			CreatedAt:    time.Now(),
+		if err := s.trackOperationUsage(tx, reservation.UserID, reservation.Operation); err != nil {
+			return fmt.Errorf("failed to track usage: %w", err)
 		}
+		return nil
+	})
+}
 
-		if err := tx.Create(&freeTransaction).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to create transaction record: %w", err)
-		}
+// Release settles a reservation after the operation failed: the free
+// operation or the amount is given back to the user
+func (s *BalanceService) Release(reservationID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := s.release(tx, reservationID, models.ReservationStatusReleased, models.TransactionStatusReleased)
+		return err
+	})
+}
 
-		// Commit transaction
-		if err := tx.Commit().Error; err != nil {
-			return nil, fmt.Errorf("failed to commit transaction: %w", err)
-		}
+// release gives back a held reservation and records the outcome
+func (s *BalanceService) release(tx *gorm.DB, reservationID, status, transactionStatus string) (*models.Reservation, error) {
+	reservation, err := s.settle(tx, reservationID, status)
+	if err != nil {
+		return nil, err
+	}
 
-		// Success with free operation
-		result = OperationResult{
-			Success:                 true,
-			UsedFreeOperation:       true,
-			FreeOperationsRemaining: freeOperationsLimit - (freeOpsUsed + 1),
-			CurrentBalance:          originalBalance, // Unchanged
-			OperationCost:           operationCost,
+	updates := map[string]interface{}{"status": transactionStatus}
+	if reservation.FreeOperation {
+		if err := tx.Exec("UPDATE users SET free_operations_used = CASE WHEN free_operations_used > 0 THEN free_operations_used - 1 ELSE 0 END WHERE id = ?",
+			reservation.UserID).Error; err != nil {
+			return nil, fmt.Errorf("failed to give back free operation: %w", err)
 		}
 	} else {
-		// Use balance
-		fmt.Printf("BALANCE: Using balance for user %s (%.6f - %.6f)\n", userID, originalBalance, operationCost)
-
-		// Check if balance is sufficient
-		if originalBalance < operationCost {
-			tx.Rollback()
-			return &OperationResult{
-				Success:                 false,
-				UsedFreeOperation:       false,
-				FreeOperationsRemaining: 0,
-				CurrentBalance:          originalBalance,
-				OperationCost:           operationCost,
-				Error:                   "Insufficient balance",
-			}, nil
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", reservation.UserID).
+			First(&user).Error; err != nil {
+			return nil, fmt.Errorf("failed to read balance: %w", err)
 		}
+		balance, amount := Money(user.Balance), Money(reservation.Amount)
+		newBalance := balance.Add(amount)
+		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, reservation.UserID).Error; err != nil {
+			return nil, fmt.Errorf("failed to refund balance: %w", err)
+		}
+		if err := s.ledger.CreditUser(tx, reservation.UserID, balance, models.LedgerAccountReservations, amount,
+			"Released: "+reservation.Operation, reservation.ID); err != nil {
+			return nil, err
+		}
+		updates["balance_after"] = newBalance
+	}
 
-		// Calculate new balance - use exact arithmetic to avoid floating point issues
-		newBalanceExact := decimal.NewFromFloat(originalBalance).Sub(decimal.NewFromFloat(operationCost))
-		newBalance, _ := newBalanceExact.Round(3).Float64()
+	if err := tx.Model(&models.Transaction{}).Where("id = ?", reservation.TransactionID).
+		Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
 
-		// Update balance using DIRECT SQL - IMPORTANT: This is the ONLY place we update balance
-		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, userID).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to update balance: %w", err)
-		}
+	fmt.Printf("BILLING: Released %s for user %s (%s)\n", reservation.Operation, reservation.UserID, status)
+	return reservation, nil
+}
 
-		// Verify the update immediately
-		var verifiedBalance float64
-		if err := tx.Raw("SELECT balance FROM users WHERE id = ?", userID).Scan(&verifiedBalance).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to verify balance update: %w", err)
-		}
+// settle moves a held reservation to status. The conditional update makes
+// sure a reservation is settled once, even across several API instances.
+func (s *BalanceService) settle(tx *gorm.DB, reservationID, status string) (*models.Reservation, error) {
+	now := time.Now()
+	result := tx.Model(&models.Reservation{}).
+		Where("id = ? AND status = ?", reservationID, models.ReservationStatusHeld).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"settled_at": now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to settle reservation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrReservationSettled
+	}
 
-		// Check if balance was updated correctly
-		if math.Abs(verifiedBalance-newBalance) > 0.0001 {
-			tx.Rollback()
-			return nil, fmt.Errorf("balance not updated correctly: expected %.6f, got %.6f",
-				newBalance, verifiedBalance)
-		}
+	var reservation models.Reservation
+	if err := tx.First(&reservation, "id = ?", reservationID).Error; err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
 
-		fmt.Printf("BALANCE: Verified balance update: %.6f -> %.6f\n", originalBalance, verifiedBalance)
+// ExtendReservation holds a reservation for another reservation timeout,
+// e.g. when its operation is queued as a job
+func (s *BalanceService) ExtendReservation(reservationID string) error {
+	now := time.Now()
+	result := s.db.Model(&models.Reservation{}).
+		Where("id = ? AND status = ?", reservationID, models.ReservationStatusHeld).
+		Updates(map[string]interface{}{
+			"expires_at": now.Add(s.reservationTimeout()),
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to extend reservation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrReservationSettled
+	}
+	return nil
+}
 
-		// Track operation
-		if err := s.trackOperationUsage(tx, userID, operation); err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to track usage: %w", err)
-		}
+// ExtendJobReservations extends the held reservations of the jobs that are
+// queued, or processing and still reporting, so they are not released
+// before the job settles them. It returns how many were extended.
+func (s *BalanceService) ExtendJobReservations() (int64, error) {
+	now := time.Now()
+	jobs := s.db.Model(&models.Job{}).Select("reservation_id").
+		Where("reservation_id <> '' AND (status = ? OR (status = ? AND updated_at >= ?))",
+			models.JobStatusQueued, models.JobStatusProcessing, now.Add(-jobStaleAfter))
+	result := s.db.Model(&models.Reservation{}).
+		Where("status = ? AND id IN (?)", models.ReservationStatusHeld, jobs).
+		Updates(map[string]interface{}{
+			"expires_at": now.Add(s.reservationTimeout()),
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to extend job reservations: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
 
-		// Create transaction record
-		balanceTransaction := models.Transaction{
-			ID:           uuid.New().String(),
-			UserID:       userID,
-			Amount:       -operationCost,
-			BalanceAfter: newBalance,
-			Description:  "Operation: " + operation,
-			Status:       "completed",
-			CreatedAt:    time.Now(),
-		}
+// ReconcileReservations releases the held reservations that expired, e.g.
+// because the process handling the operation stopped, and returns how many
+// were released
+func (s *BalanceService) ReconcileReservations() (int, error) {
+	var expired []models.Reservation
+	if err := s.db.Where("status = ? AND expires_at < ?", models.ReservationStatusHeld, time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, fmt.Errorf("failed to find expired reservations: %w", err)
+	}
 
-		if err := tx.Create(&balanceTransaction).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to create transaction record: %w", err)
+	released := 0
+	for _, reservation := range expired {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			_, err := s.release(tx, reservation.ID, models.ReservationStatusExpired, models.TransactionStatusExpired)
+			return err
+		})
+		if errors.Is(err, ErrReservationSettled) {
+			// Settled meanwhile by the operation or another instance
+			continue
 		}
-
-		// Commit transaction
-		if err := tx.Commit().Error; err != nil {
-			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		if err != nil {
+			return released, err
 		}
+		released++
+	}
+	return released, nil
+}
 
-		// Success with balance
-		result = OperationResult{
-			Success:                 true,
-			UsedFreeOperation:       false,
-			FreeOperationsRemaining: 0,
-			CurrentBalance:          newBalance,
-			OperationCost:           operationCost,
+// StartReconciliation runs ExtendJobReservations and ReconcileReservations
+// periodically until the process exits
+func (s *BalanceService) StartReconciliation() {
+	go func() {
+		ticker := time.NewTicker(reservationSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			// Without the extension the sweep could release the reservations
+			// of queued jobs
+			if _, err := s.ExtendJobReservations(); err != nil {
+				log.Printf("Reservation reconciliation failed: %v", err)
+			} else if released, err := s.ReconcileReservations(); err != nil {
+				log.Printf("Reservation reconciliation failed: %v", err)
+			} else if released > 0 {
+				log.Printf("Reservation reconciliation released %d expired reservations", released)
+			}
+			<-ticker.C
 		}
-	}
+	}()
+}
 
-	// Final verification after commit
-	var finalBalance float64
-	if err := s.db.Raw("SELECT balance FROM users WHERE id = ?", userID).Scan(&finalBalance).Error; err == nil {
-		fmt.Printf("FINAL: Balance after commit: %.6f\n", finalBalance)
+// SetReservationTimeout sets how long a reservation is held before the
+// reconciliation sweep releases it. It must exceed the longest operation
+// run outside of a job; the reservations of jobs are extended while they
+// are queued or running.
+func (s *BalanceService) SetReservationTimeout(timeout time.Duration) {
+	s.holdTimeout = timeout
+}
 
-		// Check for expected balance
-		expectedBalance := originalBalance
-		if !result.UsedFreeOperation {
-			expectedBalance = decimal.NewFromFloat(originalBalance).Sub(decimal.NewFromFloat(operationCost)).Round(6).InexactFloat64()
-		}
+// reservationTimeout returns the hold timeout, defaultReservationTimeout if unset
+func (s *BalanceService) reservationTimeout() time.Duration {
+	if s.holdTimeout <= 0 {
+		return defaultReservationTimeout
+	}
+	return s.holdTimeout
+}
 
-		if math.Abs(finalBalance-expectedBalance) > 0.0001 {
-			fmt.Printf("CRITICAL ERROR: Balance not updated correctly: expected %.6f, got %.6f\n",
-				expectedBalance, finalBalance)
-		} else {
-			fmt.Printf("SUCCESS: Balance correctly updated to %.6f\n", finalBalance)
-		}
+// processWebSessionOperation counts an operation against the quota of an
+// anonymous web session, without any database queries
+func (s *BalanceService) processWebSessionOperation(sessionID string) (*OperationResult, error) {
+	if s.webSessions == nil {
+		return nil, errors.New("web sessions are not enabled")
+	}
+
+	remaining, ok, err := s.webSessions.Consume(context.Background(), sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count session operation: %w", err)
+	}
+	if !ok {
+		return &OperationResult{
+			Success: false,
+			Error:   "Session operation quota exhausted, sign in to continue",
+			Code:    OperationCodeSessionQuotaExhausted,
+		}, nil
 	}
 
-	return &result, nil
+	return &OperationResult{
+		Success:                 true,
+		UsedFreeOperation:       true,
+		FreeOperationsRemaining: remaining,
+	}, nil
 }
 
 // trackOperationUsage records the operation in usage stats
@@ -329,18 +527,17 @@ func (s *BalanceService) GetBalance(userID string) (map[string]interface{}, erro
 	freeOpsUsed := user.FreeOperationsUsed
 	resetDate := user.FreeOperationsReset
 
-	// Get free operations limit from pricing settings
-	freeOperationsLimit := constants.FreeOperationsMonthly
-	pricingRepo := repository.NewPricingRepository()
-	if pricing, err := pricingRepo.GetPricingSettings(); err == nil {
-		freeOperationsLimit = pricing.FreeOperationsMonthly
-	}
+	// The allowance comes from the user's plan
+	plan := s.plans.planOf(s.db, &user)
+	freeOperationsLimit := plan.IncludedOperations
 
 	if resetDate.Before(now) {
 		// Reset would happen on next operation, but for display we show as reset
 		freeOpsUsed = 0
-		nextMonth := now.AddDate(0, 1, 0)
-		resetDate = time.Date(nextMonth.Year(), nextMonth.Month(), 1, 0, 0, 0, 0, time.Local)
+		resetDate = resetDate.AddDate(0, 1, 0)
+		if resetDate.Before(now) {
+			resetDate = now.AddDate(0, 1, 0)
+		}
 	}
 
 	// Get usage statistics for current month
@@ -384,11 +581,17 @@ func (s *BalanceService) GetBalance(userID string) (map[string]interface{}, erro
 		}
 	}
 
+	freeOpsRemaining := freeOperationsLimit - freeOpsUsed
+	if freeOpsRemaining < 0 {
+		freeOpsRemaining = 0
+	}
+
 	return map[string]interface{}{
 		"success":                 true,
 		"balance":                 user.Balance,
+		"plan":                    plan,
 		"freeOperationsUsed":      freeOpsUsed,
-		"freeOperationsRemaining": freeOperationsLimit - freeOpsUsed,
+		"freeOperationsRemaining": freeOpsRemaining,
 		"freeOperationsTotal":     freeOperationsLimit,
 		"nextResetDate":           resetDate,
 		"transactions":            formattedTransactions,
@@ -397,8 +600,8 @@ func (s *BalanceService) GetBalance(userID string) (map[string]interface{}, erro
 	}, nil
 }
 
-// CreateDeposit creates a pending deposit for a user
-func (s *BalanceService) CreateDeposit(userID string, amount float64, paymentID string) (*models.Transaction, error) {
+// CreateDeposit creates a pending deposit for a user, paid with provider
+func (s *BalanceService) CreateDeposit(userID string, amount float64, provider, paymentID string) (*models.Transaction, error) {
 	// Get user's current balance
 	var user models.User
 	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
@@ -410,9 +613,10 @@ func (s *BalanceService) CreateDeposit(userID string, amount float64, paymentID
 		ID:           uuid.New().String(),
 		UserID:       userID,
 		Amount:       amount,
-		BalanceAfter: user.Balance + amount, // This will be updated when completed
+		BalanceAfter: Money(user.Balance).Add(Money(amount)).InexactFloat64(), // This will be updated when completed
 		Description:  "Deposit - pending",
 		PaymentID:    paymentID,
+		Provider:     provider,
 		Status:       "pending",
 		CreatedAt:    time.Now(),
 	}
@@ -424,49 +628,333 @@ func (s *BalanceService) CreateDeposit(userID string, amount float64, paymentID
 	return &transaction, nil
 }
 
-// In the CompleteDeposit method of BalanceService
+// CompleteDeposit credits a pending deposit to the user's balance
 func (s *BalanceService) CompleteDeposit(paymentID string) error {
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	return s.completeDeposit(paymentID, "Deposit - completed")
+}
+
+// completeDeposit credits a pending deposit and records it with description,
+// then issues its invoice. The conditional update makes sure a deposit
+// confirmed both by the client and by the payment webhook is credited once.
+func (s *BalanceService) completeDeposit(paymentID, description string) error {
+	var transactionID string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
 		// Find the pending transaction
 		var transaction models.Transaction
 		if err := tx.Where("payment_id = ? AND status = ?", paymentID, "pending").First(&transaction).Error; err != nil {
 			return err
 		}
 
-		// Log for debugging
 		fmt.Printf("Found pending transaction - ID: %s, Amount: %f\n", transaction.ID, transaction.Amount)
 
-		// Get current user balance
 		var user models.User
-		if err := tx.First(&user, "id = ?", transaction.UserID).Error; err != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", transaction.UserID).Error; err != nil {
+			return err
+		}
+
+		balance, amount := Money(user.Balance), Money(transaction.Amount)
+		newBalance := balance.Add(amount)
+		fmt.Printf("User balance: %s, new balance: %s\n", balance, newBalance)
+
+		result := tx.Model(&models.Transaction{}).
+			Where("id = ? AND status = ?", transaction.ID, "pending").
+			Updates(map[string]interface{}{
+				"status":        "completed",
+				"description":   description,
+				"balance_after": newBalance,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, user.ID).Error; err != nil {
+			return err
+		}
+		transactionID = transaction.ID
+		return s.ledger.CreditUser(tx, user.ID, balance, models.LedgerAccountCash, amount,
+			"Deposit", transaction.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A failed invoice does not undo the deposit; it is issued again when the
+	// user lists their invoices
+	if _, err := s.invoices.IssueDepositInvoice(transactionID); err != nil {
+		fmt.Printf("ERROR: Failed to issue invoice for deposit %s: %v\n", transactionID, err)
+	}
+	return nil
+}
+
+// FailDeposit marks a pending deposit as failed with description
+func (s *BalanceService) FailDeposit(paymentID, description string) error {
+	result := s.db.Model(&models.Transaction{}).
+		Where("payment_id = ? AND status = ?", paymentID, "pending").
+		Updates(map[string]interface{}{
+			"status":      "failed",
+			"description": description,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Errors returned by admin adjustments and refunds
+var (
+	// ErrInvalidAdjustment is returned for adjustments without an amount or
+	// with an amount or reason code that do not go together
+	ErrInvalidAdjustment = errors.New("invalid adjustment")
+	// ErrNegativeBalance is returned when a change would take the balance
+	// below zero without the admin allowing it
+	ErrNegativeBalance = errors.New("the change would make the balance negative")
+	// ErrRefundNotAllowed is returned for refunds of transactions that are
+	// not completed deposits, or of more than is left of a deposit
+	ErrRefundNotAllowed = errors.New("refund not allowed")
+)
+
+// Adjustment is a change of a user's balance made by an admin
+type Adjustment struct {
+	Amount        float64 // Positive to credit the balance, negative to debit it
+	ReasonCode    string  // models.TransactionReasonCredit, Goodwill or Correction
+	Description   string  // Defaults to "Admin adjustment (<reason>)"
+	ActorID       string  // Admin making the change
+	AllowNegative bool    // Lets a debit take the balance below zero
+}
+
+// Adjust credits or debits a user's balance for an admin and returns the
+// recorded transaction. Credits and goodwill only add to the balance;
+// corrections go either way.
+func (s *BalanceService) Adjust(userID string, adjustment Adjustment) (*models.Transaction, error) {
+	amount := Money(adjustment.Amount)
+	if amount.IsZero() {
+		return nil, fmt.Errorf("%w: amount must not be zero", ErrInvalidAdjustment)
+	}
+	switch adjustment.ReasonCode {
+	case models.TransactionReasonCredit, models.TransactionReasonGoodwill:
+		if amount.IsNegative() {
+			return nil, fmt.Errorf("%w: %s adjustments must be positive", ErrInvalidAdjustment, adjustment.ReasonCode)
+		}
+	case models.TransactionReasonCorrection:
+	default:
+		return nil, fmt.Errorf("%w: unknown reason code '%s'", ErrInvalidAdjustment, adjustment.ReasonCode)
+	}
+
+	return s.adjust(userID, adjustment, func(decimal.Decimal) decimal.Decimal {
+		return amount
+	})
+}
+
+// AdjustBalance sets a user's balance for an admin, recording the difference
+// as a correction. It returns the recorded transaction, nil if the balance
+// did not change.
+func (s *BalanceService) AdjustBalance(userID string, newBalance float64, actorID string, allowNegative bool) (*models.Transaction, error) {
+	target := Money(newBalance)
+	return s.adjust(userID, Adjustment{
+		ReasonCode:    models.TransactionReasonCorrection,
+		Description:   "Admin balance adjustment",
+		ActorID:       actorID,
+		AllowNegative: allowNegative,
+	}, func(balance decimal.Decimal) decimal.Decimal {
+		return target.Sub(balance)
+	})
+}
+
+// adjust records the change of an adjustment, computed by difference from
+// the balance read under the user's row lock
+func (s *BalanceService) adjust(userID string, adjustment Adjustment, difference func(balance decimal.Decimal) decimal.Decimal) (*models.Transaction, error) {
+	description := adjustment.Description
+	if description == "" {
+		description = fmt.Sprintf("Admin adjustment (%s)", adjustment.ReasonCode)
+	}
+
+	var transaction *models.Transaction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
 			return err
 		}
 
-		// Log for debugging
-		fmt.Printf("User current balance: %f\n", user.Balance)
+		balance := Money(user.Balance)
+		change := difference(balance)
+		if change.IsZero() {
+			return nil
+		}
+		newBalance := balance.Add(change)
+		if change.IsNegative() && newBalance.IsNegative() && !adjustment.AllowNegative {
+			return fmt.Errorf("%w: balance %s, change %s", ErrNegativeBalance, balance.StringFixed(3), change.StringFixed(3))
+		}
+
+		transaction = &models.Transaction{
+			ID:           uuid.New().String(),
+			UserID:       userID,
+			Amount:       change.InexactFloat64(),
+			BalanceAfter: newBalance.InexactFloat64(),
+			Description:  description,
+			Status:       models.TransactionStatusCompleted,
+			ReasonCode:   adjustment.ReasonCode,
+			ActorID:      optionalID(adjustment.ActorID),
+			CreatedAt:    time.Now(),
+		}
+		if err := tx.Create(transaction).Error; err != nil {
+			return fmt.Errorf("failed to record transaction: %w", err)
+		}
+		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, userID).Error; err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		if change.IsPositive() {
+			return s.ledger.CreditUser(tx, userID, balance, models.LedgerAccountAdjustments, change,
+				description, transaction.ID)
+		}
+		return s.ledger.DebitUser(tx, userID, balance, models.LedgerAccountAdjustments, change.Neg(),
+			description, transaction.ID)
+	})
+	if err != nil {
+		transaction = nil
+	}
+	return transaction, err
+}
+
+// optionalID returns id, or nil if it is empty
+func optionalID(id string) *string {
+	if id == "" {
+		return nil
+	}
+	return &id
+}
 
-		// Calculate new balance
-		newBalance := user.Balance + transaction.Amount
+// Refund is a refund of a deposit made by an admin
+type Refund struct {
+	Amount        float64 // 0 refunds what is left of the deposit
+	Description   string  // Defaults to "Refund of deposit"
+	ActorID       string  // Admin making the refund
+	AllowNegative bool    // Lets the refund take the balance below zero
+	SkipProvider  bool    // Records a refund already made at the payment provider
+}
 
-		// Log for debugging
-		fmt.Printf("New calculated balance: %f\n", newBalance)
+// beginRefund takes a refund of a deposit from the user's balance and records
+// it as pending until the payment provider paid it. The balance is held on
+// the refunds ledger account meanwhile; finishRefund settles it.
+func (s *BalanceService) beginRefund(depositID string, refund Refund) (*models.Transaction, error) {
+	description := refund.Description
+	if description == "" {
+		description = "Refund of deposit"
+	}
+
+	var transaction *models.Transaction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// The deposit lock serialises refunds of the same deposit
+		var deposit models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&deposit, "id = ?", depositID).Error; err != nil {
+			return err
+		}
+		if deposit.PaymentID == "" || deposit.Amount <= 0 || deposit.Status != models.TransactionStatusCompleted {
+			return fmt.Errorf("%w: only completed deposits can be refunded", ErrRefundNotAllowed)
+		}
+
+		// Refunds are negative, pending ones count until they failed
+		var refunded decimal.NullDecimal
+		if err := tx.Model(&models.Transaction{}).
+			Where("refund_of_id = ? AND status IN ?", deposit.ID, []string{"pending", models.TransactionStatusCompleted}).
+			Select("SUM(amount)").
+			Scan(&refunded).Error; err != nil {
+			return fmt.Errorf("failed to sum refunds: %w", err)
+		}
+		remaining := Money(deposit.Amount).Add(refunded.Decimal)
+		amount := Money(refund.Amount)
+		if amount.IsZero() {
+			amount = remaining
+		}
+		if !amount.IsPositive() || amount.GreaterThan(remaining) {
+			return fmt.Errorf("%w: %s of the deposit is left to refund", ErrRefundNotAllowed, remaining.StringFixed(2))
+		}
 
-		// Update user balance
-		if err := tx.Model(&user).Update("balance", newBalance).Error; err != nil {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", deposit.UserID).Error; err != nil {
 			return err
 		}
+		balance := Money(user.Balance)
+		newBalance := balance.Sub(amount)
+		if newBalance.IsNegative() && !refund.AllowNegative {
+			return fmt.Errorf("%w: balance %s, refund %s", ErrNegativeBalance, balance.StringFixed(3), amount.StringFixed(3))
+		}
+
+		transaction = &models.Transaction{
+			ID:           uuid.New().String(),
+			UserID:       deposit.UserID,
+			Amount:       amount.Neg().InexactFloat64(),
+			BalanceAfter: newBalance.InexactFloat64(),
+			Description:  description,
+			Provider:     deposit.Provider,
+			Status:       "pending",
+			ReasonCode:   models.TransactionReasonRefund,
+			ActorID:      optionalID(refund.ActorID),
+			RefundOfID:   &deposit.ID,
+			CreatedAt:    time.Now(),
+		}
+		if err := tx.Create(transaction).Error; err != nil {
+			return fmt.Errorf("failed to record transaction: %w", err)
+		}
+		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, user.ID).Error; err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+		return s.ledger.DebitUser(tx, user.ID, balance, models.LedgerAccountRefunds, amount,
+			description, transaction.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// finishRefund settles a pending refund: a paid refund leaves the cash
+// account, a refund the provider did not pay goes back to the balance
+func (s *BalanceService) finishRefund(transaction *models.Transaction, paid bool) error {
+	amount := Money(transaction.Amount).Neg()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		status := models.TransactionStatusCompleted
+		if !paid {
+			status = "failed"
+		}
+		result := tx.Model(&models.Transaction{}).
+			Where("id = ? AND status = ?", transaction.ID, "pending").
+			Update("status", status)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("refund %s is not pending", transaction.ID)
+		}
+		transaction.Status = status
 
-		// Update transaction
-		return tx.Model(&transaction).Updates(map[string]interface{}{
-			"status":        "completed",
-			"description":   "Deposit - completed",
-			"balance_after": newBalance,
-		}).Error
+		if paid {
+			return s.ledger.Transfer(tx, models.LedgerAccountRefunds, models.LedgerAccountCash, amount,
+				transaction.Description, transaction.ID)
+		}
+
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", transaction.UserID).Error; err != nil {
+			return err
+		}
+		balance := Money(user.Balance)
+		if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", balance.Add(amount), user.ID).Error; err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+		return s.ledger.CreditUser(tx, user.ID, balance, models.LedgerAccountRefunds, amount,
+			"Refund failed", transaction.ID)
 	})
 }
 
-// getOperationCost returns the cost for an operation
-func (s *BalanceService) getOperationCost(operation string) float64 {
+// globalOperationCost returns the cost for an operation from the global
+// pricing settings
+func globalOperationCost(operation string) float64 {
 	fmt.Printf("PRICING: Getting operation cost for '%s'\n", operation)
 
 	// Get pricing info
@@ -0,0 +1,116 @@
+// internal/services/balance_service_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+)
+
+func TestExtendJobReservations(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.Transaction{}, &models.Reservation{}, &models.Job{})
+	service := NewBalanceService(db)
+	service.SetReservationTimeout(time.Hour)
+
+	if err := db.Create(&models.User{ID: "user-1", Email: "user@example.com", FreeOperationsUsed: 4}).Error; err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	for _, id := range []string{"queued", "processing", "stalled", "failed", "no-job"} {
+		reservation := models.Reservation{
+			ID:            id,
+			UserID:        "user-1",
+			Operation:     "split",
+			FreeOperation: true,
+			Status:        models.ReservationStatusHeld,
+			TransactionID: "transaction-" + id,
+			ExpiresAt:     expired,
+		}
+		if err := db.Create(&reservation).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs := []models.Job{
+		{ID: "job-queued", Status: models.JobStatusQueued, ReservationID: "queued"},
+		{ID: "job-processing", Status: models.JobStatusProcessing, ReservationID: "processing"},
+		{ID: "job-stalled", Status: models.JobStatusProcessing, ReservationID: "stalled", UpdatedAt: time.Now().Add(-jobStaleAfter - time.Minute)},
+		{ID: "job-failed", Status: models.JobStatusFailed, ReservationID: "failed"},
+		{ID: "job-free", Status: models.JobStatusQueued},
+	}
+	for _, job := range jobs {
+		if err := db.Create(&job).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extended, err := service.ExtendJobReservations()
+	if err != nil {
+		t.Fatalf("ExtendJobReservations() error = %v", err)
+	}
+	if extended != 2 {
+		t.Errorf("ExtendJobReservations() = %d, want the queued and processing jobs", extended)
+	}
+
+	released, err := service.ReconcileReservations()
+	if err != nil {
+		t.Fatalf("ReconcileReservations() error = %v", err)
+	}
+	if released != 3 {
+		t.Errorf("ReconcileReservations() = %d, want 3", released)
+	}
+
+	want := map[string]string{
+		"queued":     models.ReservationStatusHeld,
+		"processing": models.ReservationStatusHeld,
+		"stalled":    models.ReservationStatusExpired,
+		"failed":     models.ReservationStatusExpired,
+		"no-job":     models.ReservationStatusExpired,
+	}
+	for id, status := range want {
+		var reservation models.Reservation
+		if err := db.First(&reservation, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if reservation.Status != status {
+			t.Errorf("reservation %s status = %s, want %s", id, reservation.Status, status)
+		}
+		if status == models.ReservationStatusHeld && time.Until(reservation.ExpiresAt) < 59*time.Minute {
+			t.Errorf("reservation %s expires at %v, want an hour from now", id, reservation.ExpiresAt)
+		}
+	}
+}
+
+func TestExtendReservation(t *testing.T) {
+	db := newTestDB(t, &models.Reservation{})
+	service := NewBalanceService(db)
+	service.SetReservationTimeout(2 * time.Hour)
+
+	reservations := []models.Reservation{
+		{ID: "held", Status: models.ReservationStatusHeld, ExpiresAt: time.Now().Add(time.Minute)},
+		{ID: "released", Status: models.ReservationStatusReleased, ExpiresAt: time.Now().Add(time.Minute)},
+	}
+	for _, reservation := range reservations {
+		if err := db.Create(&reservation).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := service.ExtendReservation("held"); err != nil {
+		t.Fatalf("ExtendReservation() error = %v", err)
+	}
+	var reservation models.Reservation
+	if err := db.First(&reservation, "id = ?", "held").Error; err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(reservation.ExpiresAt) < 119*time.Minute {
+		t.Errorf("ExpiresAt = %v, want two hours from now", reservation.ExpiresAt)
+	}
+
+	for _, id := range []string{"released", "missing"} {
+		if err := service.ExtendReservation(id); !errors.Is(err, ErrReservationSettled) {
+			t.Errorf("ExtendReservation(%s) error = %v, want ErrReservationSettled", id, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"net"
 	"net/smtp"
+	"strconv"
 	"time"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/config"
@@ -104,7 +105,7 @@ func (s *EmailService) SendEmail(data EmailData) (*EmailResult, error) {
 		}, nil
 	}
 
-	smtpHostPort := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
+	smtpHostPort := net.JoinHostPort(s.config.SMTPHost, strconv.Itoa(s.config.SMTPPort))
 	auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPass, s.config.SMTPHost)
 	dialer := &net.Dialer{Timeout: 20 * time.Second}
 	var conn net.Conn
@@ -646,6 +647,171 @@ func (s *EmailService) SendLowBalanceWarningEmail(to string, currentBalance floa
 	})
 }
 
+// SendAutoTopUpEmail tells a user that their balance was topped up
+// automatically
+func (s *EmailService) SendAutoTopUpEmail(to string, amount float64, newBalance float64, transactionID string, username string) (*EmailResult, error) {
+	formattedAmount := fmt.Sprintf("$%.2f", amount)
+	formattedBalance := fmt.Sprintf("$%.2f", newBalance)
+	dashboardURL := fmt.Sprintf("%s/en/dashboard/billing", s.config.AppURL)
+	displayName := username
+	if displayName == "" {
+		displayName = "User"
+	}
+
+	contentTemplate := `
+      <h2 style="font-size: 24px; font-weight: 700; color: #ff6666; margin-top: 0;">Automatic Top-Up</h2>
+      <p>Hello {{.Username}},</p>
+      <div class="success-box">
+        <p style="margin: 0;">Your balance fell below your auto top-up threshold, so {{.Amount}} was charged to your saved payment method.</p>
+      </div>
+      <table class="table">
+        <tr><td>Transaction ID</td><td>{{.TransactionID}}</td></tr>
+        <tr><td>Date</td><td>{{.CurrentTime}}</td></tr>
+        <tr><td>Amount</td><td>{{.Amount}}</td></tr>
+        <tr><td>New Balance</td><td>{{.NewBalance}}</td></tr>
+      </table>
+      <div class="text-center">
+        <a href="{{.DashboardURL}}" class="button">Manage Auto Top-Up</a>
+      </div>
+      <p class="text-muted">If you didn't set up automatic top-ups, contact support immediately.</p>
+    `
+
+	contentData := map[string]interface{}{
+		"Username":      displayName,
+		"Amount":        formattedAmount,
+		"NewBalance":    formattedBalance,
+		"TransactionID": transactionID,
+		"DashboardURL":  dashboardURL,
+		"CurrentTime":   time.Now().Format("January 2, 2006 15:04 MST"),
+	}
+
+	renderedContent, err := s.renderContentTemplate(contentTemplate, contentData)
+	if err != nil {
+		return nil, err
+	}
+
+	finalData := map[string]interface{}{
+		"Content": renderedContent,
+		"Year":    time.Now().Year(),
+		"Subject": "MegaPDF Automatic Top-Up",
+	}
+
+	return s.SendEmail(EmailData{
+		To:       to,
+		Subject:  "MegaPDF Automatic Top-Up",
+		Template: baseTemplate,
+		Data:     finalData,
+	})
+}
+
+// SendAutoTopUpFailedEmail tells a user that their balance could not be
+// topped up automatically, and whether auto top-up was turned off
+func (s *EmailService) SendAutoTopUpFailedEmail(to string, amount float64, currentBalance float64, reason string, disabled bool, username string) (*EmailResult, error) {
+	formattedAmount := fmt.Sprintf("$%.2f", amount)
+	formattedBalance := fmt.Sprintf("$%.2f", currentBalance)
+	depositURL := fmt.Sprintf("%s/en/dashboard/billing", s.config.AppURL)
+	displayName := username
+	if displayName == "" {
+		displayName = "User"
+	}
+
+	contentTemplate := `
+      <h2 style="font-size: 24px; font-weight: 700; color: #ff6666; margin-top: 0;">Automatic Top-Up Failed</h2>
+      <p>Hello {{.Username}},</p>
+      <div class="warning-box">
+        <p style="margin: 0;">We could not charge {{.Amount}} to your saved payment method. Your balance is {{.CurrentBalance}}.</p>
+      </div>
+      <p>Reason: {{.Reason}}</p>
+      {{if .Disabled}}<p>Automatic top-ups were turned off after repeated failures. Update your payment method to turn them back on.</p>{{end}}
+      <p>To continue using MegaPDF without interruption, please add funds to your account or update your payment method.</p>
+      <div class="text-center">
+        <a href="{{.DepositURL}}" class="button">Add Funds</a>
+      </div>
+      <p class="text-muted">Contact support if you have questions about your balance.</p>
+    `
+
+	contentData := map[string]interface{}{
+		"Username":       displayName,
+		"Amount":         formattedAmount,
+		"CurrentBalance": formattedBalance,
+		"Reason":         reason,
+		"Disabled":       disabled,
+		"DepositURL":     depositURL,
+	}
+
+	renderedContent, err := s.renderContentTemplate(contentTemplate, contentData)
+	if err != nil {
+		return nil, err
+	}
+
+	finalData := map[string]interface{}{
+		"Content": renderedContent,
+		"Year":    time.Now().Year(),
+		"Subject": "MegaPDF Automatic Top-Up Failed",
+	}
+
+	return s.SendEmail(EmailData{
+		To:       to,
+		Subject:  "MegaPDF Automatic Top-Up Failed",
+		Template: baseTemplate,
+		Data:     finalData,
+	})
+}
+
+// SendSpendingCapAlertEmail tells a user that a share of a spending cap of
+// their account or of an API key was used
+func (s *EmailService) SendSpendingCapAlertEmail(to, subject, capName string, percent int, used, limit string, resetDate time.Time, username string) (*EmailResult, error) {
+	formattedResetDate := resetDate.Format("January 2, 2006 15:04 MST")
+	settingsURL := fmt.Sprintf("%s/en/dashboard/api-keys", s.config.AppURL)
+	displayName := username
+	if displayName == "" {
+		displayName = "User"
+	}
+
+	contentTemplate := `
+      <h2 style="font-size: 24px; font-weight: 700; color: #ff6666; margin-top: 0;">Spending Cap Alert</h2>
+      <p>Hello {{.Username}},</p>
+      <div class="warning-box">
+        <p style="margin: 0;">The {{.CapName}} of {{.Subject}} is {{.Percent}}% used: {{.Used}} of {{.Limit}}.</p>
+      </div>
+      {{if ge .Percent 100}}<p>Operations beyond the cap are refused until it resets on {{.ResetDate}}.</p>{{else}}<p>The cap resets on {{.ResetDate}}.</p>{{end}}
+      <div class="text-center">
+        <a href="{{.SettingsURL}}" class="button">Manage Spending Caps</a>
+      </div>
+      <p class="text-muted">Contact support if you have questions about your spending caps.</p>
+    `
+
+	contentData := map[string]interface{}{
+		"Username":    displayName,
+		"Subject":     subject,
+		"CapName":     capName,
+		"Percent":     percent,
+		"Used":        used,
+		"Limit":       limit,
+		"ResetDate":   formattedResetDate,
+		"SettingsURL": settingsURL,
+	}
+
+	renderedContent, err := s.renderContentTemplate(contentTemplate, contentData)
+	if err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("MegaPDF Spending Cap %d%% Used", percent)
+	finalData := map[string]interface{}{
+		"Content": renderedContent,
+		"Year":    time.Now().Year(),
+		"Subject": title,
+	}
+
+	return s.SendEmail(EmailData{
+		To:       to,
+		Subject:  title,
+		Template: baseTemplate,
+		Data:     finalData,
+	})
+}
+
 // SendOperationLimitWarningEmail sends an operation limit warning email
 func (s *EmailService) SendOperationLimitWarningEmail(to string, remainingOperations int, resetDate time.Time, username string) (*EmailResult, error) {
 	formattedResetDate := resetDate.Format("January 2, 2006")
@@ -748,3 +914,52 @@ func (s *EmailService) SendOperationsExhaustedEmail(to string, resetDate time.Ti
 		Data:     finalData,
 	})
 }
+
+// SendApiKeyExpiringEmail tells the owner of an API key that it expires soon
+func (s *EmailService) SendApiKeyExpiringEmail(to, keyName, keyPrefix string, expiresAt time.Time, username string) (*EmailResult, error) {
+	formattedExpiry := expiresAt.UTC().Format("January 2, 2006 15:04 MST")
+	keysURL := fmt.Sprintf("%s/en/dashboard/api-keys", s.config.AppURL)
+	displayName := username
+	if displayName == "" {
+		displayName = "User"
+	}
+
+	contentTemplate := `
+      <h2 style="font-size: 24px; font-weight: 700; color: #ff6666; margin-top: 0;">API Key Expiring Soon</h2>
+      <p>Hello {{.Username}},</p>
+      <div class="warning-box">
+        <p style="margin: 0;">Your API key "{{.KeyName}}" ({{.KeyPrefix}}...) expires on {{.ExpiresAt}}.</p>
+      </div>
+      <p>Requests made with this key will be rejected once it expires. Rotate the key to get a replacement; the old key keeps working during a short grace period so you can update your integrations.</p>
+      <div class="text-center">
+        <a href="{{.KeysURL}}" class="button">Manage API Keys</a>
+      </div>
+      <p class="text-muted">Contact support if you have questions about your API keys.</p>
+    `
+
+	contentData := map[string]interface{}{
+		"Username":  displayName,
+		"KeyName":   keyName,
+		"KeyPrefix": keyPrefix,
+		"ExpiresAt": formattedExpiry,
+		"KeysURL":   keysURL,
+	}
+
+	renderedContent, err := s.renderContentTemplate(contentTemplate, contentData)
+	if err != nil {
+		return nil, err
+	}
+
+	finalData := map[string]interface{}{
+		"Content": renderedContent,
+		"Year":    time.Now().Year(),
+		"Subject": "MegaPDF API Key Expiring Soon",
+	}
+
+	return s.SendEmail(EmailData{
+		To:       to,
+		Subject:  "MegaPDF API Key Expiring Soon",
+		Template: baseTemplate,
+		Data:     finalData,
+	})
+}
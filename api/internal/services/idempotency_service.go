@@ -0,0 +1,175 @@
+// internal/services/idempotency_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Idempotency defaults used when the api settings do not define them
+const (
+	defaultIdempotencyRetention = 24 * time.Hour
+	idempotencySweepInterval    = time.Hour
+)
+
+// Requests holding a key refresh their record every
+// IdempotencyHeartbeatInterval; a record not refreshed for
+// idempotencyClaimTimeout belongs to a request that crashed, and a retry
+// takes the key over
+const (
+	IdempotencyHeartbeatInterval = 30 * time.Second
+	idempotencyClaimTimeout      = 2 * time.Minute
+)
+
+// Errors returned by IdempotencyService.Begin
+var (
+	// ErrIdempotencyInProgress is returned while the first request with a
+	// key is still running
+	ErrIdempotencyInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a
+	// different method, URL or body
+	ErrIdempotencyKeyReused = errors.New("Idempotency-Key was already used for a different request")
+)
+
+// IdempotencyService records the requests sent with an Idempotency-Key and
+// their responses, so retried requests are replayed instead of run twice
+type IdempotencyService struct {
+	db       *gorm.DB
+	settings *SettingsService
+}
+
+func NewIdempotencyService(db *gorm.DB) *IdempotencyService {
+	return &IdempotencyService{
+		db:       db,
+		settings: NewSettingsService(),
+	}
+}
+
+// Begin claims an idempotency key for a request. It returns a record with
+// status processing when the request should run, or the completed record of
+// an earlier request to replay. Requests whose key is held by a running or a
+// different request get ErrIdempotencyInProgress or ErrIdempotencyKeyReused.
+// A key held by a request that stopped sending heartbeats for
+// idempotencyClaimTimeout is taken over.
+func (s *IdempotencyService) Begin(userID, key, method, path, requestHash string) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	record := &models.IdempotencyRecord{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Key:         key,
+		Method:      method,
+		Path:        path,
+		RequestHash: requestHash,
+		Status:      models.IdempotencyStatusProcessing,
+		ExpiresAt:   now.Add(s.retention()),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	// A record left over after the retention window does not hold the key
+	// anymore, even if the cleanup sweep did not remove it yet
+	if err := s.db.Where("user_id = ? AND `key` = ? AND expires_at < ?", userID, key, now).
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to remove expired idempotency key: %w", err)
+	}
+
+	// The unique index on (user_id, key) decides which concurrent request
+	// gets the key
+	for attempt := 0; ; attempt++ {
+		err := s.db.Create(record).Error
+		if err == nil {
+			return record, nil
+		}
+
+		var existing models.IdempotencyRecord
+		if lookupErr := s.db.Where("user_id = ? AND `key` = ?", userID, key).First(&existing).Error; lookupErr != nil {
+			if attempt == 0 && errors.Is(lookupErr, gorm.ErrRecordNotFound) {
+				continue // Abandoned in the meantime
+			}
+			return nil, fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+		if existing.RequestHash != requestHash {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if existing.Status == models.IdempotencyStatusCompleted {
+			return &existing, nil
+		}
+		if attempt > 0 || time.Since(existing.UpdatedAt) < idempotencyClaimTimeout {
+			return nil, ErrIdempotencyInProgress
+		}
+
+		log.Printf("Taking over idempotency key %s abandoned by request %s", key, existing.ID)
+		if err := s.db.Where("id = ? AND status = ? AND updated_at < ?",
+			existing.ID, models.IdempotencyStatusProcessing, now.Add(-idempotencyClaimTimeout)).
+			Delete(&models.IdempotencyRecord{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to release idempotency key: %w", err)
+		}
+	}
+}
+
+// Heartbeat marks the request holding a key as still running
+func (s *IdempotencyService) Heartbeat(recordID string) error {
+	return s.db.Model(&models.IdempotencyRecord{}).
+		Where("id = ? AND status = ?", recordID, models.IdempotencyStatusProcessing).
+		Update("updated_at", time.Now()).Error
+}
+
+// Complete stores the response of the request holding a key
+func (s *IdempotencyService) Complete(recordID string, status int, contentType string, body []byte) error {
+	return s.db.Model(&models.IdempotencyRecord{}).
+		Where("id = ?", recordID).
+		Updates(map[string]interface{}{
+			"status":          models.IdempotencyStatusCompleted,
+			"response_status": status,
+			"content_type":    contentType,
+			"response_body":   body,
+			"updated_at":      time.Now(),
+		}).Error
+}
+
+// Abandon frees a key without storing a response, so the request may be
+// retried with the same key, e.g. after a server error
+func (s *IdempotencyService) Abandon(recordID string) error {
+	return s.db.Where("id = ?", recordID).Delete(&models.IdempotencyRecord{}).Error
+}
+
+// CleanupExpired deletes the records past the retention window and returns
+// how many were deleted
+func (s *IdempotencyService) CleanupExpired() (int64, error) {
+	result := s.db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
+
+// StartCleanup runs CleanupExpired periodically until the process exits
+func (s *IdempotencyService) StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(idempotencySweepInterval)
+		defer ticker.Stop()
+
+		for {
+			if deleted, err := s.CleanupExpired(); err != nil {
+				log.Printf("Idempotency key cleanup failed: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Idempotency key cleanup deleted %d expired keys", deleted)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// retention returns how long responses are kept (api.idempotencyRetention,
+// in seconds)
+func (s *IdempotencyService) retention() time.Duration {
+	if apiSettings, err := s.settings.GetSettings("api"); err == nil {
+		if v, ok := intSetting(apiSettings["idempotencyRetention"]); ok && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+	}
+	return defaultIdempotencyRetention
+}
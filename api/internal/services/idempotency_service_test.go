@@ -0,0 +1,101 @@
+// internal/services/idempotency_service_test.go
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+)
+
+func newTestIdempotencyService(t *testing.T) (*IdempotencyService, func(recordID string, age time.Duration)) {
+	t.Helper()
+	db := newTestDB(t, &models.IdempotencyRecord{})
+	useTestSettings(t)
+
+	// age makes a record look like its last heartbeat was age ago
+	age := func(recordID string, age time.Duration) {
+		t.Helper()
+		if err := db.Model(&models.IdempotencyRecord{}).Where("id = ?", recordID).
+			Update("updated_at", time.Now().Add(-age)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewIdempotencyService(db), age
+}
+
+func TestIdempotencyBegin(t *testing.T) {
+	service, _ := newTestIdempotencyService(t)
+
+	record, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if record.Status != models.IdempotencyStatusProcessing {
+		t.Errorf("Status = %q, want processing", record.Status)
+	}
+
+	if _, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash"); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Errorf("Begin() while running error = %v, want ErrIdempotencyInProgress", err)
+	}
+	if _, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "other-hash"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Begin() with another body error = %v, want ErrIdempotencyKeyReused", err)
+	}
+	if _, err := service.Begin("user-2", "key-1", "POST", "/api/pdf/compress", "hash"); err != nil {
+		t.Errorf("Begin() of another user error = %v", err)
+	}
+
+	if err := service.Complete(record.ID, 200, "application/json", []byte(`{"success":true}`)); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	replay, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash")
+	if err != nil {
+		t.Fatalf("Begin() after completion error = %v", err)
+	}
+	if replay.Status != models.IdempotencyStatusCompleted || string(replay.ResponseBody) != `{"success":true}` {
+		t.Errorf("Begin() after completion = %q %q, want the stored response", replay.Status, replay.ResponseBody)
+	}
+}
+
+func TestIdempotencyTakeOver(t *testing.T) {
+	service, age := newTestIdempotencyService(t)
+
+	crashed, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	// Heartbeats keep the key
+	age(crashed.ID, idempotencyClaimTimeout+time.Minute)
+	if err := service.Heartbeat(crashed.ID); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if _, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash"); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Errorf("Begin() after a heartbeat error = %v, want ErrIdempotencyInProgress", err)
+	}
+
+	// Without heartbeats a retry takes the key over
+	age(crashed.ID, idempotencyClaimTimeout+time.Minute)
+	retry, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash")
+	if err != nil {
+		t.Fatalf("Begin() of an abandoned key error = %v", err)
+	}
+	if retry.ID == crashed.ID || retry.Status != models.IdempotencyStatusProcessing {
+		t.Errorf("Begin() of an abandoned key = %s %q, want a new processing record", retry.ID, retry.Status)
+	}
+
+	// The crashed request cannot complete the key it lost
+	if err := service.Complete(crashed.ID, 200, "application/json", []byte(`{}`)); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if _, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "hash"); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Errorf("Begin() after the crashed request completed error = %v, want ErrIdempotencyInProgress", err)
+	}
+
+	// An abandoned key sent with another body is still a reused key
+	age(retry.ID, idempotencyClaimTimeout+time.Minute)
+	if _, err := service.Begin("user-1", "key-1", "POST", "/api/pdf/compress", "other-hash"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Begin() with another body error = %v, want ErrIdempotencyKeyReused", err)
+	}
+}
@@ -0,0 +1,489 @@
+// internal/services/invoice_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/pdfops"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Invoice defaults used when the billing settings do not define them
+const (
+	defaultInvoiceSeller       = "MegaPDF"
+	defaultInvoiceCurrency     = "USD"
+	defaultInvoicePrefix       = "INV"
+	defaultStatementPrefix     = "STM"
+	invoiceStatementInterval   = 6 * time.Hour
+	operationDescriptionPrefix = "Operation: "
+	freeOperationSuffix        = " (Free)"
+)
+
+// Errors returned by InvoiceService
+var (
+	ErrInvoiceNotFound       = errors.New("invoice not found")
+	ErrStatementPeriodOpen   = errors.New("statements can only be generated for past months")
+	ErrDepositNotInvoiceable = errors.New("only completed deposits can be invoiced")
+)
+
+// InvoiceService issues invoices for deposits and monthly usage statements
+// and renders them as PDFs. Invoices and statements are numbered in their own
+// gapless sequences (billing.invoicePrefix and billing.statementPrefix).
+type InvoiceService struct {
+	db       *gorm.DB
+	settings *SettingsService
+}
+
+func NewInvoiceService(db *gorm.DB) *InvoiceService {
+	return &InvoiceService{
+		db:       db,
+		settings: NewSettingsService(),
+	}
+}
+
+// ListInvoices returns the invoices and statements of a user, newest first,
+// optionally only those of one type. Completed deposits that were not
+// invoiced yet, e.g. those made before invoicing existed, are invoiced first.
+func (s *InvoiceService) ListInvoices(userID, invoiceType string) ([]models.Invoice, error) {
+	if err := s.issueMissingDepositInvoices(userID); err != nil {
+		log.Printf("Failed to issue missing invoices of user %s: %v", userID, err)
+	}
+
+	query := s.db.Where("user_id = ?", userID).Order("issued_at desc")
+	if invoiceType != "" {
+		query = query.Where("type = ?", invoiceType)
+	}
+	var invoices []models.Invoice
+	if err := query.Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// GetInvoice returns an invoice of a user
+func (s *InvoiceService) GetInvoice(userID, id string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&invoice).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// IssueDepositInvoice issues the invoice of a completed deposit transaction,
+// or returns the invoice already issued for it
+func (s *InvoiceService) IssueDepositInvoice(transactionID string) (*models.Invoice, error) {
+	var invoice *models.Invoice
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Invoice
+		if err := tx.Where("transaction_id = ?", transactionID).First(&existing).Error; err == nil {
+			invoice = &existing
+			return nil
+		}
+
+		var transaction models.Transaction
+		if err := tx.Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return fmt.Errorf("transaction not found: %w", err)
+		}
+		if transaction.PaymentID == "" || transaction.Status != models.TransactionStatusCompleted || transaction.Amount <= 0 {
+			return ErrDepositNotInvoiceable
+		}
+
+		amount := Money(transaction.Amount).InexactFloat64()
+		invoice = &models.Invoice{
+			Type:          models.InvoiceTypeDeposit,
+			UserID:        transaction.UserID,
+			TransactionID: &transaction.ID,
+			Total:         amount,
+			Lines: models.InvoiceLines{{
+				Description: "Account balance top-up (payment " + transaction.PaymentID + ")",
+				Quantity:    1,
+				UnitPrice:   amount,
+				Amount:      amount,
+			}},
+		}
+		return s.issue(tx, invoice, "invoicePrefix", defaultInvoicePrefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// issueMissingDepositInvoices invoices the completed deposits of a user that
+// have no invoice
+func (s *InvoiceService) issueMissingDepositInvoices(userID string) error {
+	var transactionIDs []string
+	if err := s.db.Model(&models.Transaction{}).
+		Where("user_id = ? AND status = ? AND payment_id <> '' AND amount > 0", userID, models.TransactionStatusCompleted).
+		Where("id NOT IN (?)", s.db.Model(&models.Invoice{}).Select("transaction_id").Where("transaction_id IS NOT NULL")).
+		Order("created_at").
+		Pluck("id", &transactionIDs).Error; err != nil {
+		return err
+	}
+
+	for _, transactionID := range transactionIDs {
+		if _, err := s.IssueDepositInvoice(transactionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateStatement issues the usage statement of a user for the calendar
+// month (UTC) containing month, or returns the statement already issued. The
+// month must be over.
+func (s *InvoiceService) GenerateStatement(userID string, month time.Time) (*models.Invoice, error) {
+	start := time.Date(month.UTC().Year(), month.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	if end.After(time.Now()) {
+		return nil, ErrStatementPeriodOpen
+	}
+
+	var invoice *models.Invoice
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Invoice
+		if err := tx.Where("user_id = ? AND type = ? AND period_start = ?", userID, models.InvoiceTypeStatement, start).
+			First(&existing).Error; err == nil {
+			invoice = &existing
+			return nil
+		}
+
+		statement, err := s.buildStatement(tx, userID, start, end)
+		if err != nil {
+			return err
+		}
+		invoice = statement
+		return s.issue(tx, invoice, "statementPrefix", defaultStatementPrefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// buildStatement summarises the usage and the completed transactions of a
+// user between start and end: one line per operation with the number of
+// operations run and their charges, and one line per other kind of
+// transaction (deposits, subscriptions, adjustments)
+func (s *InvoiceService) buildStatement(tx *gorm.DB, userID string, start, end time.Time) (*models.Invoice, error) {
+	var usage []struct {
+		Operation string
+		Count     int
+	}
+	if err := tx.Model(&models.UsageStats{}).
+		Select("operation, SUM(count) AS count").
+		Where("user_id = ? AND date >= ? AND date < ?", userID, start, end).
+		Group("operation").
+		Scan(&usage).Error; err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	var transactions []models.Transaction
+	if err := tx.Where("user_id = ? AND status = ? AND created_at >= ? AND created_at < ?",
+		userID, models.TransactionStatusCompleted, start, end).
+		Order("created_at").
+		Find(&transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load transactions: %w", err)
+	}
+
+	type summary struct {
+		count  int
+		amount decimal.Decimal
+	}
+	operations := make(map[string]*summary)
+	others := make(map[string]*summary)
+	for _, row := range usage {
+		operations[row.Operation] = &summary{count: row.Count}
+	}
+	counted := make(map[string]bool, len(operations))
+	for operation := range operations {
+		counted[operation] = true
+	}
+
+	charges := decimal.Zero
+	for _, transaction := range transactions {
+		amount := Money(transaction.Amount)
+		// Refunds pay money back and are no charges
+		if amount.IsNegative() && transaction.ReasonCode != models.TransactionReasonRefund {
+			charges = charges.Add(amount.Neg())
+		}
+
+		if strings.HasPrefix(transaction.Description, operationDescriptionPrefix) {
+			operation := strings.TrimSuffix(strings.TrimPrefix(transaction.Description, operationDescriptionPrefix), freeOperationSuffix)
+			line, ok := operations[operation]
+			if !ok {
+				line = &summary{}
+				operations[operation] = line
+			}
+			// Operations without usage stats are counted from their transactions
+			if !counted[operation] {
+				line.count++
+			}
+			line.amount = line.amount.Add(amount)
+			continue
+		}
+
+		line, ok := others[transaction.Description]
+		if !ok {
+			line = &summary{}
+			others[transaction.Description] = line
+		}
+		line.count++
+		line.amount = line.amount.Add(amount)
+	}
+
+	lines := models.InvoiceLines{}
+	for _, names := range []struct {
+		prefix  string
+		entries map[string]*summary
+	}{{"Operations: ", operations}, {"", others}} {
+		keys := make([]string, 0, len(names.entries))
+		for key := range names.entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lines = append(lines, models.InvoiceLine{
+				Description: names.prefix + key,
+				Quantity:    names.entries[key].count,
+				Amount:      names.entries[key].amount.InexactFloat64(),
+			})
+		}
+	}
+
+	opening, err := balanceAt(tx, userID, start)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := balanceAt(tx, userID, end)
+	if err != nil {
+		return nil, err
+	}
+
+	periodEnd := end.Add(-time.Second)
+	return &models.Invoice{
+		Type:           models.InvoiceTypeStatement,
+		UserID:         userID,
+		PeriodStart:    &start,
+		PeriodEnd:      &periodEnd,
+		Total:          charges.InexactFloat64(),
+		OpeningBalance: opening.InexactFloat64(),
+		ClosingBalance: closing.InexactFloat64(),
+		Lines:          lines,
+	}, nil
+}
+
+// balanceAt returns the balance of a user after the last completed
+// transaction before at
+func balanceAt(tx *gorm.DB, userID string, at time.Time) (decimal.Decimal, error) {
+	var last models.Transaction
+	err := tx.Where("user_id = ? AND status = ? AND created_at < ?", userID, models.TransactionStatusCompleted, at).
+		Order("created_at desc").
+		First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return decimal.Zero, nil
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load balance: %w", err)
+	}
+	return Money(last.BalanceAfter), nil
+}
+
+// GenerateStatements issues the statements of the calendar month containing
+// month for every user with usage or transactions in it, and returns how many
+// were issued
+func (s *InvoiceService) GenerateStatements(month time.Time) (int, error) {
+	start := time.Date(month.UTC().Year(), month.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	var userIDs []string
+	if err := s.db.Raw(`SELECT DISTINCT user_id FROM transactions WHERE status = ? AND created_at >= ? AND created_at < ?
+		UNION SELECT DISTINCT user_id FROM usage_stats WHERE date >= ? AND date < ?`,
+		models.TransactionStatusCompleted, start, end, start, end).
+		Scan(&userIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to find active users: %w", err)
+	}
+
+	var issued []string
+	if err := s.db.Model(&models.Invoice{}).
+		Where("type = ? AND period_start = ?", models.InvoiceTypeStatement, start).
+		Pluck("user_id", &issued).Error; err != nil {
+		return 0, err
+	}
+	done := make(map[string]bool, len(issued))
+	for _, userID := range issued {
+		done[userID] = true
+	}
+
+	generated := 0
+	for _, userID := range userIDs {
+		if done[userID] {
+			continue
+		}
+		if _, err := s.GenerateStatement(userID, start); err != nil {
+			return generated, fmt.Errorf("failed to generate statement of user %s: %w", userID, err)
+		}
+		generated++
+	}
+	return generated, nil
+}
+
+// StartStatements issues the statements of the previous month periodically
+// until the process exits
+func (s *InvoiceService) StartStatements() {
+	go func() {
+		ticker := time.NewTicker(invoiceStatementInterval)
+		defer ticker.Stop()
+
+		for {
+			lastMonth := time.Now().UTC().AddDate(0, 0, -time.Now().UTC().Day())
+			if generated, err := s.GenerateStatements(lastMonth); err != nil {
+				log.Printf("Statement generation failed: %v", err)
+			} else if generated > 0 {
+				log.Printf("Statement generation issued %d statements for %s", generated, lastMonth.Format("2006-01"))
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// issue numbers an invoice in the series named by the billing setting
+// prefixKey and stores it with the current seller details and the buyer's
+// details. tx must be the transaction storing the invoice, so a failed
+// invoice does not use up its number.
+func (s *InvoiceService) issue(tx *gorm.DB, invoice *models.Invoice, prefixKey, defaultPrefix string) error {
+	var user models.User
+	if err := tx.Select("id", "name", "email").Where("id = ?", invoice.UserID).First(&user).Error; err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	billing, _ := s.settings.GetSettings("billing")
+	setting := func(key, fallback string) string {
+		if value, ok := billing[key].(string); ok && value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	number, err := nextInvoiceNumber(tx, setting(prefixKey, defaultPrefix))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	invoice.ID = uuid.New().String()
+	invoice.Number = number
+	invoice.Currency = setting("currency", defaultInvoiceCurrency)
+	invoice.SellerName = setting("sellerName", defaultInvoiceSeller)
+	invoice.SellerAddress = setting("sellerAddress", "")
+	invoice.SellerEmail = setting("sellerEmail", "")
+	invoice.SellerTaxID = setting("sellerTaxId", "")
+	invoice.BuyerName = user.Name
+	invoice.BuyerEmail = user.Email
+	invoice.IssuedAt = now
+	invoice.CreatedAt = now
+
+	if err := tx.Create(invoice).Error; err != nil {
+		return fmt.Errorf("failed to store invoice: %w", err)
+	}
+	return nil
+}
+
+// nextInvoiceNumber takes the next number of the series prefix. The counter
+// row stays locked until tx ends, so numbers are issued in order.
+func nextInvoiceNumber(tx *gorm.DB, prefix string) (string, error) {
+	counter := models.InvoiceCounter{Series: prefix}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return "", fmt.Errorf("failed to create invoice counter: %w", err)
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("series = ?", prefix).First(&counter).Error; err != nil {
+		return "", fmt.Errorf("failed to lock invoice counter: %w", err)
+	}
+
+	counter.Value++
+	if err := tx.Model(&models.InvoiceCounter{}).Where("series = ?", prefix).Update("value", counter.Value).Error; err != nil {
+		return "", fmt.Errorf("failed to update invoice counter: %w", err)
+	}
+	return fmt.Sprintf("%s-%06d", prefix, counter.Value), nil
+}
+
+// RenderPDF writes an invoice or statement as a PDF to out
+func (s *InvoiceService) RenderPDF(ctx context.Context, invoice *models.Invoice, out string) error {
+	money := func(amount float64) string {
+		return invoice.Currency + " " + formatInvoiceAmount(amount)
+	}
+
+	doc := pdfops.InvoiceDocument{
+		Title:   "Invoice",
+		Details: []string{"Invoice number: " + invoice.Number, "Issued: " + invoice.IssuedAt.UTC().Format("2006-01-02")},
+		Seller:  nonEmpty(invoice.SellerName, invoice.SellerAddress, invoice.SellerEmail),
+		Buyer:   nonEmpty(invoice.BuyerName, invoice.BuyerEmail),
+		Footer:  "Issued by " + invoice.SellerName,
+	}
+	if invoice.SellerTaxID != "" {
+		doc.Seller = append(doc.Seller, "Tax ID: "+invoice.SellerTaxID)
+	}
+
+	switch invoice.Type {
+	case models.InvoiceTypeStatement:
+		doc.Title = "Usage statement"
+		doc.Details[0] = "Statement number: " + invoice.Number
+		if invoice.PeriodStart != nil && invoice.PeriodEnd != nil {
+			doc.Details = append(doc.Details, "Period: "+invoice.PeriodStart.UTC().Format("2006-01-02")+
+				" to "+invoice.PeriodEnd.UTC().Format("2006-01-02"))
+		}
+		doc.Columns = []string{"Description", "Quantity", "Amount"}
+		for _, line := range invoice.Lines {
+			doc.Rows = append(doc.Rows, []string{line.Description, fmt.Sprint(line.Quantity), money(line.Amount)})
+		}
+		doc.Totals = []string{
+			"Opening balance: " + money(invoice.OpeningBalance),
+			"Charges: " + money(invoice.Total),
+			"Closing balance: " + money(invoice.ClosingBalance),
+		}
+		doc.Footer = "This statement is not a tax invoice"
+	default:
+		doc.Columns = []string{"Description", "Quantity", "Unit price", "Amount"}
+		for _, line := range invoice.Lines {
+			doc.Rows = append(doc.Rows, []string{line.Description, fmt.Sprint(line.Quantity), money(line.UnitPrice), money(line.Amount)})
+		}
+		doc.Totals = []string{"Total paid: " + money(invoice.Total)}
+	}
+
+	return pdfops.CreateInvoice(ctx, doc, out)
+}
+
+// formatInvoiceAmount formats an amount with 2 decimals, or 3 when it has
+// fractions of a cent like most operation prices
+func formatInvoiceAmount(amount float64) string {
+	value := Money(amount)
+	if value.Equal(value.Round(2)) {
+		return value.StringFixed(2)
+	}
+	return value.StringFixed(3)
+}
+
+// nonEmpty returns the values that are not empty
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,398 @@
+// internal/services/job_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrJobNotFound is returned when a job does not exist or belongs to another user
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when trying to cancel a job that already finished
+	ErrJobFinished = errors.New("job has already finished")
+	// ErrUnknownJobOperation is returned when no runner is registered for an operation
+	ErrUnknownJobOperation = errors.New("unsupported job operation")
+)
+
+// jobPollInterval is how often idle workers look for queued jobs in the database
+const jobPollInterval = 2 * time.Second
+
+// jobStaleAfter is how long a job may stay in processing without any update
+// before it is considered abandoned (for example after a server restart)
+const jobStaleAfter = time.Hour
+
+// JobRunner executes a job. It must honour ctx cancellation where possible and
+// may report progress (0-100) through the progress callback. The returned value
+// is stored as the job result.
+type JobRunner func(ctx context.Context, job *models.Job, progress func(int)) (interface{}, error)
+
+// JobService persists jobs in the database and executes them on a bounded worker pool
+type JobService struct {
+	db      *gorm.DB
+	workers int
+
+	mu      sync.Mutex
+	runners map[string]JobRunner
+	cancels map[string]context.CancelFunc
+	wake    chan struct{}
+	started bool
+
+	finishHooks []func(job *models.Job)
+}
+
+// NewJobService creates a job service with the given number of workers
+func NewJobService(db *gorm.DB, workers int) *JobService {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &JobService{
+		db:      db,
+		workers: workers,
+		runners: make(map[string]JobRunner),
+		cancels: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, workers),
+	}
+}
+
+// RegisterRunner registers the runner used for jobs of the given operation
+func (s *JobService) RegisterRunner(operation string, runner JobRunner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runners[operation] = runner
+}
+
+// OnFinish registers a hook called after a job reaches a terminal status
+func (s *JobService) OnFinish(hook func(job *models.Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishHooks = append(s.finishHooks, hook)
+}
+
+// notifyFinished runs the finish hooks for the job with the given ID
+func (s *JobService) notifyFinished(id string) {
+	s.mu.Lock()
+	hooks := append([]func(job *models.Job){}, s.finishHooks...)
+	s.mu.Unlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	job, err := s.GetJob(id)
+	if err != nil {
+		log.Printf("Failed to load finished job %s: %v", id, err)
+		return
+	}
+	for _, hook := range hooks {
+		hook(job)
+	}
+}
+
+// HasRunner reports whether a runner is registered for the operation
+func (s *JobService) HasRunner(operation string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.runners[operation]
+	return ok
+}
+
+// Operations returns the operations that can be submitted as jobs
+func (s *JobService) Operations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	operations := make([]string, 0, len(s.runners))
+	for operation := range s.runners {
+		operations = append(operations, operation)
+	}
+	return operations
+}
+
+// Start launches the worker pool. It is safe to call more than once.
+func (s *JobService) Start() {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	s.failStaleJobs()
+
+	fmt.Printf("Starting job worker pool with %d workers\n", s.workers)
+	for i := 0; i < s.workers; i++ {
+		go s.worker(i)
+	}
+}
+
+// Submit stores a new job in the queue. ID, UserID, Operation and Params are
+// taken from the given job; everything else is initialised by the service.
+func (s *JobService) Submit(job *models.Job) error {
+	if !s.HasRunner(job.Operation) {
+		return ErrUnknownJobOperation
+	}
+
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	now := time.Now()
+	job.Status = models.JobStatusQueued
+	job.Progress = 0
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	if err := s.db.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	// Nudge an idle worker; if all are busy the job is picked up by polling
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// GetJob returns a job by ID
+func (s *JobService) GetJob(id string) (*models.Job, error) {
+	var job models.Job
+	if err := s.db.First(&job, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetUserJob returns a job by ID if it belongs to the given user
+func (s *JobService) GetUserJob(id, userID string) (*models.Job, error) {
+	job, err := s.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Cancel cancels a queued or running job owned by the user
+func (s *JobService) Cancel(id, userID string) (*models.Job, error) {
+	job, err := s.GetUserJob(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if job.IsFinished() {
+		return job, ErrJobFinished
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", id, []string{models.JobStatusQueued, models.JobStatusProcessing}).
+		Updates(map[string]interface{}{
+			"status":       models.JobStatusCancelled,
+			"error":        "Job cancelled by user",
+			"completed_at": now,
+			"updated_at":   now,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// The job finished between the read and the update
+		job, err = s.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		return job, ErrJobFinished
+	}
+
+	// Stop the runner if it is executing on this instance
+	s.mu.Lock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	s.notifyFinished(id)
+
+	return s.GetJob(id)
+}
+
+// UpdateProgress stores the progress (0-100) of a running job
+func (s *JobService) UpdateProgress(id string, progress int) {
+	if progress < 0 {
+		progress = 0
+	} else if progress > 100 {
+		progress = 100
+	}
+
+	s.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, models.JobStatusProcessing).
+		Updates(map[string]interface{}{
+			"progress":   progress,
+			"updated_at": time.Now(),
+		})
+}
+
+// worker claims and runs queued jobs until the process exits
+func (s *JobService) worker(n int) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := s.claimNext()
+		if err != nil {
+			log.Printf("Job worker %d: failed to claim job: %v", n, err)
+		}
+		if job != nil {
+			s.run(job)
+			continue
+		}
+
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimNext atomically moves the oldest queued job to processing.
+// The conditional update makes claiming safe across several API instances.
+func (s *JobService) claimNext() (*models.Job, error) {
+	operations := s.Operations()
+	if len(operations) == 0 {
+		return nil, nil
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		// Find instead of First so an empty queue is not logged as an error
+		var jobs []models.Job
+		err := s.db.Where("status = ? AND operation IN ?", models.JobStatusQueued, operations).
+			Order("created_at ASC").
+			Limit(1).
+			Find(&jobs).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			return nil, nil
+		}
+		job := jobs[0]
+
+		now := time.Now()
+		result := s.db.Model(&models.Job{}).
+			Where("id = ? AND status = ?", job.ID, models.JobStatusQueued).
+			Updates(map[string]interface{}{
+				"status":     models.JobStatusProcessing,
+				"started_at": now,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = models.JobStatusProcessing
+			job.StartedAt = &now
+			return &job, nil
+		}
+		// Another worker claimed it first, try the next one
+	}
+
+	return nil, nil
+}
+
+// run executes a claimed job and stores its outcome
+func (s *JobService) run(job *models.Job) {
+	s.mu.Lock()
+	runner := s.runners[job.Operation]
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.cancels, job.ID)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	fmt.Printf("Running job %s (operation=%s, user=%s)\n", job.ID, job.Operation, job.UserID)
+
+	result, err := func() (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic in job processing: %v", r)
+			}
+		}()
+		return runner(ctx, job, func(progress int) {
+			s.UpdateProgress(job.ID, progress)
+		})
+	}()
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"completed_at": now,
+		"updated_at":   now,
+	}
+
+	if err != nil {
+		updates["status"] = models.JobStatusFailed
+		updates["error"] = err.Error()
+		log.Printf("Job %s failed: %v", job.ID, err)
+	} else {
+		resultJSON, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			updates["status"] = models.JobStatusFailed
+			updates["error"] = "Failed to encode job result: " + marshalErr.Error()
+		} else {
+			updates["status"] = models.JobStatusCompleted
+			updates["progress"] = 100
+			updates["result"] = string(resultJSON)
+		}
+	}
+
+	// Only finish jobs that are still processing so a cancellation is not overwritten
+	update := s.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, models.JobStatusProcessing).
+		Updates(updates)
+	if update.Error != nil {
+		log.Printf("Failed to store outcome of job %s: %v", job.ID, update.Error)
+		return
+	}
+	if update.RowsAffected == 1 {
+		s.notifyFinished(job.ID)
+	}
+}
+
+// failStaleJobs marks jobs that stopped reporting while processing as failed
+func (s *JobService) failStaleJobs() {
+	now := time.Now()
+	result := s.db.Model(&models.Job{}).
+		Where("status = ? AND updated_at < ?", models.JobStatusProcessing, now.Add(-jobStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":       models.JobStatusFailed,
+			"error":        "Job was interrupted before it could finish",
+			"completed_at": now,
+			"updated_at":   now,
+		})
+	if result.Error != nil {
+		log.Printf("Failed to clean up stale jobs: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		fmt.Printf("Marked %d stale jobs as failed\n", result.RowsAffected)
+	}
+}
@@ -3,10 +3,11 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"time"
 
 	"github.com/MegaPDF/megapdf-official/api/internal/constants"
-	"github.com/MegaPDF/megapdf-official/api/internal/models"
 	"github.com/MegaPDF/megapdf-official/api/internal/repository"
 	"gorm.io/gorm"
 )
@@ -15,24 +16,35 @@ import (
 var APIOperations = constants.APIOperations
 
 type KeyValidationService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	plans *PlanService
 }
 
 func NewKeyValidationService(db *gorm.DB) *KeyValidationService {
-	return &KeyValidationService{db: db}
+	return &KeyValidationService{
+		db:    db,
+		plans: NewPlanService(db),
+	}
 }
 
 type ValidationResult struct {
-	Valid  bool
-	UserID string
-	// Permissions field can be removed or left empty
+	Valid bool
+	// Forbidden is set when the key is valid but its scopes do not include the
+	// operation or it is used from an address outside its allowed IPs
+	Forbidden               bool
+	UserID                  string
+	KeyID                   string
+	Scopes                  []string // Operations the key may use; empty allows all
 	FreeOperationsRemaining int
 	Balance                 float64
 	FreeOperationsReset     time.Time
+	MaxFileSize             int64 // Upload limit of the user's plan in bytes
 	Error                   string
 }
 
-func (s *KeyValidationService) ValidateKey(apiKey string, operation string) (*ValidationResult, error) {
+// ValidateKey checks that apiKey may run operation from clientIP. An empty
+// clientIP skips the allowed IPs check.
+func (s *KeyValidationService) ValidateKey(apiKey string, operation string, clientIP string) (*ValidationResult, error) {
 	if apiKey == "" {
 		return &ValidationResult{
 			Valid: false,
@@ -41,31 +53,50 @@ func (s *KeyValidationService) ValidateKey(apiKey string, operation string) (*Va
 	}
 
 	// Look up the key
-	var keyRecord models.ApiKey
-	result := s.db.Preload("User").Where("`key` = ?", apiKey).First(&keyRecord)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	keyRecord, err := findApiKey(s.db, apiKey, true)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return &ValidationResult{
 				Valid: false,
 				Error: "Invalid API key",
 			}, nil
 		}
-		return nil, result.Error
+		return nil, err
 	}
 
 	// Check expiration
 	if keyRecord.ExpiresAt != nil && keyRecord.ExpiresAt.Before(time.Now()) {
+		message := "API key has expired"
+		if keyRecord.ReplacedByID != nil {
+			message = "API key has been rotated, use the new key"
+		}
 		return &ValidationResult{
 			Valid: false,
-			Error: "API key has expired",
+			Error: message,
 		}, nil
 	}
 
-	// No need to check permissions - all operations are allowed
+	// Check that the request comes from an allowed address
+	if clientIP != "" && !IPAllowed(keyRecord.AllowedIPs, clientIP) {
+		return &ValidationResult{
+			Valid:     false,
+			Forbidden: true,
+			Error:     fmt.Sprintf("API key is not allowed from IP address %s", clientIP),
+		}, nil
+	}
+
+	// Check that the operation is within the key's scopes
+	if !ScopeAllows(keyRecord.Scopes, operation) {
+		return &ValidationResult{
+			Valid:     false,
+			Forbidden: true,
+			Error:     fmt.Sprintf("API key is not allowed to use the '%s' operation", operation),
+		}, nil
+	}
 
 	// Update last used timestamp
 	now := time.Now()
-	s.db.Model(&keyRecord).Update("last_used", now)
+	s.db.Model(keyRecord).Update("last_used", now)
 
 	// Check free operations reset date
 	var freeOpsUsed int = 0
@@ -75,16 +106,19 @@ func (s *KeyValidationService) ValidateKey(apiKey string, operation string) (*Va
 		// Reset will happen on actual operation
 		freeOpsUsed = 0
 
-		// Next reset date is first day of next month
-		nextMonth := now.AddDate(0, 1, 0)
-		freeOpsReset = time.Date(nextMonth.Year(), nextMonth.Month(), 1, 0, 0, 0, 0, time.Local)
+		// The next billing cycle starts where the current one ended
+		freeOpsReset = keyRecord.User.FreeOperationsReset.AddDate(0, 1, 0)
+		if freeOpsReset.Before(now) {
+			freeOpsReset = now.AddDate(0, 1, 0)
+		}
 	} else {
 		freeOpsUsed = keyRecord.User.FreeOperationsUsed
 		freeOpsReset = keyRecord.User.FreeOperationsReset
 	}
 
 	// Calculate remaining free operations
-	freeOpsRemaining := constants.FreeOperationsMonthly - freeOpsUsed
+	plan := s.plans.planOf(s.db, &keyRecord.User)
+	freeOpsRemaining := plan.IncludedOperations - freeOpsUsed
 	if freeOpsRemaining < 0 {
 		freeOpsRemaining = 0
 	}
@@ -92,11 +126,63 @@ func (s *KeyValidationService) ValidateKey(apiKey string, operation string) (*Va
 	return &ValidationResult{
 		Valid:                   true,
 		UserID:                  keyRecord.UserID,
+		KeyID:                   keyRecord.ID,
+		Scopes:                  keyRecord.Scopes,
 		FreeOperationsRemaining: freeOpsRemaining,
 		Balance:                 keyRecord.User.Balance,
 		FreeOperationsReset:     freeOpsReset,
+		MaxFileSize:             planMaxFileSize(plan),
 	}, nil
 }
+
+// MaxFileSize returns the upload limit in bytes of a user's plan
+func (s *KeyValidationService) MaxFileSize(userID string) int64 {
+	return planMaxFileSize(s.plans.ForUser(userID))
+}
+
+// ScopeAllows reports whether a key with the given scopes may use an
+// operation. Keys without scopes may use every operation, and routes that are
+// not API operations (e.g. job status) are not restricted.
+func ScopeAllows(scopes []string, operation string) bool {
+	if len(scopes) == 0 || !isAPIOperation(operation) {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAllowed reports whether a key with the given allowed CIDR ranges may be
+// used from ip. Keys without allowed IPs may be used from anywhere.
+func IPAllowed(allowedIPs []string, ip string) bool {
+	if len(allowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range allowedIPs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAPIOperation reports whether operation is listed in APIOperations
+func isAPIOperation(operation string) bool {
+	for _, op := range APIOperations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *KeyValidationService) GetPricingSettings() (float64, int, error) {
 	pricingRepo := repository.NewPricingRepository()
 	pricing, err := pricingRepo.GetPricingSettings()
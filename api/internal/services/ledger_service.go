@@ -0,0 +1,284 @@
+// internal/services/ledger_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnbalancedJournal is returned when the lines of a journal entry do not
+// sum up to zero
+var ErrUnbalancedJournal = errors.New("journal entry does not balance")
+
+// LedgerService records every balance movement in a double-entry ledger.
+// users.balance stays the balance read by the API; the ledger is the record
+// it is reconciled against (see Reconcile).
+type LedgerService struct {
+	db *gorm.DB
+}
+
+func NewLedgerService(db *gorm.DB) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+// LedgerLine is one line of a journal entry: a positive amount debits the
+// account, a negative amount credits it
+type LedgerLine struct {
+	AccountID string
+	Amount    decimal.Decimal
+}
+
+// Money rounds an amount to the 3 decimals stored for balances
+func Money(amount float64) decimal.Decimal {
+	return decimal.NewFromFloat(amount).Round(3)
+}
+
+// Post records a journal entry made of lines, which must sum up to zero.
+// tx is the database transaction changing the balances.
+func (s *LedgerService) Post(tx *gorm.DB, description, reference string, lines ...LedgerLine) (*models.JournalEntry, error) {
+	total := decimal.Zero
+	for _, line := range lines {
+		total = total.Add(line.Amount)
+	}
+	if !total.IsZero() {
+		return nil, fmt.Errorf("%w: %s is off by %s", ErrUnbalancedJournal, description, total)
+	}
+
+	now := time.Now()
+	journal := models.JournalEntry{
+		ID:          uuid.New().String(),
+		Description: description,
+		Reference:   reference,
+		CreatedAt:   now,
+	}
+	for _, line := range lines {
+		if line.Amount.IsZero() {
+			continue
+		}
+		journal.Entries = append(journal.Entries, models.LedgerEntry{
+			ID:             uuid.New().String(),
+			JournalEntryID: journal.ID,
+			AccountID:      line.AccountID,
+			Amount:         line.Amount.Round(3),
+			CreatedAt:      now,
+		})
+	}
+	if len(journal.Entries) == 0 {
+		return &journal, nil
+	}
+
+	if err := tx.Create(&journal).Error; err != nil {
+		return nil, fmt.Errorf("failed to post journal entry: %w", err)
+	}
+	return &journal, nil
+}
+
+// DebitUser records money leaving a user's balance for the account code, e.g.
+// an operation cost moved to the reservations. balanceBefore is the user's
+// balance before the change, read under the user's row lock; it opens the
+// ledger account of users who had a balance before the ledger existed.
+func (s *LedgerService) DebitUser(tx *gorm.DB, userID string, balanceBefore decimal.Decimal, code string, amount decimal.Decimal, description, reference string) error {
+	user, err := s.UserAccount(tx, userID, balanceBefore)
+	if err != nil {
+		return err
+	}
+	to, err := s.Account(tx, code)
+	if err != nil {
+		return err
+	}
+	_, err = s.Post(tx, description, reference,
+		LedgerLine{AccountID: user.ID, Amount: amount},
+		LedgerLine{AccountID: to.ID, Amount: amount.Neg()},
+	)
+	return err
+}
+
+// CreditUser records money added to a user's balance from the account code,
+// e.g. a deposit received in cash. balanceBefore is as for DebitUser.
+func (s *LedgerService) CreditUser(tx *gorm.DB, userID string, balanceBefore decimal.Decimal, code string, amount decimal.Decimal, description, reference string) error {
+	user, err := s.UserAccount(tx, userID, balanceBefore)
+	if err != nil {
+		return err
+	}
+	from, err := s.Account(tx, code)
+	if err != nil {
+		return err
+	}
+	_, err = s.Post(tx, description, reference,
+		LedgerLine{AccountID: from.ID, Amount: amount},
+		LedgerLine{AccountID: user.ID, Amount: amount.Neg()},
+	)
+	return err
+}
+
+// Transfer debits the account fromCode and credits toCode, e.g. to move held
+// balance from the reservations to the revenue once an operation succeeded
+func (s *LedgerService) Transfer(tx *gorm.DB, fromCode, toCode string, amount decimal.Decimal, description, reference string) error {
+	from, err := s.Account(tx, fromCode)
+	if err != nil {
+		return err
+	}
+	to, err := s.Account(tx, toCode)
+	if err != nil {
+		return err
+	}
+	_, err = s.Post(tx, description, reference,
+		LedgerLine{AccountID: from.ID, Amount: amount},
+		LedgerLine{AccountID: to.ID, Amount: amount.Neg()},
+	)
+	return err
+}
+
+// Account returns the system account with code, creating it on first use
+func (s *LedgerService) Account(tx *gorm.DB, code string) (*models.LedgerAccount, error) {
+	accountType, _, _ := strings.Cut(code, ":")
+	return s.findOrCreateAccount(tx, code, accountType, nil)
+}
+
+// UserAccount returns the ledger account of a user's balance. The account of
+// a user who had a balance before the ledger existed is opened with that
+// balance, credited from the opening balances account.
+func (s *LedgerService) UserAccount(tx *gorm.DB, userID string, balance decimal.Decimal) (*models.LedgerAccount, error) {
+	code := models.LedgerAccountUserPrefix + userID
+	var account models.LedgerAccount
+	err := tx.Where("code = ?", code).First(&account).Error
+	if err == nil {
+		return &account, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find ledger account: %w", err)
+	}
+
+	created, err := s.findOrCreateAccount(tx, code, models.LedgerAccountTypeLiability, &userID)
+	if err != nil {
+		return nil, err
+	}
+	if !balance.IsZero() {
+		opening, err := s.Account(tx, models.LedgerAccountOpening)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.Post(tx, "Opening balance", userID,
+			LedgerLine{AccountID: opening.ID, Amount: balance},
+			LedgerLine{AccountID: created.ID, Amount: balance.Neg()},
+		); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// findOrCreateAccount creates an account unless another request already did
+func (s *LedgerService) findOrCreateAccount(tx *gorm.DB, code, accountType string, userID *string) (*models.LedgerAccount, error) {
+	var existing models.LedgerAccount
+	if err := tx.Where("code = ?", code).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+
+	account := models.LedgerAccount{
+		ID:        uuid.New().String(),
+		Code:      code,
+		Type:      accountType,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return nil, fmt.Errorf("failed to create ledger account %s: %w", code, err)
+	}
+	if err := tx.Where("code = ?", code).First(&account).Error; err != nil {
+		return nil, fmt.Errorf("failed to find ledger account %s: %w", code, err)
+	}
+	return &account, nil
+}
+
+// BalanceDrift is a user whose balance differs from the ledger
+type BalanceDrift struct {
+	UserID        string          `json:"userId"`
+	Email         string          `json:"email"`
+	Balance       decimal.Decimal `json:"balance"`
+	LedgerBalance decimal.Decimal `json:"ledgerBalance"`
+	Difference    decimal.Decimal `json:"difference"`
+}
+
+// LedgerReport is the result of reconciling the balances against the ledger
+type LedgerReport struct {
+	CheckedUsers       int             `json:"checkedUsers"`
+	UntrackedUsers     int64           `json:"untrackedUsers"` // Users with a balance but no ledger account yet
+	Drift              []BalanceDrift  `json:"drift"`
+	UnbalancedJournals []string        `json:"unbalancedJournals"`
+	TrialBalance       decimal.Decimal `json:"trialBalance"` // Sum of all entries, zero when the ledger is consistent
+	GeneratedAt        time.Time       `json:"generatedAt"`
+}
+
+// Reconcile compares the balance of every user with a ledger account to the
+// balance derived from the ledger entries, and checks that every journal
+// entry balances
+func (s *LedgerService) Reconcile() (*LedgerReport, error) {
+	report := &LedgerReport{
+		Drift:              []BalanceDrift{},
+		UnbalancedJournals: []string{},
+		GeneratedAt:        time.Now(),
+	}
+
+	var rows []struct {
+		UserID  string
+		Email   string
+		Balance decimal.Decimal
+		Entries decimal.Decimal
+	}
+	if err := s.db.Table("ledger_accounts").
+		Select("ledger_accounts.user_id, users.email, users.balance, COALESCE(SUM(ledger_entries.amount), 0) AS entries").
+		Joins("JOIN users ON users.id = ledger_accounts.user_id").
+		Joins("LEFT JOIN ledger_entries ON ledger_entries.account_id = ledger_accounts.id").
+		Where("ledger_accounts.user_id IS NOT NULL").
+		Group("ledger_accounts.user_id, users.email, users.balance").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to compute ledger balances: %w", err)
+	}
+
+	report.CheckedUsers = len(rows)
+	for _, row := range rows {
+		// User accounts are liabilities, credited (negative) when the balance grows
+		ledgerBalance := row.Entries.Neg().Round(3)
+		balance := row.Balance.Round(3)
+		if !balance.Equal(ledgerBalance) {
+			report.Drift = append(report.Drift, BalanceDrift{
+				UserID:        row.UserID,
+				Email:         row.Email,
+				Balance:       balance,
+				LedgerBalance: ledgerBalance,
+				Difference:    balance.Sub(ledgerBalance),
+			})
+		}
+	}
+
+	if err := s.db.Model(&models.User{}).
+		Where("balance <> 0 AND id NOT IN (?)",
+			s.db.Table("ledger_accounts").Select("user_id").Where("user_id IS NOT NULL")).
+		Count(&report.UntrackedUsers).Error; err != nil {
+		return nil, fmt.Errorf("failed to count untracked users: %w", err)
+	}
+
+	if err := s.db.Table("ledger_entries").
+		Group("journal_entry_id").
+		Having("SUM(amount) <> 0").
+		Pluck("journal_entry_id", &report.UnbalancedJournals).Error; err != nil {
+		return nil, fmt.Errorf("failed to check journal entries: %w", err)
+	}
+
+	var trialBalance decimal.NullDecimal
+	if err := s.db.Table("ledger_entries").Select("SUM(amount)").Scan(&trialBalance).Error; err != nil {
+		return nil, fmt.Errorf("failed to compute trial balance: %w", err)
+	}
+	report.TrialBalance = trialBalance.Decimal.Round(3)
+
+	return report, nil
+}
@@ -0,0 +1,79 @@
+// internal/services/payment_provider.go
+package services
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Payment providers deposits can be paid with
+const (
+	PaymentProviderPayPal = "paypal"
+	PaymentProviderStripe = "stripe"
+)
+
+// Outcomes of a payment reported by a webhook event
+const (
+	PaymentEventCompleted = "completed"
+	PaymentEventFailed    = "failed"
+	PaymentEventRefunded  = "refunded"
+)
+
+// Errors returned by payment providers
+var (
+	// ErrInvalidWebhookSignature is returned for webhook deliveries the
+	// provider did not sign
+	ErrInvalidWebhookSignature = errors.New("invalid payment webhook signature")
+	// ErrInvalidWebhookEvent is returned for webhook events that cannot be
+	// read, e.g. without an ID
+	ErrInvalidWebhookEvent = errors.New("invalid payment webhook event")
+	// ErrPaymentDeclined is returned when the provider refused to charge a
+	// saved payment method. Other charge errors leave it open whether the
+	// charge was made.
+	ErrPaymentDeclined = errors.New("payment declined")
+)
+
+// PaymentProvider takes the payments of deposits. Deposits are identified by
+// the provider's payment ID, stored as the PaymentID of their transaction.
+type PaymentProvider interface {
+	// Name returns the name deposits and webhook URLs use for the provider
+	Name() string
+	// CreateCheckout starts a payment of amount and returns its payment ID
+	// and the URL the user approves it at
+	CreateCheckout(userID string, amount float64, description string) (paymentID string, checkoutURL string, err error)
+	// VerifyPayment completes an approved payment and returns whether it was
+	// paid and the amount paid
+	VerifyPayment(paymentID string) (bool, float64, error)
+	// HandleWebhook checks the signature of a webhook delivery and returns
+	// its event. Deliveries that fail the check get ErrInvalidWebhookSignature.
+	HandleWebhook(header http.Header, rawBody []byte) (*PaymentEvent, error)
+	// Refund pays amount of a completed payment back to the payer. refundID
+	// identifies the refund, so a retried request is paid once.
+	Refund(paymentID string, amount float64, refundID string) error
+}
+
+// SavedPaymentProvider is a PaymentProvider that can save a user's payment
+// method and charge it later while the user is away, for automatic top-ups
+type SavedPaymentProvider interface {
+	PaymentProvider
+	// CreateSetup starts saving a payment method of a user and returns the
+	// setup ID and the URL the user approves it at
+	CreateSetup(userID string) (setupID string, approvalURL string, err error)
+	// ConfirmSetup returns the saved payment method of an approved setup
+	ConfirmSetup(setupID string) (paymentMethodID string, err error)
+	// ChargeSaved charges amount to a saved payment method and returns the
+	// payment ID. chargeID identifies the charge, so a retried request is
+	// charged once.
+	ChargeSaved(paymentMethodID string, amount float64, description, chargeID string) (paymentID string, err error)
+}
+
+// PaymentEvent is a webhook event of a payment provider
+type PaymentEvent struct {
+	ID           string // Provider's event ID
+	Type         string // Provider's event type
+	ResourceType string
+	ResourceID   string
+	PaymentID    string // Payment the event is about
+	Outcome      string // PaymentEventCompleted, Failed, Refunded or empty if the event needs no processing
+	RawData      string
+}
@@ -0,0 +1,314 @@
+// internal/services/payment_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// webhookClaimTimeout is how long a delivery may hold an event before it is
+// considered abandoned and the event is processed again
+const webhookClaimTimeout = 10 * time.Minute
+
+// Errors returned by PaymentService
+var (
+	// ErrUnknownPaymentProvider is returned for providers that are not
+	// registered, e.g. because they are not configured
+	ErrUnknownPaymentProvider = errors.New("unknown payment provider")
+	// ErrDepositNotFound is returned when a user has no deposit with a
+	// payment ID
+	ErrDepositNotFound = errors.New("deposit not found")
+	// ErrDuplicateWebhookEvent is returned for events already processed
+	ErrDuplicateWebhookEvent = errors.New("payment webhook event was already processed")
+	// ErrWebhookEventInProgress is returned while another delivery of the
+	// event is being processed
+	ErrWebhookEventInProgress = errors.New("payment webhook event is being processed")
+)
+
+// PaymentService takes deposits with the registered payment providers and
+// processes their webhook events
+type PaymentService struct {
+	db        *gorm.DB
+	balance   *BalanceService
+	providers map[string]PaymentProvider
+}
+
+func NewPaymentService(db *gorm.DB, balanceService *BalanceService) *PaymentService {
+	return &PaymentService{
+		db:        db,
+		balance:   balanceService,
+		providers: make(map[string]PaymentProvider),
+	}
+}
+
+// RegisterProvider makes a provider available for deposits and webhooks
+func (s *PaymentService) RegisterProvider(provider PaymentProvider) {
+	s.providers[provider.Name()] = provider
+}
+
+// Provider returns a registered provider. An empty name is PayPal, the
+// provider of deposits made before the provider could be chosen.
+func (s *PaymentService) Provider(name string) (PaymentProvider, error) {
+	if name == "" {
+		name = PaymentProviderPayPal
+	}
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPaymentProvider, name)
+	}
+	return provider, nil
+}
+
+// CreateDeposit starts a deposit of amount paid with a provider. It returns
+// the pending transaction and the URL the user pays at.
+func (s *PaymentService) CreateDeposit(userID, providerName string, amount float64) (*models.Transaction, string, error) {
+	provider, err := s.Provider(providerName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	paymentID, checkoutURL, err := provider.CreateCheckout(userID, amount, "Balance Deposit")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create %s checkout: %w", provider.Name(), err)
+	}
+
+	transaction, err := s.balance.CreateDeposit(userID, amount, provider.Name(), paymentID)
+	if err != nil {
+		return nil, "", err
+	}
+	return transaction, checkoutURL, nil
+}
+
+// VerifyDeposit completes a deposit of a user once they paid it, with the
+// provider the deposit was made with. It returns whether the payment was
+// made and the amount paid; deposits the webhook completed already are
+// reported as paid.
+func (s *PaymentService) VerifyDeposit(userID, paymentID string) (bool, float64, error) {
+	var transaction models.Transaction
+	if err := s.db.Where("user_id = ? AND payment_id = ?", userID, paymentID).First(&transaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, 0, ErrDepositNotFound
+		}
+		return false, 0, err
+	}
+	if transaction.Status == "completed" {
+		return true, transaction.Amount, nil
+	}
+
+	provider, err := s.Provider(transaction.Provider)
+	if err != nil {
+		return false, 0, err
+	}
+
+	verified, amount, err := provider.VerifyPayment(paymentID)
+	if err != nil || !verified {
+		return verified, amount, err
+	}
+
+	if err := s.balance.CompleteDeposit(paymentID); err != nil {
+		return false, 0, fmt.Errorf("failed to complete deposit: %w", err)
+	}
+	return true, amount, nil
+}
+
+// RefundDeposit pays a deposit, or part of it, back through the provider the
+// deposit was made with and takes the refund from the user's balance. The
+// refund is recorded as pending while the provider is called, and as failed
+// with the balance restored if the provider refuses it.
+func (s *PaymentService) RefundDeposit(depositID string, refund Refund) (*models.Transaction, error) {
+	var deposit models.Transaction
+	if err := s.db.First(&deposit, "id = ?", depositID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDepositNotFound
+		}
+		return nil, err
+	}
+	provider, err := s.Provider(deposit.Provider)
+	if err != nil && !refund.SkipProvider {
+		return nil, err
+	}
+
+	transaction, err := s.balance.beginRefund(depositID, refund)
+	if err != nil {
+		return nil, err
+	}
+
+	if !refund.SkipProvider {
+		if err := provider.Refund(deposit.PaymentID, -transaction.Amount, transaction.ID); err != nil {
+			if finishErr := s.balance.finishRefund(transaction, false); finishErr != nil {
+				fmt.Printf("ERROR: Failed to restore the balance of refund %s: %v\n", transaction.ID, finishErr)
+			}
+			return nil, fmt.Errorf("failed to refund with %s: %w", provider.Name(), err)
+		}
+	}
+
+	if err := s.balance.finishRefund(transaction, true); err != nil {
+		// The money was paid back, the refund stays pending for support to
+		// look into
+		return nil, fmt.Errorf("refund %s was paid but could not be completed: %w", transaction.ID, err)
+	}
+	return transaction, nil
+}
+
+// HandleWebhook records and processes a webhook delivery of a provider. An
+// event is processed by one delivery only: later deliveries get
+// ErrDuplicateWebhookEvent, or ErrWebhookEventInProgress while the first one
+// runs. When processing fails the event is released so the provider's retry
+// processes it again.
+func (s *PaymentService) HandleWebhook(providerName string, header http.Header, rawBody []byte) error {
+	provider, err := s.Provider(providerName)
+	if err != nil {
+		return err
+	}
+
+	event, err := provider.HandleWebhook(header, rawBody)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Processing %s webhook: type=%s, resource=%s, ID=%s\n",
+		provider.Name(), event.Type, event.ResourceType, event.ResourceID)
+
+	eventKey := webhookEventKey(provider.Name(), event.ID)
+	webhookEvent := models.PaymentWebhookEvent{
+		ID:               uuid.New().String(),
+		Provider:         provider.Name(),
+		EventId:          event.ID,
+		EventType:        event.Type,
+		ResourceType:     event.ResourceType,
+		ResourceId:       event.ResourceID,
+		RawData:          event.RawData,
+		Status:           models.WebhookEventStatusProcessing,
+		ProcessedEventId: &eventKey,
+		CreatedAt:        time.Now(),
+	}
+	if err := s.claimWebhookEvent(&webhookEvent); err != nil {
+		return err
+	}
+
+	switch event.Outcome {
+	case PaymentEventCompleted:
+		err = s.completeDeposit(provider.Name(), event.PaymentID)
+	case PaymentEventFailed:
+		err = s.failDeposit(provider.Name(), event.PaymentID)
+	case PaymentEventRefunded:
+		err = s.noteRefund(provider.Name(), event.PaymentID)
+	}
+
+	if err != nil {
+		if releaseErr := s.db.Model(&webhookEvent).Updates(map[string]interface{}{
+			"status":             models.WebhookEventStatusFailed,
+			"processed_event_id": nil,
+		}).Error; releaseErr != nil {
+			fmt.Printf("ERROR: Failed to release webhook event %s: %v\n", event.ID, releaseErr)
+		}
+		return err
+	}
+
+	return s.db.Model(&webhookEvent).Update("status", models.WebhookEventStatusProcessed).Error
+}
+
+// claimWebhookEvent saves a delivery holding its event. The unique index on
+// processed_event_id decides which concurrent delivery gets the event; a
+// delivery holding it for longer than webhookClaimTimeout is taken over.
+func (s *PaymentService) claimWebhookEvent(webhookEvent *models.PaymentWebhookEvent) error {
+	for attempt := 0; ; attempt++ {
+		err := s.db.Create(webhookEvent).Error
+		if err == nil {
+			return nil
+		}
+
+		var existing models.PaymentWebhookEvent
+		if lookupErr := s.db.Where("processed_event_id = ?", *webhookEvent.ProcessedEventId).First(&existing).Error; lookupErr != nil {
+			if attempt == 0 && errors.Is(lookupErr, gorm.ErrRecordNotFound) {
+				continue // Released in the meantime
+			}
+			return fmt.Errorf("failed to save webhook event: %v", err)
+		}
+		if existing.Status == models.WebhookEventStatusProcessed {
+			return ErrDuplicateWebhookEvent
+		}
+		if attempt > 0 || time.Since(existing.CreatedAt) < webhookClaimTimeout {
+			return ErrWebhookEventInProgress
+		}
+
+		fmt.Printf("WARNING: Taking over webhook event %s abandoned by delivery %s\n", existing.EventId, existing.ID)
+		if err := s.db.Model(&models.PaymentWebhookEvent{}).
+			Where("id = ? AND status = ?", existing.ID, models.WebhookEventStatusProcessing).
+			Updates(map[string]interface{}{
+				"status":             models.WebhookEventStatusFailed,
+				"processed_event_id": nil,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to release webhook event: %v", err)
+		}
+	}
+}
+
+// webhookEventKey is the processed_event_id of a provider's event. Event IDs
+// are unique per provider only, so the key includes the provider.
+func webhookEventKey(provider, eventID string) string {
+	return provider + ":" + eventID
+}
+
+// completeDeposit credits the pending deposit of a payment reported as paid
+func (s *PaymentService) completeDeposit(provider, paymentID string) error {
+	// Credit the pending deposit, unless the client already verified it
+	err := s.balance.completeDeposit(paymentID, "Deposit - completed (webhook)")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("No pending %s deposit for payment %s, nothing to complete\n", provider, paymentID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete deposit: %v", err)
+	}
+	return nil
+}
+
+// failDeposit marks the pending deposit of a payment reported as failed
+func (s *PaymentService) failDeposit(provider, paymentID string) error {
+	err := s.balance.FailDeposit(paymentID, "Deposit - failed (webhook)")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("No pending %s deposit for payment %s, nothing to fail\n", provider, paymentID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fail deposit: %v", err)
+	}
+	return nil
+}
+
+// noteRefund handles a payment the provider reports as refunded. A pending
+// deposit fails; refunds of completed deposits change the balance only when
+// an admin records them, so refunds made at the provider without a recorded
+// refund are reported for support to record.
+func (s *PaymentService) noteRefund(provider, paymentID string) error {
+	var deposit models.Transaction
+	err := s.db.Where("payment_id = ? AND amount > 0", paymentID).First(&deposit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("No %s deposit for refunded payment %s\n", provider, paymentID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deposit.Status == "pending" {
+		return s.failDeposit(provider, paymentID)
+	}
+
+	var refunds int64
+	if err := s.db.Model(&models.Transaction{}).
+		Where("refund_of_id = ? AND status IN ?", deposit.ID, []string{"pending", models.TransactionStatusCompleted}).
+		Count(&refunds).Error; err != nil {
+		return err
+	}
+	if refunds == 0 {
+		fmt.Printf("WARNING: %s payment %s of deposit %s was refunded without a recorded refund; record it with skipProvider\n",
+			provider, paymentID, deposit.ID)
+	}
+	return nil
+}
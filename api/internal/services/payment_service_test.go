@@ -0,0 +1,119 @@
+// internal/services/payment_service_test.go
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+)
+
+// stubProvider is a payment provider whose webhook deliveries are their
+// PaymentEvent as JSON, without a signature
+type stubProvider struct {
+	name string
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) CreateCheckout(userID string, amount float64, description string) (string, string, error) {
+	return "", "", errors.New("not supported")
+}
+
+func (p *stubProvider) VerifyPayment(paymentID string) (bool, float64, error) {
+	return false, 0, errors.New("not supported")
+}
+
+func (p *stubProvider) HandleWebhook(header http.Header, rawBody []byte) (*PaymentEvent, error) {
+	var event PaymentEvent
+	if err := json.Unmarshal(rawBody, &event); err != nil {
+		return nil, err
+	}
+	event.RawData = string(rawBody)
+	return &event, nil
+}
+
+func (p *stubProvider) Refund(paymentID string, amount float64, refundID string) error {
+	return errors.New("not supported")
+}
+
+func stubEvent(t *testing.T, event PaymentEvent) []byte {
+	t.Helper()
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestWebhookEventIDsArePerProvider(t *testing.T) {
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(&stubProvider{name: PaymentProviderPayPal})
+	payments.RegisterProvider(&stubProvider{name: PaymentProviderStripe})
+	createTestDeposit(t, payments, PaymentProviderPayPal, "ORDER-1", 10)
+	createTestDeposit(t, payments, PaymentProviderStripe, "cs_test_1", 15)
+
+	// Both providers happen to use the same event ID
+	paypal := stubEvent(t, PaymentEvent{ID: "EVENT-1", PaymentID: "ORDER-1", Outcome: PaymentEventCompleted})
+	stripe := stubEvent(t, PaymentEvent{ID: "EVENT-1", PaymentID: "cs_test_1", Outcome: PaymentEventCompleted})
+
+	if err := payments.HandleWebhook(PaymentProviderPayPal, nil, paypal); err != nil {
+		t.Fatalf("PayPal HandleWebhook() error = %v", err)
+	}
+	if err := payments.HandleWebhook(PaymentProviderStripe, nil, stripe); err != nil {
+		t.Fatalf("Stripe HandleWebhook() error = %v, want the event processed", err)
+	}
+	if balance := userBalance(t, db); balance != 25 {
+		t.Errorf("balance = %v, want both deposits credited", balance)
+	}
+
+	// Replays are still recognized for each provider
+	if err := payments.HandleWebhook(PaymentProviderPayPal, nil, paypal); !errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Errorf("PayPal replay error = %v, want ErrDuplicateWebhookEvent", err)
+	}
+	if err := payments.HandleWebhook(PaymentProviderStripe, nil, stripe); !errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Errorf("Stripe replay error = %v, want ErrDuplicateWebhookEvent", err)
+	}
+
+	var events []models.PaymentWebhookEvent
+	if err := db.Order("provider").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	for _, event := range events {
+		if event.ProcessedEventId == nil || *event.ProcessedEventId != event.Provider+":EVENT-1" {
+			t.Errorf("%s event is held as %v, want it keyed by provider", event.Provider, event.ProcessedEventId)
+		}
+	}
+}
+
+func TestWebhookEventReleasedWhenProcessingFails(t *testing.T) {
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(&stubProvider{name: PaymentProviderStripe})
+	createTestDeposit(t, payments, PaymentProviderStripe, "cs_test_1", 15)
+
+	body := stubEvent(t, PaymentEvent{ID: "EVENT-1", PaymentID: "cs_test_1", Outcome: PaymentEventCompleted})
+
+	// The deposit cannot be credited while its user's table is gone
+	if err := db.Migrator().RenameTable("users", "users_away"); err != nil {
+		t.Fatal(err)
+	}
+	if err := payments.HandleWebhook(PaymentProviderStripe, nil, body); err == nil {
+		t.Fatal("HandleWebhook() succeeded without the users table")
+	}
+	if err := db.Migrator().RenameTable("users_away", "users"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider's retry processes the event again
+	if err := payments.HandleWebhook(PaymentProviderStripe, nil, body); err != nil {
+		t.Fatalf("retry error = %v", err)
+	}
+	if balance := userBalance(t, db); balance != 15 {
+		t.Errorf("balance = %v, want the deposit credited by the retry", balance)
+	}
+}
@@ -4,6 +4,7 @@ package services
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,15 +13,16 @@ import (
 
 	"github.com/MegaPDF/megapdf-official/api/internal/db"
 	"github.com/MegaPDF/megapdf-official/api/internal/models"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
+// PayPalService takes deposit payments with PayPal orders. PayPal accounts
+// saved for automatic top-ups are payment tokens of the PayPal vault.
 type PayPalService struct {
 	clientID     string
 	clientSecret string
 	apiBase      string
 	appURL       string
+	webhookID    string
 }
 
 func NewPayPalService(clientID, clientSecret, apiBase, appURL string) *PayPalService {
@@ -32,6 +34,12 @@ func NewPayPalService(clientID, clientSecret, apiBase, appURL string) *PayPalSer
 	}
 }
 
+// SetWebhookID sets the ID of the webhook whose deliveries
+// VerifyWebhookSignature accepts
+func (s *PayPalService) SetWebhookID(webhookID string) {
+	s.webhookID = webhookID
+}
+
 // GetAccessToken obtains an access token from PayPal
 func (s *PayPalService) GetAccessToken() (string, error) {
 	if s.clientID == "" || s.clientSecret == "" {
@@ -71,8 +79,13 @@ func (s *PayPalService) GetAccessToken() (string, error) {
 	return result.AccessToken, nil
 }
 
-// CreateOrder creates a PayPal order for deposit
-func (s *PayPalService) CreateOrder(userID string, amount float64, description string) (string, string, error) {
+// Name implements PaymentProvider
+func (s *PayPalService) Name() string {
+	return PaymentProviderPayPal
+}
+
+// CreateCheckout creates a PayPal order for deposit
+func (s *PayPalService) CreateCheckout(userID string, amount float64, description string) (string, string, error) {
 	accessToken, err := s.GetAccessToken()
 	if err != nil {
 		return "", "", err
@@ -163,8 +176,8 @@ func (s *PayPalService) CreateOrder(userID string, amount float64, description s
 	return orderID, approvalURL, nil
 }
 
-// VerifyOrder verifies and captures a PayPal order
-func (s *PayPalService) VerifyOrder(orderID string) (bool, float64, error) {
+// VerifyPayment verifies and captures a PayPal order
+func (s *PayPalService) VerifyPayment(orderID string) (bool, float64, error) {
 	accessToken, err := s.GetAccessToken()
 	if err != nil {
 		return false, 0, err
@@ -285,139 +298,399 @@ func (s *PayPalService) VerifyOrder(orderID string) (bool, float64, error) {
 	return true, amount, nil
 }
 
-// HandleWebhook processes PayPal webhook events
-func (s *PayPalService) HandleWebhook(event map[string]interface{}) error {
-	// Save webhook event to database for debugging/audit
-	eventID, _ := event["id"].(string)
-	eventType, _ := event["event_type"].(string)
-	resourceType, _ := event["resource_type"].(string)
+// VerifyWebhookSignature checks with PayPal's verify-webhook-signature API
+// that a delivery with the given headers and raw body was sent by PayPal for
+// the configured webhook. Deliveries that fail the check get
+// ErrInvalidWebhookSignature; other errors mean the check could not be made.
+func (s *PayPalService) VerifyWebhookSignature(header http.Header, rawBody []byte) error {
+	if s.webhookID == "" {
+		return fmt.Errorf("PayPal webhook ID is not configured")
+	}
+
+	transmission := map[string]string{
+		"auth_algo":         header.Get("PAYPAL-AUTH-ALGO"),
+		"cert_url":          header.Get("PAYPAL-CERT-URL"),
+		"transmission_id":   header.Get("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": header.Get("PAYPAL-TRANSMISSION-TIME"),
+	}
+	for field, value := range transmission {
+		if value == "" {
+			return fmt.Errorf("%w: missing %s", ErrInvalidWebhookSignature, field)
+		}
+	}
+	if !json.Valid(rawBody) {
+		return fmt.Errorf("%w: body is not JSON", ErrInvalidWebhookSignature)
+	}
+	transmission["webhook_id"] = s.webhookID
 
-	var resourceID string
-	if resource, ok := event["resource"].(map[string]interface{}); ok {
-		resourceID, _ = resource["id"].(string)
+	// The event is passed on byte for byte, since the signature covers the
+	// body exactly as it was sent
+	fields, err := json.Marshal(transmission)
+	if err != nil {
+		return err
 	}
+	payload := append(fields[:len(fields)-1], `,"webhook_event":`...)
+	payload = append(payload, rawBody...)
+	payload = append(payload, '}')
 
-	// Convert event to JSON for storage
-	eventJSON, err := json.Marshal(event)
+	accessToken, err := s.GetAccessToken()
 	if err != nil {
-		return fmt.Errorf("failed to marshal event: %v", err)
+		return err
 	}
 
-	webhookEvent := models.PaymentWebhookEvent{
-		ID:           uuid.New().String(),
-		EventId:      eventID,
-		EventType:    eventType,
-		ResourceType: resourceType,
-		ResourceId:   resourceID,
-		RawData:      string(eventJSON),
-		CreatedAt:    time.Now(),
+	url := fmt.Sprintf("%s/v1/notifications/verify-webhook-signature", s.apiBase)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
 	}
 
-	if err := db.DB.Create(&webhookEvent).Error; err != nil {
-		return fmt.Errorf("failed to save webhook event: %v", err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	// Process based on event type
-	switch eventType {
-	case "PAYMENT.CAPTURE.COMPLETED":
-		err = s.handlePaymentCompleted(event)
-	case "PAYMENT.CAPTURE.DENIED", "PAYMENT.CAPTURE.REFUNDED":
-		err = s.handlePaymentFailed(event)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to verify webhook signature: %s - %s", resp.Status, string(body))
 	}
 
-	return err
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.VerificationStatus != "SUCCESS" {
+		return fmt.Errorf("%w: verification status %s", ErrInvalidWebhookSignature, result.VerificationStatus)
+	}
+	return nil
 }
 
-// handlePaymentCompleted processes a completed payment
-func (s *PayPalService) handlePaymentCompleted(event map[string]interface{}) error {
-	var orderID string
-	if resource, ok := event["resource"].(map[string]interface{}); ok {
-		if data, ok := resource["supplementary_data"].(map[string]interface{}); ok {
-			if ids, ok := data["related_ids"].(map[string]interface{}); ok {
-				if id, ok := ids["order_id"].(string); ok {
-					orderID = id
-				} else if id, ok := ids["payment_id"].(string); ok {
-					orderID = id
-				}
-			}
-		}
+// HandleWebhook verifies the signature of a PayPal webhook delivery and
+// returns its event. Capture events are about the order of the deposit.
+func (s *PayPalService) HandleWebhook(header http.Header, rawBody []byte) (*PaymentEvent, error) {
+	// The signature covers the raw body, so it is checked before parsing
+	if err := s.VerifyWebhookSignature(header, rawBody); err != nil {
+		return nil, err
+	}
 
-		// If still no ID, try resource ID
-		if orderID == "" {
-			if id, ok := resource["id"].(string); ok {
-				orderID = id
-			}
-		}
+	var event struct {
+		ID           string `json:"id"`
+		EventType    string `json:"event_type"`
+		ResourceType string `json:"resource_type"`
+		Resource     struct {
+			ID                string `json:"id"`
+			SupplementaryData struct {
+				RelatedIDs struct {
+					OrderID   string `json:"order_id"`
+					PaymentID string `json:"payment_id"`
+				} `json:"related_ids"`
+			} `json:"supplementary_data"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(rawBody, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhookEvent, err)
+	}
+	if event.ID == "" {
+		return nil, fmt.Errorf("%w: missing id", ErrInvalidWebhookEvent)
 	}
 
-	if orderID == "" {
-		return fmt.Errorf("missing order ID in payment completed event")
+	paymentEvent := &PaymentEvent{
+		ID:           event.ID,
+		Type:         event.EventType,
+		ResourceType: event.ResourceType,
+		ResourceID:   event.Resource.ID,
+		RawData:      string(rawBody),
 	}
 
-	// Find the pending transaction
-	var transaction models.Transaction
-	if err := db.DB.Where("payment_id = ? AND status = ?", orderID, "pending").First(&transaction).Error; err != nil {
-		return fmt.Errorf("transaction not found: %v", err)
+	switch event.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED":
+		paymentEvent.Outcome = PaymentEventCompleted
+	case "PAYMENT.CAPTURE.DENIED":
+		paymentEvent.Outcome = PaymentEventFailed
+	case "PAYMENT.CAPTURE.REFUNDED":
+		paymentEvent.Outcome = PaymentEventRefunded
+	default:
+		return paymentEvent, nil
+	}
+
+	// Deposits are stored with the order ID, fall back to the capture ID
+	relatedIDs := event.Resource.SupplementaryData.RelatedIDs
+	switch {
+	case relatedIDs.OrderID != "":
+		paymentEvent.PaymentID = relatedIDs.OrderID
+	case relatedIDs.PaymentID != "":
+		paymentEvent.PaymentID = relatedIDs.PaymentID
+	default:
+		paymentEvent.PaymentID = event.Resource.ID
+	}
+	if paymentEvent.PaymentID == "" {
+		return nil, fmt.Errorf("%w: missing order ID in %s event", ErrInvalidWebhookEvent, event.EventType)
+	}
+	return paymentEvent, nil
+}
+
+// Refund refunds amount of the capture of a PayPal order
+func (s *PayPalService) Refund(orderID string, amount float64, refundID string) error {
+	accessToken, err := s.GetAccessToken()
+	if err != nil {
+		return err
 	}
 
-	// Get user's current balance
-	var user models.User
-	if err := db.DB.First(&user, "id = ?", transaction.UserID).Error; err != nil {
-		return fmt.Errorf("user not found: %v", err)
+	// Refunds are made against the capture, which is found on the order
+	detailsURL := fmt.Sprintf("%s/v2/checkout/orders/%s", s.apiBase, orderID)
+	req, err := http.NewRequest("GET", detailsURL, nil)
+	if err != nil {
+		return err
 	}
 
-	// Calculate new balance
-	newBalance := user.Balance + transaction.Amount
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	// Update user balance and transaction in a single transaction
-	return db.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&user).Update("balance", newBalance).Error; err != nil {
-			return err
-		}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		return tx.Model(&transaction).Updates(map[string]interface{}{
-			"status":        "completed",
-			"description":   "Deposit - completed (webhook)",
-			"balance_after": newBalance,
-		}).Error
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to get order details: %s - %s", resp.Status, string(body))
+	}
+
+	var order struct {
+		PurchaseUnits []struct {
+			Payments struct {
+				Captures []struct {
+					ID     string `json:"id"`
+					Amount struct {
+						CurrencyCode string `json:"currency_code"`
+					} `json:"amount"`
+				} `json:"captures"`
+			} `json:"payments"`
+		} `json:"purchase_units"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&order); err != nil {
+		return err
+	}
+	if len(order.PurchaseUnits) == 0 || len(order.PurchaseUnits[0].Payments.Captures) == 0 {
+		return fmt.Errorf("order %s has no capture to refund", orderID)
+	}
+	capture := order.PurchaseUnits[0].Payments.Captures[0]
+	currency := capture.Amount.CurrencyCode
+	if currency == "" {
+		currency = "USD"
+	}
+
+	refundData, err := json.Marshal(map[string]interface{}{
+		"amount": map[string]interface{}{
+			"currency_code": currency,
+			"value":         fmt.Sprintf("%.2f", amount),
+		},
 	})
+	if err != nil {
+		return err
+	}
+
+	refundURL := fmt.Sprintf("%s/v2/payments/captures/%s/refund", s.apiBase, capture.ID)
+	refundReq, err := http.NewRequest("POST", refundURL, bytes.NewBuffer(refundData))
+	if err != nil {
+		return err
+	}
+
+	refundReq.Header.Set("Content-Type", "application/json")
+	refundReq.Header.Set("Authorization", "Bearer "+accessToken)
+	refundReq.Header.Set("PayPal-Request-Id", "refund_"+refundID)
+
+	refundResp, err := client.Do(refundReq)
+	if err != nil {
+		return err
+	}
+	defer refundResp.Body.Close()
+
+	if refundResp.StatusCode != http.StatusCreated && refundResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(refundResp.Body)
+		return fmt.Errorf("failed to refund payment: %s - %s", refundResp.Status, string(body))
+	}
+	return nil
 }
 
-// handlePaymentFailed processes a failed payment
-func (s *PayPalService) handlePaymentFailed(event map[string]interface{}) error {
-	var orderID string
-	if resource, ok := event["resource"].(map[string]interface{}); ok {
-		if data, ok := resource["supplementary_data"].(map[string]interface{}); ok {
-			if ids, ok := data["related_ids"].(map[string]interface{}); ok {
-				if id, ok := ids["order_id"].(string); ok {
-					orderID = id
-				} else if id, ok := ids["payment_id"].(string); ok {
-					orderID = id
-				}
-			}
-		}
+// CreateSetup creates a PayPal vault setup token, which saves the user's
+// PayPal account for payments made without them once they approve it
+func (s *PayPalService) CreateSetup(userID string) (string, string, error) {
+	setupData := map[string]interface{}{
+		"payment_source": map[string]interface{}{
+			"paypal": map[string]interface{}{
+				"description":                    "MegaPDF automatic top-ups",
+				"usage_type":                     "MERCHANT",
+				"customer_type":                  "CONSUMER",
+				"permit_multiple_payment_tokens": false,
+				"experience_context": map[string]interface{}{
+					"brand_name":          "MegaPDF",
+					"locale":              "en-US",
+					"shipping_preference": "NO_SHIPPING",
+					"return_url":          fmt.Sprintf("%s/en/dashboard/billing?setup=paypal", s.appURL),
+					"cancel_url":          fmt.Sprintf("%s/en/dashboard/billing", s.appURL),
+				},
+			},
+		},
+	}
 
-		// If still no ID, try resource ID
-		if orderID == "" {
-			if id, ok := resource["id"].(string); ok {
-				orderID = id
-			}
+	var result struct {
+		ID    string `json:"id"`
+		Links []struct {
+			Href string `json:"href"`
+			Rel  string `json:"rel"`
+		} `json:"links"`
+	}
+	if err := s.request("POST", "/v3/vault/setup-tokens", setupData, "setup_"+userID+"_"+strconv.FormatInt(time.Now().Unix(), 10), &result); err != nil {
+		return "", "", fmt.Errorf("failed to create setup token: %w", err)
+	}
+
+	var approvalURL string
+	for _, link := range result.Links {
+		if link.Rel == "approve" {
+			approvalURL = link.Href
+			break
 		}
 	}
+	if result.ID == "" || approvalURL == "" {
+		return "", "", fmt.Errorf("setup token ID or approval URL not found in response")
+	}
+
+	return result.ID, approvalURL, nil
+}
+
+// ConfirmSetup exchanges an approved setup token for a payment token
+func (s *PayPalService) ConfirmSetup(setupTokenID string) (string, error) {
+	tokenData := map[string]interface{}{
+		"payment_source": map[string]interface{}{
+			"token": map[string]interface{}{
+				"id":   setupTokenID,
+				"type": "SETUP_TOKEN",
+			},
+		},
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := s.request("POST", "/v3/vault/payment-tokens", tokenData, "token_"+setupTokenID, &result); err != nil {
+		return "", fmt.Errorf("failed to create payment token: %w", err)
+	}
+	if result.ID == "" {
+		return "", fmt.Errorf("payment token ID not found in response")
+	}
+
+	return result.ID, nil
+}
+
+// ChargeSaved creates and captures an order paid with a payment token. Its
+// payment ID is the order ID, like the deposits paid at checkout.
+func (s *PayPalService) ChargeSaved(paymentTokenID string, amount float64, description, chargeID string) (string, error) {
+	orderData := map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{
+			{
+				"reference_id": chargeID,
+				"description":  description,
+				"amount": map[string]interface{}{
+					"currency_code": "USD",
+					"value":         fmt.Sprintf("%.2f", amount),
+				},
+			},
+		},
+		"payment_source": map[string]interface{}{
+			"paypal": map[string]interface{}{
+				"vault_id": paymentTokenID,
+			},
+		},
+	}
+
+	// Orders paid with a payment token are captured when they are created
+	var order struct {
+		ID            string `json:"id"`
+		Status        string `json:"status"`
+		PurchaseUnits []struct {
+			Payments struct {
+				Captures []struct {
+					Status string `json:"status"`
+				} `json:"captures"`
+			} `json:"payments"`
+		} `json:"purchase_units"`
+	}
+	err := s.request("POST", "/v2/checkout/orders", orderData, "charge_"+chargeID, &order)
+	var apiErr *paypalAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+		return "", fmt.Errorf("%w: %v", ErrPaymentDeclined, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create order: %w", err)
+	}
 
-	if orderID == "" {
-		return fmt.Errorf("missing order ID in payment failed event")
+	if order.Status != "COMPLETED" || len(order.PurchaseUnits) == 0 || len(order.PurchaseUnits[0].Payments.Captures) == 0 {
+		return "", fmt.Errorf("%w: order %s is %s", ErrPaymentDeclined, order.ID, order.Status)
 	}
+	if captureStatus := order.PurchaseUnits[0].Payments.Captures[0].Status; captureStatus != "COMPLETED" {
+		return "", fmt.Errorf("%w: capture of order %s is %s", ErrPaymentDeclined, order.ID, captureStatus)
+	}
+
+	return order.ID, nil
+}
+
+// paypalAPIError is a response of the PayPal API with an error status
+type paypalAPIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
 
-	// Find the transaction
-	var transaction models.Transaction
-	if err := db.DB.Where("payment_id = ? AND status = ?", orderID, "pending").First(&transaction).Error; err != nil {
-		return fmt.Errorf("transaction not found: %v", err)
+func (e *paypalAPIError) Error() string {
+	return fmt.Sprintf("%s - %s", e.Status, e.Body)
+}
+
+// request calls the PayPal API with a JSON body and decodes the response into
+// result. Requests with a request ID are carried out once by PayPal, however
+// often they are sent. Error statuses are returned as *paypalAPIError.
+func (s *PayPalService) request(method, path string, data interface{}, requestID string, result interface{}) error {
+	accessToken, err := s.GetAccessToken()
+	if err != nil {
+		return err
 	}
 
-	// Mark as failed
-	return db.DB.Model(&transaction).Updates(map[string]interface{}{
-		"status":      "failed",
-		"description": "Deposit - failed (webhook)",
-	}).Error
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, s.apiBase+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if requestID != "" {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return &paypalAPIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
 }
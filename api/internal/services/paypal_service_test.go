@@ -0,0 +1,307 @@
+// internal/services/paypal_service_test.go
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	testPayPalWebhookID = "WH-TEST-1"
+	testPayPalToken     = "test-access-token"
+)
+
+// fakePayPal is an httptest server standing in for the PayPal API. It signs
+// deliveries like PayPal does, over "<transmission id>|<time>|<webhook
+// id>|<CRC32 of the body>", with an HMAC key instead of a certificate.
+type fakePayPal struct {
+	*httptest.Server
+
+	key []byte
+
+	mu            sync.Mutex
+	verifications int
+}
+
+func newFakePayPal(t *testing.T) *fakePayPal {
+	p := &fakePayPal{key: []byte("fake-paypal-signing-key")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "client-id" || pass != "client-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": testPayPalToken})
+	})
+	mux.HandleFunc("POST /v1/notifications/verify-webhook-signature", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testPayPalToken {
+			http.Error(w, `{"name":"AUTHENTICATION_FAILURE"}`, http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			AuthAlgo         string          `json:"auth_algo"`
+			CertURL          string          `json:"cert_url"`
+			TransmissionID   string          `json:"transmission_id"`
+			TransmissionSig  string          `json:"transmission_sig"`
+			TransmissionTime string          `json:"transmission_time"`
+			WebhookID        string          `json:"webhook_id"`
+			WebhookEvent     json.RawMessage `json:"webhook_event"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, `{"name":"VALIDATION_ERROR"}`, http.StatusBadRequest)
+			return
+		}
+
+		p.mu.Lock()
+		p.verifications++
+		p.mu.Unlock()
+
+		status := "FAILURE"
+		if hmac.Equal([]byte(request.TransmissionSig), []byte(p.signature(request.TransmissionID, request.TransmissionTime, request.WebhookID, request.WebhookEvent))) {
+			status = "SUCCESS"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"verification_status": status})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakePayPal) signature(transmissionID, transmissionTime, webhookID string, body []byte) string {
+	mac := hmac.New(sha256.New, p.key)
+	fmt.Fprintf(mac, "%s|%s|%s|%d", transmissionID, transmissionTime, webhookID, crc32.ChecksumIEEE(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign returns the headers PayPal sends with a delivery of body
+func (p *fakePayPal) sign(transmissionID string, body []byte) http.Header {
+	transmissionTime := time.Now().UTC().Format(time.RFC3339)
+	header := http.Header{}
+	header.Set("PAYPAL-AUTH-ALGO", "SHA256withRSA")
+	header.Set("PAYPAL-CERT-URL", p.URL+"/v1/notifications/certs/CERT-1")
+	header.Set("PAYPAL-TRANSMISSION-ID", transmissionID)
+	header.Set("PAYPAL-TRANSMISSION-TIME", transmissionTime)
+	header.Set("PAYPAL-TRANSMISSION-SIG", p.signature(transmissionID, transmissionTime, testPayPalWebhookID, body))
+	return header
+}
+
+func (p *fakePayPal) verificationCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.verifications
+}
+
+// newTestPaymentService returns a payment service on an empty database with
+// a user without balance, user-1
+func newTestPaymentService(t *testing.T) (*PaymentService, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t,
+		&models.User{}, &models.Transaction{}, &models.PaymentWebhookEvent{},
+		&models.LedgerAccount{}, &models.JournalEntry{}, &models.LedgerEntry{},
+		&models.Invoice{}, &models.InvoiceCounter{},
+	)
+	if err := db.Create(&models.User{ID: "user-1", Email: "user@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Invoices read the settings with the global connection
+	useTestSettings(t)
+
+	return NewPaymentService(db, NewBalanceService(db)), db
+}
+
+func newTestPayPalService(fake *fakePayPal) *PayPalService {
+	service := NewPayPalService("client-id", "client-secret", fake.URL, "https://megapdf.example.com")
+	service.SetWebhookID(testPayPalWebhookID)
+	return service
+}
+
+// createTestDeposit records a pending deposit of user-1
+func createTestDeposit(t *testing.T, payments *PaymentService, provider, paymentID string, amount float64) {
+	t.Helper()
+	if _, err := payments.balance.CreateDeposit("user-1", amount, provider, paymentID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func userBalance(t *testing.T, db *gorm.DB) float64 {
+	t.Helper()
+	var user models.User
+	if err := db.First(&user, "id = ?", "user-1").Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Balance
+}
+
+func depositStatus(t *testing.T, db *gorm.DB, paymentID string) string {
+	t.Helper()
+	var transaction models.Transaction
+	if err := db.First(&transaction, "payment_id = ?", paymentID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return transaction.Status
+}
+
+func paypalCaptureEvent(eventID, eventType, orderID string) []byte {
+	return []byte(`{"id":"` + eventID + `","event_type":"` + eventType + `","resource_type":"capture",` +
+		`"resource":{"id":"CAPTURE-1","status":"COMPLETED","amount":{"currency_code":"USD","value":"25.00"},` +
+		`"supplementary_data":{"related_ids":{"order_id":"` + orderID + `"}}}}`)
+}
+
+func TestPayPalWebhookCreditsDepositOnce(t *testing.T) {
+	fake := newFakePayPal(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestPayPalService(fake))
+	createTestDeposit(t, payments, PaymentProviderPayPal, "ORDER-1", 25)
+
+	body := paypalCaptureEvent("WH-EVENT-1", "PAYMENT.CAPTURE.COMPLETED", "ORDER-1")
+	if err := payments.HandleWebhook(PaymentProviderPayPal, fake.sign("TX-1", body), body); err != nil {
+		t.Fatalf("HandleWebhook() error = %v", err)
+	}
+	if balance := userBalance(t, db); balance != 25 {
+		t.Fatalf("balance = %v, want 25", balance)
+	}
+	if status := depositStatus(t, db, "ORDER-1"); status != "completed" {
+		t.Errorf("deposit status = %q, want completed", status)
+	}
+
+	// PayPal delivers an event again when it misses the response, with a new
+	// transmission
+	for i, header := range []http.Header{fake.sign("TX-1", body), fake.sign("TX-2", body)} {
+		if err := payments.HandleWebhook(PaymentProviderPayPal, header, body); !errors.Is(err, ErrDuplicateWebhookEvent) {
+			t.Errorf("replay %d error = %v, want ErrDuplicateWebhookEvent", i+1, err)
+		}
+	}
+	if balance := userBalance(t, db); balance != 25 {
+		t.Errorf("balance after replays = %v, want the deposit credited once", balance)
+	}
+
+	var events []models.PaymentWebhookEvent
+	if err := db.Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Status != models.WebhookEventStatusProcessed || events[0].Provider != PaymentProviderPayPal {
+		t.Errorf("recorded events = %+v, want one processed PayPal event", events)
+	}
+}
+
+func TestPayPalWebhookRejectsInvalidSignatures(t *testing.T) {
+	fake := newFakePayPal(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestPayPalService(fake))
+	createTestDeposit(t, payments, PaymentProviderPayPal, "ORDER-1", 25)
+
+	body := paypalCaptureEvent("WH-EVENT-1", "PAYMENT.CAPTURE.COMPLETED", "ORDER-1")
+	signed := fake.sign("TX-1", body)
+
+	forged := fake.sign("TX-1", body)
+	forged.Set("PAYPAL-TRANSMISSION-SIG", "forged")
+
+	unsigned := fake.sign("TX-1", body)
+	unsigned.Del("PAYPAL-TRANSMISSION-SIG")
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+	}{
+		{"forged signature", forged, body},
+		{"missing signature", unsigned, body},
+		{"tampered body", signed, paypalCaptureEvent("WH-EVENT-1", "PAYMENT.CAPTURE.COMPLETED", "ORDER-2")},
+		{"reformatted body", signed, bytes.Replace(body, []byte(`"id":`), []byte(`"id": `), 1)},
+		{"not JSON", signed, []byte("id=WH-EVENT-1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := payments.HandleWebhook(PaymentProviderPayPal, tt.header, tt.body); !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Errorf("HandleWebhook() error = %v, want ErrInvalidWebhookSignature", err)
+			}
+		})
+	}
+
+	if balance := userBalance(t, db); balance != 0 {
+		t.Errorf("balance = %v, want nothing credited", balance)
+	}
+	var events int64
+	if err := db.Model(&models.PaymentWebhookEvent{}).Count(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if events != 0 {
+		t.Errorf("recorded %d events of rejected deliveries", events)
+	}
+}
+
+func TestPayPalWebhookOtherWebhook(t *testing.T) {
+	fake := newFakePayPal(t)
+	service := newTestPayPalService(fake)
+	body := paypalCaptureEvent("WH-EVENT-1", "PAYMENT.CAPTURE.COMPLETED", "ORDER-1")
+
+	// A delivery signed for another webhook of the account is not ours
+	service.SetWebhookID("WH-OTHER")
+	if _, err := service.HandleWebhook(fake.sign("TX-1", body), body); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("HandleWebhook() error = %v, want ErrInvalidWebhookSignature", err)
+	}
+
+	// Without a webhook ID nothing can be verified, so nothing is accepted
+	service.SetWebhookID("")
+	calls := fake.verificationCount()
+	if _, err := service.HandleWebhook(fake.sign("TX-1", body), body); err == nil {
+		t.Error("HandleWebhook() accepted a delivery without a configured webhook ID")
+	}
+	if fake.verificationCount() != calls {
+		t.Error("verification was requested without a webhook ID")
+	}
+}
+
+func TestPayPalWebhookOutcomes(t *testing.T) {
+	fake := newFakePayPal(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestPayPalService(fake))
+
+	tests := []struct {
+		eventType  string
+		wantStatus string
+	}{
+		{"PAYMENT.CAPTURE.COMPLETED", "completed"},
+		{"PAYMENT.CAPTURE.DENIED", "failed"},
+		{"PAYMENT.CAPTURE.REFUNDED", "failed"},
+		{"CHECKOUT.ORDER.APPROVED", "pending"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			orderID := "ORDER-" + strconv.Itoa(i+1)
+			createTestDeposit(t, payments, PaymentProviderPayPal, orderID, 10)
+
+			body := paypalCaptureEvent("WH-EVENT-"+strconv.Itoa(i+1), tt.eventType, orderID)
+			if err := payments.HandleWebhook(PaymentProviderPayPal, fake.sign("TX-"+strconv.Itoa(i+1), body), body); err != nil {
+				t.Fatalf("HandleWebhook() error = %v", err)
+			}
+			if status := depositStatus(t, db, orderID); status != tt.wantStatus {
+				t.Errorf("deposit status = %q, want %q", status, tt.wantStatus)
+			}
+		})
+	}
+
+	if balance := userBalance(t, db); balance != 10 {
+		t.Errorf("balance = %v, want only the completed deposit credited", balance)
+	}
+}
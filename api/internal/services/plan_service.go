@@ -0,0 +1,413 @@
+// internal/services/plan_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/constants"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/repository"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Plan defaults used when no default plan is stored
+const (
+	DefaultPlanCode        = "free"
+	defaultPlanMaxFileSize = 50 // MB, when the api.maxFileSize setting is not set
+	defaultPlanMaxApiKeys  = 1
+	planRenewalInterval    = time.Hour
+)
+
+// Errors returned by PlanService
+var (
+	ErrPlanNotFound        = errors.New("plan not found")
+	ErrPlanInactive        = errors.New("plan is not available")
+	ErrPlanInUse           = errors.New("plan is assigned to users")
+	ErrDefaultPlan         = errors.New("the default plan cannot be deleted")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+var planCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// PlanService manages the subscription plans and the users' billing cycles
+type PlanService struct {
+	db     *gorm.DB
+	ledger *LedgerService
+}
+
+func NewPlanService(db *gorm.DB) *PlanService {
+	return &PlanService{
+		db:     db,
+		ledger: NewLedgerService(db),
+	}
+}
+
+// ListPlans returns the plans ordered by price, only the active ones unless
+// all is set
+func (s *PlanService) ListPlans(all bool) ([]models.Plan, error) {
+	query := s.db.Order("monthly_price, code")
+	if !all {
+		query = query.Where("active = ?", true)
+	}
+	var plans []models.Plan
+	if err := query.Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlan returns a plan by ID
+func (s *PlanService) GetPlan(id string) (*models.Plan, error) {
+	var plan models.Plan
+	err := s.db.Where("id = ?", id).First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// SavePlan creates a plan, or updates it when plan.ID is set. Making a plan
+// the default unsets the previous default.
+func (s *PlanService) SavePlan(plan *models.Plan) error {
+	if !planCodePattern.MatchString(plan.Code) {
+		return errors.New("code must be 1-50 lowercase letters, digits, '-' or '_'")
+	}
+	if plan.Name == "" {
+		return errors.New("name is required")
+	}
+	if plan.MonthlyPrice < 0 || plan.OverageCost < 0 || plan.IncludedOperations < 0 ||
+		plan.MaxFileSize < 0 || plan.MaxApiKeys < 0 || plan.RateLimit < 0 || plan.DailyQuota < 0 {
+		return errors.New("prices and limits must not be negative")
+	}
+	for operation, price := range plan.OveragePrices {
+		if price < 0 {
+			return fmt.Errorf("overage price of %s must not be negative", operation)
+		}
+	}
+	if plan.IsDefault && !plan.Active {
+		return errors.New("the default plan must be active")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		plan.UpdatedAt = now
+		if plan.ID == "" {
+			plan.ID = uuid.New().String()
+			plan.CreatedAt = now
+			if err := tx.Create(plan).Error; err != nil {
+				return fmt.Errorf("failed to create plan: %w", err)
+			}
+		} else {
+			var existing models.Plan
+			if err := tx.Where("id = ?", plan.ID).First(&existing).Error; err != nil {
+				return ErrPlanNotFound
+			}
+			plan.CreatedAt = existing.CreatedAt
+			if err := tx.Save(plan).Error; err != nil {
+				return fmt.Errorf("failed to update plan: %w", err)
+			}
+		}
+
+		if plan.IsDefault {
+			return tx.Model(&models.Plan{}).Where("id <> ?", plan.ID).Update("is_default", false).Error
+		}
+		return nil
+	})
+}
+
+// DeletePlan deletes a plan that is neither the default nor assigned to users
+func (s *PlanService) DeletePlan(id string) error {
+	plan, err := s.GetPlan(id)
+	if err != nil {
+		return err
+	}
+	if plan.IsDefault {
+		return ErrDefaultPlan
+	}
+
+	var users int64
+	if err := s.db.Model(&models.User{}).Where("plan_id = ?", id).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrPlanInUse
+	}
+	return s.db.Delete(&models.Plan{}, "id = ?", id).Error
+}
+
+// DefaultPlan returns the plan of users without a plan. Without a stored
+// default plan, a free plan is derived from the global pricing settings.
+func (s *PlanService) DefaultPlan() *models.Plan {
+	var plan models.Plan
+	if err := s.db.Where("is_default = ?", true).First(&plan).Error; err == nil {
+		return &plan
+	}
+	return BuiltinPlan()
+}
+
+// BuiltinPlan is the default plan used when none is stored: the global free
+// operations and pricing, with the default upload and API key limits
+func BuiltinPlan() *models.Plan {
+	included := constants.FreeOperationsMonthly
+	if pricing, err := repository.NewPricingRepository().GetPricingSettings(); err == nil {
+		included = pricing.FreeOperationsMonthly
+	}
+	return &models.Plan{
+		Code:               DefaultPlanCode,
+		Name:               "Free",
+		IncludedOperations: included,
+		MaxFileSize:        int(MaxFileSizeSetting() / (1024 * 1024)),
+		MaxApiKeys:         defaultPlanMaxApiKeys,
+		IsDefault:          true,
+		Active:             true,
+	}
+}
+
+// MaxFileSizeSetting returns the api.maxFileSize setting (MB) in bytes. It is
+// the upload limit of plans that do not set their own.
+func MaxFileSizeSetting() int64 {
+	if apiSettings, err := NewSettingsService().GetSettings("api"); err == nil {
+		if size, ok := intSetting(apiSettings["maxFileSize"]); ok && size > 0 {
+			return int64(size) * 1024 * 1024
+		}
+	}
+	return defaultPlanMaxFileSize * 1024 * 1024
+}
+
+// planMaxFileSize returns the upload limit of a plan in bytes
+func planMaxFileSize(plan *models.Plan) int64 {
+	if size := plan.MaxFileSizeBytes(); size > 0 {
+		return size
+	}
+	return MaxFileSizeSetting()
+}
+
+// ForUser returns the plan of a user. Web sessions and unknown users get the
+// default plan.
+func (s *PlanService) ForUser(userID string) *models.Plan {
+	if _, ok := webSessionID(userID); ok || userID == "" {
+		return s.DefaultPlan()
+	}
+	var user models.User
+	if err := s.db.Select("id", "plan_id").Where("id = ?", userID).Take(&user).Error; err != nil {
+		return s.DefaultPlan()
+	}
+	return s.planOf(s.db, &user)
+}
+
+// planOf returns the plan of a loaded user
+func (s *PlanService) planOf(tx *gorm.DB, user *models.User) *models.Plan {
+	if user.PlanID != nil {
+		var plan models.Plan
+		if err := tx.Where("id = ?", *user.PlanID).First(&plan).Error; err == nil {
+			return &plan
+		}
+	}
+	return s.DefaultPlan()
+}
+
+// Subscribe moves a user to a plan and starts a new billing cycle, charging
+// the plan's monthly price from the balance. The allowance of the previous
+// cycle is not carried over.
+func (s *PlanService) Subscribe(userID, planID string) (*models.Plan, error) {
+	plan, err := s.GetPlan(planID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Active {
+		return nil, ErrPlanInactive
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error; err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		if err := s.chargePlan(tx, &user, plan); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("plan_id", plan.ID).Error; err != nil {
+			return fmt.Errorf("failed to change plan: %w", err)
+		}
+		return s.startCycle(tx, &user, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// AssignPlan moves a user to a plan without charging it (admin function).
+// An empty planID moves the user to the default plan. The current billing
+// cycle continues with the new plan's allowance.
+func (s *PlanService) AssignPlan(userID, planID string) error {
+	var value interface{}
+	if planID != "" {
+		if _, err := s.GetPlan(planID); err != nil {
+			return err
+		}
+		value = planID
+	}
+
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"plan_id": value, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// RenewIfDue starts the next billing cycle of a locked user whose cycle
+// ended, charging the plan's price. Users who cannot pay are moved to the
+// default plan. It returns the plan for the current cycle.
+func (s *PlanService) RenewIfDue(tx *gorm.DB, user *models.User, now time.Time) (*models.Plan, error) {
+	plan := s.planOf(tx, user)
+	if !user.FreeOperationsReset.Before(now) {
+		return plan, nil
+	}
+
+	if plan.MonthlyPrice > 0 {
+		err := s.chargePlan(tx, user, plan)
+		if errors.Is(err, ErrInsufficientBalance) {
+			log.Printf("Plan %s of user %s not renewed: insufficient balance, moving to the default plan", plan.Code, user.ID)
+			plan = s.DefaultPlan()
+			if err := tx.Exec("UPDATE users SET plan_id = NULL WHERE id = ?", user.ID).Error; err != nil {
+				return nil, fmt.Errorf("failed to change plan: %w", err)
+			}
+			user.PlanID = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// Cycles follow each other unless the user was inactive for longer
+	start := user.FreeOperationsReset
+	if start.IsZero() || start.AddDate(0, 1, 0).Before(now) {
+		start = now
+	}
+	if err := s.startCycle(tx, user, start); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// RenewDuePlans renews the paid plans whose billing cycle ended, so fees are
+// charged even if the user runs no operation, and returns how many were
+// renewed. Free cycles are renewed on the user's next operation.
+func (s *PlanService) RenewDuePlans() (int, error) {
+	var userIDs []string
+	if err := s.db.Model(&models.User{}).
+		Joins("JOIN plans ON plans.id = users.plan_id").
+		Where("plans.monthly_price > 0 AND users.free_operations_reset < ?", time.Now()).
+		Pluck("users.id", &userIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to find due plans: %w", err)
+	}
+
+	renewed := 0
+	for _, userID := range userIDs {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var user models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error; err != nil {
+				return err
+			}
+			_, err := s.RenewIfDue(tx, &user, time.Now())
+			return err
+		})
+		if err != nil {
+			return renewed, err
+		}
+		renewed++
+	}
+	return renewed, nil
+}
+
+// StartRenewals runs RenewDuePlans periodically until the process exits
+func (s *PlanService) StartRenewals() {
+	go func() {
+		ticker := time.NewTicker(planRenewalInterval)
+		defer ticker.Stop()
+
+		for {
+			if renewed, err := s.RenewDuePlans(); err != nil {
+				log.Printf("Plan renewal failed: %v", err)
+			} else if renewed > 0 {
+				log.Printf("Plan renewal renewed %d plans", renewed)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// chargePlan takes the monthly price of a plan from a locked user's balance
+func (s *PlanService) chargePlan(tx *gorm.DB, user *models.User, plan *models.Plan) error {
+	if plan.MonthlyPrice <= 0 {
+		return nil
+	}
+
+	balance, price := Money(user.Balance), Money(plan.MonthlyPrice)
+	if balance.LessThan(price) {
+		return ErrInsufficientBalance
+	}
+	newBalance := balance.Sub(price)
+
+	transaction := models.Transaction{
+		ID:           uuid.New().String(),
+		UserID:       user.ID,
+		Amount:       price.Neg().InexactFloat64(),
+		BalanceAfter: newBalance.InexactFloat64(),
+		Description:  "Subscription: " + plan.Name,
+		Status:       models.TransactionStatusCompleted,
+		CreatedAt:    time.Now(),
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return fmt.Errorf("failed to record transaction: %w", err)
+	}
+	if err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, user.ID).Error; err != nil {
+		return fmt.Errorf("failed to update balance: %w", err)
+	}
+	if err := s.ledger.DebitUser(tx, user.ID, balance, models.LedgerAccountSubscriptions, price,
+		transaction.Description, transaction.ID); err != nil {
+		return err
+	}
+
+	user.Balance = newBalance.InexactFloat64()
+	return nil
+}
+
+// startCycle starts a billing cycle of a month at start with a full allowance
+func (s *PlanService) startCycle(tx *gorm.DB, user *models.User, start time.Time) error {
+	end := start.AddDate(0, 1, 0)
+	if err := tx.Exec("UPDATE users SET free_operations_used = 0, free_operations_reset = ? WHERE id = ?",
+		end, user.ID).Error; err != nil {
+		return fmt.Errorf("failed to start billing cycle: %w", err)
+	}
+
+	user.FreeOperationsUsed = 0
+	user.FreeOperationsReset = end
+	return nil
+}
+
+// OperationCost returns the price of an operation beyond the allowance of a
+// plan: the plan's price for the operation, its overage cost or else the
+// global pricing
+func (s *PlanService) OperationCost(plan *models.Plan, operation string) float64 {
+	if price, ok := plan.OveragePrices[operation]; ok {
+		return price
+	}
+	if plan.OverageCost > 0 {
+		return plan.OverageCost
+	}
+	return globalOperationCost(operation)
+}
@@ -0,0 +1,321 @@
+// internal/services/rate_limit_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Rate limit defaults used when the api settings do not define them
+const (
+	defaultRateLimitRequests = 100
+	defaultRateLimitPeriod   = 60 // seconds
+)
+
+// rateLimitReloadInterval is how long settings and overrides are cached, so
+// changes made by an admin apply without a restart
+const rateLimitReloadInterval = 30 * time.Second
+
+// maxUnknownApiKeys is how many unknown API keys are cached between reloads,
+// so callers sending random keys cannot grow the cache without bound
+const maxUnknownApiKeys = 10000
+
+// RateLimitService resolves the rate limit and daily quota of a caller from
+// the api settings (defaultRateLimit, rateLimitPeriod, defaultDailyQuota) and
+// the rate limit overrides, and enforces them through a ratelimit.Limiter
+type RateLimitService struct {
+	db       *gorm.DB
+	settings *SettingsService
+	limiter  *ratelimit.Limiter
+
+	mu        sync.RWMutex
+	loadedAt  time.Time
+	defaults  ratelimit.Policy
+	overrides map[string]ratelimit.Policy // Keyed by "scope:subject"
+	plans     map[string]*models.Plan     // User ID to plan, cleared on reload
+	keys      map[string]apiKeyOwner      // API key to its ID and user, cleared on reload
+	unknown   int                         // Unknown API keys in keys
+
+	planService *PlanService
+}
+
+// NewRateLimitService creates a rate limit service counting requests in store
+func NewRateLimitService(db *gorm.DB, store ratelimit.Store) *RateLimitService {
+	return &RateLimitService{
+		db:       db,
+		settings: NewSettingsService(),
+		limiter:  ratelimit.NewLimiter(store),
+		defaults: ratelimit.Policy{
+			Requests: defaultRateLimitRequests,
+			Period:   defaultRateLimitPeriod * time.Second,
+		},
+		overrides:   make(map[string]ratelimit.Policy),
+		plans:       make(map[string]*models.Plan),
+		keys:        make(map[string]apiKeyOwner),
+		planService: NewPlanService(db),
+	}
+}
+
+// apiKeyOwner identifies the record and user of an API key
+type apiKeyOwner struct {
+	keyID  string
+	userID string
+}
+
+// Check records a request and reports whether it is within the limits. The
+// most specific policy applies: the API key, then the user, then the user's
+// plan (an override of the plan, else the plan's own limits) and finally the
+// default. Anonymous callers are identified by IP.
+func (s *RateLimitService) Check(ctx context.Context, keyID, userID, ip string) (*ratelimit.Decision, error) {
+	var identity string
+	switch {
+	case keyID != "":
+		identity = "key:" + keyID
+	case userID != "":
+		identity = "user:" + userID
+	default:
+		identity = "ip:" + ip
+	}
+
+	return s.limiter.Allow(ctx, identity, s.PolicyFor(keyID, userID))
+}
+
+// PolicyFor returns the policy that applies to an API key and/or user
+func (s *RateLimitService) PolicyFor(keyID, userID string) ratelimit.Policy {
+	s.reloadIfStale()
+
+	s.mu.RLock()
+	keyPolicy, keyOverride := s.overrides[models.RateLimitScopeKey+":"+keyID]
+	userPolicy, userOverride := s.overrides[models.RateLimitScopeUser+":"+userID]
+	defaults := s.defaults
+	s.mu.RUnlock()
+
+	switch {
+	case keyID != "" && keyOverride:
+		return keyPolicy
+	case userID != "" && userOverride:
+		return userPolicy
+	case userID != "":
+		plan := s.userPlan(userID)
+		s.mu.RLock()
+		planPolicy, ok := s.overrides[models.RateLimitScopePlan+":"+plan.Code]
+		s.mu.RUnlock()
+		if ok {
+			return planPolicy
+		}
+		return planRateLimit(plan, defaults)
+	}
+
+	return defaults
+}
+
+// userPlan returns the plan of a user
+func (s *RateLimitService) userPlan(userID string) *models.Plan {
+	s.mu.RLock()
+	plan, ok := s.plans[userID]
+	s.mu.RUnlock()
+	if ok {
+		return plan
+	}
+
+	plan = s.planService.ForUser(userID)
+
+	s.mu.Lock()
+	s.plans[userID] = plan
+	s.mu.Unlock()
+	return plan
+}
+
+// planRateLimit applies the limits a plan sets to the default policy
+func planRateLimit(plan *models.Plan, defaults ratelimit.Policy) ratelimit.Policy {
+	policy := defaults
+	if plan.RateLimit > 0 {
+		policy.Requests = plan.RateLimit
+	}
+	if plan.DailyQuota > 0 {
+		policy.DailyQuota = plan.DailyQuota
+	}
+	return policy
+}
+
+// KeyOwner returns the ID and user of an API key, or empty strings if the key
+// does not exist. Unknown keys are cached too until the next reload, so
+// requests repeating an invalid key do not query the database every time.
+func (s *RateLimitService) KeyOwner(apiKey string) (keyID, userID string) {
+	s.reloadIfStale()
+
+	s.mu.RLock()
+	owner, ok := s.keys[apiKey]
+	s.mu.RUnlock()
+	if ok {
+		return owner.keyID, owner.userID
+	}
+
+	key, err := findApiKey(s.db, apiKey, false)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.mu.Lock()
+		if s.unknown < maxUnknownApiKeys {
+			s.keys[apiKey] = apiKeyOwner{}
+			s.unknown++
+		}
+		s.mu.Unlock()
+	}
+	if err != nil {
+		return "", ""
+	}
+
+	s.mu.Lock()
+	s.keys[apiKey] = apiKeyOwner{keyID: key.ID, userID: key.UserID}
+	s.mu.Unlock()
+	return key.ID, key.UserID
+}
+
+// reloadIfStale reloads the settings and overrides once the cache expired
+func (s *RateLimitService) reloadIfStale() {
+	// Claim the reload so concurrent requests keep using the cached values
+	s.mu.Lock()
+	stale := time.Since(s.loadedAt) > rateLimitReloadInterval
+	if stale {
+		s.loadedAt = time.Now()
+	}
+	s.mu.Unlock()
+
+	if stale {
+		if err := s.Reload(); err != nil {
+			fmt.Printf("WARNING: Failed to reload rate limits: %v\n", err)
+		}
+	}
+}
+
+// Reload reads the api settings and the overrides from the database
+func (s *RateLimitService) Reload() error {
+	defaults := ratelimit.Policy{
+		Requests: defaultRateLimitRequests,
+		Period:   defaultRateLimitPeriod * time.Second,
+	}
+	if apiSettings, err := s.settings.GetSettings("api"); err == nil {
+		if v, ok := intSetting(apiSettings["defaultRateLimit"]); ok && v >= 0 {
+			defaults.Requests = v
+		}
+		if v, ok := intSetting(apiSettings["rateLimitPeriod"]); ok && v > 0 {
+			defaults.Period = time.Duration(v) * time.Second
+		}
+		if v, ok := intSetting(apiSettings["defaultDailyQuota"]); ok && v >= 0 {
+			defaults.DailyQuota = v
+		}
+	}
+
+	var rows []models.RateLimitOverride
+	err := s.db.Find(&rows).Error
+
+	overrides := make(map[string]ratelimit.Policy, len(rows))
+	for _, row := range rows {
+		overrides[row.Scope+":"+row.Subject] = overridePolicy(row, defaults)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep the previous overrides if the table cannot be read
+	s.defaults = defaults
+	s.loadedAt = time.Now()
+	if err != nil {
+		return fmt.Errorf("failed to load rate limit overrides: %w", err)
+	}
+	s.overrides = overrides
+	s.plans = make(map[string]*models.Plan)
+	s.keys = make(map[string]apiKeyOwner)
+	s.unknown = 0
+	return nil
+}
+
+// overridePolicy converts an override to a policy, using the default period
+// when the override does not set one
+func overridePolicy(row models.RateLimitOverride, defaults ratelimit.Policy) ratelimit.Policy {
+	policy := ratelimit.Policy{
+		Requests:   row.Requests,
+		Period:     time.Duration(row.Period) * time.Second,
+		DailyQuota: row.DailyQuota,
+	}
+	if row.Period <= 0 {
+		policy.Period = defaults.Period
+	}
+	return policy
+}
+
+// ListOverrides returns all rate limit overrides
+func (s *RateLimitService) ListOverrides() ([]models.RateLimitOverride, error) {
+	var overrides []models.RateLimitOverride
+	if err := s.db.Order("scope, subject").Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// SaveOverride creates or updates the override of a scope and subject
+func (s *RateLimitService) SaveOverride(override *models.RateLimitOverride) error {
+	switch override.Scope {
+	case models.RateLimitScopePlan, models.RateLimitScopeUser, models.RateLimitScopeKey:
+	default:
+		return fmt.Errorf("invalid scope '%s', expected plan, user or key", override.Scope)
+	}
+	if override.Subject == "" {
+		return errors.New("subject is required")
+	}
+	if override.Requests < 0 || override.Period < 0 || override.DailyQuota < 0 {
+		return errors.New("requests, period and dailyQuota must not be negative")
+	}
+
+	var existing models.RateLimitOverride
+	err := s.db.Where("scope = ? AND subject = ?", override.Scope, override.Subject).Take(&existing).Error
+	switch {
+	case err == nil:
+		override.ID = existing.ID
+		override.CreatedAt = existing.CreatedAt
+		err = s.db.Save(override).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		override.ID = uuid.New().String()
+		err = s.db.Create(override).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.Reload()
+}
+
+// DeleteOverride removes an override
+func (s *RateLimitService) DeleteOverride(id string) error {
+	result := s.db.Delete(&models.RateLimitOverride{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return s.Reload()
+}
+
+// intSetting converts a JSON decoded setting value to an int
+func intSetting(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
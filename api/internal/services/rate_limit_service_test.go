@@ -0,0 +1,73 @@
+// internal/services/rate_limit_service_test.go
+package services
+
+import (
+	"testing"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
+)
+
+func TestRateLimitKeyOwner(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.ApiKey{}, &models.RateLimitOverride{})
+	useTestSettings(t)
+	service := NewRateLimitService(db, ratelimit.NewMemoryStore())
+
+	const apiKey = "sk_test_0123456789abcdef0123456789abcdef"
+	if keyID, userID := service.KeyOwner(apiKey); keyID != "" || userID != "" {
+		t.Fatalf("KeyOwner() of an unknown key = %q, %q, want empty", keyID, userID)
+	}
+
+	// Unknown keys are cached until the next reload
+	hash, err := models.HashApiKey(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.User{ID: "user-1", Email: "user@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ApiKey{ID: "key-1", UserID: "user-1", Prefix: models.ApiKeyPrefix(apiKey), KeyHash: hash}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _ := service.KeyOwner(apiKey); keyID != "" {
+		t.Errorf("KeyOwner() of a cached unknown key = %q, want empty until the reload", keyID)
+	}
+	if service.unknown != 1 {
+		t.Errorf("unknown keys = %d, want 1", service.unknown)
+	}
+
+	if err := service.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if keyID, userID := service.KeyOwner(apiKey); keyID != "key-1" || userID != "user-1" {
+		t.Errorf("KeyOwner() after the reload = %q, %q, want key-1, user-1", keyID, userID)
+	}
+	if service.unknown != 0 {
+		t.Errorf("unknown keys after the reload = %d, want 0", service.unknown)
+	}
+
+	// Deleted keys stay cached until the next reload as well
+	if err := db.Delete(&models.ApiKey{}, "id = ?", "key-1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _ := service.KeyOwner(apiKey); keyID != "key-1" {
+		t.Errorf("KeyOwner() of a cached key = %q, want key-1", keyID)
+	}
+}
+
+func TestRateLimitUnknownKeyLimit(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.ApiKey{}, &models.RateLimitOverride{})
+	useTestSettings(t)
+	service := NewRateLimitService(db, ratelimit.NewMemoryStore())
+	service.KeyOwner("sk_test_first")
+
+	// Once full, unknown keys are looked up every time instead of cached
+	service.unknown = maxUnknownApiKeys
+	service.KeyOwner("sk_test_second")
+	if _, cached := service.keys["sk_test_second"]; cached {
+		t.Error("unknown key cached beyond maxUnknownApiKeys")
+	}
+	if _, cached := service.keys["sk_test_first"]; !cached {
+		t.Error("unknown key not cached")
+	}
+}
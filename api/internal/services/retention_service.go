@@ -0,0 +1,454 @@
+// internal/services/retention_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrRetentionRunning is returned when a run is started while another run of
+// this instance is still going
+var ErrRetentionRunning = errors.New("a retention run is already in progress")
+
+// retentionBatchSize is how many expired artifacts are purged per query
+const retentionBatchSize = 500
+
+// retentionMaxErrors is how many errors are kept in the record of a run
+const retentionMaxErrors = 100
+
+// RetentionPolicy says how long stored files are kept. It is read from the
+// storage settings.
+type RetentionPolicy struct {
+	FreeRetention   time.Duration            // Results of users on free plans
+	PaidRetention   time.Duration            // Results of users on paid plans
+	PlanRetention   map[string]time.Duration // Per plan code, overrides the above
+	FolderRetention map[string]time.Duration // Longest any file of a folder is kept
+	OrphanRetention time.Duration            // Stored files without a result record
+	TempRetention   time.Duration            // Files in the upload and temp directories
+	RecordRetention time.Duration            // Records of purged results, which answer 410
+	Interval        time.Duration            // Between scheduled runs
+	OrphanInterval  time.Duration            // Between scheduled sweeps of the whole store for orphans
+}
+
+// defaultRetentionPolicy applies to the storage settings that are not set
+var defaultRetentionPolicy = RetentionPolicy{
+	FreeRetention:   time.Hour,
+	PaidRetention:   7 * 24 * time.Hour,
+	PlanRetention:   map[string]time.Duration{},
+	FolderRetention: map[string]time.Duration{"jobs": 24 * time.Hour, "editor-sessions": 24 * time.Hour, "uploads": 24 * time.Hour},
+	OrphanRetention: 24 * time.Hour,
+	TempRetention:   time.Hour,
+	RecordRetention: 30 * 24 * time.Hour,
+	Interval:        10 * time.Minute,
+	OrphanInterval:  6 * time.Hour,
+}
+
+// RetentionService sets the expiry of stored results by plan and folder and
+// periodically removes expired results, stale stored files and old files of
+// the local upload and temp directories. Every run is recorded.
+type RetentionService struct {
+	db       *gorm.DB
+	storage  storage.Storage
+	settings *SettingsService
+	plans    *PlanService
+	tempDirs []string
+	running  sync.Mutex
+}
+
+// NewRetentionService creates a retention service for the results in store
+// and the local files in tempDirs
+func NewRetentionService(db *gorm.DB, store storage.Storage, tempDirs ...string) *RetentionService {
+	return &RetentionService{
+		db:       db,
+		storage:  store,
+		settings: NewSettingsService(),
+		plans:    NewPlanService(db),
+		tempDirs: tempDirs,
+	}
+}
+
+// Policy returns the retention policy of the storage settings
+func (s *RetentionService) Policy() RetentionPolicy {
+	policy := defaultRetentionPolicy
+	storageSettings, err := s.settings.GetSettings("storage")
+	if err != nil {
+		return policy
+	}
+
+	seconds := func(key string, value *time.Duration) {
+		if v, ok := intSetting(storageSettings[key]); ok && v >= 0 {
+			*value = time.Duration(v) * time.Second
+		}
+	}
+	seconds("freeRetention", &policy.FreeRetention)
+	seconds("paidRetention", &policy.PaidRetention)
+	seconds("orphanRetention", &policy.OrphanRetention)
+	seconds("tempRetention", &policy.TempRetention)
+	seconds("recordRetention", &policy.RecordRetention)
+	seconds("retentionInterval", &policy.Interval)
+	seconds("orphanInterval", &policy.OrphanInterval)
+	if policy.Interval < time.Minute {
+		policy.Interval = time.Minute
+	}
+	if policy.OrphanInterval < policy.Interval {
+		policy.OrphanInterval = policy.Interval
+	}
+
+	if value, ok := storageSettings["planRetention"].(string); ok {
+		policy.PlanRetention = retentionMap(value)
+	}
+	if value, ok := storageSettings["folderRetention"].(string); ok {
+		policy.FolderRetention = retentionMap(value)
+	}
+	return policy
+}
+
+// ExpiresAt returns when a result of userID stored in folder at now expires:
+// after the retention of the user's plan, at most the folder's retention
+func (s *RetentionService) ExpiresAt(userID, folder string, now time.Time) time.Time {
+	policy := s.Policy()
+	plan := s.plans.ForUser(userID)
+
+	retention := policy.FreeRetention
+	if plan.MonthlyPrice > 0 {
+		retention = policy.PaidRetention
+	}
+	if planRetention, ok := policy.PlanRetention[plan.Code]; ok {
+		retention = planRetention
+	}
+	if folderRetention, ok := policy.FolderRetention[topFolder(folder)]; ok && folderRetention < retention {
+		retention = folderRetention
+	}
+
+	return now.Add(retention).Truncate(time.Second)
+}
+
+// UploadExpiresAt returns when a resumable upload created or completed at now
+// expires: after the retention of the uploads folder, or the orphan retention
+func (s *RetentionService) UploadExpiresAt(now time.Time) time.Time {
+	policy := s.Policy()
+	retention := policy.OrphanRetention
+	if folderRetention, ok := policy.FolderRetention["uploads"]; ok {
+		retention = folderRetention
+	}
+	return now.Add(retention).Truncate(time.Second)
+}
+
+// Run applies the retention policy once and records the run. adminID is set
+// for runs started by an admin. Walking the whole store for orphans is
+// costly, so scheduled runs only do it once per orphan interval; runs started
+// by an admin always do.
+func (s *RetentionService) Run(ctx context.Context, trigger string, adminID *string) (*models.RetentionRun, error) {
+	if !s.running.TryLock() {
+		return nil, ErrRetentionRunning
+	}
+	defer s.running.Unlock()
+
+	policy := s.Policy()
+	run := &models.RetentionRun{
+		ID:          uuid.New().String(),
+		Trigger:     trigger,
+		AdminID:     adminID,
+		Status:      models.RetentionStatusRunning,
+		OrphanSweep: trigger != models.RetentionTriggerScheduler || s.orphanSweepDue(policy.OrphanInterval),
+		StartedAt:   time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record retention run: %w", err)
+	}
+
+	var errs []string
+	steps := []func() error{
+		func() error { return s.purgeArtifacts(ctx, run, run.StartedAt, &errs) },
+		func() error { return s.deleteExpiredUploads(ctx, run, run.StartedAt, &errs) },
+	}
+	if run.OrphanSweep {
+		steps = append(steps, func() error { return s.deleteOrphans(ctx, run, policy, run.StartedAt, &errs) })
+	}
+	steps = append(steps,
+		func() error { return s.deleteTempFiles(run, policy, run.StartedAt, &errs) },
+		func() error { return s.deleteRecords(run, policy, run.StartedAt) },
+	)
+
+	failed := false
+	for _, step := range steps {
+		if err := step(); err != nil {
+			failed = true
+			errs = append(errs, err.Error())
+		}
+	}
+
+	completedAt := time.Now()
+	run.CompletedAt = &completedAt
+	run.Status = models.RetentionStatusCompleted
+	if failed {
+		run.Status = models.RetentionStatusFailed
+	}
+	if len(errs) > retentionMaxErrors {
+		errs = append(errs[:retentionMaxErrors], fmt.Sprintf("... and %d more errors", len(errs)-retentionMaxErrors))
+	}
+	run.Errors = strings.Join(errs, "\n")
+	if err := s.db.Save(run).Error; err != nil {
+		return run, fmt.Errorf("failed to record retention run: %w", err)
+	}
+
+	return run, nil
+}
+
+// ListRuns returns the latest runs, newest first
+func (s *RetentionService) ListRuns(limit int) ([]models.RetentionRun, error) {
+	var runs []models.RetentionRun
+	if err := s.db.Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Start runs the retention policy periodically until the process exits. A
+// run is skipped when another instance ran recently.
+func (s *RetentionService) Start() {
+	go func() {
+		for {
+			policy := s.Policy()
+			if s.due(policy.Interval) {
+				run, err := s.Run(context.Background(), models.RetentionTriggerScheduler, nil)
+				if err != nil {
+					log.Printf("Retention run failed: %v", err)
+				} else if run.ArtifactsPurged+run.UploadsDeleted+run.ObjectsDeleted+run.TempFilesDeleted > 0 {
+					log.Printf("Retention run purged %d results and %d uploads and deleted %d stored and %d temporary files (%d bytes)",
+						run.ArtifactsPurged, run.UploadsDeleted, run.ObjectsDeleted, run.TempFilesDeleted, run.BytesRecovered)
+				}
+			}
+			time.Sleep(policy.Interval)
+		}
+	}()
+}
+
+// due reports whether no run started within the last half interval
+func (s *RetentionService) due(interval time.Duration) bool {
+	var count int64
+	if err := s.db.Model(&models.RetentionRun{}).
+		Where("started_at > ?", time.Now().Add(-interval/2)).
+		Count(&count).Error; err != nil {
+		return true
+	}
+	return count == 0
+}
+
+// orphanSweepDue reports whether no run swept the store for orphans within
+// the last orphan interval
+func (s *RetentionService) orphanSweepDue(interval time.Duration) bool {
+	var count int64
+	if err := s.db.Model(&models.RetentionRun{}).
+		Where("orphan_sweep = ? AND started_at > ?", true, time.Now().Add(-interval)).
+		Count(&count).Error; err != nil {
+		return true
+	}
+	return count == 0
+}
+
+// purgeArtifacts removes the content of expired artifacts from storage. The
+// records are kept, so downloads answer 410 instead of 404.
+func (s *RetentionService) purgeArtifacts(ctx context.Context, run *models.RetentionRun, now time.Time, errs *[]string) error {
+	lastID := ""
+	for {
+		var artifacts []models.Artifact
+		if err := s.db.Where("purged_at IS NULL AND expires_at <= ? AND id > ?", now, lastID).
+			Order("id").Limit(retentionBatchSize).Find(&artifacts).Error; err != nil {
+			return fmt.Errorf("failed to find expired results: %w", err)
+		}
+		if len(artifacts) == 0 {
+			return nil
+		}
+
+		for _, artifact := range artifacts {
+			lastID = artifact.ID
+			if err := s.storage.Delete(ctx, artifact.StorageKey); err != nil {
+				*errs = append(*errs, fmt.Sprintf("Error deleting %s: %v", artifact.StorageKey, err))
+				continue
+			}
+			if err := s.db.Model(&models.Artifact{}).Where("id = ?", artifact.ID).Update("purged_at", now).Error; err != nil {
+				*errs = append(*errs, fmt.Sprintf("Error marking %s purged: %v", artifact.StorageKey, err))
+				continue
+			}
+			run.ArtifactsPurged++
+			run.BytesRecovered += artifact.Size
+		}
+	}
+}
+
+// deleteExpiredUploads deletes expired resumable uploads, completed or not,
+// together with their chunks
+func (s *RetentionService) deleteExpiredUploads(ctx context.Context, run *models.RetentionRun, now time.Time, errs *[]string) error {
+	lastID := ""
+	for {
+		var uploads []models.Upload
+		if err := s.db.Where("expires_at <= ? AND id > ?", now, lastID).
+			Order("id").Limit(retentionBatchSize).Find(&uploads).Error; err != nil {
+			return fmt.Errorf("failed to find expired uploads: %w", err)
+		}
+		if len(uploads) == 0 {
+			return nil
+		}
+
+		for _, upload := range uploads {
+			lastID = upload.ID
+			if err := deleteUpload(ctx, s.db, s.storage, upload.ID); err != nil {
+				*errs = append(*errs, fmt.Sprintf("Error deleting upload %s: %v", upload.ID, err))
+				continue
+			}
+			run.UploadsDeleted++
+			run.BytesRecovered += upload.Received
+		}
+	}
+}
+
+// deleteOrphans deletes stored files without a live result record, such as
+// job inputs and editor sessions, once they are older than the retention of
+// their folder or the orphan retention. The store is walked page by page and
+// checked against the results in batches, so memory use does not grow with
+// the number of stored files.
+func (s *RetentionService) deleteOrphans(ctx context.Context, run *models.RetentionRun, policy RetentionPolicy, now time.Time, errs *[]string) error {
+	stale := make([]storage.Object, 0, retentionBatchSize)
+	err := s.storage.Walk(ctx, "", func(object storage.Object) error {
+		retention := policy.OrphanRetention
+		if folderRetention, ok := policy.FolderRetention[topFolder(object.Key)]; ok {
+			retention = folderRetention
+		}
+		if !object.ModTime.Before(now.Add(-retention)) {
+			return nil
+		}
+
+		stale = append(stale, object)
+		if len(stale) < retentionBatchSize {
+			return nil
+		}
+		err := s.deleteStaleObjects(ctx, run, stale, errs)
+		stale = stale[:0]
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk storage: %w", err)
+	}
+	return s.deleteStaleObjects(ctx, run, stale, errs)
+}
+
+// deleteStaleObjects deletes the stale objects that are not live results
+func (s *RetentionService) deleteStaleObjects(ctx context.Context, run *models.RetentionRun, stale []storage.Object, errs *[]string) error {
+	if len(stale) == 0 {
+		return nil
+	}
+	keys := make([]string, len(stale))
+	for i, object := range stale {
+		keys[i] = object.Key
+	}
+
+	// Live results are removed once their artifact expires
+	var live []string
+	if err := s.db.Model(&models.Artifact{}).
+		Where("storage_key IN ? AND purged_at IS NULL", keys).
+		Pluck("storage_key", &live).Error; err != nil {
+		return fmt.Errorf("failed to look up results: %w", err)
+	}
+	isLive := make(map[string]bool, len(live))
+	for _, key := range live {
+		isLive[key] = true
+	}
+
+	for _, object := range stale {
+		if isLive[object.Key] {
+			continue
+		}
+		if err := s.storage.Delete(ctx, object.Key); err != nil {
+			*errs = append(*errs, fmt.Sprintf("Error deleting %s: %v", object.Key, err))
+			continue
+		}
+		run.ObjectsDeleted++
+		run.BytesRecovered += object.Size
+	}
+	return nil
+}
+
+// deleteTempFiles deletes the files of the local upload and temp directories
+// that are older than the temp retention
+func (s *RetentionService) deleteTempFiles(run *models.RetentionRun, policy RetentionPolicy, now time.Time, errs *[]string) error {
+	threshold := now.Add(-policy.TempRetention)
+	for _, dir := range s.tempDirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					*errs = append(*errs, fmt.Sprintf("Error accessing %s: %v", path, err))
+				}
+				return nil // Continue despite errors
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil || !info.ModTime().Before(threshold) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				*errs = append(*errs, fmt.Sprintf("Error deleting %s: %v", path, err))
+				return nil
+			}
+			run.TempFilesDeleted++
+			run.BytesRecovered += info.Size()
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error walking directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// deleteRecords deletes the records of results purged longer than the
+// record retention ago; their downloads answer 404 from then on
+func (s *RetentionService) deleteRecords(run *models.RetentionRun, policy RetentionPolicy, now time.Time) error {
+	result := s.db.Where("purged_at IS NOT NULL AND purged_at < ?", now.Add(-policy.RecordRetention)).
+		Delete(&models.Artifact{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete purged result records: %w", result.Error)
+	}
+	run.RecordsDeleted = int(result.RowsAffected)
+	return nil
+}
+
+// retentionMap parses "<name>=<seconds>" pairs separated by commas
+func retentionMap(value string) map[string]time.Duration {
+	retention := make(map[string]time.Duration)
+	for _, field := range strings.Split(value, ",") {
+		name, seconds, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(seconds))
+		if err != nil || n < 0 {
+			continue
+		}
+		retention[strings.TrimSpace(name)] = time.Duration(n) * time.Second
+	}
+	return retention
+}
+
+// topFolder returns the first segment of a storage key or folder
+func topFolder(key string) string {
+	folder, _, _ := strings.Cut(key, "/")
+	return folder
+}
@@ -0,0 +1,123 @@
+// internal/services/retention_service_test.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+)
+
+func TestRetentionDeleteOrphans(t *testing.T) {
+	db := newTestDB(t, &models.Artifact{}, &models.RetentionRun{}, &models.Upload{}, &models.UploadChunk{})
+	useTestSettings(t)
+	root := t.TempDir()
+	store := storage.NewLocalStore(root)
+	service := NewRetentionService(db, store)
+	ctx := context.Background()
+
+	old := time.Now().Add(-48 * time.Hour)
+	put := func(key string, modTime time.Time) {
+		t.Helper()
+		if err := store.Put(ctx, key, strings.NewReader("%PDF-1.7"), 8, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// More stale job inputs than one batch
+	staleJobs := retentionBatchSize + 20
+	for i := 0; i < staleJobs; i++ {
+		put(fmt.Sprintf("jobs/%04d-input.pdf", i), old)
+	}
+	put("jobs/recent-input.pdf", time.Now())
+	put("compressions/live.pdf", old)
+	put("compressions/orphan.pdf", old)
+
+	artifact := models.Artifact{ID: "artifact-1", StorageKey: "compressions/live.pdf", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&artifact).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := service.Run(ctx, models.RetentionTriggerScheduler, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if run.Status != models.RetentionStatusCompleted || run.Errors != "" {
+		t.Fatalf("Run() = %s with errors %q", run.Status, run.Errors)
+	}
+	if !run.OrphanSweep {
+		t.Error("first scheduled run did not sweep for orphans")
+	}
+	if want := staleJobs + 1; run.ObjectsDeleted != want {
+		t.Errorf("ObjectsDeleted = %d, want %d", run.ObjectsDeleted, want)
+	}
+
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if want := "compressions/live.pdf|jobs/recent-input.pdf"; strings.Join(keys, "|") != want {
+		t.Errorf("stored keys = %q, want %s", keys, want)
+	}
+}
+
+func TestRetentionOrphanInterval(t *testing.T) {
+	db := newTestDB(t, &models.Artifact{}, &models.RetentionRun{}, &models.Upload{}, &models.UploadChunk{})
+	useTestSettings(t)
+	root := t.TempDir()
+	store := storage.NewLocalStore(root)
+	service := NewRetentionService(db, store)
+	ctx := context.Background()
+
+	if run, err := service.Run(ctx, models.RetentionTriggerScheduler, nil); err != nil || !run.OrphanSweep {
+		t.Fatalf("first scheduled run = %+v, %v, want an orphan sweep", run, err)
+	}
+
+	// Scheduled runs within the orphan interval leave the store alone
+	if err := store.Put(ctx, "jobs/input.pdf", strings.NewReader("%PDF-1.7"), 8, ""); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "jobs", "input.pdf"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	run, err := service.Run(ctx, models.RetentionTriggerScheduler, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if run.OrphanSweep || run.ObjectsDeleted != 0 {
+		t.Errorf("second scheduled run swept = %v and deleted %d objects, want no sweep", run.OrphanSweep, run.ObjectsDeleted)
+	}
+
+	// Runs started by an admin always sweep
+	adminID := "admin-1"
+	run, err = service.Run(ctx, models.RetentionTriggerAdmin, &adminID)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !run.OrphanSweep || run.ObjectsDeleted != 1 {
+		t.Errorf("admin run swept = %v and deleted %d objects, want a sweep deleting 1", run.OrphanSweep, run.ObjectsDeleted)
+	}
+
+	// Once the orphan interval passed, scheduled runs sweep again
+	if err := db.Model(&models.RetentionRun{}).Where("1 = 1").
+		Update("started_at", time.Now().Add(-defaultRetentionPolicy.OrphanInterval-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run, err := service.Run(ctx, models.RetentionTriggerScheduler, nil); err != nil || !run.OrphanSweep {
+		t.Errorf("scheduled run after the orphan interval = %+v, %v, want an orphan sweep", run, err)
+	}
+}
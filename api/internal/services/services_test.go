@@ -0,0 +1,56 @@
+// internal/services/services_test.go
+package services
+
+import (
+	"strings"
+	"testing"
+
+	dbpkg "github.com/MegaPDF/megapdf-official/api/internal/db"
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns an empty in-memory database with the given models migrated
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	return openTestDB(t, t.Name(), models...)
+}
+
+// openTestDB returns an empty in-memory database of the given name, for tests
+// that need more than one
+func openTestDB(t *testing.T, name string, models ...interface{}) *gorm.DB {
+	t.Helper()
+
+	name = strings.NewReplacer("/", "_", " ", "_").Replace(name)
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite allows one writer, so share a single connection
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+// useTestSettings points the global connection, from which settings are
+// read, to an empty database of its own, so reading settings does not wait
+// for the single connection of the test database
+func useTestSettings(t *testing.T) {
+	t.Helper()
+
+	previous := dbpkg.DB
+	dbpkg.DB = openTestDB(t, t.Name()+"_settings", &models.Setting{})
+	t.Cleanup(func() { dbpkg.DB = previous })
+}
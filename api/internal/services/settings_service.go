@@ -34,7 +34,7 @@ func (s *SettingsService) UpdateSettings(category string, settings map[string]in
 
 // GetAllSettings gets all settings from all categories
 func (s *SettingsService) GetAllSettings() (map[string]interface{}, error) {
-	categories := []string{"general", "api", "email", "security", "payment", "database", "oauth", "pricing"}
+	categories := []string{"general", "api", "email", "security", "payment", "billing", "database", "oauth", "pricing"}
 	allSettings := make(map[string]interface{})
 
 	for _, category := range categories {
@@ -90,9 +90,13 @@ func (s *SettingsService) ApplySettings() error {
 	})
 
 	setFromCategory(allSettings, "payment", map[string]string{
-		"paypalClientId":     "PAYPAL_CLIENT_ID",
-		"paypalClientSecret": "PAYPAL_CLIENT_SECRET",
-		"paypalApiBase":      "PAYPAL_API_BASE",
+		"paypalClientId":      "PAYPAL_CLIENT_ID",
+		"paypalClientSecret":  "PAYPAL_CLIENT_SECRET",
+		"paypalApiBase":       "PAYPAL_API_BASE",
+		"paypalWebhookId":     "PAYPAL_WEBHOOK_ID",
+		"stripeSecretKey":     "STRIPE_SECRET_KEY",
+		"stripeWebhookSecret": "STRIPE_WEBHOOK_SECRET",
+		"stripeApiBase":       "STRIPE_API_BASE",
 	})
 
 	setFromCategory(allSettings, "api", map[string]string{
@@ -191,9 +195,13 @@ func (s *SettingsService) GetEnvironmentOverrides() map[string]map[string]string
 			"SMTP_SECURE":     "smtpSecure",
 		},
 		"payment": {
-			"PAYPAL_CLIENT_ID":     "paypalClientId",
-			"PAYPAL_CLIENT_SECRET": "paypalClientSecret",
-			"PAYPAL_API_BASE":      "paypalApiBase",
+			"PAYPAL_CLIENT_ID":      "paypalClientId",
+			"PAYPAL_CLIENT_SECRET":  "paypalClientSecret",
+			"PAYPAL_API_BASE":       "paypalApiBase",
+			"PAYPAL_WEBHOOK_ID":     "paypalWebhookId",
+			"STRIPE_SECRET_KEY":     "stripeSecretKey",
+			"STRIPE_WEBHOOK_SECRET": "stripeWebhookSecret",
+			"STRIPE_API_BASE":       "stripeApiBase",
 		},
 		"api": {
 			"DEFAULT_RATE_LIMIT": "defaultRateLimit",
@@ -0,0 +1,384 @@
+// internal/services/spending_cap_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// defaultCapAlertThresholds are the percentages of a spending cap at which
+// its owner is emailed when the api settings do not define them
+var defaultCapAlertThresholds = []int{50, 80, 100}
+
+// ErrInvalidSpendingCap is returned for negative spending caps
+var ErrInvalidSpendingCap = errors.New("spending caps must not be negative")
+
+// capNames name the spending caps in messages
+var capNames = map[string]string{
+	models.CapDailySpend:        "daily spend cap",
+	models.CapMonthlySpend:      "monthly spend cap",
+	models.CapDailyOperations:   "daily operation cap",
+	models.CapMonthlyOperations: "monthly operation cap",
+}
+
+// SpendingCapService enforces the spending caps of users and API keys and
+// emails users as their caps are used. Usage is counted from the
+// reservations of the period that are held or committed, so operations that
+// failed do not count.
+type SpendingCapService struct {
+	db       *gorm.DB
+	settings *SettingsService
+	email    *EmailService
+}
+
+func NewSpendingCapService(db *gorm.DB) *SpendingCapService {
+	return &SpendingCapService{
+		db:       db,
+		settings: NewSettingsService(),
+	}
+}
+
+// SetEmailService enables the spending cap alert emails
+func (s *SpendingCapService) SetEmailService(email *EmailService) {
+	s.email = email
+}
+
+// CapUsage is what a user or an API key used of one of its caps in the
+// current period
+type CapUsage struct {
+	Cap      string    `json:"cap"`
+	Limit    float64   `json:"limit"`
+	Used     float64   `json:"used"`
+	ResetsAt time.Time `json:"resetsAt"`
+
+	userID  string
+	apiKey  *models.ApiKey // Nil for caps of the user
+	period  time.Time
+	refused bool // An operation was refused because of the cap
+}
+
+// SpendingCapStatus is the caps of a user or an API key and their usage
+type SpendingCapStatus struct {
+	ApiKeyID string              `json:"apiKeyId,omitempty"`
+	Name     string              `json:"name,omitempty"`
+	Caps     models.SpendingCaps `json:"caps"`
+	Usage    []CapUsage          `json:"usage"`
+}
+
+// ValidateSpendingCaps checks caps before they are saved
+func ValidateSpendingCaps(caps models.SpendingCaps) error {
+	if caps.DailySpendCap < 0 || caps.MonthlySpendCap < 0 || caps.DailyOperationCap < 0 || caps.MonthlyOperationCap < 0 {
+		return ErrInvalidSpendingCap
+	}
+	return nil
+}
+
+// SetUserCaps replaces the spending caps of a user's account
+func (s *SpendingCapService) SetUserCaps(userID string, caps models.SpendingCaps) error {
+	if err := ValidateSpendingCaps(caps); err != nil {
+		return err
+	}
+	var user models.User
+	if err := s.db.Select("id").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	return s.db.Model(&user).Updates(map[string]interface{}{
+		"daily_spend_cap":       caps.DailySpendCap,
+		"monthly_spend_cap":     caps.MonthlySpendCap,
+		"daily_operation_cap":   caps.DailyOperationCap,
+		"monthly_operation_cap": caps.MonthlyOperationCap,
+	}).Error
+}
+
+// Status returns the caps of a user's account and of their API keys that
+// have caps, with what was used of them
+func (s *SpendingCapService) Status(userID string) (*SpendingCapStatus, []SpendingCapStatus, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+
+	usage, err := s.usage(s.db, "user_id", userID, user.SpendingCaps, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	account := &SpendingCapStatus{Caps: user.SpendingCaps, Usage: usage}
+
+	var apiKeys []models.ApiKey
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		return nil, nil, err
+	}
+	keys := []SpendingCapStatus{}
+	for _, apiKey := range apiKeys {
+		if !apiKey.SpendingCaps.Any() {
+			continue
+		}
+		usage, err := s.usage(s.db, "api_key_id", apiKey.ID, apiKey.SpendingCaps, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, SpendingCapStatus{
+			ApiKeyID: apiKey.ID,
+			Name:     apiKey.Name,
+			Caps:     apiKey.SpendingCaps,
+			Usage:    usage,
+		})
+	}
+	return account, keys, nil
+}
+
+// check counts an operation costing cost against the caps of a user and of
+// the API key it is made with. It returns the usage of the caps and the cap
+// the operation would exceed, nil if it is within all of them; the usage
+// includes the operation only when it is within the caps. tx must hold the
+// user's row lock, which orders the operations of the user and their keys.
+func (s *SpendingCapService) check(tx *gorm.DB, user *models.User, apiKeyID string, cost decimal.Decimal, now time.Time) ([]CapUsage, *CapUsage, error) {
+	var usages []CapUsage
+	if user.SpendingCaps.Any() {
+		usage, err := s.usage(tx, "user_id", user.ID, user.SpendingCaps, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		usages = append(usages, usage...)
+	}
+	if apiKeyID != "" {
+		var apiKey models.ApiKey
+		err := tx.Where("id = ? AND user_id = ?", apiKeyID, user.ID).First(&apiKey).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		if err == nil && apiKey.SpendingCaps.Any() {
+			usage, err := s.usage(tx, "api_key_id", apiKey.ID, apiKey.SpendingCaps, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			for i := range usage {
+				usage[i].apiKey = &apiKey
+			}
+			usages = append(usages, usage...)
+		}
+	}
+
+	var exceeded *CapUsage
+	for i := range usages {
+		usages[i].userID = user.ID
+		if usages[i].next(cost).GreaterThan(Money(usages[i].Limit)) {
+			usages[i].refused = true
+			if exceeded == nil {
+				exceeded = &usages[i]
+			}
+		}
+	}
+	if exceeded == nil {
+		for i := range usages {
+			usages[i].Used = usages[i].next(cost).InexactFloat64()
+		}
+	}
+	return usages, exceeded, nil
+}
+
+// usage returns what the owner of caps, the user or API key whose ID is in
+// column of the reservations, used of them this UTC day and month
+func (s *SpendingCapService) usage(tx *gorm.DB, column, id string, caps models.SpendingCaps, now time.Time) ([]CapUsage, error) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	usages := []CapUsage{}
+	periods := []struct {
+		start, end     time.Time
+		spendCap       float64
+		operationCap   int
+		spend, operate string
+	}{
+		{day, day.AddDate(0, 0, 1), caps.DailySpendCap, caps.DailyOperationCap, models.CapDailySpend, models.CapDailyOperations},
+		{month, month.AddDate(0, 1, 0), caps.MonthlySpendCap, caps.MonthlyOperationCap, models.CapMonthlySpend, models.CapMonthlyOperations},
+	}
+	for _, period := range periods {
+		if period.spendCap <= 0 && period.operationCap <= 0 {
+			continue
+		}
+
+		var totals struct {
+			Spend      decimal.NullDecimal
+			Operations int64
+		}
+		if err := tx.Model(&models.Reservation{}).
+			Select("SUM(amount) AS spend, COUNT(*) AS operations").
+			Where(column+" = ? AND created_at >= ? AND status IN ?", id, period.start,
+				[]string{models.ReservationStatusHeld, models.ReservationStatusCommitted}).
+			Scan(&totals).Error; err != nil {
+			return nil, fmt.Errorf("failed to count spending: %w", err)
+		}
+
+		if period.spendCap > 0 {
+			usages = append(usages, CapUsage{
+				Cap:      period.spend,
+				Limit:    period.spendCap,
+				Used:     totals.Spend.Decimal.InexactFloat64(),
+				ResetsAt: period.end,
+				period:   period.start,
+			})
+		}
+		if period.operationCap > 0 {
+			usages = append(usages, CapUsage{
+				Cap:      period.operate,
+				Limit:    float64(period.operationCap),
+				Used:     float64(totals.Operations),
+				ResetsAt: period.end,
+				period:   period.start,
+			})
+		}
+	}
+	return usages, nil
+}
+
+// next returns the usage of a cap after an operation costing cost
+func (u *CapUsage) next(cost decimal.Decimal) decimal.Decimal {
+	if u.isSpend() {
+		return Money(u.Used).Add(cost)
+	}
+	return Money(u.Used).Add(decimal.NewFromInt(1))
+}
+
+func (u *CapUsage) isSpend() bool {
+	return u.Cap == models.CapDailySpend || u.Cap == models.CapMonthlySpend
+}
+
+// owner names the account or API key a cap belongs to in messages
+func (u *CapUsage) owner() string {
+	if u.apiKey != nil {
+		return fmt.Sprintf("API key '%s'", u.apiKey.Name)
+	}
+	return "your account"
+}
+
+// format formats an amount of a cap for messages
+func (u *CapUsage) format(value float64) string {
+	if u.isSpend() {
+		return fmt.Sprintf("$%.3f", value)
+	}
+	return fmt.Sprintf("%d operations", int(value))
+}
+
+// percent returns the share of the cap used, 100 once an operation was
+// refused because of it
+func (u *CapUsage) percent() int {
+	if u.refused {
+		return 100
+	}
+	return int(Money(u.Used).Mul(decimal.NewFromInt(100)).Div(Money(u.Limit)).IntPart())
+}
+
+// message explains why an operation was refused because of the cap
+func (u *CapUsage) message() string {
+	return fmt.Sprintf("Spending cap reached: the %s of %s is %s, it resets at %s",
+		capNames[u.Cap], u.owner(), u.format(u.Limit), u.ResetsAt.Format(time.RFC3339))
+}
+
+// alert emails a user, in the background, about the caps that reached one
+// of the alert thresholds of the api settings. Each threshold is sent once
+// per cap and period; when several are reached at once only the highest is
+// sent.
+func (s *SpendingCapService) alert(usages []CapUsage) {
+	if s.email == nil || len(usages) == 0 {
+		return
+	}
+
+	go func() {
+		thresholds := s.alertThresholds()
+		for i := range usages {
+			usage := &usages[i]
+			percent := usage.percent()
+
+			reached := 0
+			for _, threshold := range thresholds {
+				if percent >= threshold && threshold > reached && s.claimAlert(usage, threshold) {
+					reached = threshold
+				}
+			}
+			if reached > 0 {
+				s.sendAlert(usage, reached)
+			}
+		}
+	}()
+}
+
+// alertThresholds returns the alert thresholds of the api settings
+func (s *SpendingCapService) alertThresholds() []int {
+	apiSettings, err := s.settings.GetSettings("api")
+	if err != nil {
+		return defaultCapAlertThresholds
+	}
+	value, ok := apiSettings["capAlertThresholds"].(string)
+	if !ok {
+		return defaultCapAlertThresholds
+	}
+
+	var thresholds []int
+	for _, field := range strings.Split(value, ",") {
+		threshold, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || threshold <= 0 {
+			continue
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds
+}
+
+// claimAlert records the alert of a threshold of a cap for its period. The
+// unique alert key makes sure only one operation sends it.
+func (s *SpendingCapService) claimAlert(usage *CapUsage, threshold int) bool {
+	subject := "user:" + usage.userID
+	var apiKeyID *string
+	if usage.apiKey != nil {
+		subject = "key:" + usage.apiKey.ID
+		apiKeyID = &usage.apiKey.ID
+	}
+	alertKey := fmt.Sprintf("%s:%s:%s:%d", subject, usage.Cap, usage.period.Format("2006-01-02"), threshold)
+
+	alert := models.OperationsAlert{
+		ID:        uuid.New().String(),
+		UserID:    usage.userID,
+		Type:      models.OperationsAlertTypeCap,
+		ApiKeyID:  apiKeyID,
+		Cap:       usage.Cap,
+		Threshold: threshold,
+		AlertKey:  &alertKey,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Omit("User").Create(&alert).Error; err != nil {
+		var count int64
+		if s.db.Model(&models.OperationsAlert{}).Where("alert_key = ?", alertKey).Count(&count); count == 0 {
+			fmt.Printf("ERROR: Failed to record spending cap alert %s: %v\n", alertKey, err)
+		}
+		return false
+	}
+	return true
+}
+
+// sendAlert emails a user that threshold percent of a cap was used
+func (s *SpendingCapService) sendAlert(usage *CapUsage, threshold int) {
+	var user models.User
+	if err := s.db.Select("id", "email", "name").First(&user, "id = ?", usage.userID).Error; err != nil {
+		fmt.Printf("ERROR: Failed to load user %s for spending cap alert: %v\n", usage.userID, err)
+		return
+	}
+	if user.Email == "" {
+		return
+	}
+
+	if _, err := s.email.SendSpendingCapAlertEmail(user.Email, usage.owner(), capNames[usage.Cap], threshold,
+		usage.format(usage.Used), usage.format(usage.Limit), usage.ResetsAt, user.Name); err != nil {
+		fmt.Printf("ERROR: Failed to send spending cap alert to user %s: %v\n", user.ID, err)
+	}
+}
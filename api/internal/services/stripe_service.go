@@ -0,0 +1,365 @@
+// internal/services/stripe_service.go
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance is how old the timestamp of a signed Stripe
+// webhook delivery may be, which bounds replays of captured deliveries
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeService takes deposit payments with Stripe Checkout sessions. Cards
+// saved for automatic top-ups belong to a Stripe customer and are charged
+// with payment intents.
+type StripeService struct {
+	secretKey     string
+	webhookSecret string
+	apiBase       string
+	appURL        string
+}
+
+func NewStripeService(secretKey, webhookSecret, apiBase, appURL string) *StripeService {
+	return &StripeService{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		apiBase:       apiBase,
+		appURL:        appURL,
+	}
+}
+
+// stripeSession is the part of a Checkout session the service reads
+type stripeSession struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	URL           string `json:"url"`
+	PaymentStatus string `json:"payment_status"`
+	AmountTotal   int64  `json:"amount_total"`
+	PaymentIntent string `json:"payment_intent"`
+}
+
+// Name implements PaymentProvider
+func (s *StripeService) Name() string {
+	return PaymentProviderStripe
+}
+
+// CreateCheckout creates a Stripe Checkout session for deposit
+func (s *StripeService) CreateCheckout(userID string, amount float64, description string) (string, string, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", userID)
+	form.Set("metadata[user_id]", userID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", "usd")
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(stripeAmount(amount), 10))
+	form.Set("line_items[0][price_data][product_data][name]", description)
+	form.Set("success_url", fmt.Sprintf("%s/en/dashboard/success?session_id={CHECKOUT_SESSION_ID}", s.appURL))
+	form.Set("cancel_url", fmt.Sprintf("%s/en/dashboard/cancel", s.appURL))
+
+	var session stripeSession
+	if err := s.request("POST", "/v1/checkout/sessions", form, "", &session); err != nil {
+		return "", "", fmt.Errorf("failed to create checkout session: %w", err)
+	}
+	if session.ID == "" || session.URL == "" {
+		return "", "", fmt.Errorf("checkout session ID or URL not found in response")
+	}
+
+	return session.ID, session.URL, nil
+}
+
+// VerifyPayment checks that a Checkout session was paid. Stripe captures the
+// payment when the session completes, so there is nothing to capture.
+func (s *StripeService) VerifyPayment(sessionID string) (bool, float64, error) {
+	session, err := s.getSession(sessionID)
+	if err != nil {
+		return false, 0, err
+	}
+	if session.PaymentStatus != "paid" {
+		return false, 0, nil
+	}
+
+	return true, float64(session.AmountTotal) / 100, nil
+}
+
+// HandleWebhook verifies the Stripe-Signature header of a webhook delivery
+// and returns its event. Checkout session events are about the session of
+// the deposit.
+func (s *StripeService) HandleWebhook(header http.Header, rawBody []byte) (*PaymentEvent, error) {
+	if err := s.verifySignature(header.Get("Stripe-Signature"), rawBody, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object stripeSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rawBody, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhookEvent, err)
+	}
+	if event.ID == "" {
+		return nil, fmt.Errorf("%w: missing id", ErrInvalidWebhookEvent)
+	}
+
+	session := event.Data.Object
+	paymentEvent := &PaymentEvent{
+		ID:           event.ID,
+		Type:         event.Type,
+		ResourceType: session.Object,
+		ResourceID:   session.ID,
+		RawData:      string(rawBody),
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		// Delayed payment methods complete the session before they are paid
+		// and report the payment with an async event
+		if session.PaymentStatus == "paid" {
+			paymentEvent.Outcome = PaymentEventCompleted
+		}
+	case "checkout.session.async_payment_succeeded":
+		paymentEvent.Outcome = PaymentEventCompleted
+	case "checkout.session.async_payment_failed", "checkout.session.expired":
+		paymentEvent.Outcome = PaymentEventFailed
+	}
+
+	if paymentEvent.Outcome != "" {
+		if session.ID == "" {
+			return nil, fmt.Errorf("%w: missing session ID in %s event", ErrInvalidWebhookEvent, event.Type)
+		}
+		paymentEvent.PaymentID = session.ID
+	}
+	return paymentEvent, nil
+}
+
+// Refund refunds amount of the payment of a Checkout session, or of a
+// payment intent charged to a saved card
+func (s *StripeService) Refund(paymentID string, amount float64, refundID string) error {
+	paymentIntent := paymentID
+	if !strings.HasPrefix(paymentID, "pi_") {
+		session, err := s.getSession(paymentID)
+		if err != nil {
+			return err
+		}
+		if session.PaymentIntent == "" {
+			return fmt.Errorf("checkout session %s has no payment to refund", paymentID)
+		}
+		paymentIntent = session.PaymentIntent
+	}
+
+	form := url.Values{}
+	form.Set("payment_intent", paymentIntent)
+	form.Set("amount", strconv.FormatInt(stripeAmount(amount), 10))
+	if err := s.request("POST", "/v1/refunds", form, "refund_"+refundID, nil); err != nil {
+		return fmt.Errorf("failed to refund payment: %w", err)
+	}
+	return nil
+}
+
+// CreateSetup creates a Stripe customer for a user and a Checkout session in
+// setup mode that saves a card of the customer
+func (s *StripeService) CreateSetup(userID string) (string, string, error) {
+	customerForm := url.Values{}
+	customerForm.Set("metadata[user_id]", userID)
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := s.request("POST", "/v1/customers", customerForm, "", &customer); err != nil {
+		return "", "", fmt.Errorf("failed to create customer: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("mode", "setup")
+	form.Set("customer", customer.ID)
+	form.Set("currency", "usd")
+	form.Set("client_reference_id", userID)
+	form.Set("metadata[user_id]", userID)
+	form.Set("success_url", fmt.Sprintf("%s/en/dashboard/billing?setup_session_id={CHECKOUT_SESSION_ID}", s.appURL))
+	form.Set("cancel_url", fmt.Sprintf("%s/en/dashboard/billing", s.appURL))
+
+	var session stripeSession
+	if err := s.request("POST", "/v1/checkout/sessions", form, "", &session); err != nil {
+		return "", "", fmt.Errorf("failed to create setup session: %w", err)
+	}
+	if session.ID == "" || session.URL == "" {
+		return "", "", fmt.Errorf("setup session ID or URL not found in response")
+	}
+
+	return session.ID, session.URL, nil
+}
+
+// ConfirmSetup returns the card saved by a completed setup session as
+// "<customer>/<payment method>", since charging it takes both
+func (s *StripeService) ConfirmSetup(sessionID string) (string, error) {
+	var session struct {
+		Status      string `json:"status"`
+		Customer    string `json:"customer"`
+		SetupIntent struct {
+			Status        string `json:"status"`
+			PaymentMethod string `json:"payment_method"`
+		} `json:"setup_intent"`
+	}
+	path := "/v1/checkout/sessions/" + url.PathEscape(sessionID) + "?expand[]=setup_intent"
+	if err := s.request("GET", path, nil, "", &session); err != nil {
+		return "", fmt.Errorf("failed to get setup session: %w", err)
+	}
+	if session.Status != "complete" || session.SetupIntent.Status != "succeeded" {
+		return "", fmt.Errorf("setup session is not completed: %s", session.Status)
+	}
+	if session.Customer == "" || session.SetupIntent.PaymentMethod == "" {
+		return "", fmt.Errorf("customer or payment method not found in setup session")
+	}
+
+	return session.Customer + "/" + session.SetupIntent.PaymentMethod, nil
+}
+
+// ChargeSaved charges a card saved by ConfirmSetup with a payment intent
+// confirmed off session. Its payment ID is the payment intent's ID.
+func (s *StripeService) ChargeSaved(paymentMethodID string, amount float64, description, chargeID string) (string, error) {
+	customer, paymentMethod, ok := strings.Cut(paymentMethodID, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid saved payment method '%s'", paymentMethodID)
+	}
+
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(stripeAmount(amount), 10))
+	form.Set("currency", "usd")
+	form.Set("customer", customer)
+	form.Set("payment_method", paymentMethod)
+	form.Set("off_session", "true")
+	form.Set("confirm", "true")
+	form.Set("description", description)
+	form.Set("metadata[charge_id]", chargeID)
+
+	var intent struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := s.request("POST", "/v1/payment_intents", form, "charge_"+chargeID, &intent); err != nil {
+		return "", fmt.Errorf("failed to charge saved card: %w", err)
+	}
+	if intent.Status != "succeeded" {
+		return "", fmt.Errorf("%w: payment intent %s is %s", ErrPaymentDeclined, intent.ID, intent.Status)
+	}
+
+	return intent.ID, nil
+}
+
+// verifySignature checks a Stripe-Signature header of the form
+// "t=<timestamp>,v1=<signature>[,v1=...]": one v1 signature must be the
+// HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret
+func (s *StripeService) verifySignature(signatureHeader string, rawBody []byte, now time.Time) error {
+	if s.webhookSecret == "" {
+		return fmt.Errorf("Stripe webhook secret is not configured")
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signatureHeader, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed Stripe-Signature header", ErrInvalidWebhookSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidWebhookSignature)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside the tolerance", ErrInvalidWebhookSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(rawBody)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if decoded, err := hex.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no matching signature", ErrInvalidWebhookSignature)
+}
+
+// getSession retrieves a Checkout session
+func (s *StripeService) getSession(sessionID string) (*stripeSession, error) {
+	var session stripeSession
+	if err := s.request("GET", "/v1/checkout/sessions/"+url.PathEscape(sessionID), nil, "", &session); err != nil {
+		return nil, fmt.Errorf("failed to get checkout session: %w", err)
+	}
+	return &session, nil
+}
+
+// request calls the Stripe API with a form encoded body and decodes the
+// response into result unless it is nil. Requests with an idempotency key
+// are carried out once by Stripe, however often they are sent. Card errors,
+// which Stripe answers with 402, get ErrPaymentDeclined.
+func (s *StripeService) request(method, path string, form url.Values, idempotencyKey string, result interface{}) error {
+	if s.secretKey == "" {
+		return fmt.Errorf("Stripe credentials are not configured")
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, s.apiBase+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.secretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusPaymentRequired {
+			return fmt.Errorf("%w: %s - %s", ErrPaymentDeclined, resp.Status, string(respBody))
+		}
+		return fmt.Errorf("%s - %s", resp.Status, string(respBody))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// stripeAmount converts a dollar amount to the cents Stripe expects
+func stripeAmount(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
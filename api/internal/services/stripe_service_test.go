@@ -0,0 +1,281 @@
+// internal/services/stripe_service_test.go
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testStripeKey           = "sk_test_key"
+	testStripeWebhookSecret = "whsec_test_secret"
+)
+
+// fakeStripe is an httptest server standing in for the Stripe API's Checkout
+// sessions
+type fakeStripe struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sessions map[string]*stripeSession
+}
+
+func newFakeStripe(t *testing.T) *fakeStripe {
+	s := &fakeStripe{sessions: make(map[string]*stripeSession)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/checkout/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testStripeKey {
+			http.Error(w, `{"error":{"type":"invalid_request_error"}}`, http.StatusUnauthorized)
+			return
+		}
+		amount, err := strconv.ParseInt(r.FormValue("line_items[0][price_data][unit_amount]"), 10, 64)
+		if err != nil || r.FormValue("mode") != "payment" {
+			http.Error(w, `{"error":{"type":"invalid_request_error"}}`, http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		id := "cs_test_" + strconv.Itoa(len(s.sessions)+1)
+		session := &stripeSession{
+			ID:            id,
+			Object:        "checkout.session",
+			URL:           "https://checkout.stripe.com/c/pay/" + id,
+			PaymentStatus: "unpaid",
+			AmountTotal:   amount,
+		}
+		s.sessions[id] = session
+		s.mu.Unlock()
+
+		json.NewEncoder(w).Encode(session)
+	})
+	mux.HandleFunc("GET /v1/checkout/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		session, ok := s.sessions[r.PathValue("id")]
+		if !ok {
+			http.Error(w, `{"error":{"type":"invalid_request_error"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(session)
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// pay marks a session as paid and returns the webhook event Stripe sends
+// about it
+func (s *fakeStripe) pay(eventID, sessionID string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.sessions[sessionID]
+	session.PaymentStatus = "paid"
+	return stripeSessionEvent(eventID, "checkout.session.completed", session)
+}
+
+func stripeSessionEvent(eventID, eventType string, session *stripeSession) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":     eventID,
+		"object": "event",
+		"type":   eventType,
+		"data":   map[string]interface{}{"object": session},
+	})
+	return body
+}
+
+// stripeSignature returns the Stripe-Signature header of a delivery of body
+// signed at signedAt
+func stripeSignature(secret string, signedAt time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func stripeHeader(signature string) http.Header {
+	header := http.Header{}
+	header.Set("Stripe-Signature", signature)
+	return header
+}
+
+func newTestStripeService(fake *fakeStripe) *StripeService {
+	return NewStripeService(testStripeKey, testStripeWebhookSecret, fake.URL, "https://megapdf.example.com")
+}
+
+func TestStripeWebhookCreditsDepositOnce(t *testing.T) {
+	fake := newFakeStripe(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestStripeService(fake))
+
+	transaction, checkoutURL, err := payments.CreateDeposit("user-1", PaymentProviderStripe, 20)
+	if err != nil {
+		t.Fatalf("CreateDeposit() error = %v", err)
+	}
+	if transaction.PaymentID != "cs_test_1" || !strings.HasPrefix(checkoutURL, "https://checkout.stripe.com/") {
+		t.Fatalf("deposit %q at %q, want the Checkout session", transaction.PaymentID, checkoutURL)
+	}
+
+	body := fake.pay("evt_1", "cs_test_1")
+	if err := payments.HandleWebhook(PaymentProviderStripe, stripeHeader(stripeSignature(testStripeWebhookSecret, time.Now(), body)), body); err != nil {
+		t.Fatalf("HandleWebhook() error = %v", err)
+	}
+	if balance := userBalance(t, db); balance != 20 {
+		t.Fatalf("balance = %v, want 20", balance)
+	}
+
+	// Stripe signs every delivery again, a replay carries a new timestamp
+	replay := stripeHeader(stripeSignature(testStripeWebhookSecret, time.Now().Add(time.Second), body))
+	if err := payments.HandleWebhook(PaymentProviderStripe, replay, body); !errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Errorf("replay error = %v, want ErrDuplicateWebhookEvent", err)
+	}
+
+	// The client verifying the paid deposit afterwards does not credit it again
+	paid, amount, err := payments.VerifyDeposit("user-1", "cs_test_1")
+	if err != nil || !paid || amount != 20 {
+		t.Errorf("VerifyDeposit() = %v, %v, %v, want the deposit paid", paid, amount, err)
+	}
+	if balance := userBalance(t, db); balance != 20 {
+		t.Errorf("balance = %v, want the deposit credited once", balance)
+	}
+}
+
+func TestStripeWebhookRejectsInvalidSignatures(t *testing.T) {
+	fake := newFakeStripe(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestStripeService(fake))
+	if _, _, err := payments.CreateDeposit("user-1", PaymentProviderStripe, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	body := fake.pay("evt_1", "cs_test_1")
+	now := time.Now()
+	valid := stripeSignature(testStripeWebhookSecret, now, body)
+	timestamp, _, _ := strings.Cut(valid, ",")
+
+	tests := []struct {
+		name      string
+		signature string
+		body      []byte
+	}{
+		{"no header", "", body},
+		{"malformed header", "v1=" + strings.Repeat("0", 64), body},
+		{"other secret", stripeSignature("whsec_other", now, body), body},
+		{"tampered body", valid, []byte(strings.Replace(string(body), "cs_test_1", "cs_test_2", 1))},
+		{"other timestamp", "t=" + strconv.FormatInt(now.Unix()+1, 10) + valid[len(timestamp):], body},
+		{"expired", stripeSignature(testStripeWebhookSecret, now.Add(-stripeSignatureTolerance-time.Minute), body), body},
+		{"from the future", stripeSignature(testStripeWebhookSecret, now.Add(stripeSignatureTolerance+time.Minute), body), body},
+		{"v0 scheme only", strings.Replace(valid, "v1=", "v0=", 1), body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := payments.HandleWebhook(PaymentProviderStripe, stripeHeader(tt.signature), tt.body); !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Errorf("HandleWebhook() error = %v, want ErrInvalidWebhookSignature", err)
+			}
+		})
+	}
+
+	if balance := userBalance(t, db); balance != 0 {
+		t.Errorf("balance = %v, want nothing credited", balance)
+	}
+
+	// Stripe sends one signature per active secret while a secret is rolled
+	rolled := stripeSignature("whsec_old", now, body) + "," + strings.SplitN(valid, ",", 2)[1]
+	if err := payments.HandleWebhook(PaymentProviderStripe, stripeHeader(rolled), body); err != nil {
+		t.Errorf("HandleWebhook() with a rolled secret error = %v", err)
+	}
+}
+
+func TestStripeWebhookDelayedPayment(t *testing.T) {
+	fake := newFakeStripe(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestStripeService(fake))
+	if _, _, err := payments.CreateDeposit("user-1", PaymentProviderStripe, 20); err != nil {
+		t.Fatal(err)
+	}
+	session := &stripeSession{ID: "cs_test_1", Object: "checkout.session", PaymentStatus: "unpaid", AmountTotal: 2000}
+
+	deliver := func(eventID, eventType string) {
+		t.Helper()
+		body := stripeSessionEvent(eventID, eventType, session)
+		if err := payments.HandleWebhook(PaymentProviderStripe, stripeHeader(stripeSignature(testStripeWebhookSecret, time.Now(), body)), body); err != nil {
+			t.Fatalf("HandleWebhook(%s) error = %v", eventType, err)
+		}
+	}
+
+	// The session completes before a delayed payment method is paid
+	deliver("evt_1", "checkout.session.completed")
+	if status := depositStatus(t, db, "cs_test_1"); status != "pending" {
+		t.Fatalf("deposit status = %q after an unpaid session completed, want pending", status)
+	}
+
+	session.PaymentStatus = "paid"
+	deliver("evt_2", "checkout.session.async_payment_succeeded")
+	if status := depositStatus(t, db, "cs_test_1"); status != "completed" {
+		t.Errorf("deposit status = %q, want completed", status)
+	}
+	if balance := userBalance(t, db); balance != 20 {
+		t.Errorf("balance = %v, want 20", balance)
+	}
+}
+
+func TestWebhooksOfBothProviders(t *testing.T) {
+	paypal, stripe := newFakePayPal(t), newFakeStripe(t)
+	payments, db := newTestPaymentService(t)
+	payments.RegisterProvider(newTestPayPalService(paypal))
+	payments.RegisterProvider(newTestStripeService(stripe))
+
+	createTestDeposit(t, payments, PaymentProviderPayPal, "ORDER-1", 10)
+	if _, _, err := payments.CreateDeposit("user-1", PaymentProviderStripe, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	paypalBody := paypalCaptureEvent("WH-EVENT-1", "PAYMENT.CAPTURE.COMPLETED", "ORDER-1")
+	paypalHeader := paypal.sign("TX-1", paypalBody)
+	stripeBody := stripe.pay("evt_1", "cs_test_1")
+	stripeSigned := stripeHeader(stripeSignature(testStripeWebhookSecret, time.Now(), stripeBody))
+
+	// A delivery is verified by the provider whose webhook URL it was sent to
+	if err := payments.HandleWebhook(PaymentProviderStripe, paypalHeader, paypalBody); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("PayPal delivery to Stripe error = %v, want ErrInvalidWebhookSignature", err)
+	}
+	if err := payments.HandleWebhook(PaymentProviderPayPal, stripeSigned, stripeBody); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("Stripe delivery to PayPal error = %v, want ErrInvalidWebhookSignature", err)
+	}
+	if balance := userBalance(t, db); balance != 0 {
+		t.Fatalf("balance = %v, want nothing credited", balance)
+	}
+
+	for i := 0; i < 2; i++ {
+		paypalErr := payments.HandleWebhook(PaymentProviderPayPal, paypalHeader, paypalBody)
+		stripeErr := payments.HandleWebhook(PaymentProviderStripe, stripeSigned, stripeBody)
+		if i == 0 && (paypalErr != nil || stripeErr != nil) {
+			t.Fatalf("HandleWebhook() errors = %v, %v", paypalErr, stripeErr)
+		}
+		if i == 1 && (!errors.Is(paypalErr, ErrDuplicateWebhookEvent) || !errors.Is(stripeErr, ErrDuplicateWebhookEvent)) {
+			t.Errorf("replay errors = %v, %v, want ErrDuplicateWebhookEvent", paypalErr, stripeErr)
+		}
+	}
+
+	if balance := userBalance(t, db); balance != 25 {
+		t.Errorf("balance = %v, want each deposit credited once", balance)
+	}
+	for _, paymentID := range []string{"ORDER-1", "cs_test_1"} {
+		if status := depositStatus(t, db, paymentID); status != "completed" {
+			t.Errorf("deposit %s status = %q, want completed", paymentID, status)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// internal/services/upload_service.go
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/MegaPDF/megapdf-official/api/internal/storage"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Errors returned by UploadService
+var (
+	ErrUploadNotFound         = errors.New("upload not found")
+	ErrInvalidUpload          = errors.New("invalid upload")
+	ErrUploadTooLarge         = errors.New("upload exceeds the size limit")
+	ErrUploadOffsetMismatch   = errors.New("chunk offset does not match the received bytes")
+	ErrUploadChunkTooLarge    = errors.New("chunk exceeds the announced upload size")
+	ErrUploadIncomplete       = errors.New("upload is not complete")
+	ErrUploadCompleted        = errors.New("upload is already completed")
+	ErrInvalidChecksum        = errors.New("checksum must be a hex encoded SHA-256, optionally prefixed with sha256:")
+	ErrUploadChecksumMismatch = errors.New("checksum does not match the uploaded content")
+)
+
+// UploadService runs resumable uploads. Chunks are kept in storage, so the
+// chunks of an upload may be sent to different API instances, and are joined
+// into a single object when the upload is completed.
+type UploadService struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	retention *RetentionService
+	tempDir   string
+}
+
+// NewUploadService creates an upload service keeping uploads in store and
+// spooling chunks in tempDir
+func NewUploadService(db *gorm.DB, store storage.Storage, retention *RetentionService, tempDir string) *UploadService {
+	return &UploadService{
+		db:        db,
+		storage:   store,
+		retention: retention,
+		tempDir:   tempDir,
+	}
+}
+
+// Create starts an upload of size bytes. limit is the caller's upload limit.
+func (s *UploadService) Create(userID, filename string, size, limit int64) (*models.Upload, error) {
+	filename = filepath.Base(strings.TrimSpace(filename))
+	if filename == "" || filename == "." || filename == string(filepath.Separator) {
+		return nil, fmt.Errorf("%w: filename is required", ErrInvalidUpload)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrInvalidUpload)
+	}
+	if size > limit {
+		return nil, ErrUploadTooLarge
+	}
+
+	now := time.Now()
+	upload := &models.Upload{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Filename:  filename,
+		Size:      size,
+		Status:    models.UploadStatusUploading,
+		ExpiresAt: s.retention.UploadExpiresAt(now),
+	}
+	if err := s.db.Create(upload).Error; err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return upload, nil
+}
+
+// Get returns an upload of userID that has not expired
+func (s *UploadService) Get(id, userID string) (*models.Upload, error) {
+	var upload models.Upload
+	if err := s.db.Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// WriteChunk stores the chunk read from body at offset, which must be the
+// number of bytes received so far. The upload is returned with its new
+// offset, or with the current one on ErrUploadOffsetMismatch.
+func (s *UploadService) WriteChunk(ctx context.Context, id, userID string, offset int64, body io.Reader) (*models.Upload, error) {
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.UploadStatusUploading {
+		return upload, ErrUploadCompleted
+	}
+	if offset != upload.Received {
+		return upload, ErrUploadOffsetMismatch
+	}
+
+	// Spool the chunk, so its size is known before it is stored
+	if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp(s.tempDir, "upload-chunk-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+
+	remaining := upload.Size - upload.Received
+	size, err := io.Copy(spool, io.LimitReader(body, remaining+1))
+	spool.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive chunk: %w", err)
+	}
+	if size > remaining {
+		return upload, ErrUploadChunkTooLarge
+	}
+	if size == 0 {
+		return upload, nil
+	}
+
+	key := storage.Key("uploads/"+upload.ID, fmt.Sprintf("chunk-%020d-%s", offset, uuid.New().String()))
+	if err := storage.MoveFile(ctx, s.storage, key, spool.Name()); err != nil {
+		return nil, fmt.Errorf("failed to store chunk: %w", err)
+	}
+
+	// Only one of concurrent chunks sent at the same offset is kept
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Upload{}).
+			Where("id = ? AND received = ? AND status = ?", upload.ID, offset, models.UploadStatusUploading).
+			Update("received", offset+size)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUploadOffsetMismatch
+		}
+		return tx.Create(&models.UploadChunk{
+			ID:         uuid.New().String(),
+			UploadID:   upload.ID,
+			Offset:     offset,
+			Size:       size,
+			StorageKey: key,
+		}).Error
+	})
+	if err != nil {
+		s.storage.Delete(context.Background(), key)
+		if errors.Is(err, ErrUploadOffsetMismatch) {
+			if current, getErr := s.Get(id, userID); getErr == nil {
+				upload = current
+			}
+			return upload, err
+		}
+		return nil, err
+	}
+
+	upload.Received = offset + size
+	return upload, nil
+}
+
+// Complete joins the chunks of a fully received upload and verifies them
+// against checksum. Completing a completed upload with the same checksum
+// succeeds again.
+func (s *UploadService) Complete(ctx context.Context, id, userID, checksum string) (*models.Upload, error) {
+	checksum, err := parseChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status == models.UploadStatusCompleted {
+		if upload.Checksum != checksum {
+			return upload, ErrUploadChecksumMismatch
+		}
+		return upload, nil
+	}
+	if upload.Received != upload.Size {
+		return upload, ErrUploadIncomplete
+	}
+
+	var chunks []models.UploadChunk
+	if err := s.db.Where("upload_id = ?", upload.ID).Order("chunk_offset").Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+
+	// Join the chunks in a local file while hashing them
+	if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+		return nil, err
+	}
+	joined, err := os.CreateTemp(s.tempDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(joined.Name())
+
+	hash := sha256.New()
+	var offset int64
+	for _, chunk := range chunks {
+		if chunk.Offset != offset {
+			joined.Close()
+			return nil, fmt.Errorf("upload is missing the chunk at offset %d", offset)
+		}
+		body, _, err := s.storage.Get(ctx, chunk.StorageKey)
+		if err != nil {
+			joined.Close()
+			return nil, fmt.Errorf("failed to read chunk at offset %d: %w", chunk.Offset, err)
+		}
+		_, err = io.Copy(io.MultiWriter(joined, hash), body)
+		body.Close()
+		if err != nil {
+			joined.Close()
+			return nil, fmt.Errorf("failed to join chunks: %w", err)
+		}
+		offset += chunk.Size
+	}
+	if err := joined.Close(); err != nil {
+		return nil, err
+	}
+	if offset != upload.Size {
+		return upload, ErrUploadIncomplete
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return upload, ErrUploadChecksumMismatch
+	}
+
+	key := storage.Key("uploads/"+upload.ID, "content")
+	if err := storage.MoveFile(ctx, s.storage, key, joined.Name()); err != nil {
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.Upload{}).
+		Where("id = ? AND status = ?", upload.ID, models.UploadStatusUploading).
+		Updates(map[string]interface{}{
+			"status":       models.UploadStatusCompleted,
+			"checksum":     checksum,
+			"storage_key":  key,
+			"completed_at": now,
+			"expires_at":   s.retention.UploadExpiresAt(now),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Completed concurrently
+		return s.Get(id, userID)
+	}
+
+	// The chunks are no longer needed
+	for _, chunk := range chunks {
+		s.storage.Delete(ctx, chunk.StorageKey)
+	}
+	s.db.Where("upload_id = ?", upload.ID).Delete(&models.UploadChunk{})
+
+	return s.Get(id, userID)
+}
+
+// Fetch copies the content of a completed upload of userID into dir and
+// returns the upload and the path of the copy
+func (s *UploadService) Fetch(ctx context.Context, id, userID, dir string) (*models.Upload, string, error) {
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if upload.Status != models.UploadStatusCompleted {
+		return upload, "", ErrUploadIncomplete
+	}
+
+	localPath := filepath.Join(dir, upload.ID+"-"+upload.Filename)
+	if err := storage.FetchFile(ctx, s.storage, upload.StorageKey, localPath); err != nil {
+		return upload, "", fmt.Errorf("failed to fetch upload: %w", err)
+	}
+	return upload, localPath, nil
+}
+
+// Abort removes an upload of userID and everything stored for it
+func (s *UploadService) Abort(ctx context.Context, id, userID string) error {
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return err
+	}
+	return deleteUpload(ctx, s.db, s.storage, upload.ID)
+}
+
+// deleteUpload removes an upload, its chunks and their objects
+func deleteUpload(ctx context.Context, db *gorm.DB, store storage.Storage, uploadID string) error {
+	objects, err := store.List(ctx, "uploads/"+uploadID+"/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := store.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ?", uploadID).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", uploadID).Delete(&models.Upload{}).Error
+	})
+}
+
+// parseChecksum returns the lower case hex SHA-256 of "sha256:<hex>" or "<hex>"
+func parseChecksum(checksum string) (string, error) {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	checksum = strings.TrimPrefix(checksum, "sha256:")
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+		return "", ErrInvalidChecksum
+	}
+	return checksum, nil
+}
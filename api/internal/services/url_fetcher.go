@@ -0,0 +1,223 @@
+// internal/services/url_fetcher.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by URLFetcher
+var (
+	ErrInvalidFileURL     = errors.New("file URL must be an absolute http or https URL")
+	ErrBlockedFileURL     = errors.New("file URL points to a private or reserved address")
+	ErrRemoteFileTooLarge = errors.New("remote file exceeds the size limit")
+	ErrRemoteFileFailed   = errors.New("failed to download the remote file")
+)
+
+// urlFetcherMaxRedirects is how many redirects a download may follow
+const urlFetcherMaxRedirects = 5
+
+// blockedNetworks are reserved ranges not covered by the net.IP predicates
+// used in publicIP
+var blockedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // "This" network
+		"100.64.0.0/10",   // Carrier-grade NAT
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // Documentation
+		"198.18.0.0/15",   // Benchmarking
+		"198.51.100.0/24", // Documentation
+		"203.0.113.0/24",  // Documentation
+		"240.0.0.0/4",     // Reserved
+		"64:ff9b::/96",    // NAT64, may embed private IPv4 addresses
+		"64:ff9b:1::/48",  // Local-use NAT64
+		"2001:db8::/32",   // Documentation
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// publicIP reports whether ip is a public unicast address that downloads and
+// webhooks may connect to
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicDialer returns a dialer that refuses to connect to addresses that
+// allowed rejects, failing with blocked. The check runs on the resolved
+// address of every connection, so DNS answers changing after a URL was
+// validated cannot reach internal services.
+func publicDialer(allowed func(net.IP) bool, blocked error) *net.Dialer {
+	return &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return blocked
+			}
+			return nil
+		},
+	}
+}
+
+// URLFetcher downloads the files of fileUrl inputs. It only connects to
+// public addresses, checked when dialing so DNS answers changing between
+// lookup and connection or redirects cannot reach internal services, and
+// limits the size and duration of every download.
+type URLFetcher struct {
+	client  *http.Client
+	timeout time.Duration
+	allowIP func(net.IP) bool
+}
+
+// NewURLFetcher creates a fetcher whose downloads, redirects included, take
+// at most timeout
+func NewURLFetcher(timeout time.Duration) *URLFetcher {
+	f := &URLFetcher{
+		timeout: timeout,
+		allowIP: publicIP,
+	}
+
+	dialer := publicDialer(func(ip net.IP) bool { return f.allowIP(ip) }, ErrBlockedFileURL)
+	f.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 nil, // A proxy would connect on our behalf, bypassing the address check
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= urlFetcherMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", urlFetcherMaxRedirects)
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// Fetch downloads rawURL into dir and returns the file name the server gave
+// it and the local path. Files larger than maxSize are refused.
+func (f *URLFetcher) Fetch(ctx context.Context, rawURL, dir string, maxSize int64) (string, string, error) {
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", "", ErrInvalidFileURL
+	}
+	if err := f.checkURL(target); err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", "", ErrInvalidFileURL
+	}
+	req.Header.Set("User-Agent", "MegaPDF-Fetcher/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		for _, known := range []error{ErrBlockedFileURL, ErrInvalidFileURL} {
+			if errors.Is(err, known) {
+				return "", "", known
+			}
+		}
+		return "", "", fmt.Errorf("%w: %v", ErrRemoteFileFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", "", fmt.Errorf("%w: server answered %s", ErrRemoteFileFailed, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return "", "", ErrRemoteFileTooLarge
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	filename := remoteFilename(resp)
+	localPath := filepath.Join(dir, uuid.New().String()+"-"+filename)
+	file, err := os.Create(localPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	size, err := io.Copy(file, io.LimitReader(resp.Body, maxSize+1))
+	file.Close()
+	if err == nil && size > maxSize {
+		err = ErrRemoteFileTooLarge
+	} else if err != nil {
+		err = fmt.Errorf("%w: %v", ErrRemoteFileFailed, err)
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return "", "", err
+	}
+
+	return filename, localPath, nil
+}
+
+// checkURL refuses URLs other than http and https and hosts that are IP
+// addresses outside the public ranges. Host names are checked when dialing.
+func (f *URLFetcher) checkURL(target *url.URL) error {
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return ErrInvalidFileURL
+	}
+	if ip := net.ParseIP(target.Hostname()); ip != nil && !f.allowIP(ip) {
+		return ErrBlockedFileURL
+	}
+	return nil
+}
+
+// remoteFilename returns the file name of a download from its
+// Content-Disposition header or URL path
+func remoteFilename(resp *http.Response) string {
+	filename := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		filename = params["filename"]
+	}
+	if filename == "" {
+		filename = path.Base(resp.Request.URL.Path)
+	}
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "" || filename == "." || filename == ".." || filename == "/" {
+		filename = "download"
+	}
+	return filename
+}
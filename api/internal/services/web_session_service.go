@@ -0,0 +1,197 @@
+// internal/services/web_session_service.go
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// WebSessionUserPrefix starts the user ID of anonymous web sessions, e.g.
+// "web-session:<session ID>". Such users have no database record.
+const WebSessionUserPrefix = "web-session:"
+
+// ErrInvalidWebSession is returned for tokens that are malformed, not signed
+// with the session secret or expired
+var ErrInvalidWebSession = errors.New("invalid or expired session token")
+
+// webSessionIssueWindow is the window in which a client may request at most
+// the issue limit of tokens
+const webSessionIssueWindow = time.Hour
+
+// WebSessionLimitError is returned by Issue when a client requested more
+// tokens than the issue limit allows
+type WebSessionLimitError struct {
+	RetryAt time.Time
+}
+
+func (e *WebSessionLimitError) Error() string {
+	return "too many session tokens requested, try again later"
+}
+
+// WebSessionService issues the short-lived anonymous session tokens used by
+// the web frontend instead of an API key. Tokens are HMAC-signed, so they are
+// verified without a database query. Operations are counted per client IP in
+// the rate limit store, so requesting new tokens does not reset the quota,
+// and every IP may only request a limited number of tokens per hour.
+type WebSessionService struct {
+	secret     []byte
+	ttl        time.Duration
+	quota      int
+	issueLimit int
+	store      ratelimit.Store
+}
+
+// WebSession is a verified session token
+type WebSession struct {
+	// ID is "<client>.<random>", where client identifies the IP that
+	// requested the token without revealing it
+	ID        string
+	ExpiresAt time.Time
+	Quota     int
+}
+
+// webSessionClaims is the signed payload of a token
+type webSessionClaims struct {
+	ID        string `json:"sid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewWebSessionService creates a web session service signing tokens with
+// secret. Tokens are valid for ttl, clients may run quota operations per ttl
+// and request issueLimit tokens per hour.
+func NewWebSessionService(secret string, ttl time.Duration, quota, issueLimit int, store ratelimit.Store) *WebSessionService {
+	return &WebSessionService{
+		secret:     []byte(secret),
+		ttl:        ttl,
+		quota:      quota,
+		issueLimit: issueLimit,
+		store:      store,
+	}
+}
+
+// Issue creates a new session for the client at clientIP and returns its
+// token, or a *WebSessionLimitError if the client requested too many
+func (s *WebSessionService) Issue(ctx context.Context, clientIP string) (string, *WebSession, error) {
+	client := s.clientID(clientIP)
+	if s.issueLimit > 0 {
+		count, reset, err := s.store.Increment(ctx, "websession-issue:"+client, webSessionIssueWindow)
+		if err != nil {
+			return "", nil, err
+		}
+		if count > int64(s.issueLimit) {
+			return "", nil, &WebSessionLimitError{RetryAt: reset}
+		}
+	}
+
+	session := &WebSession{
+		ID:        client + "." + uuid.New().String(),
+		ExpiresAt: time.Now().Add(s.ttl).Truncate(time.Second),
+		Quota:     s.quota,
+	}
+
+	payload, err := json.Marshal(webSessionClaims{
+		ID:        session.ID,
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), session, nil
+}
+
+// Verify checks the signature and expiry of a token
+func (s *WebSessionService) Verify(token string) (*WebSession, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return nil, ErrInvalidWebSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidWebSession
+	}
+	var claims webSessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidWebSession
+	}
+	if _, ok := webSessionClient(claims.ID); !ok {
+		return nil, ErrInvalidWebSession
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !expiresAt.After(time.Now()) {
+		return nil, ErrInvalidWebSession
+	}
+
+	return &WebSession{ID: claims.ID, ExpiresAt: expiresAt, Quota: s.quota}, nil
+}
+
+// Consume counts an operation of a session against the quota of the client
+// it was issued to and returns the operations left. ok is false once the
+// quota is used up.
+func (s *WebSessionService) Consume(ctx context.Context, sessionID string) (remaining int, ok bool, err error) {
+	client, ok := webSessionClient(sessionID)
+	if !ok {
+		return 0, false, ErrInvalidWebSession
+	}
+
+	// The counter starts at the first operation and lasts one token
+	// lifetime, so the quota is shared by every token the client requests
+	// meanwhile
+	count, _, err := s.store.Increment(ctx, "websession-client:"+client, s.ttl)
+	if err != nil {
+		return 0, false, err
+	}
+	if count > int64(s.quota) {
+		return 0, false, nil
+	}
+	return s.quota - int(count), true, nil
+}
+
+// webSessionClient returns the client part of a session ID
+func webSessionClient(sessionID string) (string, bool) {
+	client, random, found := strings.Cut(sessionID, ".")
+	if !found || client == "" || random == "" {
+		return "", false
+	}
+	return client, true
+}
+
+// clientID returns an identifier of a client IP that does not reveal it
+func (s *WebSessionService) clientID(clientIP string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("client:" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// sign returns the HMAC-SHA256 signature of an encoded payload
+func (s *WebSessionService) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WebSessionUserID returns the user ID of a web session
+func WebSessionUserID(sessionID string) string {
+	return WebSessionUserPrefix + sessionID
+}
+
+// webSessionID returns the session ID of a web session user ID
+func webSessionID(userID string) (string, bool) {
+	if !strings.HasPrefix(userID, WebSessionUserPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(userID, WebSessionUserPrefix), true
+}
@@ -0,0 +1,116 @@
+// internal/services/web_session_service_test.go
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/ratelimit"
+)
+
+func TestWebSessionIssueLimit(t *testing.T) {
+	service := NewWebSessionService("test-session-secret", time.Minute, 5, 2, ratelimit.NewMemoryStore())
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := service.Issue(ctx, "203.0.113.7"); err != nil {
+			t.Fatalf("Issue() %d error = %v", i+1, err)
+		}
+	}
+
+	_, _, err := service.Issue(ctx, "203.0.113.7")
+	var limitErr *WebSessionLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Issue() error = %v, want a WebSessionLimitError", err)
+	}
+	if !limitErr.RetryAt.After(time.Now()) {
+		t.Errorf("RetryAt = %v, want a time in the future", limitErr.RetryAt)
+	}
+
+	// Other clients are not affected
+	if _, _, err := service.Issue(ctx, "198.51.100.9"); err != nil {
+		t.Errorf("Issue() for another IP error = %v", err)
+	}
+}
+
+func TestWebSessionQuotaIsPerClient(t *testing.T) {
+	service := NewWebSessionService("test-session-secret", time.Minute, 3, 0, ratelimit.NewMemoryStore())
+	ctx := context.Background()
+
+	consume := func(ip string) bool {
+		t.Helper()
+		token, _, err := service.Issue(ctx, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := service.Verify(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err := service.Consume(ctx, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	// A new token for every operation still uses the quota of the IP
+	for i := 0; i < 3; i++ {
+		if !consume("203.0.113.7") {
+			t.Fatalf("operation %d refused, want it within the quota", i+1)
+		}
+	}
+	if consume("203.0.113.7") {
+		t.Error("operation beyond the quota allowed with a new token")
+	}
+	if !consume("198.51.100.9") {
+		t.Error("operation of another IP refused")
+	}
+}
+
+func TestWebSessionVerify(t *testing.T) {
+	service := NewWebSessionService("test-session-secret", time.Minute, 3, 0, ratelimit.NewMemoryStore())
+	token, issued, err := service.Issue(context.Background(), "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := service.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if session.ID != issued.ID {
+		t.Errorf("Verify() ID = %q, want %q", session.ID, issued.ID)
+	}
+	if strings.Contains(session.ID, "203.0.113.7") {
+		t.Error("session ID reveals the client IP")
+	}
+
+	other := NewWebSessionService("other-secret", time.Minute, 3, 0, ratelimit.NewMemoryStore())
+	encoded, _, _ := strings.Cut(token, ".")
+	for _, invalid := range []string{"", encoded, encoded + ".forged"} {
+		if _, err := service.Verify(invalid); !errors.Is(err, ErrInvalidWebSession) {
+			t.Errorf("Verify(%q) error = %v, want ErrInvalidWebSession", invalid, err)
+		}
+	}
+	// Session IDs must name the client whose quota they use
+	for _, id := range []string{"", "6b3898d0-legacy-id", ".random", "client."} {
+		payload, _ := json.Marshal(webSessionClaims{ID: id, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+		encoded := base64.RawURLEncoding.EncodeToString(payload)
+		if _, err := service.Verify(encoded + "." + service.sign(encoded)); !errors.Is(err, ErrInvalidWebSession) {
+			t.Errorf("Verify() of session ID %q error = %v, want ErrInvalidWebSession", id, err)
+		}
+		if _, _, err := service.Consume(context.Background(), id); !errors.Is(err, ErrInvalidWebSession) {
+			t.Errorf("Consume(%q) error = %v, want ErrInvalidWebSession", id, err)
+		}
+	}
+
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidWebSession) {
+		t.Errorf("Verify() with another secret error = %v, want ErrInvalidWebSession", err)
+	}
+}
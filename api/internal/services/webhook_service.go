@@ -0,0 +1,437 @@
+// internal/services/webhook_service.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook signature headers sent with every callback
+const (
+	WebhookSignatureHeader = "X-MegaPDF-Signature"
+	WebhookTimestampHeader = "X-MegaPDF-Timestamp"
+	WebhookEventHeader     = "X-MegaPDF-Event"
+)
+
+// ErrBlockedCallbackURL is returned for callback URLs whose host resolves to
+// a private or reserved address
+var ErrBlockedCallbackURL = errors.New("callback URL points to a private or reserved address")
+
+const (
+	// webhookPollInterval is how often the worker looks for pending deliveries
+	webhookPollInterval = 5 * time.Second
+	// webhookDeliveryClaimTimeout is how long a claimed delivery is reserved
+	// for the instance sending it, after which another worker may send it
+	// again
+	webhookDeliveryClaimTimeout = time.Minute
+	// webhookBatchSize is how many due deliveries are sent per poll
+	webhookBatchSize = 20
+)
+
+// WebhookService delivers signed job callbacks. Every attempt is stored with
+// the time it is due, so retries survive restarts and are sent by whichever
+// instance polls first.
+type WebhookService struct {
+	db      *gorm.DB
+	secret  string
+	baseURL string
+	client  *http.Client
+	allowIP func(net.IP) bool
+
+	mu      sync.Mutex
+	started bool
+	wake    chan struct{}
+
+	// MaxAttempts is the number of delivery attempts before giving up
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles after each attempt
+	BaseDelay time.Duration
+}
+
+// WebhookPayload is the JSON body POSTed to a job's callback URL
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	JobID     string      `json:"jobId"`
+	Operation string      `json:"operation"`
+	Status    string      `json:"status"`
+	Results   []string    `json:"results"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// NewWebhookService creates a webhook service that signs payloads with the given secret.
+// Relative result URLs are made absolute using baseURL.
+func NewWebhookService(db *gorm.DB, secret, baseURL string) *WebhookService {
+	s := &WebhookService{
+		db:          db,
+		secret:      secret,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		allowIP:     publicIP,
+		wake:        make(chan struct{}, 1),
+		MaxAttempts: 6,
+		BaseDelay:   5 * time.Second,
+	}
+
+	dialer := publicDialer(func(ip net.IP) bool { return s.allowIP(ip) }, ErrBlockedCallbackURL)
+	s.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               nil, // A proxy would connect on our behalf, bypassing the address check
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		// Redirects are not followed; receivers must answer at the registered URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// ValidateCallbackURL checks that a callback URL is an absolute http(s) URL
+// whose host only resolves to public addresses. Deliveries check the address
+// again when connecting, in case the DNS answer changes.
+func ValidateCallbackURL(ctx context.Context, callbackURL string) error {
+	if len(callbackURL) > 2048 {
+		return errors.New("callback URL is too long")
+	}
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("callback URL must use http or https")
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return errors.New("callback URL must include a host")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return ErrBlockedCallbackURL
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("callback URL host %s cannot be resolved", host)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrBlockedCallbackURL
+		}
+	}
+	return nil
+}
+
+// UserSecret returns the signing secret for a user's callbacks.
+// It is derived from the server secret so it never has to be stored.
+func (s *WebhookService) UserSecret(userID string) string {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte("webhook:" + userID))
+	return "whsec_" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignWebhookPayload computes the signature header value for a payload sent at the given timestamp
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NotifyJobFinished queues the callback for a finished job, which the worker
+// sends in the background
+func (s *WebhookService) NotifyJobFinished(job *models.Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+
+	payload := WebhookPayload{
+		Event:     "job." + job.Status,
+		JobID:     job.ID,
+		Operation: job.Operation,
+		Status:    job.Status,
+		Results:   []string{},
+		Error:     job.Error,
+		Timestamp: time.Now().UTC(),
+	}
+
+	if job.Result != "" {
+		var result interface{}
+		if err := json.Unmarshal([]byte(job.Result), &result); err == nil {
+			payload.Result = result
+			for _, resultURL := range collectResultURLs(result) {
+				if strings.HasPrefix(resultURL, "/") {
+					resultURL = s.baseURL + resultURL
+				}
+				payload.Results = append(payload.Results, resultURL)
+			}
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for job %s: %v", job.ID, err)
+		return
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		ID:            uuid.New().String(),
+		JobID:         job.ID,
+		UserID:        job.UserID,
+		URL:           job.CallbackURL,
+		Event:         payload.Event,
+		Payload:       string(body),
+		Attempt:       1,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+	if err := s.db.Create(&delivery).Error; err != nil {
+		log.Printf("Failed to queue webhook for job %s: %v", job.ID, err)
+		return
+	}
+
+	// Nudge the worker; otherwise the delivery is picked up by polling
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the worker sending pending deliveries. It is safe to call
+// more than once.
+func (s *WebhookService) Start() {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.DeliverPending(); err != nil {
+				log.Printf("Webhook worker: %v", err)
+			}
+			select {
+			case <-s.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// DeliverPending sends the deliveries that are due and returns how many were
+// sent, successfully or not
+func (s *WebhookService) DeliverPending() (int, error) {
+	sent := 0
+	for {
+		var due []models.WebhookDelivery
+		if err := s.db.Where("next_attempt_at <= ?", time.Now()).
+			Order("next_attempt_at ASC").
+			Limit(webhookBatchSize).
+			Find(&due).Error; err != nil {
+			return sent, fmt.Errorf("failed to find pending deliveries: %w", err)
+		}
+		if len(due) == 0 {
+			return sent, nil
+		}
+
+		claimed := 0
+		for i := range due {
+			ok, err := s.claim(&due[i])
+			if err != nil {
+				return sent, err
+			}
+			if !ok {
+				continue // Another worker is sending it
+			}
+			claimed++
+			if err := s.send(&due[i]); err != nil {
+				log.Printf("Failed to record webhook delivery for job %s: %v", due[i].JobID, err)
+			}
+			sent++
+		}
+		if claimed == 0 || len(due) < webhookBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// claim reserves a due delivery for this worker. The conditional update makes
+// claiming safe across several API instances; a claim that is not settled,
+// because the instance stopped, expires after webhookDeliveryClaimTimeout.
+func (s *WebhookService) claim(delivery *models.WebhookDelivery) (bool, error) {
+	now := time.Now()
+	result := s.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND next_attempt_at <= ?", delivery.ID, now).
+		Update("next_attempt_at", now.Add(webhookDeliveryClaimTimeout))
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim delivery %s: %w", delivery.ID, result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// send performs a claimed delivery, stores its outcome and schedules the next
+// attempt with exponential backoff if it failed
+func (s *WebhookService) send(delivery *models.WebhookDelivery) error {
+	outcome := s.attempt(delivery.URL, s.UserSecret(delivery.UserID), delivery.Event, []byte(delivery.Payload))
+	now := time.Now()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+			"next_attempt_at": nil,
+			"attempted_at":    now,
+			"status_code":     outcome.StatusCode,
+			"success":         outcome.Success,
+			"error":           outcome.Error,
+			"duration_ms":     outcome.DurationMs,
+		}).Error; err != nil {
+			return err
+		}
+
+		if outcome.Success {
+			fmt.Printf("Webhook for job %s delivered on attempt %d\n", delivery.JobID, delivery.Attempt)
+			return nil
+		}
+		if delivery.Attempt >= s.MaxAttempts {
+			log.Printf("Giving up on webhook for job %s after %d attempts", delivery.JobID, delivery.Attempt)
+			return nil
+		}
+
+		delay := s.BaseDelay << (delivery.Attempt - 1)
+		nextAttemptAt := now.Add(delay)
+		log.Printf("Webhook for job %s failed (attempt %d/%d), retrying in %s", delivery.JobID, delivery.Attempt, s.MaxAttempts, delay)
+		return tx.Create(&models.WebhookDelivery{
+			ID:            uuid.New().String(),
+			JobID:         delivery.JobID,
+			UserID:        delivery.UserID,
+			URL:           delivery.URL,
+			Event:         delivery.Event,
+			Payload:       delivery.Payload,
+			Attempt:       delivery.Attempt + 1,
+			NextAttemptAt: &nextAttemptAt,
+			CreatedAt:     now,
+		}).Error
+	})
+}
+
+// attempt performs a single delivery and returns its outcome
+func (s *WebhookService) attempt(callbackURL, secret, event string, body []byte) models.WebhookDelivery {
+	var delivery models.WebhookDelivery
+	start := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MegaPDF-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		if errors.Is(err, ErrBlockedCallbackURL) {
+			delivery.Error = ErrBlockedCallbackURL.Error()
+		}
+		return delivery
+	}
+	// The response body is not kept, so receivers cannot expose internal
+	// content through the API
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return delivery
+}
+
+// GetDeliveries returns the delivery attempts for a job, oldest first
+func (s *WebhookService) GetDeliveries(jobID string) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	if err := s.db.Where("job_id = ?", jobID).Order("attempt ASC").Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// collectResultURLs extracts the file URLs from a job result.
+// Any string field whose name ends in "Url" is treated as a result URL,
+// except the status URL used for polling.
+func collectResultURLs(value interface{}) []string {
+	urls := []string{}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch typed := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(typed))
+			for key := range typed {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				item := typed[key]
+				if str, ok := item.(string); ok {
+					if strings.HasSuffix(key, "Url") && key != "statusUrl" && str != "" {
+						urls = append(urls, str)
+					}
+					continue
+				}
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range typed {
+				walk(item)
+			}
+		}
+	}
+	walk(value)
+
+	return urls
+}
@@ -0,0 +1,332 @@
+// internal/services/webhook_service_test.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MegaPDF/megapdf-official/api/internal/models"
+)
+
+// webhookReceiver is an httptest server recording the callbacks it receives
+// and answering with the next of its statuses, then 200
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte("internal details the API must not expose"))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// newTestWebhookService returns a webhook service allowed to reach the local
+// test receivers
+func newTestWebhookService(t *testing.T) *WebhookService {
+	db := newTestDB(t, &models.WebhookDelivery{})
+	service := NewWebhookService(db, "test-webhook-secret", "https://api.example.com/")
+	service.allowIP = func(net.IP) bool { return true }
+	return service
+}
+
+func finishedJob(callbackURL string) *models.Job {
+	return &models.Job{
+		ID:          "job-1",
+		UserID:      "user-1",
+		Operation:   "compress",
+		Status:      models.JobStatusCompleted,
+		Result:      `{"success":true,"fileUrl":"/api/file?file=out.pdf","statusUrl":"/api/jobs/job-1"}`,
+		CallbackURL: callbackURL,
+	}
+}
+
+// makeDue moves the pending deliveries of the service to the past
+func makeDue(t *testing.T, service *WebhookService) {
+	t.Helper()
+	if err := service.db.Model(&models.WebhookDelivery{}).
+		Where("next_attempt_at IS NOT NULL").
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func deliverPending(t *testing.T, service *WebhookService, want int) {
+	t.Helper()
+	sent, err := service.DeliverPending()
+	if err != nil {
+		t.Fatalf("DeliverPending() error = %v", err)
+	}
+	if sent != want {
+		t.Fatalf("DeliverPending() sent %d deliveries, want %d", sent, want)
+	}
+}
+
+func deliveries(t *testing.T, service *WebhookService) []models.WebhookDelivery {
+	t.Helper()
+	deliveries, err := service.GetDeliveries("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	service := newTestWebhookService(t)
+
+	service.NotifyJobFinished(finishedJob(receiver.URL + "/hooks"))
+	deliverPending(t, service, 1)
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+
+	if got := request.header.Get(WebhookEventHeader); got != "job.completed" {
+		t.Errorf("%s = %q, want job.completed", WebhookEventHeader, got)
+	}
+	timestamp, err := strconv.ParseInt(request.header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", WebhookTimestampHeader, err)
+	}
+	want := SignWebhookPayload(service.UserSecret("user-1"), timestamp, request.body)
+	if got := request.header.Get(WebhookSignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", WebhookSignatureHeader, got, want)
+	}
+	if SignWebhookPayload(service.UserSecret("user-2"), timestamp, request.body) == want {
+		t.Error("signature does not depend on the user")
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.JobID != "job-1" || payload.Status != models.JobStatusCompleted {
+		t.Errorf("payload = %+v", payload)
+	}
+	if len(payload.Results) != 1 || payload.Results[0] != "https://api.example.com/api/file?file=out.pdf" {
+		t.Errorf("payload results = %q, want the absolute file URL", payload.Results)
+	}
+
+	recorded := deliveries(t, service)
+	if len(recorded) != 1 {
+		t.Fatalf("recorded %d deliveries, want 1", len(recorded))
+	}
+	if !recorded[0].Success || recorded[0].StatusCode != http.StatusOK || recorded[0].NextAttemptAt != nil || recorded[0].AttemptedAt == nil {
+		t.Errorf("delivery = %+v, want a sent successful attempt", recorded[0])
+	}
+}
+
+func TestWebhookDeliveryRetriesWithBackoff(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	service := newTestWebhookService(t)
+	service.BaseDelay = time.Hour
+
+	service.NotifyJobFinished(finishedJob(receiver.URL))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		deliverPending(t, service, 1)
+		// The retry is not due before its backoff
+		deliverPending(t, service, 0)
+
+		recorded := deliveries(t, service)
+		sent := recorded[attempt-1]
+		if sent.Attempt != attempt || sent.AttemptedAt == nil || sent.NextAttemptAt != nil {
+			t.Fatalf("attempt %d = %+v, want it sent", attempt, sent)
+		}
+		if attempt == 3 {
+			if !sent.Success || len(recorded) != 3 {
+				t.Fatalf("attempt 3 = %+v with %d attempts, want the last one to succeed", sent, len(recorded))
+			}
+			break
+		}
+
+		if sent.Success || sent.Error == "" {
+			t.Fatalf("attempt %d = %+v, want a failure", attempt, sent)
+		}
+		if len(recorded) != attempt+1 {
+			t.Fatalf("recorded %d attempts after attempt %d, want the next one scheduled", len(recorded), attempt)
+		}
+		next := recorded[attempt]
+		wantDelay := service.BaseDelay << (attempt - 1)
+		if next.NextAttemptAt == nil {
+			t.Fatalf("attempt %d is not scheduled", attempt+1)
+		}
+		if delay := next.NextAttemptAt.Sub(*sent.AttemptedAt); delay < wantDelay-time.Second || delay > wantDelay+time.Second {
+			t.Errorf("attempt %d scheduled after %s, want %s", attempt+1, delay, wantDelay)
+		}
+		if next.Payload != sent.Payload {
+			t.Errorf("attempt %d does not resend the same payload", attempt+1)
+		}
+
+		makeDue(t, service)
+	}
+
+	// Every attempt carries a valid signature over the same body
+	requests := receiver.received()
+	if len(requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(requests))
+	}
+	for i, request := range requests {
+		timestamp, _ := strconv.ParseInt(request.header.Get(WebhookTimestampHeader), 10, 64)
+		if request.header.Get(WebhookSignatureHeader) != SignWebhookPayload(service.UserSecret("user-1"), timestamp, request.body) {
+			t.Errorf("request %d has an invalid signature", i+1)
+		}
+		if string(request.body) != string(requests[0].body) {
+			t.Errorf("request %d has a different body", i+1)
+		}
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	receiver := newWebhookReceiver(t, 500, 500, 500, 500)
+	service := newTestWebhookService(t)
+	service.MaxAttempts = 2
+
+	service.NotifyJobFinished(finishedJob(receiver.URL))
+	deliverPending(t, service, 1)
+	makeDue(t, service)
+	deliverPending(t, service, 1)
+	makeDue(t, service)
+	deliverPending(t, service, 0)
+
+	recorded := deliveries(t, service)
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d attempts, want 2", len(recorded))
+	}
+	for _, delivery := range recorded {
+		if delivery.Success || delivery.NextAttemptAt != nil {
+			t.Errorf("delivery = %+v, want a failed attempt with nothing pending", delivery)
+		}
+	}
+}
+
+func TestWebhookDeliveryRedirectIsNotFollowed(t *testing.T) {
+	target := newWebhookReceiver(t)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	service := newTestWebhookService(t)
+	service.MaxAttempts = 1
+
+	service.NotifyJobFinished(finishedJob(redirect.URL))
+	deliverPending(t, service, 1)
+
+	if len(target.received()) != 0 {
+		t.Error("redirect was followed")
+	}
+	if recorded := deliveries(t, service); recorded[0].Success || recorded[0].StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("delivery = %+v, want a failed attempt", recorded[0])
+	}
+}
+
+func TestWebhookDeliveryBlocksPrivateAddresses(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	service := newTestWebhookService(t)
+	service.allowIP = publicIP
+	service.MaxAttempts = 1
+
+	// The receiver listens on a loopback address, as an internal service would
+	service.NotifyJobFinished(finishedJob(receiver.URL))
+	deliverPending(t, service, 1)
+
+	if len(receiver.received()) != 0 {
+		t.Fatal("webhook reached a loopback address")
+	}
+	if recorded := deliveries(t, service); recorded[0].Error != ErrBlockedCallbackURL.Error() {
+		t.Errorf("delivery error = %q, want %q", recorded[0].Error, ErrBlockedCallbackURL)
+	}
+}
+
+func TestWebhookDeliveryClaimedOnce(t *testing.T) {
+	service := newTestWebhookService(t)
+	service.NotifyJobFinished(finishedJob("https://hooks.example.com/"))
+
+	pending := deliveries(t, service)[0]
+	first, err := service.claim(&pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.claim(&pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first || second {
+		t.Errorf("claims = %v, %v, want only the first to succeed", first, second)
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr error
+		valid   bool
+	}{
+		{"https://93.184.216.34/hooks", nil, true},
+		{"http://127.0.0.1:8080/hooks", ErrBlockedCallbackURL, false},
+		{"http://localhost/hooks", ErrBlockedCallbackURL, false},
+		{"http://10.0.0.5/hooks", ErrBlockedCallbackURL, false},
+		{"http://169.254.169.254/latest/meta-data", ErrBlockedCallbackURL, false},
+		{"http://[::1]/hooks", ErrBlockedCallbackURL, false},
+		{"http://[::ffff:192.168.1.1]/hooks", ErrBlockedCallbackURL, false},
+		{"ftp://93.184.216.34/hooks", nil, false},
+		{"/relative/hooks", nil, false},
+		{"https:///hooks", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := ValidateCallbackURL(context.Background(), tt.url)
+			if tt.valid {
+				if err != nil {
+					t.Errorf("ValidateCallbackURL() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("ValidateCallbackURL() accepted the URL")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateCallbackURL() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
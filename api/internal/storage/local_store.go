@@ -0,0 +1,225 @@
+// internal/storage/local_store.go
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps the objects as files below a directory. It is only shared
+// between API instances that mount the same directory.
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a store keeping its objects below root
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+// path returns the file of a key
+func (s *LocalStore) path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file renamed into place, so readers
+// never see a partial object
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".put-*.part")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, localError(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+	return file, localObject(key, info), nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (*Object, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, localError(err)
+	}
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+	return localObject(key, info), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List collects the objects Walk finds
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := s.Walk(ctx, prefix, func(object Object) error {
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Walk walks the directory of the prefix; files being written by Put are
+// left out
+func (s *LocalStore) Walk(ctx context.Context, prefix string, fn func(Object) error) error {
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		prefixDir, err := s.path(prefix[:i])
+		if err != nil {
+			return err
+		}
+		dir = prefixDir
+	}
+
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".put-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed in the meantime
+		}
+		return fn(*localObject(key, info))
+	})
+}
+
+// SignedURL is not supported, local objects are served by the API
+func (s *LocalStore) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+// moveFile renames a local file into the store; files already in place are
+// left alone
+func (s *LocalStore) moveFile(key, filePath string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	source, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	if absTarget, err := filepath.Abs(target); err == nil && absTarget == source {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+
+	// Across file systems: copy, then remove the original
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	err = s.Put(context.Background(), key, file, -1, "")
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+// localObject describes a file of the store
+func localObject(key string, info fs.FileInfo) *Object {
+	return &Object{
+		Key:         key,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		ContentType: ContentType(key),
+	}
+}
+
+// localError maps a missing file to ErrNotFound
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+// internal/storage/local_store_test.go
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLocalStore returns a store in a directory of its own, next to which
+// files escaping the store would land
+func newTestLocalStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "store")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewLocalStore(root), parent
+}
+
+func TestLocalStoreRejectsPathTraversal(t *testing.T) {
+	store, parent := newTestLocalStore(t)
+	ctx := context.Background()
+
+	// A file outside the store the keys try to reach
+	secret := filepath.Join(parent, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"",
+		".",
+		"..",
+		"../secret.txt",
+		"../escape.txt",
+		"a/../../secret.txt",
+		"a/b/../../../escape.txt",
+		"/etc/passwd",
+		"/" + secret,
+		`..\secret.txt`,
+		`a\..\..\escape.txt`,
+	}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			if err := store.Put(ctx, key, strings.NewReader("overwritten"), 11, ""); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put() error = %v, want ErrInvalidKey", err)
+			}
+			if body, _, err := store.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				if err == nil {
+					body.Close()
+				}
+				t.Errorf("Get() error = %v, want ErrInvalidKey", err)
+			}
+			if _, err := store.Stat(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Stat() error = %v, want ErrInvalidKey", err)
+			}
+			if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete() error = %v, want ErrInvalidKey", err)
+			}
+			if _, err := store.SignedURL(ctx, key, time.Minute); err == nil {
+				t.Error("SignedURL() succeeded")
+			}
+		})
+	}
+
+	for _, prefix := range []string{"../", "../secret", "a/../../"} {
+		if _, err := store.List(ctx, prefix); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("List(%q) error = %v, want ErrInvalidKey", prefix, err)
+		}
+	}
+
+	if data, err := os.ReadFile(secret); err != nil || string(data) != "secret" {
+		t.Errorf("file outside the store = %q, %v, want it untouched", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file written outside the store: %v", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store, _ := newTestLocalStore(t)
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"results/a.pdf":        "%PDF-1.7 a",
+		"results/nested/b.txt": "text",
+		"results/./c.pdf":      "%PDF-1.7 c",
+		"uploads/d.bin":        "",
+	}
+	for key, data := range objects {
+		if err := store.Put(ctx, key, strings.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+
+	body, object, err := store.Get(ctx, "results/c.pdf")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "%PDF-1.7 c" || object.Size != 10 || object.ContentType != "application/pdf" {
+		t.Errorf("Get() = %q, %+v", data, object)
+	}
+
+	// Files being written are not objects yet
+	if err := os.WriteFile(filepath.Join(store.root, "results", ".put-123.part"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := store.List(ctx, "results/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var keys []string
+	for _, object := range listed {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	if want := "results/a.pdf|results/c.pdf|results/nested/b.txt"; strings.Join(keys, "|") != want {
+		t.Errorf("List() keys = %q, want %s", keys, want)
+	}
+	if listed, err := store.List(ctx, "missing/"); err != nil || len(listed) != 0 {
+		t.Errorf("List() of a missing folder = %v, %v, want nothing", listed, err)
+	}
+
+	// Directories are not objects
+	if _, err := store.Stat(ctx, "results/nested"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() of a directory error = %v, want ErrNotFound", err)
+	}
+
+	if err := store.Delete(ctx, "results/a.pdf"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, _, err := store.Get(ctx, "results/a.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a deleted object error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "results/a.pdf"); err != nil {
+		t.Errorf("Delete() of a missing object error = %v", err)
+	}
+
+	if _, err := store.SignedURL(ctx, "results/c.pdf", time.Minute); !errors.Is(err, ErrSignedURLUnsupported) {
+		t.Errorf("SignedURL() error = %v, want ErrSignedURLUnsupported", err)
+	}
+}
+
+func TestLocalStoreWalk(t *testing.T) {
+	store, _ := newTestLocalStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"jobs/a.pdf", "jobs/nested/b.pdf", "results/c.pdf"} {
+		if err := store.Put(ctx, key, strings.NewReader("%PDF-1.7"), 8, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An error of fn stops the walk
+	errStop := errors.New("stop")
+	visited := 0
+	err := store.Walk(ctx, "", func(Object) error {
+		visited++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || visited != 1 {
+		t.Errorf("Walk() stopped after %d objects with %v, want 1 and the error of fn", visited, err)
+	}
+
+	// fn may delete the objects it is called with
+	var keys []string
+	err = store.Walk(ctx, "jobs/", func(object Object) error {
+		keys = append(keys, object.Key)
+		return store.Delete(ctx, object.Key)
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	sort.Strings(keys)
+	if want := "jobs/a.pdf|jobs/nested/b.pdf"; strings.Join(keys, "|") != want {
+		t.Errorf("Walk() keys = %q, want %s", keys, want)
+	}
+	if listed, err := store.List(ctx, ""); err != nil || len(listed) != 1 || listed[0].Key != "results/c.pdf" {
+		t.Errorf("List() after deleting while walking = %v, %v, want results/c.pdf", listed, err)
+	}
+}
+
+func TestLocalStoreMoveFile(t *testing.T) {
+	store, parent := newTestLocalStore(t)
+	ctx := context.Background()
+
+	source := filepath.Join(parent, "result.pdf")
+	if err := os.WriteFile(source, []byte("%PDF-1.7"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveFile(ctx, store, "results/result.pdf", source); err != nil {
+		t.Fatalf("MoveFile() error = %v", err)
+	}
+	if _, err := os.Stat(source); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source still exists: %v", err)
+	}
+
+	// A file written into the store's directory stays in place
+	inPlace := filepath.Join(store.root, "results", "result.pdf")
+	if err := MoveFile(ctx, store, "results/result.pdf", inPlace); err != nil {
+		t.Fatalf("MoveFile() in place error = %v", err)
+	}
+
+	target := filepath.Join(parent, "fetched", "result.pdf")
+	if err := FetchFile(ctx, store, "results/result.pdf", target); err != nil {
+		t.Fatalf("FetchFile() error = %v", err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "%PDF-1.7" {
+		t.Errorf("fetched file = %q, %v", data, err)
+	}
+
+	if err := MoveFile(ctx, store, "../result.pdf", target); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("MoveFile() out of the store error = %v, want ErrInvalidKey", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("source of a rejected move was removed: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// internal/storage/storage.go
+
+// Package storage keeps the files the API hands out (operation results,
+// signed documents, queued job inputs, ...) in a pluggable object store. A
+// shared store (e.g. S3 or MinIO) lets several API instances serve the files
+// any of them produced.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Errors returned by stores
+var (
+	// ErrNotFound is returned for keys without an object
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that are empty or leave the store,
+	// e.g. "../x"
+	ErrInvalidKey = errors.New("invalid object key")
+	// ErrSignedURLUnsupported is returned by stores that cannot hand out
+	// direct download URLs
+	ErrSignedURLUnsupported = errors.New("store does not support signed URLs")
+)
+
+// Object describes a stored object
+type Object struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage keeps objects by key, a slash separated path such as
+// "compressions/<id>-compressed.pdf". Implementations must be safe for
+// concurrent use.
+type Storage interface {
+	// Put stores size bytes read from body under key, replacing the object
+	// stored there
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Stat describes the object stored under key
+	Stat(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under key. Deleting a missing object
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Walk calls fn for every object whose key starts with prefix, reading
+	// the listing page by page, so a large store is not held in memory. An
+	// error returned by fn stops the walk and is returned. fn may delete the
+	// object it is called with.
+	Walk(ctx context.Context, prefix string, fn func(Object) error) error
+	// SignedURL returns a URL the object can be downloaded from without
+	// credentials until it expires
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Key joins a folder and a file name into an object key
+func Key(folder, filename string) string {
+	return path.Join(folder, filename)
+}
+
+// CleanKey validates a key and returns it in canonical form
+func CleanKey(key string) (string, error) {
+	if key == "" || strings.Contains(key, `\`) || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
+
+// ContentType returns the MIME type of a key from its extension
+func ContentType(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// fileMover is implemented by stores that can take over a local file
+// without copying it
+type fileMover interface {
+	moveFile(key, filePath string) error
+}
+
+// MoveFile stores the local file at filePath under key and removes the local
+// copy. Local stores rename the file instead of copying it, so results
+// written straight into the store's directory are left in place.
+func MoveFile(ctx context.Context, store Storage, key, filePath string) error {
+	if mover, ok := store.(fileMover); ok {
+		return mover.moveFile(key, filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = store.Put(ctx, key, file, info.Size(), ContentType(key))
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// FetchFile copies the object stored under key to a local file at filePath
+func FetchFile(ctx context.Context, store Storage, key, filePath string) error {
+	body, _, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(filePath)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,43 @@
+// internal/storage/storage_test.go
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"results/out.pdf", "results/out.pdf"},
+		{"results//out.pdf", "results/out.pdf"},
+		{"results/./out.pdf", "results/out.pdf"},
+		{"results/tmp/../out.pdf", "results/out.pdf"},
+		{"results/", "results"},
+		{"..out.pdf", "..out.pdf"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../out.pdf", ""},
+		{"results/../../out.pdf", ""},
+		{"/results/out.pdf", ""},
+		{`results\out.pdf`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := CleanKey(tt.key)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Errorf("CleanKey() = %q, %v, want ErrInvalidKey", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("CleanKey() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}